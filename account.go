@@ -0,0 +1,426 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/mail"
+	"strings"
+	"time"
+	"unicode/utf8"
+
+	"golang.org/x/crypto/bcrypt"
+)
+
+// Управление своим аккаунтом: имя, смена email и пароля, удаление и выгрузка данных
+
+const (
+	purposeChangeEmail = "change_email"
+	changeEmailTTL     = 24 * time.Hour
+	maxNameLength      = 100
+)
+
+// currentUser загружает пользователя из access-токена запроса
+func (s *Server) currentUser(w http.ResponseWriter, r *http.Request) (User, bool) {
+	user, err := s.users.Get(r.Context(), claimsUserID(r.Context().Value("user").(*Claims)))
+	if err != nil {
+		http.Error(w, "User not found", http.StatusUnauthorized)
+		return User{}, false
+	}
+	return user, true
+}
+
+// reauthenticate проверяет текущий пароль перед опасным действием. Ошибки
+// учитываются тем же счётчиком, что и вход, чтобы украденный access-токен
+// не позволял подбирать пароль
+func (s *Server) reauthenticate(w http.ResponseWriter, r *http.Request, user User, password string) bool {
+	if user.PasswordHash == "" {
+		http.Error(w, "Set a password first using password reset", http.StatusBadRequest)
+		return false
+	}
+	ip := s.clientIP(r)
+	if wait := s.loginRetryAfter(ip, user.Email); wait > 0 {
+		http.Error(w, "Too many failed attempts, try again later", http.StatusTooManyRequests)
+		return false
+	}
+	if bcrypt.CompareHashAndPassword([]byte(user.PasswordHash), []byte(password)) != nil {
+		s.loginFailed(r.Context(), ip, user.Email, &user)
+		http.Error(w, "Current password is incorrect", http.StatusForbidden)
+		return false
+	}
+	return true
+}
+
+// Данные аккаунта: GET /api/account
+func (s *Server) accountHandler(w http.ResponseWriter, r *http.Request) {
+	user, ok := s.currentUser(w, r)
+	if !ok {
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(user)
+}
+
+// Изменение профиля: PATCH /api/account {"name": "..."}
+func (s *Server) updateAccountHandler(w http.ResponseWriter, r *http.Request) {
+	user, ok := s.currentUser(w, r)
+	if !ok {
+		return
+	}
+	var req struct {
+		Name *string `json:"name"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, "Invalid request", http.StatusBadRequest)
+		return
+	}
+	if req.Name != nil {
+		name := strings.TrimSpace(*req.Name)
+		if name == "" || utf8.RuneCountInString(name) > maxNameLength {
+			http.Error(w, fmt.Sprintf("Name must be 1 to %d characters", maxNameLength), http.StatusBadRequest)
+			return
+		}
+		user.Name = name
+	}
+	if err := s.users.Save(r.Context(), &user); err != nil {
+		http.Error(w, "Failed to update account", http.StatusInternalServerError)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(user)
+}
+
+// Смена email: POST /api/account/email {"email": "...", "password": "..."}.
+// Адрес меняется только после перехода по ссылке, отправленной на новый email
+func (s *Server) changeEmailHandler(w http.ResponseWriter, r *http.Request) {
+	user, ok := s.currentUser(w, r)
+	if !ok {
+		return
+	}
+	var req struct {
+		Email    string `json:"email"`
+		Password string `json:"password"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, "Invalid request", http.StatusBadRequest)
+		return
+	}
+	email := strings.TrimSpace(req.Email)
+	if addr, err := mail.ParseAddress(email); err != nil || addr.Address != email {
+		http.Error(w, "Invalid email address", http.StatusBadRequest)
+		return
+	}
+	if strings.EqualFold(email, user.Email) {
+		http.Error(w, "This is already your email", http.StatusBadRequest)
+		return
+	}
+	if !s.reauthenticate(w, r, user, req.Password) {
+		return
+	}
+
+	// Занятый адрес не выдаём: ответ тот же, но письмо не отправляется
+	_, err := s.users.GetByEmail(r.Context(), email)
+	switch {
+	case errors.Is(err, ErrNotFound):
+		user.PendingEmail = email
+		if err := s.users.Save(r.Context(), &user); err != nil {
+			http.Error(w, "Failed to update account", http.StatusInternalServerError)
+			return
+		}
+		token, err := s.issueUserToken(r.Context(), user.ID, purposeChangeEmail, changeEmailTTL)
+		if err != nil {
+			http.Error(w, "Failed to start email change", http.StatusInternalServerError)
+			return
+		}
+		ctx := context.WithoutCancel(r.Context())
+		go func() {
+			if err := s.sendEmailChangeLink(ctx, email, token); err != nil {
+				s.log(ctx).WithError(err).Error("Failed to send email change link")
+			}
+		}()
+	case err != nil:
+		http.Error(w, "Failed to check email", http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusAccepted)
+	json.NewEncoder(w).Encode(map[string]string{
+		"message": "Follow the link sent to the new address to confirm the change.",
+	})
+}
+
+func (s *Server) sendEmailChangeLink(ctx context.Context, to, token string) error {
+	link := fmt.Sprintf("%s/account/email/confirm?token=%s", s.config.BaseURL(), token)
+	message := fmt.Sprintf("Follow the link to use this address for your Bookstore account: %s\r\n"+
+		"The link expires in %d hours and works once.\r\n\r\n"+
+		"If you didn't ask for this, ignore this email.",
+		link, int(changeEmailTTL.Hours()))
+	msg := []byte("To: " + to + "\r\n" + "Subject: Confirm your new email\r\n" + "\r\n" + message)
+
+	return s.sendMail(ctx, "email_change", []string{to}, msg)
+}
+
+// Подтверждение нового email по ссылке: GET /account/email/confirm?token=...
+// Старый адрес получает уведомление, все сессии завершаются
+func (s *Server) confirmEmailChangeHandler(w http.ResponseWriter, r *http.Request) {
+	ip := s.clientIP(r)
+	if !s.verifyAttempts.Allow(ip) {
+		http.Error(w, "Too many attempts, try again later", http.StatusTooManyRequests)
+		return
+	}
+	token, err := s.consumeUserToken(r.Context(), purposeChangeEmail, r.URL.Query().Get("token"))
+	if err != nil {
+		s.verifyAttempts.Fail(ip)
+		http.Error(w, "Invalid or expired token", http.StatusBadRequest)
+		return
+	}
+	s.verifyAttempts.Reset(ip)
+
+	user, err := s.users.Get(r.Context(), token.UserID)
+	if err != nil || user.PendingEmail == "" {
+		http.Error(w, "Invalid or expired token", http.StatusBadRequest)
+		return
+	}
+	oldEmail := user.Email
+	user.Email, user.PendingEmail = user.PendingEmail, ""
+	user.Confirmed = true
+	if err := s.users.Save(r.Context(), &user); err != nil {
+		if errors.Is(err, ErrDuplicate) {
+			http.Error(w, "This email is already in use", http.StatusConflict)
+			return
+		}
+		http.Error(w, "Failed to change email", http.StatusInternalServerError)
+		return
+	}
+	if err := s.tokens.RevokeUserSessions(r.Context(), user.ID, time.Now()); err != nil {
+		http.Error(w, "Failed to revoke sessions", http.StatusInternalServerError)
+		return
+	}
+	s.log(r.Context()).WithField("user_id", user.ID).Info("Email changed")
+	ctx := context.WithoutCancel(r.Context())
+	go func() {
+		if err := s.sendEmailChangedNotice(ctx, oldEmail, user.Email); err != nil {
+			s.log(ctx).WithError(err).Error("Failed to send email change notice")
+		}
+	}()
+
+	w.WriteHeader(http.StatusOK)
+	fmt.Fprintf(w, "Email changed successfully. Please log in with the new address.")
+}
+
+func (s *Server) sendEmailChangedNotice(ctx context.Context, to, newEmail string) error {
+	message := fmt.Sprintf("The email of your Bookstore account was changed to %s.\r\n\r\n"+
+		"If it wasn't you, contact us right away.", newEmail)
+	msg := []byte("To: " + to + "\r\n" + "Subject: Your email was changed\r\n" + "\r\n" + message)
+
+	return s.sendMail(ctx, "email_changed", []string{to}, msg)
+}
+
+// Смена пароля: POST /api/account/password {"current_password": "...", "new_password": "..."}.
+// Остальные сессии завершаются, текущая получает новую пару токенов
+func (s *Server) changePasswordHandler(w http.ResponseWriter, r *http.Request) {
+	user, ok := s.currentUser(w, r)
+	if !ok {
+		return
+	}
+	var req struct {
+		CurrentPassword string `json:"current_password"`
+		NewPassword     string `json:"new_password"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, "Invalid request", http.StatusBadRequest)
+		return
+	}
+	if err := validatePassword(req.NewPassword, user.Email); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	if !s.reauthenticate(w, r, user, req.CurrentPassword) {
+		return
+	}
+
+	hash, err := bcrypt.GenerateFromPassword([]byte(req.NewPassword), bcrypt.DefaultCost)
+	if err != nil {
+		http.Error(w, "Failed to hash password", http.StatusInternalServerError)
+		return
+	}
+	user.PasswordHash = string(hash)
+	if err := s.users.Save(r.Context(), &user); err != nil {
+		http.Error(w, "Failed to update password", http.StatusInternalServerError)
+		return
+	}
+	if err := s.tokens.RevokeUserSessions(r.Context(), user.ID, time.Now()); err != nil {
+		http.Error(w, "Failed to revoke sessions", http.StatusInternalServerError)
+		return
+	}
+	// Текущий браузер или клиент остаётся в системе с новой сессией того же вида
+	mfa := r.Context().Value("user").(*Claims).MFA
+	if err := s.signIn(w, r, user, mfa, authenticatedByCookie(r)); err != nil {
+		http.Error(w, "Failed to generate token", http.StatusInternalServerError)
+		return
+	}
+	s.log(r.Context()).WithField("user_id", user.ID).Info("Password changed")
+}
+
+// Удаление аккаунта: DELETE /api/account {"password": "..."}.
+// Стираются пользователь и всё, что с ним связано: входы через провайдеров,
+// 2FA, passkeys, сессии и одноразовые токены
+func (s *Server) deleteAccountHandler(w http.ResponseWriter, r *http.Request) {
+	user, ok := s.currentUser(w, r)
+	if !ok {
+		return
+	}
+	if user.Role == "admin" {
+		http.Error(w, "Administrators cannot delete their own account", http.StatusForbidden)
+		return
+	}
+	var req struct {
+		Password string `json:"password"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, "Invalid request", http.StatusBadRequest)
+		return
+	}
+	if !s.reauthenticate(w, r, user, req.Password) {
+		return
+	}
+
+	if err := s.eraseUser(r.Context(), user.ID); err != nil {
+		s.log(r.Context()).WithError(err).WithField("user_id", user.ID).Error("Failed to delete account")
+		http.Error(w, "Failed to delete account", http.StatusInternalServerError)
+		return
+	}
+	s.accountLogins.Reset(loginKey(user.Email))
+	// Логи хранятся в log_entries, поэтому пользователей в них называют только по ID
+	s.log(r.Context()).WithField("user_id", user.ID).Info("Account deleted")
+
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// eraseUser сначала отзывает сессии, затем удаляет пользователя и только потом
+// его токены. Если последний шаг не удался, аккаунт всё равно стёрт, а
+// оставшиеся записи удалит purgeExpiredTokens как осиротевшие
+func (s *Server) eraseUser(ctx context.Context, userID uint) error {
+	if err := s.tokens.RevokeUserSessions(ctx, userID, time.Now()); err != nil {
+		return err
+	}
+	if err := s.users.Delete(ctx, userID); err != nil {
+		return err
+	}
+	if err := s.tokens.DeleteUserData(ctx, userID); err != nil {
+		s.log(ctx).WithError(err).WithField("user_id", userID).Warn("Failed to delete token data of an erased user, leaving it for the purge")
+	}
+	return nil
+}
+
+type accountExport struct {
+	ExportedAt time.Time         `json:"exported_at"`
+	Account    User              `json:"account"`
+	Identities []exportIdentity  `json:"identities"`
+	TwoFactor  exportTwoFactor   `json:"two_factor"`
+	Passkeys   []Passkey         `json:"passkeys"`
+	Sessions   []exportedSession `json:"sessions"`
+}
+
+type exportIdentity struct {
+	Provider  string    `json:"provider"`
+	Email     string    `json:"email"`
+	CreatedAt time.Time `json:"created_at"`
+}
+
+type exportTwoFactor struct {
+	Enabled   bool       `json:"enabled"`
+	EnabledAt *time.Time `json:"enabled_at,omitempty"`
+}
+
+type exportedSession struct {
+	CreatedAt time.Time  `json:"created_at"`
+	RevokedAt *time.Time `json:"revoked_at,omitempty"`
+	MFA       bool       `json:"mfa"`
+}
+
+// Выгрузка персональных данных: GET /api/account/export. Секреты (хеши паролей,
+// ключи TOTP, токены) не выгружаются
+func (s *Server) exportAccountHandler(w http.ResponseWriter, r *http.Request) {
+	user, ok := s.currentUser(w, r)
+	if !ok {
+		return
+	}
+	export, err := s.exportAccount(r.Context(), user)
+	if err != nil {
+		http.Error(w, "Failed to export account", http.StatusInternalServerError)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("Content-Disposition", fmt.Sprintf(`attachment; filename="bookstore-account-%d.json"`, user.ID))
+	enc := json.NewEncoder(w)
+	enc.SetIndent("", "  ")
+	enc.Encode(export)
+}
+
+func (s *Server) exportAccount(ctx context.Context, user User) (accountExport, error) {
+	export := accountExport{
+		ExportedAt: time.Now().UTC(),
+		Account:    user,
+		Identities: []exportIdentity{},
+		Sessions:   []exportedSession{},
+	}
+
+	identities, err := s.users.ListIdentities(ctx, user.ID)
+	if err != nil {
+		return export, err
+	}
+	for _, identity := range identities {
+		export.Identities = append(export.Identities, exportIdentity{Provider: identity.Provider, Email: identity.Email, CreatedAt: identity.CreatedAt})
+	}
+
+	tf, err := s.users.GetTwoFactor(ctx, user.ID)
+	if err != nil && !errors.Is(err, ErrNotFound) {
+		return export, err
+	}
+	export.TwoFactor = exportTwoFactor{Enabled: tf.Enabled, EnabledAt: tf.EnabledAt}
+
+	if export.Passkeys, err = s.users.ListPasskeys(ctx, user.ID); err != nil {
+		return export, err
+	}
+	if export.Passkeys == nil {
+		export.Passkeys = []Passkey{}
+	}
+
+	sessions, err := s.tokens.ListUserSessions(ctx, user.ID)
+	if err != nil {
+		return export, err
+	}
+	for _, session := range sessions {
+		export.Sessions = append(export.Sessions, exportedSession{CreatedAt: session.CreatedAt, RevokedAt: session.RevokedAt, MFA: session.MFA})
+	}
+	return export, nil
+}
+
+// runSetRoleCommand меняет роль пользователя из командной строки. Регистрация
+// всегда создаёт роль user, так что администраторов назначают только так или
+// через role_claim провайдера OIDC. Сессии пользователя отзываются, чтобы
+// новая роль действовала со следующего входа
+func runSetRoleCommand(ctx context.Context, users UserRepository, tokens TokenRepository, args []string) error {
+	if len(args) != 2 || args[1] == "" {
+		return fmt.Errorf("usage: set-role <email> <role>")
+	}
+	user, err := users.GetByEmail(ctx, args[0])
+	if errors.Is(err, ErrNotFound) {
+		return fmt.Errorf("no user with email %q", args[0])
+	} else if err != nil {
+		return err
+	}
+	user.Role = args[1]
+	if err := users.Save(ctx, &user); err != nil {
+		return err
+	}
+	if err := tokens.RevokeUserSessions(ctx, user.ID, time.Now()); err != nil {
+		return err
+	}
+	fmt.Printf("✅ %s is now %s\n", user.Email, user.Role)
+	return nil
+}
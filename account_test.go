@@ -0,0 +1,225 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"regexp"
+	"testing"
+	"time"
+
+	"github.com/sirupsen/logrus/hooks/test"
+	"github.com/stretchr/testify/assert"
+)
+
+var changeEmailPattern = regexp.MustCompile(`/account/email/confirm\?token=([A-Za-z0-9_-]+)`)
+
+func TestUpdateAccountName(t *testing.T) {
+	t.Parallel()
+	s := newMemoryTestServer(t)
+	handler := s.routes()
+	pair := loginTestUser(t, s, handler)
+
+	rr := requestJSON(handler, "PATCH", "/api/account", pair.AccessToken, map[string]string{"name": "  Bilbo Baggins "})
+	assert.Equal(t, http.StatusOK, rr.Code, "Expected 200 OK")
+
+	rr = requestJSON(handler, "GET", "/api/account", pair.AccessToken, nil)
+	var account map[string]any
+	assert.NoError(t, json.NewDecoder(rr.Body).Decode(&account))
+	assert.Equal(t, "Bilbo Baggins", account["name"])
+	assert.Equal(t, "reader@example.com", account["email"])
+	assert.NotContains(t, account, "PasswordHash")
+
+	rr = requestJSON(handler, "PATCH", "/api/account", pair.AccessToken, map[string]string{"name": " "})
+	assert.Equal(t, http.StatusBadRequest, rr.Code, "Expected 400 for an empty name")
+}
+
+func TestChangeEmail(t *testing.T) {
+	t.Parallel()
+	s := newMemoryTestServer(t)
+	handler := s.routes()
+	pair := loginTestUser(t, s, handler)
+	mailer := s.mailer.(*fakeMailer)
+
+	rr := postJSON(handler, "/api/account/email", pair.AccessToken, map[string]string{"email": "new@example.com", "password": "wrong"})
+	assert.Equal(t, http.StatusForbidden, rr.Code, "Expected 403 without the current password")
+
+	rr = postJSON(handler, "/api/account/email", pair.AccessToken, map[string]string{"email": "new@example.com", "password": "secret123"})
+	assert.Equal(t, http.StatusAccepted, rr.Code, "Expected 202 Accepted")
+	token := mailedSecret(t, mailer, changeEmailPattern)
+	assert.Equal(t, []string{"new@example.com"}, mailer.Sent()[0].To)
+
+	// До подтверждения адрес не меняется
+	user, _ := s.users.GetByEmail(context.Background(), "reader@example.com")
+	assert.Equal(t, "new@example.com", user.PendingEmail)
+
+	req, _ := http.NewRequest("GET", "/account/email/confirm?token="+token, nil)
+	rr = httptest.NewRecorder()
+	handler.ServeHTTP(rr, req)
+	assert.Equal(t, http.StatusOK, rr.Code, "Expected 200 OK")
+
+	user, err := s.users.Get(context.Background(), user.ID)
+	assert.NoError(t, err)
+	assert.Equal(t, "new@example.com", user.Email)
+	assert.Empty(t, user.PendingEmail)
+	assert.Equal(t, http.StatusUnauthorized, getProfile(handler, pair.AccessToken), "Sessions end after an email change")
+
+	// Старый адрес получает уведомление
+	assert.Eventually(t, func() bool { return len(mailer.Sent()) == 2 }, time.Second, 10*time.Millisecond)
+	assert.Equal(t, []string{"reader@example.com"}, mailer.Sent()[1].To)
+
+	rr = httptest.NewRecorder()
+	handler.ServeHTTP(rr, req)
+	assert.Equal(t, http.StatusBadRequest, rr.Code, "The link works once")
+}
+
+func TestChangeEmailToTakenAddress(t *testing.T) {
+	t.Parallel()
+	s := newMemoryTestServer(t)
+	handler := s.routes()
+	pair := loginTestUser(t, s, handler)
+	assert.NoError(t, s.users.Create(context.Background(), &User{Email: "taken@example.com", Role: "user"}))
+
+	// Ответ тот же, что и для свободного адреса
+	rr := postJSON(handler, "/api/account/email", pair.AccessToken, map[string]string{"email": "taken@example.com", "password": "secret123"})
+	assert.Equal(t, http.StatusAccepted, rr.Code, "Expected 202 Accepted")
+	time.Sleep(50 * time.Millisecond)
+	assert.Empty(t, s.mailer.(*fakeMailer).Sent())
+}
+
+func TestChangePassword(t *testing.T) {
+	t.Parallel()
+	s := newMemoryTestServer(t)
+	handler := s.routes()
+	pair := loginTestUser(t, s, handler)
+
+	rr := postJSON(handler, "/api/account/password", pair.AccessToken, map[string]string{"current_password": "wrong", "new_password": "turn-the-page-42"})
+	assert.Equal(t, http.StatusForbidden, rr.Code, "Expected 403 for a wrong current password")
+	rr = postJSON(handler, "/api/account/password", pair.AccessToken, map[string]string{"current_password": "secret123", "new_password": "short"})
+	assert.Equal(t, http.StatusBadRequest, rr.Code, "Expected 400 for a weak password")
+
+	rr = postJSON(handler, "/api/account/password", pair.AccessToken, map[string]string{"current_password": "secret123", "new_password": "turn-the-page-42"})
+	assert.Equal(t, http.StatusOK, rr.Code, "Expected 200 OK")
+	var fresh tokenPair
+	assert.NoError(t, json.NewDecoder(rr.Body).Decode(&fresh))
+	assert.Equal(t, http.StatusUnauthorized, getProfile(handler, pair.AccessToken), "Other sessions end")
+	assert.Equal(t, http.StatusOK, getProfile(handler, fresh.AccessToken))
+
+	code, _ := login(handler, "reader@example.com", "turn-the-page-42")
+	assert.Equal(t, http.StatusOK, code)
+}
+
+func TestDeleteAccount(t *testing.T) {
+	t.Parallel()
+	s := newMemoryTestServer(t)
+	handler := s.routes()
+	pair := loginTestUser(t, s, handler)
+	ctx := context.Background()
+	user, _ := s.users.GetByEmail(ctx, "reader@example.com")
+	assert.NoError(t, s.users.CreateIdentity(ctx, &UserIdentity{UserID: user.ID, Provider: "google", Subject: "g-1"}))
+	assert.NoError(t, s.users.CreatePasskey(ctx, &Passkey{UserID: user.ID, CredentialID: []byte("cred")}))
+
+	rr := requestJSON(handler, "DELETE", "/api/account", pair.AccessToken, map[string]string{"password": "wrong"})
+	assert.Equal(t, http.StatusForbidden, rr.Code, "Expected 403 without the password")
+
+	rr = requestJSON(handler, "DELETE", "/api/account", pair.AccessToken, map[string]string{"password": "secret123"})
+	assert.Equal(t, http.StatusNoContent, rr.Code, "Expected 204 No Content")
+
+	_, err := s.users.Get(ctx, user.ID)
+	assert.ErrorIs(t, err, ErrNotFound)
+	_, err = s.users.GetIdentity(ctx, "google", "g-1")
+	assert.ErrorIs(t, err, ErrNotFound)
+	_, err = s.users.GetPasskeyByCredentialID(ctx, []byte("cred"))
+	assert.ErrorIs(t, err, ErrNotFound)
+	sessions, _ := s.tokens.ListUserSessions(ctx, user.ID)
+	assert.Empty(t, sessions)
+	assert.Equal(t, http.StatusUnauthorized, getProfile(handler, pair.AccessToken))
+	rr = postJSON(handler, "/token/refresh", "", map[string]string{"refresh_token": pair.RefreshToken})
+	assert.Equal(t, http.StatusUnauthorized, rr.Code, "Refresh tokens are erased too")
+}
+
+func TestErasedEmailNotLogged(t *testing.T) {
+	t.Parallel()
+	s := newMemoryTestServer(t)
+	hook := test.NewLocal(s.logger)
+	handler := s.routes()
+	pair := loginTestUser(t, s, handler)
+
+	assert.Equal(t, http.StatusOK, postJSON(handler, "/logout", pair.AccessToken, map[string]string{"refresh_token": pair.RefreshToken}).Code)
+	rr := postJSON(handler, "/login", "", map[string]string{"email": "reader@example.com", "password": "secret123"})
+	assert.NoError(t, json.NewDecoder(rr.Body).Decode(&pair))
+	rr = requestJSON(handler, "DELETE", "/api/account", pair.AccessToken, map[string]string{"password": "secret123"})
+	assert.Equal(t, http.StatusNoContent, rr.Code)
+
+	// Поля записей попадают в log_entries и переживают удаление аккаунта
+	assert.NotEmpty(t, hook.AllEntries())
+	for _, entry := range hook.AllEntries() {
+		assert.NotContains(t, entry.Message, "reader@example.com")
+		for key, value := range entry.Data {
+			assert.NotContains(t, fmt.Sprint(value), "reader@example.com", "%s of %q", key, entry.Message)
+		}
+	}
+}
+
+// failingUserDataTokens не может удалить данные пользователя
+type failingUserDataTokens struct {
+	*memoryTokenRepository
+}
+
+func (failingUserDataTokens) DeleteUserData(ctx context.Context, userID uint) error {
+	return errors.New("connection reset")
+}
+
+func TestDeleteAccountWhenTokenCleanupFails(t *testing.T) {
+	t.Parallel()
+	tokens := failingUserDataTokens{newMemoryTokenRepository()}
+	s := newConfigTestServer(t, testConfig(), tokens)
+	handler := s.routes()
+	pair := loginTestUser(t, s, handler)
+	user, _ := s.users.GetByEmail(context.Background(), "reader@example.com")
+
+	rr := requestJSON(handler, "DELETE", "/api/account", pair.AccessToken, map[string]string{"password": "secret123"})
+	assert.Equal(t, http.StatusNoContent, rr.Code, "The account is erased even if leftover tokens have to wait for the purge")
+	_, err := s.users.Get(context.Background(), user.ID)
+	assert.ErrorIs(t, err, ErrNotFound)
+	assert.Equal(t, http.StatusUnauthorized, getProfile(handler, pair.AccessToken), "Sessions are revoked before anything is deleted")
+	rr = postJSON(handler, "/token/refresh", "", map[string]string{"refresh_token": pair.RefreshToken})
+	assert.Equal(t, http.StatusUnauthorized, rr.Code)
+}
+
+func TestExportAccount(t *testing.T) {
+	t.Parallel()
+	s := newMemoryTestServer(t)
+	handler := s.routes()
+	pair := loginTestUser(t, s, handler)
+
+	rr := requestJSON(handler, "GET", "/api/account/export", pair.AccessToken, nil)
+	assert.Equal(t, http.StatusOK, rr.Code, "Expected 200 OK")
+	assert.Contains(t, rr.Header().Get("Content-Disposition"), "attachment")
+	assert.NotContains(t, rr.Body.String(), "$2a$", "Password hash must not be exported")
+
+	var export accountExport
+	assert.NoError(t, json.NewDecoder(rr.Body).Decode(&export))
+	assert.Equal(t, "reader@example.com", export.Account.Email)
+	assert.Len(t, export.Sessions, 1)
+	assert.False(t, export.TwoFactor.Enabled)
+}
+
+func TestSetRoleCommand(t *testing.T) {
+	t.Parallel()
+	s := newMemoryTestServer(t)
+	handler := s.routes()
+	pair := loginTestUser(t, s, handler)
+	ctx := context.Background()
+
+	assert.Error(t, runSetRoleCommand(ctx, s.users, s.tokens, []string{"reader@example.com"}))
+	assert.ErrorContains(t, runSetRoleCommand(ctx, s.users, s.tokens, []string{"nobody@example.com", "admin"}), "no user")
+
+	assert.NoError(t, runSetRoleCommand(ctx, s.users, s.tokens, []string{"reader@example.com", "admin"}))
+	user, _ := s.users.GetByEmail(ctx, "reader@example.com")
+	assert.Equal(t, "admin", user.Role)
+	assert.Equal(t, http.StatusUnauthorized, getProfile(handler, pair.AccessToken), "Sessions with the old role are revoked")
+}
@@ -0,0 +1,285 @@
+package main
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/subtle"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/sirupsen/logrus"
+)
+
+// API-ключи для скриптов и партнёрских маркетплейсов: вызывают API без входа
+// человека. Ключ выпускает администратор, в базе хранится только SHA-256 хеш
+// и открытый префикс для поиска. Ключ передаётся как обычный Bearer-токен:
+// Authorization: Bearer bk_<prefix>_<secret>.
+
+const apiKeyPrefix = "bk_"
+
+// Области доступа ключей
+const (
+	scopeCatalogRead  = "catalog:read"
+	scopeCatalogWrite = "catalog:write"
+)
+
+// Области заказов появятся вместе с маршрутами заказов: область без маршрута ничего не даёт
+var apiKeyScopes = []string{scopeCatalogRead, scopeCatalogWrite}
+
+// apiKeyTouchInterval — как часто записывать время последнего использования ключа
+const apiKeyTouchInterval = time.Minute
+
+// APIKey — выпущенный ключ; сам ключ показывается один раз при выпуске
+type APIKey struct {
+	ID         uint       `gorm:"primaryKey" json:"id"`
+	Name       string     `json:"name"`
+	Prefix     string     `gorm:"uniqueIndex" json:"prefix"`
+	KeyHash    string     `json:"-"`
+	Scopes     string     `json:"-"` // через пробел, как scope в OAuth
+	CreatedBy  uint       `json:"created_by"`
+	CreatedAt  time.Time  `json:"created_at"`
+	ExpiresAt  *time.Time `json:"expires_at"`
+	LastUsedAt *time.Time `json:"last_used_at"`
+	RevokedAt  *time.Time `json:"revoked_at"`
+}
+
+func (k APIKey) hasScope(scope string) bool {
+	return slices.Contains(strings.Fields(k.Scopes), scope)
+}
+
+type apiKeyResponse struct {
+	APIKey
+	Scopes []string `json:"scopes"`
+	Key    string   `json:"key,omitempty"`
+}
+
+func newAPIKeyResponse(key APIKey) apiKeyResponse {
+	return apiKeyResponse{APIKey: key, Scopes: strings.Fields(key.Scopes)}
+}
+
+var errInvalidAPIKey = errors.New("invalid, expired or revoked API key")
+
+// isAPIKey отличает API-ключ от JWT в заголовке Authorization
+func isAPIKey(token string) bool {
+	return strings.HasPrefix(token, apiKeyPrefix)
+}
+
+// generateAPIKey возвращает открытый префикс и полный ключ
+func generateAPIKey() (string, string, error) {
+	b := make([]byte, 4)
+	if _, err := rand.Read(b); err != nil {
+		return "", "", err
+	}
+	prefix := hex.EncodeToString(b)
+	secret, err := randomToken(24)
+	if err != nil {
+		return "", "", err
+	}
+	return prefix, apiKeyPrefix + prefix + "_" + secret, nil
+}
+
+// lookupAPIKey находит действующий ключ; errInvalidAPIKey — для чужого, отозванного или истёкшего
+func (s *Server) lookupAPIKey(ctx context.Context, raw string) (APIKey, error) {
+	prefix, _, ok := strings.Cut(strings.TrimPrefix(raw, apiKeyPrefix), "_")
+	if !ok || prefix == "" {
+		return APIKey{}, errInvalidAPIKey
+	}
+	key, err := s.tokens.GetAPIKeyByPrefix(ctx, prefix)
+	if errors.Is(err, ErrNotFound) {
+		return APIKey{}, errInvalidAPIKey
+	}
+	if err != nil {
+		return APIKey{}, err
+	}
+	if subtle.ConstantTimeCompare([]byte(hashToken(raw)), []byte(key.KeyHash)) != 1 {
+		return APIKey{}, errInvalidAPIKey
+	}
+	if key.RevokedAt != nil || (key.ExpiresAt != nil && !time.Now().Before(*key.ExpiresAt)) {
+		return APIKey{}, errInvalidAPIKey
+	}
+	return key, nil
+}
+
+type apiKeyScopeKey struct{}
+
+// requireScope защищает маршрут для интеграций: пускает API-ключ с областью scope
+// или администратора, вошедшего со вторым фактором
+func (s *Server) requireScope(scope string, next http.Handler) http.Handler {
+	protected := s.authMiddleware(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Context().Value("user").(*Claims).APIKeyID != 0 {
+			next.ServeHTTP(w, r)
+			return
+		}
+		s.requireRole("admin", next).ServeHTTP(w, r)
+	}))
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		ctx := context.WithValue(r.Context(), apiKeyScopeKey{}, scope)
+		protected.ServeHTTP(w, r.WithContext(ctx))
+	})
+}
+
+// allowAPIKey — для открытых маршрутов: без ключа запрос проходит как раньше,
+// а ключ, если он передан, должен быть действующим и иметь область scope
+func (s *Server) allowAPIKey(scope string, next http.Handler) http.Handler {
+	protected := s.requireScope(scope, next)
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if token, ok := strings.CutPrefix(r.Header.Get("Authorization"), "Bearer "); ok && isAPIKey(token) {
+			protected.ServeHTTP(w, r)
+			return
+		}
+		next.ServeHTTP(w, r)
+	})
+}
+
+// apiKeyAuth — ветка authMiddleware для API-ключей. Ключи принимаются только
+// маршрутами, обёрнутыми в requireScope: личный кабинет и админка им закрыты
+func (s *Server) apiKeyAuth(w http.ResponseWriter, r *http.Request, raw string, next http.Handler) {
+	ctx := r.Context()
+	scope, ok := ctx.Value(apiKeyScopeKey{}).(string)
+	if !ok {
+		http.Error(w, "Unauthorized: API keys are not accepted here", http.StatusUnauthorized)
+		return
+	}
+	key, err := s.resolveAPIKey(ctx, raw)
+	if errors.Is(err, errInvalidAPIKey) {
+		http.Error(w, "Unauthorized: Invalid API key", http.StatusUnauthorized)
+		return
+	}
+	if err != nil {
+		s.log(ctx).WithError(err).Error("Failed to check API key")
+		http.Error(w, "Internal server error", http.StatusInternalServerError)
+		return
+	}
+	if !key.hasScope(scope) {
+		s.log(ctx).WithFields(logrus.Fields{
+			"api_key": key.Prefix,
+			"scope":   scope,
+			"path":    r.URL.Path,
+		}).Warn("API key used outside its scopes")
+		http.Error(w, "Forbidden: API key lacks the "+scope+" scope", http.StatusForbidden)
+		return
+	}
+
+	now := time.Now()
+	if key.LastUsedAt == nil || now.Sub(*key.LastUsedAt) >= apiKeyTouchInterval {
+		if err := s.tokens.TouchAPIKey(ctx, key.ID, now); err != nil {
+			s.log(ctx).WithError(err).Error("Failed to update API key usage")
+		}
+	}
+	next.ServeHTTP(w, withClaims(r, &Claims{APIKeyID: key.ID}))
+}
+
+// Выпуск ключа: POST /api/admin/api-keys {"name": "...", "scopes": ["catalog:write"], "expires_at": "..."}.
+// Ключ целиком есть только в этом ответе
+func (s *Server) createAPIKeyHandler(w http.ResponseWriter, r *http.Request) {
+	claims := r.Context().Value("user").(*Claims)
+	var req struct {
+		Name      string     `json:"name"`
+		Scopes    []string   `json:"scopes"`
+		ExpiresAt *time.Time `json:"expires_at"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, "Invalid request", http.StatusBadRequest)
+		return
+	}
+	req.Name = strings.TrimSpace(req.Name)
+	if req.Name == "" || len(req.Name) > 100 {
+		http.Error(w, "Name is required and must be at most 100 characters", http.StatusBadRequest)
+		return
+	}
+	if len(req.Scopes) == 0 {
+		http.Error(w, "At least one scope is required", http.StatusBadRequest)
+		return
+	}
+	for _, scope := range req.Scopes {
+		if !slices.Contains(apiKeyScopes, scope) {
+			http.Error(w, fmt.Sprintf("Unknown scope %q, expected one of %s", scope, strings.Join(apiKeyScopes, ", ")), http.StatusBadRequest)
+			return
+		}
+	}
+	slices.Sort(req.Scopes)
+	if req.ExpiresAt != nil && !req.ExpiresAt.After(time.Now()) {
+		http.Error(w, "expires_at must be in the future", http.StatusBadRequest)
+		return
+	}
+
+	prefix, raw, err := generateAPIKey()
+	if err != nil {
+		http.Error(w, "Failed to create API key", http.StatusInternalServerError)
+		return
+	}
+	key := APIKey{
+		Name:      req.Name,
+		Prefix:    prefix,
+		KeyHash:   hashToken(raw),
+		Scopes:    strings.Join(slices.Compact(req.Scopes), " "),
+		CreatedBy: claimsUserID(claims),
+		ExpiresAt: req.ExpiresAt,
+	}
+	if err := s.tokens.CreateAPIKey(r.Context(), &key); err != nil {
+		s.log(r.Context()).WithError(err).Error("Failed to store API key")
+		http.Error(w, "Failed to create API key", http.StatusInternalServerError)
+		return
+	}
+
+	s.log(r.Context()).WithFields(logrus.Fields{
+		"action":  "create_api_key",
+		"user_id": key.CreatedBy,
+		"key_id":  key.ID,
+		"api_key": key.Prefix,
+		"scopes":  key.Scopes,
+	}).Info("API key issued")
+
+	response := newAPIKeyResponse(key)
+	response.Key = raw
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusCreated)
+	json.NewEncoder(w).Encode(response)
+}
+
+// Список ключей: GET /api/admin/api-keys
+func (s *Server) listAPIKeysHandler(w http.ResponseWriter, r *http.Request) {
+	keys, err := s.tokens.ListAPIKeys(r.Context())
+	if err != nil {
+		http.Error(w, "Failed to load API keys", http.StatusInternalServerError)
+		return
+	}
+	response := make([]apiKeyResponse, 0, len(keys))
+	for _, key := range keys {
+		response = append(response, newAPIKeyResponse(key))
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(response)
+}
+
+// Отзыв ключа: DELETE /api/admin/api-keys/{id}
+func (s *Server) revokeAPIKeyHandler(w http.ResponseWriter, r *http.Request) {
+	claims := r.Context().Value("user").(*Claims)
+	id, err := strconv.ParseUint(r.PathValue("id"), 10, 64)
+	if err != nil {
+		http.Error(w, "Invalid API key ID", http.StatusBadRequest)
+		return
+	}
+	if err := s.tokens.RevokeAPIKey(r.Context(), uint(id), time.Now()); err != nil {
+		if errors.Is(err, ErrNotFound) {
+			http.Error(w, "API key not found", http.StatusNotFound)
+			return
+		}
+		http.Error(w, "Failed to revoke API key", http.StatusInternalServerError)
+		return
+	}
+
+	s.log(r.Context()).WithFields(logrus.Fields{
+		"action":  "revoke_api_key",
+		"user_id": claimsUserID(claims),
+		"key_id":  id,
+	}).Info("API key revoked")
+	w.WriteHeader(http.StatusNoContent)
+}
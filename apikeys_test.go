@@ -0,0 +1,182 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// adminAccessToken выпускает access-токен администратора, вошедшего со вторым фактором
+func adminAccessToken(t *testing.T, s *Server) string {
+	t.Helper()
+	admin := User{Email: "admin@example.com", Role: "admin", Confirmed: true}
+	assert.NoError(t, s.users.Create(context.Background(), &admin))
+	token, err := s.generateJWT(context.Background(), admin, Session{MFA: true})
+	assert.NoError(t, err)
+	return token
+}
+
+func issueAPIKey(t *testing.T, handler http.Handler, adminToken string, body map[string]any) apiKeyResponse {
+	t.Helper()
+	rr := postJSON(handler, "/api/admin/api-keys", adminToken, body)
+	assert.Equal(t, http.StatusCreated, rr.Code, rr.Body.String())
+	var key apiKeyResponse
+	assert.NoError(t, json.NewDecoder(rr.Body).Decode(&key))
+	return key
+}
+
+func withAPIKey(handler http.Handler, method, path, key string, body string) *httptest.ResponseRecorder {
+	req, _ := http.NewRequest(method, path, strings.NewReader(body))
+	req.Header.Set("Content-Type", "application/json")
+	if key != "" {
+		req.Header.Set("Authorization", "Bearer "+key)
+	}
+	rr := httptest.NewRecorder()
+	handler.ServeHTTP(rr, req)
+	return rr
+}
+
+const apiTestBook = `{"title":"Partner Book","author":"Author P","published":"2024","price":12.5,"stock":3}`
+
+func TestAPIKeyLifecycle(t *testing.T) {
+	t.Parallel()
+	s := newMemoryTestServer(t)
+	handler := s.routes()
+	admin := adminAccessToken(t, s)
+
+	key := issueAPIKey(t, handler, admin, map[string]any{"name": "Stock sync", "scopes": []string{"catalog:write", "catalog:read"}})
+	assert.True(t, strings.HasPrefix(key.Key, "bk_"+key.Prefix+"_"), "The key starts with its public prefix")
+	assert.Equal(t, []string{"catalog:read", "catalog:write"}, key.Scopes)
+	stored, _ := s.tokens.GetAPIKeyByPrefix(context.Background(), key.Prefix)
+	assert.Equal(t, hashToken(key.Key), stored.KeyHash, "Only the hash is stored")
+
+	rr := withAPIKey(handler, "POST", "/books/add", key.Key, apiTestBook)
+	assert.Equal(t, http.StatusCreated, rr.Code, "Expected 201 Created")
+	rr = withAPIKey(handler, "GET", "/books", key.Key, "")
+	assert.Equal(t, http.StatusOK, rr.Code)
+
+	// Ключ не открывает личный кабинет и админку
+	assert.Equal(t, http.StatusUnauthorized, withAPIKey(handler, "GET", "/api/profile", key.Key, "").Code)
+	assert.Equal(t, http.StatusUnauthorized, withAPIKey(handler, "GET", "/api/admin/api-keys", key.Key, "").Code)
+
+	req, _ := http.NewRequest("GET", "/api/admin/api-keys", nil)
+	req.Header.Set("Authorization", "Bearer "+admin)
+	rr = httptest.NewRecorder()
+	handler.ServeHTTP(rr, req)
+	assert.Equal(t, http.StatusOK, rr.Code)
+	assert.NotContains(t, rr.Body.String(), key.Key)
+	var listed []apiKeyResponse
+	assert.NoError(t, json.NewDecoder(rr.Body).Decode(&listed))
+	if assert.Len(t, listed, 1) {
+		assert.Equal(t, "Stock sync", listed[0].Name)
+		assert.NotNil(t, listed[0].LastUsedAt, "Usage is tracked")
+	}
+
+	req, _ = http.NewRequest("DELETE", fmt.Sprintf("/api/admin/api-keys/%d", key.ID), nil)
+	req.Header.Set("Authorization", "Bearer "+admin)
+	rr = httptest.NewRecorder()
+	handler.ServeHTTP(rr, req)
+	assert.Equal(t, http.StatusNoContent, rr.Code)
+	rr = withAPIKey(handler, "DELETE", "/books/delete?id=1", key.Key, "")
+	assert.Equal(t, http.StatusUnauthorized, rr.Code, "A revoked key stops working")
+
+	req, _ = http.NewRequest("DELETE", "/api/admin/api-keys/999", nil)
+	req.Header.Set("Authorization", "Bearer "+admin)
+	rr = httptest.NewRecorder()
+	handler.ServeHTTP(rr, req)
+	assert.Equal(t, http.StatusNotFound, rr.Code)
+}
+
+func TestAPIKeyScopesAndExpiry(t *testing.T) {
+	t.Parallel()
+	s := newMemoryTestServer(t)
+	handler := s.routes()
+	admin := adminAccessToken(t, s)
+
+	reader := issueAPIKey(t, handler, admin, map[string]any{"name": "Marketplace feed", "scopes": []string{"catalog:read"}})
+	rr := withAPIKey(handler, "POST", "/books/add", reader.Key, apiTestBook)
+	assert.Equal(t, http.StatusForbidden, rr.Code, "A read-only key cannot change the catalogue")
+	assert.Contains(t, rr.Body.String(), "catalog:write")
+	assert.NotEqual(t, http.StatusUnauthorized, withAPIKey(handler, "GET", "/books/search?id=1", reader.Key, "").Code)
+
+	forged := reader.Key[:len(reader.Key)-4] + "abcd"
+	assert.Equal(t, http.StatusUnauthorized, withAPIKey(handler, "GET", "/books", forged, "").Code, "A wrong secret is rejected even on public routes")
+	assert.Equal(t, http.StatusUnauthorized, withAPIKey(handler, "GET", "/books", "bk_nonsense", "").Code)
+
+	expires := time.Now().Add(time.Hour)
+	writer := issueAPIKey(t, handler, admin, map[string]any{"name": "Import", "scopes": []string{"catalog:write"}, "expires_at": expires})
+	rr = withAPIKey(handler, "POST", "/books/add", writer.Key, apiTestBook)
+	assert.Equal(t, http.StatusCreated, rr.Code)
+	var added Book
+	assert.NoError(t, json.NewDecoder(rr.Body).Decode(&added))
+	rr = withAPIKey(handler, "PUT", "/books/update", writer.Key, fmt.Sprintf(`{"id":%d,"stock":7}`, added.ID))
+	assert.Equal(t, http.StatusOK, rr.Code, "Stock is synchronised with the catalog:write scope")
+	stored, _ := s.books.Get(context.Background(), added.ID)
+	assert.Equal(t, 7, stored.Stock)
+	assert.Equal(t, "Partner Book", stored.Title)
+	past := time.Now().Add(-time.Minute)
+	assert.NoError(t, s.tokens.CreateAPIKey(context.Background(), &APIKey{Prefix: "0a1b2c3d", KeyHash: hashToken("bk_0a1b2c3d_secret"), Scopes: "catalog:write", ExpiresAt: &past}))
+	assert.Equal(t, http.StatusUnauthorized, withAPIKey(handler, "POST", "/books/add", "bk_0a1b2c3d_secret", apiTestBook).Code, "Expired keys are rejected")
+
+	// Людям менять каталог можно только администраторам
+	assert.Equal(t, http.StatusUnauthorized, withAPIKey(handler, "POST", "/books/add", "", apiTestBook).Code)
+	user := loginTestUser(t, s, handler)
+	assert.Equal(t, http.StatusForbidden, withAPIKey(handler, "POST", "/books/add", user.AccessToken, apiTestBook).Code)
+	assert.Equal(t, http.StatusForbidden, postJSON(handler, "/api/admin/api-keys", user.AccessToken, map[string]any{"name": "x", "scopes": []string{"catalog:read"}}).Code)
+
+	for _, body := range []map[string]any{
+		{"name": "", "scopes": []string{"catalog:read"}},
+		{"name": "No scopes"},
+		{"name": "Bad scope", "scopes": []string{"admin"}},
+		{"name": "No order routes yet", "scopes": []string{"orders"}},
+		{"name": "Expired", "scopes": []string{"catalog:read"}, "expires_at": past},
+	} {
+		assert.Equal(t, http.StatusBadRequest, postJSON(handler, "/api/admin/api-keys", admin, body).Code, body["name"])
+	}
+}
+
+func TestAPIKeyRateLimitKey(t *testing.T) {
+	t.Parallel()
+	s := newMemoryTestServer(t)
+	key := issueAPIKey(t, s.routes(), adminAccessToken(t, s), map[string]any{"name": "Feed", "scopes": []string{"catalog:read"}})
+
+	req, _ := http.NewRequest("GET", "/books", nil)
+	req.RemoteAddr = "203.0.113.1:1234"
+	req.Header.Set("Authorization", "Bearer "+key.Key)
+	assert.Equal(t, "apikey:"+key.Prefix, s.rateLimitKey(httptest.NewRecorder(), req))
+
+	// Выдуманный ключ не даёт отдельного лимита
+	req.Header.Set("Authorization", "Bearer bk_"+key.Prefix+"_forged")
+	assert.Equal(t, "ip:203.0.113.1", s.rateLimitKey(httptest.NewRecorder(), req))
+}
+
+// countingAPIKeys считает обращения к базе за API-ключом
+type countingAPIKeys struct {
+	*memoryTokenRepository
+	lookups atomic.Int32
+}
+
+func (r *countingAPIKeys) GetAPIKeyByPrefix(ctx context.Context, prefix string) (APIKey, error) {
+	r.lookups.Add(1)
+	return r.memoryTokenRepository.GetAPIKeyByPrefix(ctx, prefix)
+}
+
+func TestAPIKeyLookedUpOncePerRequest(t *testing.T) {
+	t.Parallel()
+	tokens := &countingAPIKeys{memoryTokenRepository: newMemoryTokenRepository()}
+	s := newConfigTestServer(t, testConfig(), tokens)
+	handler := s.routes()
+	key := issueAPIKey(t, handler, adminAccessToken(t, s), map[string]any{"name": "Feed", "scopes": []string{"catalog:write"}})
+
+	rr := withAPIKey(handler, "DELETE", "/books/delete?id=1", key.Key, "")
+	assert.Equal(t, http.StatusNoContent, rr.Code, rr.Body.String())
+	assert.EqualValues(t, 1, tokens.lookups.Load(), "The rate limiter and authentication share one lookup")
+}
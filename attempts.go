@@ -0,0 +1,127 @@
+package main
+
+import (
+	"fmt"
+	"net"
+	"net/http"
+	"net/netip"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+)
+
+// attemptLimiter считает неудачные попытки по ключу (IP, email) в скользящем окне
+// и блокирует ключ, когда их набирается max
+type attemptLimiter struct {
+	max    int
+	window time.Duration
+	now    func() time.Time
+
+	mu        sync.Mutex
+	failures  map[string][]time.Time
+	lastSweep time.Time
+}
+
+func newAttemptLimiter(max int, window time.Duration) *attemptLimiter {
+	return &attemptLimiter{max: max, window: window, now: time.Now, failures: map[string][]time.Time{}}
+}
+
+// recent убирает устаревшие попытки; вызывается под l.mu
+func (l *attemptLimiter) recent(key string) []time.Time {
+	cutoff := l.now().Add(-l.window)
+	kept := l.failures[key][:0]
+	for _, at := range l.failures[key] {
+		if at.After(cutoff) {
+			kept = append(kept, at)
+		}
+	}
+	if len(kept) == 0 {
+		delete(l.failures, key)
+		return nil
+	}
+	l.failures[key] = kept
+	return kept
+}
+
+// Allow сообщает, можно ли ещё пробовать
+func (l *attemptLimiter) Allow(key string) bool {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	return len(l.recent(key)) < l.max
+}
+
+// Fail записывает неудачную попытку
+func (l *attemptLimiter) Fail(key string) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if now := l.now(); now.Sub(l.lastSweep) >= l.window {
+		l.sweep(now)
+	}
+	l.failures[key] = append(l.recent(key), l.now())
+}
+
+// sweep удаляет ключи, у которых все попытки вышли из окна; вызывается под l.mu
+func (l *attemptLimiter) sweep(now time.Time) {
+	cutoff := now.Add(-l.window)
+	for key, attempts := range l.failures {
+		if !attempts[len(attempts)-1].After(cutoff) {
+			delete(l.failures, key)
+		}
+	}
+	l.lastSweep = now
+}
+
+// Reset забывает попытки после успеха
+func (l *attemptLimiter) Reset(key string) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	delete(l.failures, key)
+}
+
+// parseTrustedProxies разбирает TRUSTED_PROXIES: подсети и отдельные адреса
+func parseTrustedProxies(entries []string) ([]netip.Prefix, error) {
+	var prefixes []netip.Prefix
+	for _, entry := range entries {
+		if prefix, err := netip.ParsePrefix(entry); err == nil {
+			prefixes = append(prefixes, prefix.Masked())
+			continue
+		}
+		addr, err := netip.ParseAddr(entry)
+		if err != nil {
+			return nil, fmt.Errorf("TRUSTED_PROXIES must contain IP addresses or CIDR ranges, got %q", entry)
+		}
+		prefixes = append(prefixes, netip.PrefixFrom(addr.Unmap(), addr.Unmap().BitLen()))
+	}
+	return prefixes, nil
+}
+
+func (s *Server) trustedProxy(addr netip.Addr) bool {
+	return slices.ContainsFunc(s.trustedProxies, func(p netip.Prefix) bool { return p.Contains(addr) })
+}
+
+// clientIP — адрес клиента без порта. Если соединение пришло от доверенного
+// прокси, адрес берётся из X-Forwarded-For справа налево до первого
+// недоверенного: записи левее него мог дописать сам клиент
+func (s *Server) clientIP(r *http.Request) string {
+	host, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		host = r.RemoteAddr
+	}
+	addr, err := netip.ParseAddr(host)
+	if err != nil || !s.trustedProxy(addr.Unmap()) {
+		return host
+	}
+	hops := strings.Split(strings.Join(r.Header.Values("X-Forwarded-For"), ","), ",")
+	for i := len(hops) - 1; i >= 0; i-- {
+		hop, err := netip.ParseAddr(strings.TrimSpace(hops[i]))
+		if err != nil {
+			break
+		}
+		addr = hop.Unmap()
+		if !s.trustedProxy(addr) {
+			break
+		}
+	}
+	return addr.Unmap().String()
+}
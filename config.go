@@ -0,0 +1,670 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"net/url"
+	"os"
+	"regexp"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/joho/godotenv"
+	"github.com/redis/go-redis/v9"
+	"gopkg.in/yaml.v3"
+)
+
+// Secret — строка, которая не попадает в логи и JSON в открытом виде
+type Secret string
+
+const redacted = "[REDACTED]"
+
+func (s Secret) String() string {
+	if s == "" {
+		return ""
+	}
+	return redacted
+}
+
+func (s Secret) MarshalJSON() ([]byte, error) {
+	return []byte(strconv.Quote(s.String())), nil
+}
+
+// Value возвращает настоящее значение секрета
+func (s Secret) Value() string {
+	return string(s)
+}
+
+type DatabaseConfig struct {
+	// DSN: путь к файлу SQLite (или sqlite://...) либо postgres://...
+	DSN             Secret        `yaml:"dsn" json:"dsn"`
+	MaxOpenConns    int           `yaml:"max_open_conns" json:"max_open_conns"`
+	MaxIdleConns    int           `yaml:"max_idle_conns" json:"max_idle_conns"`
+	ConnMaxLifetime time.Duration `yaml:"conn_max_lifetime" json:"conn_max_lifetime"`
+	ConnMaxIdleTime time.Duration `yaml:"conn_max_idle_time" json:"conn_max_idle_time"`
+}
+
+type JWTConfig struct {
+	// Secret шифрует закрытые ключи подписи в базе
+	Secret      Secret        `yaml:"secret" json:"secret"`
+	Algorithm   string        `yaml:"algorithm" json:"algorithm"`
+	Issuer      string        `yaml:"issuer" json:"issuer"`
+	Audience    string        `yaml:"audience" json:"audience"`
+	KeyRotation time.Duration `yaml:"key_rotation" json:"key_rotation"`
+	AccessTTL   time.Duration `yaml:"access_ttl" json:"access_ttl"`
+	RefreshTTL  time.Duration `yaml:"refresh_ttl" json:"refresh_ttl"`
+}
+
+type SMTPConfig struct {
+	Host     string `yaml:"host" json:"host"`
+	Port     int    `yaml:"port" json:"port"`
+	Username string `yaml:"username" json:"username"`
+	Password Secret `yaml:"password" json:"password"`
+	From     string `yaml:"from" json:"from"`
+}
+
+type GoogleConfig struct {
+	ClientID     string `yaml:"client_id" json:"client_id"`
+	ClientSecret Secret `yaml:"client_secret" json:"client_secret"`
+	RedirectURL  string `yaml:"redirect_url" json:"redirect_url"`
+	Issuer       string `yaml:"issuer" json:"issuer"`
+	// TrustEmail разрешает привязывать вход к существующей учётной записи по email
+	TrustEmail bool `yaml:"trust_email" json:"trust_email"`
+}
+
+// RateLimitConfig — лимиты на клиента (пользователя или IP): RPS и Burst
+// действуют для всех маршрутов, кроме входа (Auth) и каталога (Books)
+type RateLimitConfig struct {
+	RPS   float64       `yaml:"rps" json:"rps"`
+	Burst int           `yaml:"burst" json:"burst"`
+	Auth  RateLimitRule `yaml:"auth" json:"auth"`
+	Books RateLimitRule `yaml:"books" json:"books"`
+	// IdleTTL — через сколько простоя ведро клиента забывается
+	IdleTTL time.Duration `yaml:"idle_ttl" json:"idle_ttl"`
+	// Backend: memory — счётчики в процессе, redis — общие для всех экземпляров
+	Backend  string `yaml:"backend" json:"backend"`
+	RedisURL Secret `yaml:"redis_url" json:"redis_url"`
+}
+
+type RateLimitRule struct {
+	RPS   float64 `yaml:"rps" json:"rps"`
+	Burst int     `yaml:"burst" json:"burst"`
+}
+
+// OIDCProviderConfig — провайдер единого входа (например, корпоративный IdP)
+type OIDCProviderConfig struct {
+	Name         string   `yaml:"name" json:"name"` // часть пути /auth/{name}/login
+	Label        string   `yaml:"label" json:"label"`
+	Issuer       string   `yaml:"issuer" json:"issuer"`
+	ClientID     string   `yaml:"client_id" json:"client_id"`
+	ClientSecret Secret   `yaml:"client_secret" json:"client_secret"`
+	RedirectURL  string   `yaml:"redirect_url" json:"redirect_url"`
+	Scopes       []string `yaml:"scopes" json:"scopes"`
+	// RoleClaim — claim ID-токена с группами или ролями, допускается путь через точку
+	RoleClaim   string            `yaml:"role_claim" json:"role_claim"`
+	RoleMapping []OIDCRoleMapping `yaml:"role_mapping" json:"role_mapping"`
+	DefaultRole string            `yaml:"default_role" json:"default_role"`
+	// TrustEmail — провайдер сам подтверждает владение почтой, и его вход можно
+	// привязать к существующей учётной записи по email. Без него учётную запись
+	// привязывает только вошедший владелец (POST /api/auth/{name}/link)
+	TrustEmail bool `yaml:"trust_email" json:"trust_email"`
+}
+
+// OIDCRoleMapping: если RoleClaim содержит Value, пользователь получает Role; первое совпадение побеждает
+type OIDCRoleMapping struct {
+	Value string `yaml:"value" json:"value"`
+	Role  string `yaml:"role" json:"role"`
+}
+
+var oidcProviderName = regexp.MustCompile(`^[a-z0-9][a-z0-9-]*$`)
+
+// WebAuthnConfig — relying party для passkeys; пустые RPID и Origins берутся из SITE_URL
+type WebAuthnConfig struct {
+	RPID    string   `yaml:"rp_id" json:"rp_id"`
+	RPName  string   `yaml:"rp_name" json:"rp_name"`
+	Origins []string `yaml:"origins" json:"origins"`
+}
+
+// CORSConfig — каким чужим сайтам можно обращаться к API из браузера.
+// Пустой AllowedOrigins — только свой сайт; "*" — любой, но без credentials
+type CORSConfig struct {
+	AllowedOrigins []string `yaml:"allowed_origins" json:"allowed_origins"`
+	// AllowedHeaders — заголовки сверх Content-Type и Authorization
+	AllowedHeaders   []string      `yaml:"allowed_headers" json:"allowed_headers"`
+	AllowCredentials bool          `yaml:"allow_credentials" json:"allow_credentials"`
+	MaxAge           time.Duration `yaml:"max_age" json:"max_age"`
+}
+
+// SessionConfig — вход страниц сайта по HttpOnly cookie вместо токенов в JavaScript
+type SessionConfig struct {
+	CookieName string `yaml:"cookie_name" json:"cookie_name"`
+	// IdleTimeout — сессия заканчивается, если столько времени не было запросов
+	IdleTimeout time.Duration `yaml:"idle_timeout" json:"idle_timeout"`
+	// MaxAge — предельный срок сессии независимо от активности
+	MaxAge time.Duration `yaml:"max_age" json:"max_age"`
+}
+
+// SecurityConfig — заголовки безопасности. В CSP вместо {nonce} подставляется
+// одноразовое значение для встроенных скриптов; пустая строка отключает CSP
+type SecurityConfig struct {
+	ContentSecurityPolicy string `yaml:"content_security_policy" json:"content_security_policy"`
+	// HSTSMaxAge — Strict-Transport-Security для HTTPS; 0 — не отправлять
+	HSTSMaxAge time.Duration `yaml:"hsts_max_age" json:"hsts_max_age"`
+	// TrustedProxies — адреса и подсети балансировщиков; только от них
+	// принимается X-Forwarded-For (см. Server.clientIP)
+	TrustedProxies []string `yaml:"trusted_proxies" json:"trusted_proxies"`
+}
+
+// LogConfig — запись журнала в таблицу log_entries. Строки копятся в буфере и
+// пишутся пачками; если база не успевает и буфер полон, запись ждёт не дольше
+// DBBlockTimeout, а затем строка отбрасывается и учитывается в счётчике
+type LogConfig struct {
+	DBBuffer        int           `yaml:"db_buffer" json:"db_buffer"`
+	DBBatchSize     int           `yaml:"db_batch_size" json:"db_batch_size"`
+	DBFlushInterval time.Duration `yaml:"db_flush_interval" json:"db_flush_interval"`
+	DBBlockTimeout  time.Duration `yaml:"db_block_timeout" json:"db_block_timeout"`
+	// Retention — сколько хранить записи; 0 — не удалять
+	Retention time.Duration `yaml:"retention" json:"retention"`
+}
+
+// MetricsConfig — доступ к GET /metrics. Без токена метрики открыты, как принято
+// для Prometheus во внутренней сети; с токеном нужен Authorization: Bearer <token>
+type MetricsConfig struct {
+	Token Secret `yaml:"token" json:"token"`
+}
+
+// TracingConfig — трассировка OpenTelemetry. Exporter: none — выключена,
+// otlp — OTLP/HTTP на Endpoint (локальный коллектор: http://localhost:4318),
+// stdout — spans в стандартный вывод, для отладки
+type TracingConfig struct {
+	Exporter    string `yaml:"exporter" json:"exporter"`
+	Endpoint    string `yaml:"endpoint" json:"endpoint"`
+	ServiceName string `yaml:"service_name" json:"service_name"`
+	// SampleRatio — доля новых трасс, которые записываются; решение вызывающего из traceparent соблюдается
+	SampleRatio float64 `yaml:"sample_ratio" json:"sample_ratio"`
+}
+
+// Скрипт Bootstrap, который подключают страницы. В script-src разрешён только он,
+// а не весь CDN, где лежит любой пакет из npm
+const bootstrapBundleURL = "https://cdn.jsdelivr.net/npm/bootstrap@5.3.0-alpha1/dist/js/bootstrap.bundle.min.js"
+
+const defaultContentSecurityPolicy = "default-src 'self'; " +
+	"script-src 'self' 'nonce-{nonce}' " + bootstrapBundleURL + "; " +
+	"style-src 'self' 'unsafe-inline' https://cdn.jsdelivr.net https://cdnjs.cloudflare.com https://fonts.googleapis.com; " +
+	"font-src 'self' https://cdnjs.cloudflare.com https://fonts.gstatic.com; " +
+	"img-src 'self' data: https:; connect-src 'self'; object-src 'none'; " +
+	"base-uri 'self'; form-action 'self'; frame-ancestors 'none'"
+
+// Config — все настройки сервера
+type Config struct {
+	Port      string               `yaml:"port" json:"port"`
+	SiteURL   string               `yaml:"site_url" json:"site_url"`
+	Database  DatabaseConfig       `yaml:"database" json:"database"`
+	JWT       JWTConfig            `yaml:"jwt" json:"jwt"`
+	SMTP      SMTPConfig           `yaml:"smtp" json:"smtp"`
+	Google    GoogleConfig         `yaml:"google" json:"google"`
+	OIDC      []OIDCProviderConfig `yaml:"oidc" json:"oidc"`
+	WebAuthn  WebAuthnConfig       `yaml:"webauthn" json:"webauthn"`
+	CORS      CORSConfig           `yaml:"cors" json:"cors"`
+	Security  SecurityConfig       `yaml:"security" json:"security"`
+	Session   SessionConfig        `yaml:"session" json:"session"`
+	RateLimit RateLimitConfig      `yaml:"rate_limit" json:"rate_limit"`
+	Log       LogConfig            `yaml:"log" json:"log"`
+	Metrics   MetricsConfig        `yaml:"metrics" json:"metrics"`
+	Tracing   TracingConfig        `yaml:"tracing" json:"tracing"`
+}
+
+func defaultConfig() Config {
+	return Config{
+		Port: "8080",
+		Database: DatabaseConfig{
+			DSN:             "./books.db",
+			MaxOpenConns:    10,
+			MaxIdleConns:    5,
+			ConnMaxLifetime: 30 * time.Minute,
+			ConnMaxIdleTime: 5 * time.Minute,
+		},
+		JWT: JWTConfig{
+			Algorithm:   "EdDSA",
+			Issuer:      "bookstore",
+			Audience:    "bookstore",
+			KeyRotation: 7 * 24 * time.Hour,
+			AccessTTL:   15 * time.Minute,
+			RefreshTTL:  30 * 24 * time.Hour,
+		},
+		Google: GoogleConfig{Issuer: "https://accounts.google.com"},
+		SMTP: SMTPConfig{
+			Host: "smtp.gmail.com",
+			Port: 587,
+		},
+		WebAuthn: WebAuthnConfig{RPName: "Bookstore"},
+		CORS:     CORSConfig{MaxAge: 10 * time.Minute},
+		Session: SessionConfig{
+			CookieName:  "bookstore_session",
+			IdleTimeout: 30 * time.Minute,
+			MaxAge:      7 * 24 * time.Hour,
+		},
+		Security: SecurityConfig{
+			ContentSecurityPolicy: defaultContentSecurityPolicy,
+			HSTSMaxAge:            365 * 24 * time.Hour,
+		},
+		RateLimit: RateLimitConfig{
+			RPS:     5,
+			Burst:   20,
+			Auth:    RateLimitRule{RPS: 0.2, Burst: 5},
+			Books:   RateLimitRule{RPS: 20, Burst: 50},
+			IdleTTL: 10 * time.Minute,
+			Backend: "memory",
+		},
+		Log: LogConfig{
+			DBBuffer:        1024,
+			DBBatchSize:     100,
+			DBFlushInterval: time.Second,
+			DBBlockTimeout:  50 * time.Millisecond,
+			Retention:       30 * 24 * time.Hour,
+		},
+		Tracing: TracingConfig{
+			Exporter:    "none",
+			ServiceName: "bookstore",
+			SampleRatio: 1,
+		},
+	}
+}
+
+// loadConfig собирает конфигурацию: значения по умолчанию, затем YAML-файл
+// (CONFIG_FILE), затем .env и переменные окружения
+func loadConfig() (Config, error) {
+	cfg := defaultConfig()
+
+	if err := godotenv.Load(); err != nil && !errors.Is(err, os.ErrNotExist) {
+		return cfg, fmt.Errorf("failed to read .env: %w", err)
+	}
+
+	if path := os.Getenv("CONFIG_FILE"); path != "" {
+		data, err := os.ReadFile(path)
+		if err != nil {
+			return cfg, fmt.Errorf("failed to read config file: %w", err)
+		}
+		if err := yaml.Unmarshal(data, &cfg); err != nil {
+			return cfg, fmt.Errorf("failed to parse config file %s: %w", path, err)
+		}
+	}
+
+	if err := applyEnv(&cfg); err != nil {
+		return cfg, err
+	}
+	if err := cfg.Validate(); err != nil {
+		return cfg, err
+	}
+	return cfg, nil
+}
+
+func applyEnv(cfg *Config) error {
+	setString := func(key string, dst *string) {
+		if v, ok := os.LookupEnv(key); ok {
+			*dst = v
+		}
+	}
+	setSecret := func(key string, dst *Secret) {
+		if v, ok := os.LookupEnv(key); ok {
+			*dst = Secret(v)
+		}
+	}
+
+	var errs []error
+	setInt := func(key string, dst *int) {
+		if v, ok := os.LookupEnv(key); ok {
+			n, err := strconv.Atoi(v)
+			if err != nil {
+				errs = append(errs, fmt.Errorf("%s must be an integer, got %q", key, v))
+				return
+			}
+			*dst = n
+		}
+	}
+	setDuration := func(key string, dst *time.Duration) {
+		if v, ok := os.LookupEnv(key); ok {
+			d, err := time.ParseDuration(v)
+			if err != nil {
+				errs = append(errs, fmt.Errorf("%s must be a duration like 30s or 5m, got %q", key, v))
+				return
+			}
+			*dst = d
+		}
+	}
+	setFloat := func(key string, dst *float64) {
+		if v, ok := os.LookupEnv(key); ok {
+			f, err := strconv.ParseFloat(v, 64)
+			if err != nil {
+				errs = append(errs, fmt.Errorf("%s must be a number, got %q", key, v))
+				return
+			}
+			*dst = f
+		}
+	}
+	setBool := func(key string, dst *bool) {
+		if v, ok := os.LookupEnv(key); ok {
+			b, err := strconv.ParseBool(v)
+			if err != nil {
+				errs = append(errs, fmt.Errorf("%s must be true or false, got %q", key, v))
+				return
+			}
+			*dst = b
+		}
+	}
+	// setList читает список через запятую
+	setList := func(key string, dst *[]string) {
+		if v, ok := os.LookupEnv(key); ok {
+			*dst = nil
+			for _, item := range strings.Split(v, ",") {
+				if item = strings.TrimSpace(item); item != "" {
+					*dst = append(*dst, item)
+				}
+			}
+		}
+	}
+
+	setString("PORT", &cfg.Port)
+	setString("SITE_URL", &cfg.SiteURL)
+	setSecret("DB_PATH", &cfg.Database.DSN) // старое имя, DATABASE_URL имеет приоритет
+	setSecret("DATABASE_URL", &cfg.Database.DSN)
+	setInt("DB_MAX_OPEN_CONNS", &cfg.Database.MaxOpenConns)
+	setInt("DB_MAX_IDLE_CONNS", &cfg.Database.MaxIdleConns)
+	setDuration("DB_CONN_MAX_LIFETIME", &cfg.Database.ConnMaxLifetime)
+	setDuration("DB_CONN_MAX_IDLE_TIME", &cfg.Database.ConnMaxIdleTime)
+	setSecret("JWT_SECRET", &cfg.JWT.Secret)
+	setString("JWT_ALGORITHM", &cfg.JWT.Algorithm)
+	setString("JWT_ISSUER", &cfg.JWT.Issuer)
+	setString("JWT_AUDIENCE", &cfg.JWT.Audience)
+	setDuration("JWT_KEY_ROTATION", &cfg.JWT.KeyRotation)
+	setDuration("JWT_ACCESS_TTL", &cfg.JWT.AccessTTL)
+	setDuration("JWT_REFRESH_TTL", &cfg.JWT.RefreshTTL)
+	setString("SMTP_HOST", &cfg.SMTP.Host)
+	setInt("SMTP_PORT", &cfg.SMTP.Port)
+	setString("SMTP_USERNAME", &cfg.SMTP.Username)
+	setSecret("SMTP_PASSWORD", &cfg.SMTP.Password)
+	setString("SMTP_FROM", &cfg.SMTP.From)
+	setString("GOOGLE_CLIENT_ID", &cfg.Google.ClientID)
+	setSecret("GOOGLE_CLIENT_SECRET", &cfg.Google.ClientSecret)
+	setString("GOOGLE_REDIRECT_URL", &cfg.Google.RedirectURL)
+	setString("GOOGLE_ISSUER", &cfg.Google.Issuer)
+	setBool("GOOGLE_TRUST_EMAIL", &cfg.Google.TrustEmail)
+	setString("WEBAUTHN_RP_ID", &cfg.WebAuthn.RPID)
+	setString("WEBAUTHN_RP_NAME", &cfg.WebAuthn.RPName)
+	setList("WEBAUTHN_ORIGINS", &cfg.WebAuthn.Origins)
+	setList("CORS_ALLOWED_ORIGINS", &cfg.CORS.AllowedOrigins)
+	setList("CORS_ALLOWED_HEADERS", &cfg.CORS.AllowedHeaders)
+	setBool("CORS_ALLOW_CREDENTIALS", &cfg.CORS.AllowCredentials)
+	setDuration("CORS_MAX_AGE", &cfg.CORS.MaxAge)
+	setString("SESSION_COOKIE_NAME", &cfg.Session.CookieName)
+	setDuration("SESSION_IDLE_TIMEOUT", &cfg.Session.IdleTimeout)
+	setDuration("SESSION_MAX_AGE", &cfg.Session.MaxAge)
+	setString("CONTENT_SECURITY_POLICY", &cfg.Security.ContentSecurityPolicy)
+	setDuration("HSTS_MAX_AGE", &cfg.Security.HSTSMaxAge)
+	setList("TRUSTED_PROXIES", &cfg.Security.TrustedProxies)
+	setFloat("RATE_LIMIT_RPS", &cfg.RateLimit.RPS)
+	setInt("RATE_LIMIT_BURST", &cfg.RateLimit.Burst)
+	setFloat("RATE_LIMIT_AUTH_RPS", &cfg.RateLimit.Auth.RPS)
+	setInt("RATE_LIMIT_AUTH_BURST", &cfg.RateLimit.Auth.Burst)
+	setFloat("RATE_LIMIT_BOOKS_RPS", &cfg.RateLimit.Books.RPS)
+	setInt("RATE_LIMIT_BOOKS_BURST", &cfg.RateLimit.Books.Burst)
+	setDuration("RATE_LIMIT_IDLE_TTL", &cfg.RateLimit.IdleTTL)
+	setString("RATE_LIMIT_BACKEND", &cfg.RateLimit.Backend)
+	setSecret("RATE_LIMIT_REDIS_URL", &cfg.RateLimit.RedisURL)
+	setInt("LOG_DB_BUFFER", &cfg.Log.DBBuffer)
+	setInt("LOG_DB_BATCH_SIZE", &cfg.Log.DBBatchSize)
+	setDuration("LOG_DB_FLUSH_INTERVAL", &cfg.Log.DBFlushInterval)
+	setDuration("LOG_DB_BLOCK_TIMEOUT", &cfg.Log.DBBlockTimeout)
+	setDuration("LOG_RETENTION", &cfg.Log.Retention)
+	setSecret("METRICS_TOKEN", &cfg.Metrics.Token)
+	setString("TRACING_EXPORTER", &cfg.Tracing.Exporter)
+	setString("TRACING_ENDPOINT", &cfg.Tracing.Endpoint)
+	setString("TRACING_SERVICE_NAME", &cfg.Tracing.ServiceName)
+	setFloat("TRACING_SAMPLE_RATIO", &cfg.Tracing.SampleRatio)
+
+	if v, ok := os.LookupEnv("OIDC_PROVIDERS"); ok {
+		for _, name := range strings.Split(v, ",") {
+			if name = strings.TrimSpace(name); name != "" {
+				applyOIDCEnv(cfg, name, setString, setSecret, setBool)
+			}
+		}
+	}
+
+	return errors.Join(errs...)
+}
+
+// applyOIDCEnv читает OIDC_<NAME>_* для провайдера из OIDC_PROVIDERS,
+// дополняя провайдера с тем же именем из YAML
+func applyOIDCEnv(cfg *Config, name string, setString func(string, *string), setSecret func(string, *Secret), setBool func(string, *bool)) {
+	i := slices.IndexFunc(cfg.OIDC, func(p OIDCProviderConfig) bool { return p.Name == name })
+	if i < 0 {
+		cfg.OIDC = append(cfg.OIDC, OIDCProviderConfig{Name: name})
+		i = len(cfg.OIDC) - 1
+	}
+	p := &cfg.OIDC[i]
+	prefix := "OIDC_" + strings.ToUpper(strings.ReplaceAll(name, "-", "_")) + "_"
+
+	setString(prefix+"LABEL", &p.Label)
+	setString(prefix+"ISSUER", &p.Issuer)
+	setString(prefix+"CLIENT_ID", &p.ClientID)
+	setSecret(prefix+"CLIENT_SECRET", &p.ClientSecret)
+	setString(prefix+"REDIRECT_URL", &p.RedirectURL)
+	setString(prefix+"ROLE_CLAIM", &p.RoleClaim)
+	setString(prefix+"DEFAULT_ROLE", &p.DefaultRole)
+	setBool(prefix+"TRUST_EMAIL", &p.TrustEmail)
+	if v, ok := os.LookupEnv(prefix + "SCOPES"); ok {
+		p.Scopes = strings.Split(v, ",")
+	}
+	// Формат: bookstore-admins=admin,staff=staff
+	if v, ok := os.LookupEnv(prefix + "ROLE_MAPPING"); ok {
+		p.RoleMapping = nil
+		for _, pair := range strings.Split(v, ",") {
+			value, role, _ := strings.Cut(strings.TrimSpace(pair), "=")
+			p.RoleMapping = append(p.RoleMapping, OIDCRoleMapping{Value: value, Role: role})
+		}
+	}
+}
+
+// Validate проверяет конфигурацию и возвращает все ошибки сразу
+func (c Config) Validate() error {
+	var errs []error
+
+	if _, err := strconv.Atoi(c.Port); err != nil {
+		errs = append(errs, fmt.Errorf("PORT must be a number, got %q", c.Port))
+	}
+	if c.SiteURL == "" {
+		// Иначе адрес в canonical, sitemap и письмах пришлось бы брать из заголовка Host
+		errs = append(errs, errors.New("SITE_URL is required"))
+	} else if !strings.HasPrefix(c.SiteURL, "http://") && !strings.HasPrefix(c.SiteURL, "https://") {
+		errs = append(errs, fmt.Errorf("SITE_URL must start with http:// or https://, got %q", c.SiteURL))
+	}
+	if c.Database.DSN == "" {
+		errs = append(errs, errors.New("DATABASE_URL is required"))
+	} else if _, _, err := parseDSN(c.Database.DSN.Value()); err != nil {
+		errs = append(errs, fmt.Errorf("DATABASE_URL: %w", err))
+	}
+	if c.Database.MaxOpenConns < 0 || c.Database.MaxIdleConns < 0 {
+		errs = append(errs, errors.New("DB_MAX_OPEN_CONNS and DB_MAX_IDLE_CONNS must not be negative"))
+	}
+	if len(c.JWT.Secret) < 32 {
+		errs = append(errs, errors.New("JWT_SECRET is required and must be at least 32 characters"))
+	}
+	if !slices.Contains(allowedJWTAlgorithms, c.JWT.Algorithm) {
+		errs = append(errs, fmt.Errorf("JWT_ALGORITHM must be one of %s, got %q", strings.Join(allowedJWTAlgorithms, ", "), c.JWT.Algorithm))
+	}
+	if c.JWT.Issuer == "" || c.JWT.Audience == "" {
+		errs = append(errs, errors.New("JWT_ISSUER and JWT_AUDIENCE must not be empty"))
+	}
+	if c.JWT.KeyRotation <= jwksMaxAge {
+		errs = append(errs, fmt.Errorf("JWT_KEY_ROTATION must be longer than the JWKS cache lifetime %v, got %v", jwksMaxAge, c.JWT.KeyRotation))
+	}
+	if c.JWT.AccessTTL <= 0 || c.JWT.RefreshTTL <= c.JWT.AccessTTL {
+		errs = append(errs, errors.New("JWT_ACCESS_TTL must be positive and shorter than JWT_REFRESH_TTL"))
+	}
+	if c.SMTP.Username != "" {
+		if c.SMTP.Password == "" {
+			errs = append(errs, errors.New("SMTP_PASSWORD is required when SMTP_USERNAME is set"))
+		}
+		if c.SMTP.Host == "" {
+			errs = append(errs, errors.New("SMTP_HOST is required when SMTP_USERNAME is set"))
+		}
+		if c.SMTP.Port <= 0 || c.SMTP.Port > 65535 {
+			errs = append(errs, fmt.Errorf("SMTP_PORT must be between 1 and 65535, got %d", c.SMTP.Port))
+		}
+	}
+	if (c.Google.ClientID == "") != (c.Google.ClientSecret == "") {
+		errs = append(errs, errors.New("GOOGLE_CLIENT_ID and GOOGLE_CLIENT_SECRET must be set together"))
+	}
+	seen := map[string]bool{"google": c.Google.ClientID != ""}
+	for _, p := range c.OIDC {
+		if !oidcProviderName.MatchString(p.Name) {
+			errs = append(errs, fmt.Errorf("OIDC provider name %q must contain only lowercase letters, digits and dashes", p.Name))
+			continue
+		}
+		if seen[p.Name] {
+			errs = append(errs, fmt.Errorf("OIDC provider %q is configured twice", p.Name))
+		}
+		seen[p.Name] = true
+		if u, err := url.Parse(p.Issuer); err != nil || (u.Scheme != "https" && u.Scheme != "http") || u.Host == "" {
+			errs = append(errs, fmt.Errorf("OIDC provider %q: issuer must be an http(s) URL, got %q", p.Name, p.Issuer))
+		}
+		if p.ClientID == "" {
+			errs = append(errs, fmt.Errorf("OIDC provider %q: client_id is required", p.Name))
+		}
+		for _, m := range p.RoleMapping {
+			if m.Value == "" || m.Role == "" {
+				errs = append(errs, fmt.Errorf("OIDC provider %q: role mapping entries need both value and role", p.Name))
+				break
+			}
+		}
+		if len(p.RoleMapping) > 0 && p.RoleClaim == "" {
+			errs = append(errs, fmt.Errorf("OIDC provider %q: role_mapping requires role_claim", p.Name))
+		}
+	}
+	for _, origin := range c.WebAuthn.Origins {
+		if u, err := url.Parse(origin); err != nil || (u.Scheme != "https" && u.Scheme != "http") || u.Host == "" {
+			errs = append(errs, fmt.Errorf("WEBAUTHN_ORIGINS must contain http(s) URLs, got %q", origin))
+		}
+	}
+	for _, origin := range c.CORS.AllowedOrigins {
+		if origin == "*" {
+			if c.CORS.AllowCredentials {
+				errs = append(errs, errors.New("CORS_ALLOW_CREDENTIALS cannot be used with CORS_ALLOWED_ORIGINS=*"))
+			}
+			continue
+		}
+		if u, err := url.Parse(origin); err != nil || (u.Scheme != "https" && u.Scheme != "http") || u.Host == "" || strings.TrimSuffix(u.Path, "/") != "" {
+			errs = append(errs, fmt.Errorf("CORS_ALLOWED_ORIGINS must contain origins like https://example.com, got %q", origin))
+		}
+	}
+	if c.Session.CookieName == "" {
+		errs = append(errs, errors.New("SESSION_COOKIE_NAME is required"))
+	}
+	if c.Session.IdleTimeout <= 0 || c.Session.MaxAge < c.Session.IdleTimeout {
+		errs = append(errs, fmt.Errorf("SESSION_IDLE_TIMEOUT must be positive and not longer than SESSION_MAX_AGE, got %v and %v", c.Session.IdleTimeout, c.Session.MaxAge))
+	}
+	if c.Security.HSTSMaxAge < 0 {
+		errs = append(errs, fmt.Errorf("HSTS_MAX_AGE must not be negative, got %v", c.Security.HSTSMaxAge))
+	}
+	if _, err := parseTrustedProxies(c.Security.TrustedProxies); err != nil {
+		errs = append(errs, err)
+	}
+	if c.CORS.MaxAge < 0 {
+		errs = append(errs, fmt.Errorf("CORS_MAX_AGE must not be negative, got %v", c.CORS.MaxAge))
+	}
+	rules := []struct {
+		env  string
+		rule RateLimitRule
+	}{
+		{"RATE_LIMIT", RateLimitRule{RPS: c.RateLimit.RPS, Burst: c.RateLimit.Burst}},
+		{"RATE_LIMIT_AUTH", c.RateLimit.Auth},
+		{"RATE_LIMIT_BOOKS", c.RateLimit.Books},
+	}
+	for _, r := range rules {
+		if r.rule.RPS <= 0 {
+			errs = append(errs, fmt.Errorf("%s_RPS must be positive, got %v", r.env, r.rule.RPS))
+		}
+		if r.rule.Burst <= 0 {
+			errs = append(errs, fmt.Errorf("%s_BURST must be positive, got %d", r.env, r.rule.Burst))
+		}
+	}
+	if c.RateLimit.IdleTTL <= 0 {
+		errs = append(errs, fmt.Errorf("RATE_LIMIT_IDLE_TTL must be positive, got %v", c.RateLimit.IdleTTL))
+	}
+	switch c.RateLimit.Backend {
+	case "memory":
+	case "redis":
+		if _, err := redis.ParseURL(c.RateLimit.RedisURL.Value()); err != nil {
+			errs = append(errs, fmt.Errorf("RATE_LIMIT_REDIS_URL must be a redis:// URL: %w", err))
+		}
+	default:
+		errs = append(errs, fmt.Errorf("RATE_LIMIT_BACKEND must be memory or redis, got %q", c.RateLimit.Backend))
+	}
+	if c.Log.DBBuffer <= 0 {
+		errs = append(errs, fmt.Errorf("LOG_DB_BUFFER must be positive, got %d", c.Log.DBBuffer))
+	}
+	if c.Log.DBBatchSize <= 0 || c.Log.DBBatchSize > 1000 {
+		errs = append(errs, fmt.Errorf("LOG_DB_BATCH_SIZE must be between 1 and 1000, got %d", c.Log.DBBatchSize))
+	}
+	if c.Log.DBFlushInterval <= 0 {
+		errs = append(errs, fmt.Errorf("LOG_DB_FLUSH_INTERVAL must be positive, got %v", c.Log.DBFlushInterval))
+	}
+	if c.Log.DBBlockTimeout < 0 || c.Log.Retention < 0 {
+		errs = append(errs, fmt.Errorf("LOG_DB_BLOCK_TIMEOUT and LOG_RETENTION must not be negative, got %v and %v", c.Log.DBBlockTimeout, c.Log.Retention))
+	}
+	switch c.Tracing.Exporter {
+	case "none", "stdout":
+	case "otlp":
+		if c.Tracing.Endpoint != "" {
+			if u, err := url.Parse(c.Tracing.Endpoint); err != nil || (u.Scheme != "https" && u.Scheme != "http") || u.Host == "" {
+				errs = append(errs, fmt.Errorf("TRACING_ENDPOINT must be an http(s) URL like http://localhost:4318, got %q", c.Tracing.Endpoint))
+			}
+		}
+	default:
+		errs = append(errs, fmt.Errorf("TRACING_EXPORTER must be none, otlp or stdout, got %q", c.Tracing.Exporter))
+	}
+	if c.Tracing.SampleRatio < 0 || c.Tracing.SampleRatio > 1 {
+		errs = append(errs, fmt.Errorf("TRACING_SAMPLE_RATIO must be between 0 and 1, got %v", c.Tracing.SampleRatio))
+	}
+
+	if len(errs) > 0 {
+		return fmt.Errorf("invalid configuration: %w", errors.Join(errs...))
+	}
+	return nil
+}
+
+// BaseURL — адрес сайта для ссылок в письмах, canonical и sitemap
+func (c Config) BaseURL() string {
+	if c.SiteURL != "" {
+		return strings.TrimSuffix(c.SiteURL, "/")
+	}
+	return "http://localhost:" + c.Port
+}
+
+// WebAuthnRP возвращает идентификатор relying party и допустимые origins для passkeys
+func (c Config) WebAuthnRP() (string, []string) {
+	rpID, origins := c.WebAuthn.RPID, c.WebAuthn.Origins
+	if rpID == "" {
+		if u, err := url.Parse(c.BaseURL()); err == nil {
+			rpID = u.Hostname()
+		}
+	}
+	if len(origins) == 0 {
+		origins = []string{c.BaseURL()}
+	}
+	return rpID, origins
+}
+
+// Sender — адрес отправителя писем
+func (c SMTPConfig) Sender() string {
+	if c.From != "" {
+		return c.From
+	}
+	return c.Username
+}
+
+func (c SMTPConfig) Addr() string {
+	return c.Host + ":" + strconv.Itoa(c.Port)
+}
@@ -0,0 +1,137 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+const testJWTSecret = "test-secret-key-that-is-long-enough"
+
+func TestLoadConfigFromFileAndEnv(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "config.yaml")
+	os.WriteFile(path, []byte(`
+port: "9090"
+database:
+  dsn: ./from-file.db
+  conn_max_lifetime: 1h
+smtp:
+  username: shop@example.com
+  password: file-password
+rate_limit:
+  rps: 10
+  burst: 20
+`), 0o600)
+
+	t.Setenv("CONFIG_FILE", path)
+	t.Setenv("JWT_SECRET", testJWTSecret)
+	t.Setenv("SITE_URL", "https://books.example.com")
+	t.Setenv("RATE_LIMIT_BURST", "50")
+
+	cfg, err := loadConfig()
+	assert.NoError(t, err)
+	assert.Equal(t, "9090", cfg.Port)
+	assert.Equal(t, "./from-file.db", cfg.Database.DSN.Value())
+	assert.Equal(t, time.Hour, cfg.Database.ConnMaxLifetime)
+	assert.Equal(t, "file-password", cfg.SMTP.Password.Value())
+	assert.Equal(t, 10.0, cfg.RateLimit.RPS)
+	assert.Equal(t, 50, cfg.RateLimit.Burst, "Env must override the config file")
+}
+
+func TestConfigValidation(t *testing.T) {
+	cfg := defaultConfig()
+	cfg.Port = "http"
+	cfg.Google.ClientID = "client-id"
+	cfg.RateLimit.Burst = 0
+	cfg.RateLimit.Auth.RPS = 0
+	cfg.Session.IdleTimeout = 8 * 24 * time.Hour
+	cfg.Log.DBBatchSize = 5000
+	cfg.Tracing.Exporter = "jaeger"
+	cfg.Security.TrustedProxies = []string{"10.0.0.0/8", "load-balancer"}
+	cfg.JWT.KeyRotation = time.Minute
+
+	err := cfg.Validate()
+	assert.Error(t, err)
+	assert.Contains(t, err.Error(), "PORT must be a number")
+	assert.Contains(t, err.Error(), "SITE_URL is required")
+	assert.Contains(t, err.Error(), "JWT_SECRET is required")
+	assert.Contains(t, err.Error(), "GOOGLE_CLIENT_ID and GOOGLE_CLIENT_SECRET must be set together")
+	assert.Contains(t, err.Error(), "RATE_LIMIT_BURST must be positive")
+	assert.Contains(t, err.Error(), "RATE_LIMIT_AUTH_RPS must be positive")
+	assert.Contains(t, err.Error(), "SESSION_IDLE_TIMEOUT must be positive and not longer than SESSION_MAX_AGE")
+	assert.Contains(t, err.Error(), "LOG_DB_BATCH_SIZE must be between 1 and 1000")
+	assert.Contains(t, err.Error(), "TRACING_EXPORTER must be none, otlp or stdout")
+	assert.Contains(t, err.Error(), `TRUSTED_PROXIES must contain IP addresses or CIDR ranges, got "load-balancer"`)
+	assert.Contains(t, err.Error(), "JWT_KEY_ROTATION must be longer than the JWKS cache lifetime")
+
+	t.Setenv("SMTP_PORT", "smtp")
+	assert.ErrorContains(t, applyEnv(&cfg), "SMTP_PORT must be an integer")
+}
+
+func TestConfigRedactsSecrets(t *testing.T) {
+	cfg := defaultConfig()
+	cfg.JWT.Secret = testJWTSecret
+	cfg.SMTP.Password = "smtp-password"
+
+	data, _ := json.Marshal(cfg)
+	assert.NotContains(t, string(data), testJWTSecret)
+	assert.NotContains(t, string(data), "smtp-password")
+	assert.Contains(t, string(data), `"password":"[REDACTED]"`)
+
+	text := fmt.Sprintf("%v", cfg)
+	assert.NotContains(t, text, testJWTSecret)
+	assert.NotContains(t, text, "smtp-password")
+}
+
+func TestOIDCProvidersFromEnv(t *testing.T) {
+	t.Setenv("JWT_SECRET", testJWTSecret)
+	t.Setenv("SITE_URL", "https://books.example.com")
+	t.Setenv("OIDC_PROVIDERS", "company, partner-sso")
+	t.Setenv("OIDC_COMPANY_ISSUER", "https://id.company.example")
+	t.Setenv("OIDC_COMPANY_CLIENT_ID", "bookstore")
+	t.Setenv("OIDC_COMPANY_CLIENT_SECRET", "company-secret")
+	t.Setenv("OIDC_COMPANY_ROLE_CLAIM", "groups")
+	t.Setenv("OIDC_COMPANY_ROLE_MAPPING", "bookstore-admins=admin, staff=staff")
+	t.Setenv("OIDC_PARTNER_SSO_ISSUER", "https://login.partner.example")
+	t.Setenv("OIDC_PARTNER_SSO_CLIENT_ID", "bookstore-partner")
+
+	cfg, err := loadConfig()
+	assert.NoError(t, err)
+	assert.Len(t, cfg.OIDC, 2)
+	assert.Equal(t, "company", cfg.OIDC[0].Name)
+	assert.Equal(t, "company-secret", cfg.OIDC[0].ClientSecret.Value())
+	assert.Equal(t, []OIDCRoleMapping{{Value: "bookstore-admins", Role: "admin"}, {Value: "staff", Role: "staff"}}, cfg.OIDC[0].RoleMapping)
+	assert.Equal(t, "https://login.partner.example", cfg.OIDC[1].Issuer)
+
+	cfg.OIDC = append(cfg.OIDC, OIDCProviderConfig{Name: "Bad Name"}, OIDCProviderConfig{Name: "company", Issuer: "ftp://x", ClientID: "x"})
+	err = cfg.Validate()
+	assert.ErrorContains(t, err, `OIDC provider name "Bad Name"`)
+	assert.ErrorContains(t, err, `OIDC provider "company" is configured twice`)
+	assert.ErrorContains(t, err, "issuer must be an http(s) URL")
+}
+
+func TestWebAuthnRelyingParty(t *testing.T) {
+	cfg := defaultConfig()
+	cfg.SiteURL = "https://books.example.com/"
+	rpID, origins := cfg.WebAuthnRP()
+	assert.Equal(t, "books.example.com", rpID, "RP ID defaults to the SITE_URL host")
+	assert.Equal(t, []string{"https://books.example.com"}, origins)
+
+	t.Setenv("JWT_SECRET", testJWTSecret)
+	t.Setenv("SITE_URL", "https://books.example.com")
+	t.Setenv("WEBAUTHN_RP_ID", "example.com")
+	t.Setenv("WEBAUTHN_ORIGINS", "https://example.com, https://shop.example.com")
+	cfg, err := loadConfig()
+	assert.NoError(t, err)
+	rpID, origins = cfg.WebAuthnRP()
+	assert.Equal(t, "example.com", rpID)
+	assert.Equal(t, []string{"https://example.com", "https://shop.example.com"}, origins)
+
+	cfg.WebAuthn.Origins = []string{"example.com"}
+	assert.ErrorContains(t, cfg.Validate(), "WEBAUTHN_ORIGINS must contain http(s) URLs")
+}
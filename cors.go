@@ -0,0 +1,105 @@
+package main
+
+import (
+	"net/http"
+	"slices"
+	"strconv"
+	"strings"
+)
+
+// CORS: браузер пускает чужой сайт к API, только если его origin есть в
+// CORS_ALLOWED_ORIGINS. Методы для preflight берутся из таблицы маршрутов,
+// чтобы список не приходилось поддерживать отдельно
+
+// corsProbeMethods — методы, которые проверяются по маршрутам при preflight
+var corsProbeMethods = []string{http.MethodGet, http.MethodPost, http.MethodPut, http.MethodPatch, http.MethodDelete}
+
+// corsLegacyMethods — для маршрутов без метода в шаблоне метод проверяет сам обработчик
+var corsLegacyMethods = []string{http.MethodGet, http.MethodPost, http.MethodPut, http.MethodDelete}
+
+// corsExposedHeaders — заголовки ответа, которые скрипт на чужом сайте может прочитать
+var corsExposedHeaders = []string{"RateLimit-Limit", "RateLimit-Remaining", "RateLimit-Reset", "RateLimit-Policy", "Retry-After", "Content-Disposition", requestIDHeader}
+
+// corsAllowedHeaders — заголовки запроса, разрешённые всегда; CORS_ALLOWED_HEADERS дополняет список
+var corsAllowedHeaders = []string{"Content-Type", "Authorization", requestIDHeader}
+
+// allowedOrigin возвращает значение Access-Control-Allow-Origin или "", если origin не разрешён
+func (c CORSConfig) allowedOrigin(origin string) string {
+	for _, allowed := range c.AllowedOrigins {
+		if allowed == "*" {
+			return "*"
+		}
+		if strings.EqualFold(strings.TrimSuffix(allowed, "/"), origin) {
+			return origin
+		}
+	}
+	return ""
+}
+
+// routeMethods — какие методы зарегистрированы для пути. Маршрут "/" ловит
+// всё подряд, поэтому совпадение с шаблоном без метода не считается
+func routeMethods(mux *http.ServeMux, r *http.Request) []string {
+	var methods []string
+	for _, method := range corsProbeMethods {
+		probe := r.Clone(r.Context())
+		probe.Method = method
+		_, pattern := mux.Handler(probe)
+		if scoped, _, ok := strings.Cut(pattern, " "); ok && !strings.Contains(scoped, "/") {
+			methods = append(methods, method)
+		}
+	}
+	if len(methods) == 0 {
+		return corsLegacyMethods
+	}
+	return methods
+}
+
+func (s *Server) corsMiddleware(mux *http.ServeMux, next http.Handler) http.Handler {
+	cfg := s.config.CORS
+	headers := slices.Clone(corsAllowedHeaders)
+	for _, header := range cfg.AllowedHeaders {
+		if !slices.ContainsFunc(headers, func(h string) bool { return strings.EqualFold(h, header) }) {
+			headers = append(headers, header)
+		}
+	}
+	allowHeaders := strings.Join(headers, ", ")
+	exposeHeaders := strings.Join(corsExposedHeaders, ", ")
+	maxAge := strconv.Itoa(int(cfg.MaxAge.Seconds()))
+
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		h := w.Header()
+		// Ответ зависит от Origin, кэши не должны отдавать его другому сайту
+		h.Add("Vary", "Origin")
+		origin := r.Header.Get("Origin")
+		allowed := ""
+		if origin != "" {
+			allowed = cfg.allowedOrigin(origin)
+		}
+		preflight := r.Method == http.MethodOptions && r.Header.Get("Access-Control-Request-Method") != ""
+
+		if allowed != "" {
+			h.Set("Access-Control-Allow-Origin", allowed)
+			if cfg.AllowCredentials {
+				h.Set("Access-Control-Allow-Credentials", "true")
+			}
+			if !preflight {
+				h.Set("Access-Control-Expose-Headers", exposeHeaders)
+			}
+		}
+		if r.Method != http.MethodOptions {
+			next.ServeHTTP(w, r)
+			return
+		}
+
+		methods := strings.Join(routeMethods(mux, r), ", ")
+		h.Set("Allow", methods+", OPTIONS")
+		if preflight && allowed != "" {
+			h.Add("Vary", "Access-Control-Request-Method")
+			h.Add("Vary", "Access-Control-Request-Headers")
+			h.Set("Access-Control-Allow-Methods", methods)
+			h.Set("Access-Control-Allow-Headers", allowHeaders)
+			h.Set("Access-Control-Max-Age", maxAge)
+		}
+		w.WriteHeader(http.StatusNoContent)
+	})
+}
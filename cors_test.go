@@ -0,0 +1,104 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func corsTestHandler(t *testing.T, cors CORSConfig) http.Handler {
+	t.Helper()
+	cfg := testConfig()
+	cfg.CORS = cors
+	assert.NoError(t, cfg.Validate())
+	return newConfigTestServer(t, cfg, newMemoryTokenRepository()).routes()
+}
+
+func preflight(handler http.Handler, path, origin, method string) *httptest.ResponseRecorder {
+	req, _ := http.NewRequest("OPTIONS", path, nil)
+	req.Header.Set("Origin", origin)
+	req.Header.Set("Access-Control-Request-Method", method)
+	req.Header.Set("Access-Control-Request-Headers", "authorization, content-type")
+	rr := httptest.NewRecorder()
+	handler.ServeHTTP(rr, req)
+	return rr
+}
+
+func TestCORSPreflight(t *testing.T) {
+	t.Parallel()
+	handler := corsTestHandler(t, CORSConfig{
+		AllowedOrigins:   []string{"https://shop.example.com"},
+		AllowedHeaders:   []string{"x-request-id", "X-Client-Version"},
+		AllowCredentials: true,
+		MaxAge:           10 * time.Minute,
+	})
+
+	rr := preflight(handler, "/api/account", "https://shop.example.com", "PATCH")
+	assert.Equal(t, http.StatusNoContent, rr.Code)
+	assert.Equal(t, "https://shop.example.com", rr.Header().Get("Access-Control-Allow-Origin"))
+	assert.Equal(t, "true", rr.Header().Get("Access-Control-Allow-Credentials"))
+	assert.Equal(t, "GET, PATCH, DELETE", rr.Header().Get("Access-Control-Allow-Methods"), "Methods come from the route table")
+	assert.Equal(t, "Content-Type, Authorization, X-Request-ID, X-Client-Version", rr.Header().Get("Access-Control-Allow-Headers"))
+	assert.Equal(t, "600", rr.Header().Get("Access-Control-Max-Age"))
+	assert.Contains(t, rr.Header().Values("Vary"), "Origin")
+
+	rr = preflight(handler, "/api/account/email", "https://shop.example.com", "POST")
+	assert.Equal(t, "POST", rr.Header().Get("Access-Control-Allow-Methods"))
+	rr = preflight(handler, "/books/search", "https://shop.example.com", "GET")
+	assert.Equal(t, "GET, POST, PUT, DELETE", rr.Header().Get("Access-Control-Allow-Methods"), "Routes without a method keep the old list")
+
+	rr = preflight(handler, "/api/account", "https://evil.example.net", "PATCH")
+	assert.Equal(t, http.StatusNoContent, rr.Code)
+	assert.Empty(t, rr.Header().Get("Access-Control-Allow-Origin"), "Unknown origins get no CORS headers")
+	assert.Empty(t, rr.Header().Get("Access-Control-Allow-Methods"))
+}
+
+func TestCORSActualRequest(t *testing.T) {
+	t.Parallel()
+	handler := corsTestHandler(t, CORSConfig{AllowedOrigins: []string{"https://shop.example.com/"}})
+
+	req, _ := http.NewRequest("GET", "/healthz", nil)
+	req.Header.Set("Origin", "https://shop.example.com")
+	rr := httptest.NewRecorder()
+	handler.ServeHTTP(rr, req)
+	assert.Equal(t, "https://shop.example.com", rr.Header().Get("Access-Control-Allow-Origin"))
+	assert.Empty(t, rr.Header().Get("Access-Control-Allow-Credentials"))
+	assert.Contains(t, rr.Header().Get("Access-Control-Expose-Headers"), "Retry-After")
+	assert.Contains(t, rr.Header().Values("Vary"), "Origin")
+
+	req.Header.Set("Origin", "https://evil.example.net")
+	rr = httptest.NewRecorder()
+	handler.ServeHTTP(rr, req)
+	assert.Empty(t, rr.Header().Get("Access-Control-Allow-Origin"))
+	assert.Contains(t, rr.Header().Values("Vary"), "Origin", "Vary is set for every origin")
+}
+
+func TestCORSDefaultsToSameOrigin(t *testing.T) {
+	t.Parallel()
+	handler := corsTestHandler(t, defaultConfig().CORS)
+	rr := preflight(handler, "/login", "https://shop.example.com", "POST")
+	assert.Empty(t, rr.Header().Get("Access-Control-Allow-Origin"))
+
+	handler = corsTestHandler(t, CORSConfig{AllowedOrigins: []string{"*"}})
+	rr = preflight(handler, "/login", "https://shop.example.com", "POST")
+	assert.Equal(t, "*", rr.Header().Get("Access-Control-Allow-Origin"))
+	assert.Empty(t, rr.Header().Get("Access-Control-Allow-Credentials"))
+}
+
+func TestCORSConfigValidation(t *testing.T) {
+	cfg := testConfig()
+	cfg.CORS = CORSConfig{AllowedOrigins: []string{"*"}, AllowCredentials: true}
+	assert.ErrorContains(t, cfg.Validate(), "CORS_ALLOW_CREDENTIALS cannot be used with CORS_ALLOWED_ORIGINS=*")
+
+	cfg.CORS = CORSConfig{AllowedOrigins: []string{"https://shop.example.com/catalog"}}
+	assert.ErrorContains(t, cfg.Validate(), "CORS_ALLOWED_ORIGINS must contain origins")
+
+	t.Setenv("CORS_ALLOWED_ORIGINS", "https://a.example.com, https://b.example.com")
+	t.Setenv("CORS_ALLOW_CREDENTIALS", "yes")
+	err := applyEnv(&cfg)
+	assert.ErrorContains(t, err, "CORS_ALLOW_CREDENTIALS must be true or false")
+	assert.Equal(t, []string{"https://a.example.com", "https://b.example.com"}, cfg.CORS.AllowedOrigins)
+}
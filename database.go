@@ -0,0 +1,185 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/sirupsen/logrus"
+	"gorm.io/driver/postgres"
+	"gorm.io/driver/sqlite"
+	"gorm.io/gorm"
+	gormlogger "gorm.io/gorm/logger"
+)
+
+const (
+	driverSQLite   = "sqlite"
+	driverPostgres = "postgres"
+)
+
+// parseDSN определяет драйвер по DSN: postgres://, postgresql:// или путь к SQLite
+func parseDSN(dsn string) (driver, source string, err error) {
+	switch {
+	case strings.HasPrefix(dsn, "postgres://"), strings.HasPrefix(dsn, "postgresql://"):
+		return driverPostgres, dsn, nil
+	case strings.HasPrefix(dsn, "sqlite://"):
+		return driverSQLite, strings.TrimPrefix(dsn, "sqlite://"), nil
+	case strings.Contains(dsn, "://"):
+		return "", "", fmt.Errorf("unsupported database scheme in %q", dsn[:strings.Index(dsn, "://")+3])
+	case dsn == "":
+		return "", "", fmt.Errorf("empty DSN")
+	default:
+		return driverSQLite, dsn, nil
+	}
+}
+
+// openDatabase подключается к SQLite или PostgreSQL и настраивает пул соединений
+func openDatabase(cfg DatabaseConfig, logger logrus.FieldLogger) (*gorm.DB, error) {
+	driver, source, err := parseDSN(cfg.DSN.Value())
+	if err != nil {
+		return nil, err
+	}
+
+	var dialector gorm.Dialector
+	switch driver {
+	case driverPostgres:
+		dialector = postgres.Open(source)
+	default:
+		// ✅ Проверяем, существует ли файл базы
+		if _, err := os.Stat(source); os.IsNotExist(err) && !strings.HasPrefix(source, "file:") && source != ":memory:" {
+			logger.WithField("path", source).Info("Database file not found, creating a new one")
+			file, err := os.Create(source)
+			if err != nil {
+				return nil, fmt.Errorf("failed to create database file: %w", err)
+			}
+			file.Close()
+		}
+		dialector = sqlite.Open(source)
+	}
+
+	conn, err := gorm.Open(dialector, &gorm.Config{TranslateError: true, Logger: newGormLogger(logger)})
+	if err != nil {
+		return nil, fmt.Errorf("failed to connect to %s: %w", driver, err)
+	}
+
+	sqlDB, err := conn.DB()
+	if err != nil {
+		return nil, err
+	}
+	sqlDB.SetMaxOpenConns(cfg.MaxOpenConns)
+	sqlDB.SetMaxIdleConns(cfg.MaxIdleConns)
+	sqlDB.SetConnMaxLifetime(cfg.ConnMaxLifetime)
+	sqlDB.SetConnMaxIdleTime(cfg.ConnMaxIdleTime)
+
+	return conn, nil
+}
+
+// Запросы медленнее этого попадают в журнал предупреждением
+const slowQueryThreshold = 200 * time.Millisecond
+
+// gormLogger пишет сообщения GORM через logrus, а не в stdout, так что они
+// попадают в тот же журнал (и log_entries), что и остальное. «Запись не найдена»
+// — обычный ответ для обработчиков, его не логируем. SQL пишется без значений
+// параметров, чтобы в журнал не попадали email, хеши паролей и токены.
+type gormLogger struct {
+	logger logrus.FieldLogger
+	level  gormlogger.LogLevel
+}
+
+func newGormLogger(logger logrus.FieldLogger) gormLogger {
+	return gormLogger{logger: logger, level: gormlogger.Warn}
+}
+
+// entry берёт логгер запроса из контекста, чтобы у строки был request_id
+func (l gormLogger) entry(ctx context.Context) logrus.FieldLogger {
+	if ctx != nil {
+		if rl, ok := ctx.Value(requestLogKey{}).(*requestLog); ok {
+			return rl.entry
+		}
+	}
+	return l.logger
+}
+
+func (l gormLogger) LogMode(level gormlogger.LogLevel) gormlogger.Interface {
+	l.level = level
+	return l
+}
+
+func (l gormLogger) Info(ctx context.Context, msg string, args ...interface{}) {
+	if l.level >= gormlogger.Info {
+		l.entry(ctx).Infof(msg, args...)
+	}
+}
+
+func (l gormLogger) Warn(ctx context.Context, msg string, args ...interface{}) {
+	if l.level >= gormlogger.Warn {
+		l.entry(ctx).Warnf(msg, args...)
+	}
+}
+
+func (l gormLogger) Error(ctx context.Context, msg string, args ...interface{}) {
+	if l.level >= gormlogger.Error {
+		l.entry(ctx).Errorf(msg, args...)
+	}
+}
+
+func (l gormLogger) Trace(ctx context.Context, begin time.Time, fc func() (string, int64), err error) {
+	if l.level <= gormlogger.Silent {
+		return
+	}
+	elapsed := time.Since(begin)
+	query := func() logrus.Fields {
+		sql, rows := fc()
+		return logrus.Fields{"sql": sql, "rows": rows, "duration": elapsed.String()}
+	}
+	switch {
+	case err != nil && l.level >= gormlogger.Error && !errors.Is(err, gorm.ErrRecordNotFound):
+		l.entry(ctx).WithError(err).WithFields(query()).Error("Database query failed")
+	case elapsed > slowQueryThreshold && l.level >= gormlogger.Warn:
+		l.entry(ctx).WithFields(query()).Warn("Slow database query")
+	case l.level >= gormlogger.Info:
+		l.entry(ctx).WithFields(query()).Debug("Database query")
+	}
+}
+
+// ParamsFilter убирает значения параметров из SQL в журнале
+func (l gormLogger) ParamsFilter(ctx context.Context, sql string, params ...interface{}) (string, []interface{}) {
+	return sql, nil
+}
+
+// pingDatabase проверяет, что база отвечает
+func pingDatabase(ctx context.Context, conn *gorm.DB) error {
+	if conn == nil {
+		return fmt.Errorf("database connection is not initialized")
+	}
+	sqlDB, err := conn.DB()
+	if err != nil {
+		return err
+	}
+	return sqlDB.PingContext(ctx)
+}
+
+// Обработчик проверки состояния сервера и базы данных
+func (s *Server) healthHandler(w http.ResponseWriter, r *http.Request) {
+	ctx, cancel := context.WithTimeout(r.Context(), 2*time.Second)
+	defer cancel()
+
+	status := map[string]string{"status": "ok", "database": "ok"}
+	code := http.StatusOK
+	if pinger, ok := s.books.(Pinger); ok {
+		if err := pinger.Ping(ctx); err != nil {
+			s.log(r.Context()).WithError(err).Error("Database health check failed")
+			status = map[string]string{"status": "unavailable", "database": "unavailable"}
+			code = http.StatusServiceUnavailable
+		}
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(code)
+	json.NewEncoder(w).Encode(status)
+}
@@ -0,0 +1,361 @@
+package main
+
+import (
+	"context"
+	"crypto"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/ed25519"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"math/big"
+	"net/http"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/golang-jwt/jwt/v4"
+)
+
+// Ключи подписи JWT. Токены подписываются асимметричным ключом (RS256 или EdDSA),
+// kid в заголовке указывает, каким именно. Ключи хранятся в базе, чтобы все
+// экземпляры сервера подписывали одним набором; закрытая часть зашифрована JWT_SECRET.
+// Раз в JWT_KEY_ROTATION создаётся новый ключ. Он публикуется в JWKS за jwksMaxAge
+// до того, как начнёт подписывать, чтобы закэшированный у проверяющих набор
+// уже содержал его. Старый ключ ещё AccessTTL остаётся в JWKS, чтобы выданные
+// им токены дожили до истечения.
+
+// Алгоритмы, которые принимает authMiddleware
+var allowedJWTAlgorithms = []string{"RS256", "EdDSA"}
+
+// Сколько проверяющие кэшируют JWKS (Cache-Control: max-age)
+const jwksMaxAge = 5 * time.Minute
+
+// SigningKey — ключ подписи JWT в базе
+type SigningKey struct {
+	ID         string `gorm:"primaryKey"` // kid
+	Algorithm  string
+	PrivateKey []byte    // PKCS#8, зашифрован AES-GCM
+	CreatedAt  time.Time `gorm:"index"`
+}
+
+// loadedKey — расшифрованный ключ в памяти
+type loadedKey struct {
+	id        string
+	algorithm string
+	signer    crypto.Signer
+	createdAt time.Time
+}
+
+type keyManager struct {
+	repo        TokenRepository
+	algorithm   string
+	secret      [32]byte
+	rotateEvery time.Duration
+	retain      time.Duration
+	now         func() time.Time
+
+	mu          sync.RWMutex
+	keys        []loadedKey // новые первыми
+	lastRefresh time.Time
+}
+
+func newKeyManager(cfg JWTConfig, repo TokenRepository) *keyManager {
+	return &keyManager{
+		repo:        repo,
+		algorithm:   cfg.Algorithm,
+		secret:      sha256.Sum256([]byte(cfg.Secret.Value())),
+		rotateEvery: cfg.KeyRotation,
+		retain:      cfg.AccessTTL,
+		now:         time.Now,
+	}
+}
+
+// refresh перечитывает ключи из базы, удаляет отслужившие и создаёт новый, если пора
+func (m *keyManager) refresh(ctx context.Context) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	now := m.now()
+	// Ключ подписывает rotateEvery (после jwksMaxAge в публикации), а затем ещё
+	// retain нужен для проверки
+	if err := m.repo.DeleteSigningKeysBefore(ctx, now.Add(-m.rotateEvery-m.retain)); err != nil {
+		return err
+	}
+	stored, err := m.repo.ListSigningKeys(ctx)
+	if err != nil {
+		return err
+	}
+
+	keys := make([]loadedKey, 0, len(stored)+1)
+	for _, sk := range stored {
+		key, err := m.decode(sk)
+		if err != nil {
+			return fmt.Errorf("signing key %s: %w", sk.ID, err)
+		}
+		keys = append(keys, key)
+	}
+	sort.Slice(keys, func(i, j int) bool { return keys[i].createdAt.After(keys[j].createdAt) })
+
+	if !hasFreshKey(keys, m.algorithm, m.nextKeyDue(now)) {
+		key, err := m.generate(ctx, now)
+		if err != nil {
+			return err
+		}
+		keys = append([]loadedKey{key}, keys...)
+	}
+
+	m.keys = keys
+	m.lastRefresh = now
+	return nil
+}
+
+// nextKeyDue — следующий ключ создаётся за jwksMaxAge до конца срока текущего,
+// поэтому ключ старше этого момента уже нужно сменить
+func (m *keyManager) nextKeyDue(now time.Time) time.Time {
+	return now.Add(-m.rotateEvery + jwksMaxAge)
+}
+
+func hasFreshKey(keys []loadedKey, algorithm string, since time.Time) bool {
+	return len(keys) > 0 && keys[0].algorithm == algorithm && keys[0].createdAt.After(since)
+}
+
+// activeKey выбирает ключ подписи: самый новый из опубликованных не менее
+// jwksMaxAge назад. Если таких нет (первый запуск), подписывает самый старый
+// из имеющихся
+func activeKey(keys []loadedKey, now time.Time) loadedKey {
+	for _, key := range keys {
+		if !key.createdAt.After(now.Add(-jwksMaxAge)) {
+			return key
+		}
+	}
+	return keys[len(keys)-1]
+}
+
+// generate создаёт новый ключ и сохраняет его в базе; вызывается под m.mu
+func (m *keyManager) generate(ctx context.Context, now time.Time) (loadedKey, error) {
+	var signer crypto.Signer
+	var err error
+	switch m.algorithm {
+	case "RS256":
+		signer, err = rsa.GenerateKey(rand.Reader, 2048)
+	case "EdDSA":
+		_, signer, err = ed25519.GenerateKey(rand.Reader)
+	default:
+		err = fmt.Errorf("unsupported JWT algorithm %q", m.algorithm)
+	}
+	if err != nil {
+		return loadedKey{}, err
+	}
+
+	der, err := x509.MarshalPKCS8PrivateKey(signer)
+	if err != nil {
+		return loadedKey{}, err
+	}
+	sealed, err := m.seal(der)
+	if err != nil {
+		return loadedKey{}, err
+	}
+	kid, err := randomToken(12)
+	if err != nil {
+		return loadedKey{}, err
+	}
+
+	sk := SigningKey{ID: kid, Algorithm: m.algorithm, PrivateKey: sealed, CreatedAt: now}
+	if err := m.repo.CreateSigningKey(ctx, &sk); err != nil {
+		return loadedKey{}, err
+	}
+	return loadedKey{id: kid, algorithm: m.algorithm, signer: signer, createdAt: now}, nil
+}
+
+func (m *keyManager) decode(sk SigningKey) (loadedKey, error) {
+	der, err := m.open(sk.PrivateKey)
+	if err != nil {
+		return loadedKey{}, err
+	}
+	parsed, err := x509.ParsePKCS8PrivateKey(der)
+	if err != nil {
+		return loadedKey{}, err
+	}
+	signer, ok := parsed.(crypto.Signer)
+	if !ok {
+		return loadedKey{}, errors.New("not a signing key")
+	}
+	return loadedKey{id: sk.ID, algorithm: sk.Algorithm, signer: signer, createdAt: sk.CreatedAt}, nil
+}
+
+func (m *keyManager) aead() (cipher.AEAD, error) {
+	block, err := aes.NewCipher(m.secret[:])
+	if err != nil {
+		return nil, err
+	}
+	return cipher.NewGCM(block)
+}
+
+func (m *keyManager) seal(plain []byte) ([]byte, error) {
+	aead, err := m.aead()
+	if err != nil {
+		return nil, err
+	}
+	nonce := make([]byte, aead.NonceSize())
+	if _, err := rand.Read(nonce); err != nil {
+		return nil, err
+	}
+	return aead.Seal(nonce, nonce, plain, nil), nil
+}
+
+func (m *keyManager) open(sealed []byte) ([]byte, error) {
+	aead, err := m.aead()
+	if err != nil {
+		return nil, err
+	}
+	if len(sealed) < aead.NonceSize() {
+		return nil, errors.New("ciphertext too short")
+	}
+	plain, err := aead.Open(nil, sealed[:aead.NonceSize()], sealed[aead.NonceSize():], nil)
+	if err != nil {
+		return nil, errors.New("cannot decrypt, was JWT_SECRET changed?")
+	}
+	return plain, nil
+}
+
+// signingKey возвращает ключ, которым подписываются новые токены
+func (m *keyManager) signingKey(ctx context.Context) (loadedKey, error) {
+	m.mu.RLock()
+	keys := m.keys
+	m.mu.RUnlock()
+	now := m.now()
+	if hasFreshKey(keys, m.algorithm, m.nextKeyDue(now)) {
+		return activeKey(keys, now), nil
+	}
+
+	if err := m.refresh(ctx); err != nil {
+		return loadedKey{}, err
+	}
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+	return activeKey(m.keys, now), nil
+}
+
+// verificationKey ищет ключ по kid. Незнакомый kid мог создать другой экземпляр
+// сервера, поэтому перечитываем базу, но не чаще раза в минуту.
+func (m *keyManager) verificationKey(ctx context.Context, kid string) (loadedKey, bool) {
+	find := func() (loadedKey, bool, bool) {
+		m.mu.RLock()
+		defer m.mu.RUnlock()
+		for _, key := range m.keys {
+			if key.id == kid {
+				return key, true, false
+			}
+		}
+		return loadedKey{}, false, m.now().Sub(m.lastRefresh) > time.Minute
+	}
+
+	key, ok, stale := find()
+	if ok || !stale {
+		return key, ok
+	}
+	if err := m.refresh(ctx); err != nil {
+		return loadedKey{}, false
+	}
+	key, ok, _ = find()
+	return key, ok
+}
+
+// rotateSigningKeys периодически обновляет набор ключей, чтобы ротация шла без запросов
+func (s *Server) rotateSigningKeys(ctx context.Context, every time.Duration) {
+	ticker := time.NewTicker(every)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			if err := s.keys.refresh(ctx); err != nil {
+				s.logger.WithError(err).Error("Failed to rotate signing keys")
+			}
+		}
+	}
+}
+
+// jwk — открытый ключ в формате RFC 7517
+type jwk struct {
+	KeyType   string `json:"kty"`
+	KeyID     string `json:"kid"`
+	Use       string `json:"use"`
+	Algorithm string `json:"alg"`
+	N         string `json:"n,omitempty"`
+	E         string `json:"e,omitempty"`
+	Curve     string `json:"crv,omitempty"`
+	X         string `json:"x,omitempty"`
+}
+
+func publicJWK(key loadedKey) (jwk, bool) {
+	b64 := base64.RawURLEncoding.EncodeToString
+	switch pub := key.signer.Public().(type) {
+	case *rsa.PublicKey:
+		return jwk{KeyType: "RSA", KeyID: key.id, Use: "sig", Algorithm: key.algorithm,
+			N: b64(pub.N.Bytes()), E: b64(big.NewInt(int64(pub.E)).Bytes())}, true
+	case ed25519.PublicKey:
+		return jwk{KeyType: "OKP", KeyID: key.id, Use: "sig", Algorithm: key.algorithm,
+			Curve: "Ed25519", X: b64(pub)}, true
+	}
+	return jwk{}, false
+}
+
+func (m *keyManager) jwks() []jwk {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+	keys := []jwk{}
+	for _, key := range m.keys {
+		if k, ok := publicJWK(key); ok {
+			keys = append(keys, k)
+		}
+	}
+	return keys
+}
+
+// Открытые ключи для проверки наших токенов другими сервисами
+func (s *Server) jwksHandler(w http.ResponseWriter, r *http.Request) {
+	if _, err := s.keys.signingKey(r.Context()); err != nil {
+		s.log(r.Context()).WithError(err).Error("Failed to load signing keys")
+		http.Error(w, "Failed to load keys", http.StatusInternalServerError)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("Cache-Control", fmt.Sprintf("public, max-age=%d", int(jwksMaxAge.Seconds())))
+	json.NewEncoder(w).Encode(map[string][]jwk{"keys": s.keys.jwks()})
+}
+
+// parseAccessToken проверяет подпись (только разрешённые алгоритмы и известные kid),
+// срок действия, издателя и аудиторию
+func (s *Server) parseAccessToken(ctx context.Context, tokenStr string) (*Claims, error) {
+	claims := &Claims{}
+	_, err := jwt.ParseWithClaims(tokenStr, claims, func(token *jwt.Token) (interface{}, error) {
+		kid, _ := token.Header["kid"].(string)
+		key, ok := s.keys.verificationKey(ctx, kid)
+		if !ok {
+			return nil, fmt.Errorf("unknown signing key %q", kid)
+		}
+		if token.Method.Alg() != key.algorithm {
+			return nil, fmt.Errorf("signing method %s does not match key %s", token.Method.Alg(), kid)
+		}
+		return key.signer.Public(), nil
+	}, jwt.WithValidMethods(allowedJWTAlgorithms))
+	if err != nil {
+		return nil, err
+	}
+	if !claims.VerifyIssuer(s.config.JWT.Issuer, true) {
+		return nil, errors.New("unexpected token issuer")
+	}
+	if !claims.VerifyAudience(s.config.JWT.Audience, true) {
+		return nil, errors.New("unexpected token audience")
+	}
+	return claims, nil
+}
@@ -0,0 +1,200 @@
+package main
+
+import (
+	"context"
+	"crypto/ed25519"
+	"crypto/rsa"
+	"encoding/base64"
+	"encoding/json"
+	"math/big"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/golang-jwt/jwt/v4"
+	"github.com/stretchr/testify/assert"
+)
+
+func jwksResponse(s *Server) *httptest.ResponseRecorder {
+	req, _ := http.NewRequest("GET", "/.well-known/jwks.json", nil)
+	rr := httptest.NewRecorder()
+	s.routes().ServeHTTP(rr, req)
+	return rr
+}
+
+func fetchJWKS(t *testing.T, s *Server) []jwk {
+	t.Helper()
+	rr := jwksResponse(s)
+	assert.Equal(t, http.StatusOK, rr.Code, "Expected 200 OK")
+
+	var set struct {
+		Keys []jwk `json:"keys"`
+	}
+	assert.NoError(t, json.NewDecoder(rr.Body).Decode(&set))
+	return set.Keys
+}
+
+// publicKeyFromJWK восстанавливает ключ так, как это сделал бы сторонний сервис
+func publicKeyFromJWK(t *testing.T, k jwk) interface{} {
+	t.Helper()
+	b64 := func(s string) []byte {
+		b, err := base64.RawURLEncoding.DecodeString(s)
+		assert.NoError(t, err)
+		return b
+	}
+	switch k.KeyType {
+	case "RSA":
+		return &rsa.PublicKey{N: new(big.Int).SetBytes(b64(k.N)), E: int(new(big.Int).SetBytes(b64(k.E)).Int64())}
+	case "OKP":
+		return ed25519.PublicKey(b64(k.X))
+	}
+	t.Fatalf("unexpected key type %q", k.KeyType)
+	return nil
+}
+
+func TestJWKSVerifiesIssuedTokens(t *testing.T) {
+	t.Parallel()
+	for _, alg := range allowedJWTAlgorithms {
+		t.Run(alg, func(t *testing.T) {
+			t.Parallel()
+			cfg := testConfig()
+			cfg.JWT.Algorithm = alg
+			s := newConfigTestServer(t, cfg, newMemoryTokenRepository())
+
+			tokenStr, err := s.generateJWT(context.Background(), User{ID: 7, Email: "a@example.com", Role: "user"}, Session{})
+			assert.NoError(t, err)
+
+			keys := fetchJWKS(t, s)
+			assert.Len(t, keys, 1)
+			assert.Equal(t, alg, keys[0].Algorithm)
+
+			claims := &Claims{}
+			token, err := jwt.ParseWithClaims(tokenStr, claims, func(token *jwt.Token) (interface{}, error) {
+				assert.Equal(t, keys[0].KeyID, token.Header["kid"])
+				return publicKeyFromJWK(t, keys[0]), nil
+			}, jwt.WithValidMethods([]string{alg}))
+			assert.NoError(t, err)
+			assert.True(t, token.Valid)
+			assert.Equal(t, "bookstore", claims.Issuer)
+			assert.Equal(t, "7", claims.Subject)
+		})
+	}
+}
+
+func TestSigningKeyRotation(t *testing.T) {
+	t.Parallel()
+	cfg := testConfig()
+	s := newConfigTestServer(t, cfg, newMemoryTokenRepository())
+	ctx := context.Background()
+	user := User{ID: 1, Email: "a@example.com"}
+
+	now := time.Now()
+	s.keys.now = func() time.Time { return now }
+
+	oldToken, err := s.generateJWT(ctx, user, Session{})
+	assert.NoError(t, err)
+
+	// За jwksMaxAge до ротации новый ключ уже опубликован, но ещё не подписывает
+	now = now.Add(cfg.JWT.KeyRotation - jwksMaxAge + time.Second)
+	_, err = s.generateJWT(ctx, user, Session{})
+	assert.NoError(t, err)
+	keys := fetchJWKS(t, s)
+	assert.Len(t, keys, 2)
+	assert.Equal(t, "public, max-age=300", jwksResponse(s).Header().Get("Cache-Control"))
+	signing, err := s.keys.signingKey(ctx)
+	assert.NoError(t, err)
+	assert.Equal(t, keys[1].KeyID, signing.id, "The new key waits until cached sets have expired")
+
+	// Пора ротировать: новый ключ подписывает, старый ещё принимается
+	now = now.Add(jwksMaxAge)
+	newToken, err := s.generateJWT(ctx, user, Session{})
+	assert.NoError(t, err)
+	signing, err = s.keys.signingKey(ctx)
+	assert.NoError(t, err)
+	assert.Equal(t, keys[0].KeyID, signing.id)
+	assert.Len(t, s.keys.jwks(), 2)
+	_, err = s.parseAccessToken(ctx, oldToken)
+	assert.NoError(t, err)
+
+	// Через AccessTTL токенов старого ключа не осталось, ключ удаляется
+	now = now.Add(cfg.JWT.AccessTTL)
+	assert.NoError(t, s.keys.refresh(ctx))
+	assert.Len(t, s.keys.jwks(), 1)
+	_, err = s.parseAccessToken(ctx, oldToken)
+	assert.ErrorContains(t, err, "unknown signing key")
+	_, err = s.parseAccessToken(ctx, newToken)
+	assert.NoError(t, err)
+}
+
+func TestSigningKeysSharedThroughDatabase(t *testing.T) {
+	t.Parallel()
+	repo := newGormTokenRepository(newMigratedTestDB(t))
+	ctx := context.Background()
+
+	first := newConfigTestServer(t, testConfig(), repo)
+	tokenStr, err := first.generateJWT(ctx, User{ID: 1, Email: "a@example.com"}, Session{})
+	assert.NoError(t, err)
+
+	// Другой экземпляр с тем же JWT_SECRET принимает токен
+	second := newConfigTestServer(t, testConfig(), repo)
+	_, err = second.parseAccessToken(ctx, tokenStr)
+	assert.NoError(t, err)
+
+	stored, _ := repo.ListSigningKeys(ctx)
+	assert.Len(t, stored, 1)
+	assert.NotContains(t, string(stored[0].PrivateKey), "PRIVATE KEY")
+
+	cfg := testConfig()
+	cfg.JWT.Secret = "another-secret-key-that-is-long-enough"
+	assert.ErrorContains(t, newConfigTestServer(t, cfg, repo).keys.refresh(ctx), "JWT_SECRET")
+}
+
+func TestAccessTokenValidation(t *testing.T) {
+	t.Parallel()
+	s := newConfigTestServer(t, testConfig(), newMemoryTokenRepository())
+	ctx := context.Background()
+	key, err := s.keys.signingKey(ctx)
+	assert.NoError(t, err)
+
+	sign := func(method jwt.SigningMethod, signingKey interface{}, mutate func(*Claims)) string {
+		claims := &Claims{
+			Email: "a@example.com",
+			RegisteredClaims: jwt.RegisteredClaims{
+				Issuer:    "bookstore",
+				Audience:  jwt.ClaimStrings{"bookstore"},
+				ExpiresAt: jwt.NewNumericDate(time.Now().Add(time.Minute)),
+			},
+		}
+		mutate(claims)
+		token := jwt.NewWithClaims(method, claims)
+		token.Header["kid"] = key.id
+		signed, err := token.SignedString(signingKey)
+		assert.NoError(t, err)
+		return signed
+	}
+	noop := func(*Claims) {}
+
+	_, err = s.parseAccessToken(ctx, sign(jwt.GetSigningMethod(key.algorithm), key.signer, noop))
+	assert.NoError(t, err)
+
+	rejected := map[string]string{
+		"HS256 with the secret": sign(jwt.SigningMethodHS256, []byte(testJWTSecret), noop),
+		"alg none":              sign(jwt.SigningMethodNone, jwt.UnsafeAllowNoneSignatureType, noop),
+		"wrong issuer": sign(jwt.GetSigningMethod(key.algorithm), key.signer, func(c *Claims) {
+			c.Issuer = "someone-else"
+		}),
+		"wrong audience": sign(jwt.GetSigningMethod(key.algorithm), key.signer, func(c *Claims) {
+			c.Audience = jwt.ClaimStrings{"another-service"}
+		}),
+		"expired": sign(jwt.GetSigningMethod(key.algorithm), key.signer, func(c *Claims) {
+			c.ExpiresAt = jwt.NewNumericDate(time.Now().Add(-time.Minute))
+		}),
+	}
+	handler := s.routes()
+	for name, tokenStr := range rejected {
+		_, err := s.parseAccessToken(ctx, tokenStr)
+		assert.Error(t, err, name)
+		assert.Equal(t, http.StatusUnauthorized, getProfile(handler, tokenStr), name)
+	}
+}
@@ -0,0 +1,160 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/sirupsen/logrus"
+	"golang.org/x/crypto/bcrypt"
+)
+
+// Защита входа по паролю от перебора: неудачные попытки считаются отдельно
+// по аккаунту (email) и по IP. После нескольких бесплатных попыток каждая
+// следующая удваивает паузу до максимума; успешный вход сбрасывает счётчик аккаунта.
+
+// backoffPolicy: free попыток без задержки, затем base, 2*base, 4*base... но не больше max
+type backoffPolicy struct {
+	free int
+	base time.Duration
+	max  time.Duration
+	// forget — через сколько после последней неудачи счётчик обнуляется
+	forget time.Duration
+}
+
+var (
+	accountLoginPolicy = backoffPolicy{free: 5, base: time.Minute, max: time.Hour, forget: 24 * time.Hour}
+	ipLoginPolicy      = backoffPolicy{free: 20, base: 10 * time.Second, max: time.Hour, forget: 24 * time.Hour}
+)
+
+func (p backoffPolicy) delay(failures int) time.Duration {
+	if failures < p.free {
+		return 0
+	}
+	d := p.base
+	for i := p.free; i < failures && d < p.max; i++ {
+		d *= 2
+	}
+	return min(d, p.max)
+}
+
+// loginSweepInterval — как часто удалять из памяти забытые счётчики
+const loginSweepInterval = time.Minute
+
+type loginFailures struct {
+	count int
+	last  time.Time
+}
+
+// loginThrottle хранит счётчики неудач по ключу и считает, сколько ждать до следующей попытки
+type loginThrottle struct {
+	policy backoffPolicy
+	now    func() time.Time
+
+	mu        sync.Mutex
+	failures  map[string]loginFailures
+	lastSweep time.Time
+}
+
+func newLoginThrottle(policy backoffPolicy) *loginThrottle {
+	return &loginThrottle{policy: policy, now: time.Now, failures: map[string]loginFailures{}}
+}
+
+// current возвращает актуальный счётчик; вызывается под t.mu
+func (t *loginThrottle) current(key string) loginFailures {
+	f, ok := t.failures[key]
+	if ok && t.now().Sub(f.last) > t.policy.forget {
+		delete(t.failures, key)
+		return loginFailures{}
+	}
+	return f
+}
+
+// RetryAfter — сколько ещё ждать; 0, если пробовать можно
+func (t *loginThrottle) RetryAfter(key string) time.Duration {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	f := t.current(key)
+	if f.count == 0 {
+		return 0
+	}
+	return max(f.last.Add(t.policy.delay(f.count)).Sub(t.now()), 0)
+}
+
+// Fail записывает неудачу и возвращает новое число неудач подряд
+func (t *loginThrottle) Fail(key string) int {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	if now := t.now(); now.Sub(t.lastSweep) >= loginSweepInterval {
+		t.sweep(now)
+	}
+	f := t.current(key)
+	f.count++
+	f.last = t.now()
+	t.failures[key] = f
+	return f.count
+}
+
+// sweep удаляет забытые счётчики; вызывается под t.mu
+func (t *loginThrottle) sweep(now time.Time) {
+	for key, f := range t.failures {
+		if now.Sub(f.last) > t.policy.forget {
+			delete(t.failures, key)
+		}
+	}
+	t.lastSweep = now
+}
+
+func (t *loginThrottle) Reset(key string) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	delete(t.failures, key)
+}
+
+// loginKey — ключ счётчика аккаунта; считаем и несуществующие email,
+// чтобы по блокировке нельзя было узнать, есть ли такой пользователь
+func loginKey(email string) string {
+	return strings.ToLower(strings.TrimSpace(email))
+}
+
+// dummyPasswordHash сравнивается с паролем, когда пользователя нет, чтобы ответ занимал столько же времени
+var dummyPasswordHash, _ = bcrypt.GenerateFromPassword([]byte("not-a-real-password"), bcrypt.DefaultCost)
+
+// loginRetryAfter — сколько ждать до следующей попытки входа с этого IP на этот аккаунт
+func (s *Server) loginRetryAfter(ip, email string) time.Duration {
+	return max(s.ipLogins.RetryAfter(ip), s.accountLogins.RetryAfter(loginKey(email)))
+}
+
+// loginFailed учитывает неудачную попытку; при блокировке аккаунта владелец получает письмо
+func (s *Server) loginFailed(ctx context.Context, ip, email string, user *User) {
+	s.ipLogins.Fail(ip)
+	failures := s.accountLogins.Fail(loginKey(email))
+	if user == nil || failures != accountLoginPolicy.free {
+		return
+	}
+	lockout := accountLoginPolicy.delay(failures)
+	log := s.log(ctx)
+	log.WithFields(logrus.Fields{
+		"user_id": user.ID,
+		"ip":      ip,
+	}).Warn("Account temporarily locked after failed logins")
+	ctx = context.WithoutCancel(ctx)
+	go func() {
+		if err := s.sendLockoutEmail(ctx, user.Email, lockout); err != nil {
+			log.WithError(err).Error("Failed to send lockout notification")
+		}
+	}()
+}
+
+func (s *Server) sendLockoutEmail(ctx context.Context, to string, lockout time.Duration) error {
+	message := fmt.Sprintf("There were %d failed attempts to sign in to your account, so signing in "+
+		"with a password is paused for %d minutes. Further failed attempts make the pause longer.\r\n\r\n"+
+		"If it was you, wait and try again or reset your password: %s/signin.html\r\n"+
+		"If it wasn't you, your password is still safe, but consider changing it and turning on two-factor authentication.",
+		accountLoginPolicy.free, int(lockout.Minutes()), s.config.BaseURL())
+	msg := []byte("To: " + to + "\r\n" + "Subject: Sign-in attempts to your account\r\n" + "\r\n" + message)
+
+	return s.sendMail(ctx, "lockout", []string{to}, msg)
+}
@@ -0,0 +1,119 @@
+package main
+
+import (
+	"net/http"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func login(handler http.Handler, email, password string) (int, string) {
+	rr := postJSON(handler, "/login", "", map[string]string{"email": email, "password": password})
+	return rr.Code, strings.TrimSpace(rr.Body.String())
+}
+
+func TestLoginErrorsAreUniform(t *testing.T) {
+	t.Parallel()
+	s := newMemoryTestServer(t)
+	handler := s.routes()
+	loginTestUser(t, s, handler)
+
+	unknownCode, unknownBody := login(handler, "nobody@example.com", "secret123")
+	wrongCode, wrongBody := login(handler, "reader@example.com", "wrong-password")
+	assert.Equal(t, http.StatusUnauthorized, unknownCode)
+	assert.Equal(t, unknownCode, wrongCode)
+	assert.Equal(t, unknownBody, wrongBody, "Unknown email and wrong password must look the same")
+}
+
+func TestAccountLockout(t *testing.T) {
+	t.Parallel()
+	s := newMemoryTestServer(t)
+	handler := s.routes()
+	loginTestUser(t, s, handler)
+	now := time.Now()
+	s.accountLogins.now = func() time.Time { return now }
+
+	for range accountLoginPolicy.free {
+		code, _ := login(handler, "reader@example.com", "wrong-password")
+		assert.Equal(t, http.StatusUnauthorized, code)
+	}
+
+	// Даже верный пароль не принимается, пока идёт пауза
+	rr := postJSON(handler, "/login", "", map[string]string{"email": "reader@example.com", "password": "secret123"})
+	assert.Equal(t, http.StatusTooManyRequests, rr.Code, "Expected 429 while locked")
+	assert.Equal(t, "60", rr.Header().Get("Retry-After"))
+
+	mailer := s.mailer.(*fakeMailer)
+	assert.Eventually(t, func() bool { return len(mailer.Sent()) == 1 }, time.Second, 10*time.Millisecond)
+	assert.Equal(t, []string{"reader@example.com"}, mailer.Sent()[0].To)
+	assert.Contains(t, mailer.Sent()[0].Msg, "Subject: Sign-in attempts to your account")
+
+	// Каждая следующая неудача после паузы удваивает её, письмо повторно не отправляется
+	now = now.Add(time.Minute)
+	code, _ := login(handler, "reader@example.com", "wrong-password")
+	assert.Equal(t, http.StatusUnauthorized, code)
+	assert.Equal(t, 2*time.Minute, s.accountLogins.RetryAfter("reader@example.com"))
+
+	now = now.Add(2 * time.Minute)
+	code, _ = login(handler, "reader@example.com", "secret123")
+	assert.Equal(t, http.StatusOK, code, "Successful login resets the counter")
+	assert.Zero(t, s.accountLogins.RetryAfter("reader@example.com"))
+	assert.Len(t, mailer.Sent(), 1)
+}
+
+func TestUnknownAccountLockout(t *testing.T) {
+	t.Parallel()
+	s := newMemoryTestServer(t)
+	handler := s.routes()
+
+	for range accountLoginPolicy.free {
+		login(handler, "nobody@example.com", "secret123")
+	}
+	code, _ := login(handler, "nobody@example.com", "secret123")
+	assert.Equal(t, http.StatusTooManyRequests, code, "Unknown accounts are throttled the same way")
+	assert.Empty(t, s.mailer.(*fakeMailer).Sent())
+}
+
+func TestIPLoginBackoff(t *testing.T) {
+	t.Parallel()
+	s := newMemoryTestServer(t)
+	handler := s.routes()
+	loginTestUser(t, s, handler)
+	// Перебор по многим аккаунтам с одного адреса
+	for i := range ipLoginPolicy.free {
+		code, _ := login(handler, "reader"+strconv.Itoa(i)+"@example.com", "secret123")
+		assert.Equal(t, http.StatusUnauthorized, code)
+	}
+
+	code, _ := login(handler, "reader@example.com", "secret123")
+	assert.Equal(t, http.StatusTooManyRequests, code)
+}
+
+func TestBackoffPolicy(t *testing.T) {
+	p := backoffPolicy{free: 3, base: time.Minute, max: 10 * time.Minute}
+	assert.Zero(t, p.delay(2))
+	assert.Equal(t, time.Minute, p.delay(3))
+	assert.Equal(t, 2*time.Minute, p.delay(4))
+	assert.Equal(t, 8*time.Minute, p.delay(6))
+	assert.Equal(t, 10*time.Minute, p.delay(7))
+	assert.Equal(t, 10*time.Minute, p.delay(100))
+}
+
+func TestLoginThrottleSweep(t *testing.T) {
+	now := time.Now()
+	throttle := newLoginThrottle(backoffPolicy{free: 3, base: time.Minute, max: time.Hour, forget: time.Second})
+	throttle.now = func() time.Time { return now }
+
+	// Чаще раза в loginSweepInterval карта не обходится
+	throttle.Fail("old")
+	now = now.Add(2 * time.Second)
+	throttle.Fail("new")
+	assert.Len(t, throttle.failures, 2)
+
+	now = now.Add(loginSweepInterval)
+	throttle.Fail("another")
+	assert.Len(t, throttle.failures, 1, "Forgotten counters are swept")
+}
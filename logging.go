@@ -0,0 +1,130 @@
+package main
+
+import (
+	"context"
+	"net/http"
+	"time"
+
+	"github.com/sirupsen/logrus"
+	"go.opentelemetry.io/otel/trace"
+)
+
+// Журнал запросов: у каждого запроса есть ID из заголовка X-Request-ID
+// (присланный клиентом или балансировщиком, иначе новый). Он возвращается в
+// ответе и попадает во все записи, сделанные через s.log(ctx), так что по
+// нему находятся все строки одного запроса. Если запрос трассируется, в
+// журнал попадает и trace_id, см. tracing.go.
+
+const requestIDHeader = "X-Request-ID"
+
+type requestLogKey struct{}
+
+// requestLog — логгер запроса; после входа в него добавляется пользователь
+type requestLog struct {
+	entry *logrus.Entry
+}
+
+// log возвращает логгер текущего запроса, а вне запроса — общий логгер сервера
+func (s *Server) log(ctx context.Context) *logrus.Entry {
+	if rl, ok := ctx.Value(requestLogKey{}).(*requestLog); ok {
+		return rl.entry
+	}
+	return logrus.NewEntry(s.logger)
+}
+
+// withClaims кладёт claims в контекст запроса и отмечает пользователя в журнале
+func withClaims(r *http.Request, claims *Claims) *http.Request {
+	if rl, ok := r.Context().Value(requestLogKey{}).(*requestLog); ok {
+		if claims.APIKeyID != 0 {
+			rl.entry = rl.entry.WithField("api_key_id", claims.APIKeyID)
+		} else {
+			rl.entry = rl.entry.WithField("user_id", claimsUserID(claims))
+		}
+	}
+	return r.WithContext(context.WithValue(r.Context(), "user", claims))
+}
+
+// validRequestID — чужой ID принимается, только если он короткий и печатаемый
+func validRequestID(id string) bool {
+	if id == "" || len(id) > 128 {
+		return false
+	}
+	for i := 0; i < len(id); i++ {
+		if id[i] <= ' ' || id[i] > '~' {
+			return false
+		}
+	}
+	return true
+}
+
+// loggingResponseWriter запоминает статус и размер ответа
+type loggingResponseWriter struct {
+	http.ResponseWriter
+	status int
+	bytes  int
+}
+
+func (w *loggingResponseWriter) WriteHeader(status int) {
+	if w.status == 0 {
+		w.status = status
+	}
+	w.ResponseWriter.WriteHeader(status)
+}
+
+func (w *loggingResponseWriter) Write(b []byte) (int, error) {
+	if w.status == 0 {
+		w.status = http.StatusOK
+	}
+	n, err := w.ResponseWriter.Write(b)
+	w.bytes += n
+	return n, err
+}
+
+// Unwrap нужен http.ResponseController
+func (w *loggingResponseWriter) Unwrap() http.ResponseWriter {
+	return w.ResponseWriter
+}
+
+// requestLogging назначает запросу ID и пишет по строке журнала на каждый ответ
+func (s *Server) requestLogging(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		start := time.Now()
+		id := r.Header.Get(requestIDHeader)
+		if !validRequestID(id) {
+			generated, err := randomToken(12)
+			if err != nil {
+				s.logger.WithError(err).Error("Failed to generate request ID")
+			}
+			id = generated
+			r.Header.Set(requestIDHeader, id)
+		}
+		w.Header().Set(requestIDHeader, id)
+
+		fields := logrus.Fields{
+			"request_id": id,
+			"method":     r.Method,
+			"path":       r.URL.Path,
+		}
+		if sc := trace.SpanContextFromContext(r.Context()); sc.IsValid() {
+			fields["trace_id"] = sc.TraceID().String()
+		}
+		rl := &requestLog{entry: s.logger.WithFields(fields)}
+		lw := &loggingResponseWriter{ResponseWriter: w}
+		next.ServeHTTP(lw, r.WithContext(context.WithValue(r.Context(), requestLogKey{}, rl)))
+
+		if lw.status == 0 {
+			lw.status = http.StatusOK
+		}
+		entry := rl.entry.WithFields(logrus.Fields{
+			"status":     lw.status,
+			"bytes":      lw.bytes,
+			"latency_ms": float64(time.Since(start).Microseconds()) / 1000,
+			"ip":         s.clientIP(r),
+		})
+		if lw.status >= http.StatusInternalServerError {
+			entry.Error("Request completed")
+		} else {
+			entry.Info("Request completed")
+		}
+	})
+}
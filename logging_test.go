@@ -0,0 +1,84 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/sirupsen/logrus"
+	"github.com/sirupsen/logrus/hooks/test"
+	"github.com/stretchr/testify/assert"
+)
+
+// accessLog — строка журнала о завершённом запросе
+func accessLog(hook *test.Hook) *logrus.Entry {
+	entries := hook.AllEntries()
+	for i := len(entries) - 1; i >= 0; i-- {
+		if entries[i].Message == "Request completed" {
+			return entries[i]
+		}
+	}
+	return nil
+}
+
+func TestRequestLogging(t *testing.T) {
+	t.Parallel()
+	s := newMemoryTestServer(t)
+	hook := test.NewLocal(s.logger)
+	handler := s.routes()
+
+	rr := getFrom(handler, "/healthz", "203.0.113.1", "")
+	id := rr.Header().Get(requestIDHeader)
+	assert.NotEmpty(t, id, "Every response carries a request ID")
+	entry := accessLog(hook)
+	if assert.NotNil(t, entry) {
+		assert.Equal(t, logrus.InfoLevel, entry.Level)
+		assert.Equal(t, id, entry.Data["request_id"])
+		assert.Equal(t, "GET", entry.Data["method"])
+		assert.Equal(t, "/healthz", entry.Data["path"])
+		assert.Equal(t, http.StatusOK, entry.Data["status"])
+		assert.Equal(t, rr.Body.Len(), entry.Data["bytes"])
+		assert.Contains(t, entry.Data, "latency_ms")
+		assert.NotContains(t, entry.Data, "user_id")
+	}
+
+	// ID от балансировщика сохраняется, мусор заменяется
+	for header, keep := range map[string]bool{"lb-7f3a9c": true, "has space": false, strings.Repeat("x", 200): false} {
+		req, _ := http.NewRequest("GET", "/healthz", nil)
+		req.Header.Set(requestIDHeader, header)
+		rr := httptest.NewRecorder()
+		handler.ServeHTTP(rr, req)
+		assert.Equal(t, keep, rr.Header().Get(requestIDHeader) == header, header)
+		assert.Equal(t, rr.Header().Get(requestIDHeader), accessLog(hook).Data["request_id"])
+	}
+}
+
+func TestRequestLoggingCorrelatesHandlerLogs(t *testing.T) {
+	t.Parallel()
+	s := newMemoryTestServer(t)
+	hook := test.NewLocal(s.logger)
+	handler := s.routes()
+	pair := loginTestUser(t, s, handler)
+
+	hook.Reset()
+	rr := postJSON(handler, "/logout", pair.AccessToken, nil)
+	assert.Equal(t, http.StatusOK, rr.Code)
+	id := rr.Header().Get(requestIDHeader)
+
+	var logout *logrus.Entry
+	for _, entry := range hook.AllEntries() {
+		if entry.Message == "User logged out" {
+			logout = entry
+		}
+	}
+	if assert.NotNil(t, logout, "The handler logs through the request logger") {
+		assert.Equal(t, id, logout.Data["request_id"])
+		assert.Equal(t, "/logout", logout.Data["path"])
+	}
+	entry := accessLog(hook)
+	if assert.NotNil(t, entry) {
+		assert.Equal(t, id, entry.Data["request_id"])
+		assert.EqualValues(t, 1, entry.Data["user_id"], "The signed-in user is recorded")
+	}
+}
@@ -0,0 +1,360 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"database/sql/driver"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/sirupsen/logrus"
+)
+
+// DBHook пишет журнал в таблицу log_entries, не задерживая запросы: Fire только
+// кладёт строку в буфер, а отдельная горутина сохраняет её пачками. Если база
+// не успевает, Fire ждёт места не дольше DBBlockTimeout и отбрасывает строку;
+// число отброшенных строк попадает в журнал и в ответ /admin/logs.
+type DBHook struct {
+	logs          LogRepository
+	entries       chan LogEntry
+	batchSize     int
+	flushInterval time.Duration
+	blockTimeout  time.Duration
+
+	dropped  atomic.Uint64
+	reported uint64 // сколько отброшенных строк уже записано в журнал; меняет только run
+	stop     chan struct{}
+	done     chan struct{}
+	stopOnce sync.Once
+}
+
+func newDBHook(logs LogRepository, cfg LogConfig) *DBHook {
+	h := &DBHook{
+		logs:          logs,
+		entries:       make(chan LogEntry, cfg.DBBuffer),
+		batchSize:     cfg.DBBatchSize,
+		flushInterval: cfg.DBFlushInterval,
+		blockTimeout:  cfg.DBBlockTimeout,
+		stop:          make(chan struct{}),
+		done:          make(chan struct{}),
+	}
+	go h.run()
+	return h
+}
+
+// Levels implements logrus.Hook.
+func (h *DBHook) Levels() []logrus.Level {
+	return logrus.AllLevels
+}
+
+// Fire implements logrus.Hook.
+func (h *DBHook) Fire(entry *logrus.Entry) error {
+	e := LogEntry{
+		Timestamp: entry.Time.UTC(),
+		Level:     entry.Level.String(),
+		Message:   entry.Message,
+		Fields:    newLogFields(entry.Data),
+	}
+	select {
+	case h.entries <- e:
+		return nil
+	case <-h.stop:
+		h.dropped.Add(1)
+		return nil
+	default:
+	}
+
+	// Буфер полон: даём записи немного времени, но не держим запрос
+	timer := time.NewTimer(h.blockTimeout)
+	defer timer.Stop()
+	select {
+	case h.entries <- e:
+	case <-timer.C:
+		h.dropped.Add(1)
+	case <-h.stop:
+		h.dropped.Add(1)
+	}
+	return nil
+}
+
+// Dropped — сколько строк не попало в базу с момента запуска
+func (h *DBHook) Dropped() uint64 {
+	return h.dropped.Load()
+}
+
+// Close дописывает буфер в базу и останавливает запись
+func (h *DBHook) Close(ctx context.Context) error {
+	h.stopOnce.Do(func() { close(h.stop) })
+	select {
+	case <-h.done:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+func (h *DBHook) run() {
+	defer close(h.done)
+	ticker := time.NewTicker(h.flushInterval)
+	defer ticker.Stop()
+
+	batch := make([]LogEntry, 0, h.batchSize)
+	for {
+		select {
+		case e := <-h.entries:
+			batch = append(batch, e)
+			if len(batch) >= h.batchSize {
+				batch = h.flush(batch)
+			}
+		case <-ticker.C:
+			batch = h.flush(batch)
+		case <-h.stop:
+			for {
+				select {
+				case e := <-h.entries:
+					batch = append(batch, e)
+					if len(batch) >= h.batchSize {
+						batch = h.flush(batch)
+					}
+				default:
+					h.flush(batch)
+					return
+				}
+			}
+		}
+	}
+}
+
+// flush сохраняет пачку и возвращает пустой срез для следующей. Ошибки идут
+// в stderr: запись через logrus снова попала бы в этот же хук
+func (h *DBHook) flush(batch []LogEntry) []LogEntry {
+	if dropped := h.dropped.Load(); dropped > h.reported {
+		batch = append(batch, LogEntry{
+			Timestamp: time.Now().UTC(),
+			Level:     logrus.WarnLevel.String(),
+			Message:   "Log entries dropped: database log buffer is full",
+			Fields:    logFields{"dropped": dropped - h.reported, "dropped_total": dropped},
+		})
+		h.reported = dropped
+	}
+	if len(batch) == 0 {
+		return batch
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+	if err := h.logs.CreateLogEntries(ctx, batch); err != nil {
+		h.dropped.Add(uint64(len(batch)))
+		fmt.Fprintf(os.Stderr, "failed to write %d log entries to the database: %v\n", len(batch), err)
+	}
+	return batch[:0]
+}
+
+// logFields — поля записи logrus; в базе хранятся как JSON
+type logFields map[string]any
+
+// newLogFields копирует поля записи: ошибки сохраняются текстом, а значения,
+// которые не сериализуются в JSON, — в виде fmt.Sprint
+func newLogFields(data logrus.Fields) logFields {
+	if len(data) == 0 {
+		return nil
+	}
+	fields := make(logFields, len(data))
+	for k, v := range data {
+		switch v := v.(type) {
+		case error:
+			fields[k] = v.Error()
+		default:
+			if _, err := json.Marshal(v); err != nil {
+				fields[k] = fmt.Sprint(v)
+			} else {
+				fields[k] = v
+			}
+		}
+	}
+	return fields
+}
+
+// Value implements driver.Valuer.
+func (f logFields) Value() (driver.Value, error) {
+	if len(f) == 0 {
+		return nil, nil
+	}
+	data, err := marshalLogJSON(map[string]any(f))
+	return string(data), err
+}
+
+// Scan implements sql.Scanner.
+func (f *logFields) Scan(src any) error {
+	var data []byte
+	switch v := src.(type) {
+	case nil:
+		*f = nil
+		return nil
+	case string:
+		data = []byte(v)
+	case []byte:
+		data = v
+	default:
+		return fmt.Errorf("unsupported log fields type %T", src)
+	}
+	return json.Unmarshal(data, f)
+}
+
+// matches — то же условие, что logFieldPatterns, для in-memory репозитория
+func (f logFields) matches(key, value string) bool {
+	v, ok := f[key]
+	if !ok || value == "" {
+		return ok
+	}
+	if s, ok := v.(string); ok {
+		return s == value
+	}
+	return fmt.Sprint(v) == value
+}
+
+// marshalLogJSON — JSON без экранирования HTML, чтобы поиск по тексту совпадал с записанным
+func marshalLogJSON(v any) ([]byte, error) {
+	var buf bytes.Buffer
+	enc := json.NewEncoder(&buf)
+	enc.SetEscapeHTML(false)
+	if err := enc.Encode(v); err != nil {
+		return nil, err
+	}
+	return bytes.TrimSuffix(buf.Bytes(), []byte("\n")), nil
+}
+
+var likeEscaper = strings.NewReplacer(`\`, `\\`, `%`, `\%`, `_`, `\_`)
+
+// logFieldPatterns строит LIKE-шаблоны для поиска поля в JSON-тексте: строка
+// ищется в кавычках, а число или true/false — ещё и без них
+func logFieldPatterns(key, value string) []string {
+	k, _ := marshalLogJSON(key)
+	prefix := "%" + likeEscaper.Replace(string(k)+":")
+	if value == "" {
+		return []string{prefix + "%"}
+	}
+	v, _ := marshalLogJSON(value)
+	patterns := []string{prefix + likeEscaper.Replace(string(v)) + "%"}
+	var scalar any
+	if err := json.Unmarshal([]byte(value), &scalar); err == nil {
+		switch scalar.(type) {
+		case float64, bool:
+			raw := likeEscaper.Replace(value)
+			patterns = append(patterns, prefix+raw+",%", prefix+raw+"}")
+		}
+	}
+	return patterns
+}
+
+// purgeOldLogs удаляет записи журнала старше LOG_RETENTION
+func (s *Server) purgeOldLogs(ctx context.Context, every time.Duration) {
+	if s.config.Log.Retention == 0 {
+		return
+	}
+	ticker := time.NewTicker(every)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case now := <-ticker.C:
+			if err := s.logs.DeleteLogEntriesBefore(ctx, now.Add(-s.config.Log.Retention)); err != nil {
+				s.logger.WithError(err).Error("Failed to purge old log entries")
+			}
+		}
+	}
+}
+
+// droppedLogEntries — счётчик DBHook, если хук подключён к логгеру сервера
+func (s *Server) droppedLogEntries() uint64 {
+	for _, hook := range s.logger.Hooks[logrus.ErrorLevel] {
+		if h, ok := hook.(*DBHook); ok {
+			return h.Dropped()
+		}
+	}
+	return 0
+}
+
+const maxLogPageSize = 1000
+
+// Просмотр журнала: GET /admin/logs?level=error,warning&from=...&to=...&field=user_id:7&limit=100&before=<id>.
+// from и to — время в RFC 3339; field=key ищет записи с полем, field=key:value — с его значением.
+// Записи идут от новых к старым; следующую страницу даёт before=next_before
+func (s *Server) listLogsHandler(w http.ResponseWriter, r *http.Request) {
+	q := r.URL.Query()
+	filter := LogFilter{Limit: 100}
+	if v := q.Get("level"); v != "" {
+		for _, name := range strings.Split(v, ",") {
+			level, err := logrus.ParseLevel(strings.TrimSpace(name))
+			if err != nil {
+				http.Error(w, fmt.Sprintf("Unknown log level %q", name), http.StatusBadRequest)
+				return
+			}
+			filter.Levels = append(filter.Levels, level.String())
+		}
+	}
+	for _, p := range []struct {
+		name string
+		dst  *time.Time
+	}{{"from", &filter.From}, {"to", &filter.To}} {
+		if v := q.Get(p.name); v != "" {
+			t, err := time.Parse(time.RFC3339, v)
+			if err != nil {
+				http.Error(w, p.name+" must be an RFC 3339 time, e.g. 2025-01-02T15:04:05Z", http.StatusBadRequest)
+				return
+			}
+			*p.dst = t
+		}
+	}
+	if v := q.Get("field"); v != "" {
+		filter.Field, filter.Value, _ = strings.Cut(v, ":")
+		if filter.Field == "" {
+			http.Error(w, "field must look like key or key:value", http.StatusBadRequest)
+			return
+		}
+	}
+	if v := q.Get("limit"); v != "" {
+		limit, err := strconv.Atoi(v)
+		if err != nil || limit <= 0 || limit > maxLogPageSize {
+			http.Error(w, fmt.Sprintf("limit must be between 1 and %d", maxLogPageSize), http.StatusBadRequest)
+			return
+		}
+		filter.Limit = limit
+	}
+	if v := q.Get("before"); v != "" {
+		before, err := strconv.ParseUint(v, 10, 64)
+		if err != nil {
+			http.Error(w, "Invalid before ID", http.StatusBadRequest)
+			return
+		}
+		filter.BeforeID = uint(before)
+	}
+
+	entries, err := s.logs.ListLogEntries(r.Context(), filter)
+	if err != nil {
+		s.log(r.Context()).WithError(err).Error("Failed to load log entries")
+		http.Error(w, "Failed to load log entries", http.StatusInternalServerError)
+		return
+	}
+	response := struct {
+		Entries    []LogEntry `json:"entries"`
+		NextBefore uint       `json:"next_before,omitempty"`
+		Dropped    uint64     `json:"dropped"`
+	}{Entries: entries, Dropped: s.droppedLogEntries()}
+	if response.Entries == nil {
+		response.Entries = []LogEntry{}
+	}
+	if len(entries) == filter.Limit {
+		response.NextBefore = entries[len(entries)-1].ID
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(response)
+}
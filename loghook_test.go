@@ -0,0 +1,176 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/sirupsen/logrus"
+	"github.com/stretchr/testify/assert"
+)
+
+func newHookedLogger(hook *DBHook) *logrus.Logger {
+	logger := logrus.New()
+	logger.SetOutput(io.Discard)
+	logger.AddHook(hook)
+	return logger
+}
+
+func storedLogs(t *testing.T, repo LogRepository) []LogEntry {
+	t.Helper()
+	entries, err := repo.ListLogEntries(context.Background(), LogFilter{Limit: 100})
+	assert.NoError(t, err)
+	return entries
+}
+
+func TestDBHookWritesInBatches(t *testing.T) {
+	t.Parallel()
+	repo := newMemoryLogRepository()
+	hook := newDBHook(repo, LogConfig{DBBuffer: 10, DBBatchSize: 3, DBFlushInterval: time.Hour})
+	logger := newHookedLogger(hook)
+
+	logger.WithFields(logrus.Fields{"user_id": 7, "path": "/books"}).Info("First")
+	logger.WithError(errors.New("disk full")).Error("Second")
+	assert.Empty(t, storedLogs(t, repo), "Nothing is written before the batch fills up")
+	logger.Warn("Third")
+	assert.Eventually(t, func() bool { return len(storedLogs(t, repo)) == 3 }, time.Second, 5*time.Millisecond)
+
+	logger.Info("Fourth")
+	assert.NoError(t, hook.Close(context.Background()))
+	entries := storedLogs(t, repo)
+	if assert.Len(t, entries, 4, "Close flushes the rest") {
+		assert.Equal(t, "Fourth", entries[0].Message)
+		assert.Equal(t, "warning", entries[1].Level)
+		assert.Equal(t, "disk full", entries[2].Fields["error"], "Errors are stored as text")
+		assert.Equal(t, logFields{"user_id": 7, "path": "/books"}, entries[3].Fields)
+	}
+	assert.Zero(t, hook.Dropped())
+}
+
+// blockingLogRepository не отвечает, пока не закрыт release
+type blockingLogRepository struct {
+	*memoryLogRepository
+	release chan struct{}
+}
+
+func (r blockingLogRepository) CreateLogEntries(ctx context.Context, entries []LogEntry) error {
+	<-r.release
+	return r.memoryLogRepository.CreateLogEntries(ctx, entries)
+}
+
+func TestDBHookDropsWhenBufferIsFull(t *testing.T) {
+	t.Parallel()
+	repo := blockingLogRepository{newMemoryLogRepository(), make(chan struct{})}
+	hook := newDBHook(repo, LogConfig{DBBuffer: 2, DBBatchSize: 1, DBFlushInterval: time.Hour, DBBlockTimeout: time.Millisecond})
+	logger := newHookedLogger(hook)
+
+	start := time.Now()
+	for i := 0; i < 10; i++ {
+		logger.Info("Busy")
+	}
+	assert.Less(t, time.Since(start), time.Second, "A slow database does not hold up logging")
+	assert.GreaterOrEqual(t, hook.Dropped(), uint64(7))
+
+	close(repo.release)
+	assert.NoError(t, hook.Close(context.Background()))
+	var kept int
+	var warning *LogEntry
+	for _, entry := range storedLogs(t, repo) {
+		if entry.Message == "Busy" {
+			kept++
+		} else {
+			warning = &entry
+		}
+	}
+	assert.Equal(t, 10-int(hook.Dropped()), kept)
+	if assert.NotNil(t, warning, "The drop count is logged once the database catches up") {
+		assert.Equal(t, "warning", warning.Level)
+		assert.Equal(t, "Log entries dropped: database log buffer is full", warning.Message)
+		assert.EqualValues(t, hook.Dropped(), warning.Fields["dropped"])
+	}
+}
+
+func TestPurgeOldLogs(t *testing.T) {
+	t.Parallel()
+	s := newMemoryTestServer(t)
+	s.config.Log.Retention = time.Hour
+	ctx := context.Background()
+	assert.NoError(t, s.logs.CreateLogEntries(ctx, []LogEntry{
+		{Timestamp: time.Now().Add(-2 * time.Hour), Level: "info", Message: "Old"},
+		{Timestamp: time.Now(), Level: "info", Message: "Recent"},
+	}))
+
+	purgeCtx, stop := context.WithCancel(ctx)
+	defer stop()
+	go s.purgeOldLogs(purgeCtx, 5*time.Millisecond)
+	assert.Eventually(t, func() bool { return len(storedLogs(t, s.logs)) == 1 }, time.Second, 5*time.Millisecond)
+	assert.Equal(t, "Recent", storedLogs(t, s.logs)[0].Message)
+}
+
+func TestAdminLogsEndpoint(t *testing.T) {
+	t.Parallel()
+	s := newMemoryTestServer(t)
+	handler := s.routes()
+	admin := adminAccessToken(t, s)
+	now := time.Now().UTC()
+	assert.NoError(t, s.logs.CreateLogEntries(context.Background(), []LogEntry{
+		{Timestamp: now.Add(-2 * time.Hour), Level: "error", Message: "Old failure", Fields: logFields{"user_id": 7}},
+		{Timestamp: now.Add(-time.Minute), Level: "error", Message: "Failure", Fields: logFields{"user_id": 8}},
+		{Timestamp: now.Add(-time.Minute), Level: "info", Message: "Fine", Fields: logFields{"user_id": 7}},
+	}))
+
+	type logsResponse struct {
+		Entries    []LogEntry `json:"entries"`
+		NextBefore uint       `json:"next_before"`
+		Dropped    uint64     `json:"dropped"`
+	}
+	list := func(query string) (int, logsResponse) {
+		req, _ := http.NewRequest("GET", "/admin/logs"+query, nil)
+		req.Header.Set("Authorization", "Bearer "+admin)
+		rr := httptest.NewRecorder()
+		handler.ServeHTTP(rr, req)
+		var body logsResponse
+		if rr.Code == http.StatusOK {
+			assert.NoError(t, json.NewDecoder(rr.Body).Decode(&body))
+		}
+		return rr.Code, body
+	}
+	messages := func(body logsResponse) []string {
+		var names []string
+		for _, e := range body.Entries {
+			names = append(names, e.Message)
+		}
+		return names
+	}
+
+	code, body := list("")
+	assert.Equal(t, http.StatusOK, code)
+	assert.Equal(t, []string{"Fine", "Failure", "Old failure"}, messages(body))
+	_, body = list("?level=error&from=" + now.Add(-time.Hour).Format(time.RFC3339))
+	assert.Equal(t, []string{"Failure"}, messages(body))
+	_, body = list("?field=user_id:7&to=" + now.Format(time.RFC3339))
+	assert.Equal(t, []string{"Fine", "Old failure"}, messages(body))
+	_, body = list("?limit=1")
+	if assert.Len(t, body.Entries, 1) {
+		_, next := list("?limit=1&before=" + fmt.Sprint(body.NextBefore))
+		assert.Equal(t, []string{"Failure"}, messages(next), "next_before pages through older entries")
+	}
+
+	for _, query := range []string{"?level=loud", "?from=yesterday", "?field=:7", "?limit=5000", "?before=x"} {
+		code, _ := list(query)
+		assert.Equal(t, http.StatusBadRequest, code, query)
+	}
+
+	user := loginTestUser(t, s, handler)
+	req, _ := http.NewRequest("GET", "/admin/logs", nil)
+	req.Header.Set("Authorization", "Bearer "+user.AccessToken)
+	rr := httptest.NewRecorder()
+	handler.ServeHTTP(rr, req)
+	assert.Equal(t, http.StatusForbidden, rr.Code, "Only admins can read the logs")
+}
@@ -2,10 +2,10 @@ package main
 
 import (
 	"context"
-	"crypto/rand"
-	"log"
+	"errors"
+	"strconv"
+	"math"
 
-	"math/big"
 	"strings"
 
 	"encoding/json"
@@ -21,15 +21,12 @@ import (
 
 	"mime/multipart"
 	"net/http"
-	"net/smtp"
 
 	"github.com/golang-jwt/jwt/v4"
 	"github.com/sirupsen/logrus"
 	"golang.org/x/crypto/bcrypt"
 	"golang.org/x/oauth2"
 	"golang.org/x/oauth2/google"
-	"golang.org/x/time/rate"
-	"gorm.io/driver/sqlite"
 	"gorm.io/gorm"
 )
 
@@ -42,6 +39,8 @@ type Book struct {
 	Description string  `json:"description"`
 	Price       float64 `json:"price"`
 	ImageURL    string  `json:"image_url"`
+	Stock       int     `json:"stock"`
+	Slug        string  `gorm:"uniqueIndex" json:"slug"`
 }
 type Fantasy struct {
 	ID          uint    `json:"id"`
@@ -52,9 +51,10 @@ type Fantasy struct {
 }
 type LogEntry struct {
 	ID        uint      `gorm:"primaryKey" json:"id"`
-	Timestamp time.Time `json:"timestamp"` 
+	Timestamp time.Time `gorm:"index" json:"timestamp"` 
 	Level     string    `json:"level"`
 	Message   string    `json:"message"`
+	Fields    logFields `gorm:"type:text" json:"fields,omitempty"` // поля logrus в JSON, см. loghook.go
 }
 type User struct {
 	ID               uint      `gorm:"primaryKey" json:"id"`
@@ -63,28 +63,23 @@ type User struct {
 	PasswordHash     string    `json:"-"`
 	Role             string    `json:"role"`
 	Confirmed        bool      `json:"confirmed"`
-	VerificationToken string   `gorm:"unique" json:"verification_token"`
+	PendingEmail     string    `json:"pending_email,omitempty"` // новый адрес до подтверждения, см. account.go
 	CreatedAt        time.Time `json:"created_at"`
 }
 type Claims struct {
-	Email string `json:"email"`
-	Role  string `json:"role"`
+	Email     string `json:"email"`
+	Role      string `json:"role"`
+	SessionID string `json:"sid,omitempty"` // сессия refresh-токенов, см. tokens.go
+	MFA       bool   `json:"mfa,omitempty"` // вход со вторым фактором, см. twofactor.go
+	APIKeyID  uint   `json:"-"`             // вход по API-ключу, см. apikeys.go
 	jwt.RegisteredClaims
 }
 
-var db *gorm.DB
-var err error
-var logger = logrus.New()
-var jwtKey = []byte("my_secret_key")
-
-var limiter = rate.NewLimiter(1, 5) 
 
 // OAUTH для логина
+// ClientID, ClientSecret и RedirectURL задаются из конфигурации, адреса — из discovery (см. oauth.go)
 var googleOauthConfig = &oauth2.Config{
-	ClientID:     "YOUR_GOOGLE_CLIENT_ID",
-	ClientSecret: "YOUR_GOOGLE_CLIENT_SECRET",
-	RedirectURL:  "http://localhost:8080/auth/google/callback",
-	Scopes:       []string{"https://www.googleapis.com/auth/userinfo.email", "https://www.googleapis.com/auth/userinfo.profile"},
+	Scopes:       []string{"openid", "email", "profile"},
 	Endpoint:     google.Endpoint,
 }
 
@@ -109,166 +104,89 @@ var googleOauthConfig = &oauth2.Config{
 // 		logger.WithError(err).Fatal("failed to migrate User table")
 // 	}
 // }
-func initDB() {
-    dbPath := "./books.db" // Локальный путь, который работает на Render и локально
-
-    // ✅ Проверяем, существует ли база
-    if _, err := os.Stat(dbPath); os.IsNotExist(err) {
-        fmt.Println("📂 База данных не найдена, создаём новую:", dbPath)
-        file, err := os.Create(dbPath)
-        if err != nil {
-            log.Fatal("❌ Ошибка создания файла базы данных:", err)
-        }
-        file.Close() // Закрываем файл после создания
-    }
-
-    // ✅ Подключаемся к базе
-    var err error
-    db, err = gorm.Open(sqlite.Open(dbPath), &gorm.Config{})
+func initDB(cfg DatabaseConfig, logger *logrus.Logger) *gorm.DB {
+    // ✅ Подключаемся к базе (SQLite или PostgreSQL, в зависимости от DATABASE_URL)
+    db, err := openDatabase(cfg, logger)
     if err != nil {
-        log.Fatal("❌ Ошибка подключения к базе данных:", err)
+        logger.WithError(err).Fatal("Failed to connect to the database")
     }
 
-    fmt.Println("📡 Подключено к базе данных:", dbPath)
+    logger.WithField("dialect", db.Dialector.Name()).Info("Connected to the database")
 
-    // ✅ Выполняем миграции
-    if err := db.AutoMigrate(&Book{}, &Fantasy{}, &User{}); err != nil {
-        log.Fatal("❌ Ошибка миграции базы данных:", err)
+    // ✅ Схема обновляется только командой `migrate up`
+    if len(os.Args) > 1 && (os.Args[1] == "migrate" || os.Args[1] == "set-role") {
+        return db
     }
+    if err := checkSchemaCurrent(db); err != nil {
+        logger.WithError(err).Fatal("Database schema is out of date")
+    }
+    return db
 }
 
 
-type DBHook struct {
-	DB *gorm.DB
-}
-
-// Fire записывает логи в базу данных
-func (hook *DBHook) Fire(entry *logrus.Entry) error {
-	if hook.DB == nil {
-		return fmt.Errorf("database connection is not initialized")
-	}
-
-	log := LogEntry{
-		Timestamp: time.Now(), // Используем текущую дату
-		Level:     entry.Level.String(),
-		Message:   entry.Message,
-	}
+func main() {
+	// Единственный логгер приложения; обработчики получают его через Server
+	logger := logrus.New()
+	logger.SetFormatter(&logrus.JSONFormatter{})
 
-	// Сохранение логов в базу данных
-	if err := hook.DB.Create(&log).Error; err != nil {
-		return fmt.Errorf("failed to save log to database: %w", err)
+	cfg, err := loadConfig()
+	if err != nil {
+		logger.WithError(err).Fatal("Invalid configuration")
 	}
 
-	return nil
-}
-
-// Levels implements logrus.Hook.
-func (hook *DBHook) Levels() []logrus.Level {
-	return logrus.AllLevels // Возвращаем все уровни логов
-}
+	db := initDB(cfg.Database, logger)
 
-func rateLimitMiddleware(next http.Handler) http.Handler {
-	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
-		if !limiter.Allow() {
-			http.Error(w, "429 Too Many Requests: Rate limit exceeded", http.StatusTooManyRequests)
-			logger.WithFields(logrus.Fields{
-				"path":   r.URL.Path,
-				"method": r.Method,
-				"client": r.RemoteAddr,
-			}).Warn("Rate limit exceeded")
-			return
+	// Подкоманда: bookstore migrate up | down [N] | status
+	if len(os.Args) > 1 && os.Args[1] == "migrate" {
+		if err := runMigrateCommand(db, os.Args[2:]); err != nil {
+			logger.WithError(err).Fatal("Migration failed")
 		}
-		next.ServeHTTP(w, r)
-	})
-}
-
-func main() {
-	initDB()
-
-	logger := logrus.New()
-
-	// Настройка логгера
-	logger.SetFormatter(&logrus.JSONFormatter{})
+		return
+	}
 
-	logger.AddHook(&DBHook{DB: db}) // Хук добавляется после инициализации базы данных
-
-	// Пример использования логгера
-	logger.WithFields(logrus.Fields{
-		"test":   "logging",
-		"status": "ok",
-	}).Info("Test log entry")
-
-	// Роуты для CRUD операций
-	mux := http.NewServeMux()
-
-	// Обслуживание HTML-страниц
-	mux.Handle("/", http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
-		if r.URL.Path == "/" {
-			http.ServeFile(w, r, "index.html")
-			logger.WithFields(logrus.Fields{
-				"path":   r.URL.Path,
-				"method": r.Method,
-			}).Info("HTML page served")
-		} else {
-			http.FileServer(http.Dir(".")).ServeHTTP(w, r)
+	// Подкоманда: bookstore set-role <email> <role> — например, для первого администратора
+	if len(os.Args) > 1 && os.Args[1] == "set-role" {
+		if err := checkSchemaCurrent(db); err != nil {
+			logger.WithError(err).Fatal("Database schema is out of date")
 		}
-	}))
-	mux.HandleFunc("/profile", func(w http.ResponseWriter, r *http.Request) {
-		http.ServeFile(w, r, "profile.html")
-	})
-	
-	mux.HandleFunc("/fantasy", func(w http.ResponseWriter, r *http.Request) {
-		if r.Method == http.MethodGet {
-			http.ServeFile(w, r, "fantasy.html")
-		} else if r.Method == http.MethodPost {
-			getFantasyBooks(w, r) 
+		if err := runSetRoleCommand(context.Background(), newGormUserRepository(db), newGormTokenRepository(db), os.Args[2:]); err != nil {
+			logger.WithError(err).Fatal("Failed to set role")
 		}
-	})
-	mux.HandleFunc("/bouquiniste", func(w http.ResponseWriter, r *http.Request) {
-		http.ServeFile(w, r, "bouquiniste.html")
-	})
-	mux.HandleFunc("/account", func(w http.ResponseWriter, r *http.Request) {
-		http.ServeFile(w, r, "account.html")
-	})
-	
-	mux.Handle("/api/profile", authMiddleware(http.HandlerFunc(profileHandler)))
-
-	mux.Handle("/admin", roleMiddleware(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
-		http.ServeFile(w, r, "profile.html")
-	}), "admin"))
-	
-	mux.HandleFunc("/check_country", func(w http.ResponseWriter, r *http.Request) {
-		if r.Method != http.MethodPost {
-			http.Error(w, "Method Not Allowed", http.StatusMethodNotAllowed)
-			return
-		}
-		w.Header().Set("Content-Type", "application/json")
-		json.NewEncoder(w).Encode(map[string]string{"message": "Country check success!"})
-	})
-	
+		return
+	}
 
+	// Хук добавляется после инициализации базы данных и пишет журнал в фоне
+	logs := newGormLogRepository(db)
+	dbHook := newDBHook(logs, cfg.Log)
+	logger.AddHook(dbHook)
 
-	mux.HandleFunc("/books", getBooks)                 
-	mux.HandleFunc("/books/add", addBook)              
-	mux.HandleFunc("/books/update", updateBook)       
-	mux.HandleFunc("/books/delete", deleteBook)       
-	mux.HandleFunc("/books/search", getBookByID)       
-	mux.HandleFunc("/send-message", handleSendMessage) 
-	mux.HandleFunc("/register", registerHandler)
-	mux.HandleFunc("/verify", verifyEmailHandler)
-	mux.HandleFunc("/login", loginHandler)
+	logger.WithField("config", cfg).Info("Configuration loaded")
 
+	server := newServer(cfg, newGormBookRepository(db), newGormUserRepository(db), newGormTokenRepository(db), logs, smtpMailer{cfg: cfg.SMTP}, logger)
 
-	rateLimitedMux := rateLimitMiddleware(mux)
+	// Время запросов к базе — в метриках и трассах сервера
+	if err := db.Use(gormMetrics{queries: server.metrics.dbQueries}); err != nil {
+		logger.WithError(err).Fatal("Failed to register database metrics")
+	}
+	if err := db.Use(gormTracing{tracer: server.tracer}); err != nil {
+		logger.WithError(err).Fatal("Failed to register database tracing")
+	}
 
-	port := os.Getenv("PORT") // Берём порт из Render
-	if port == "" {
-    	port = "8080" // По умолчанию 8080, если Render не передал порт
+	// Ключи подписи загружаем сразу, чтобы не стартовать с неверным JWT_SECRET
+	if err := server.keys.refresh(context.Background()); err != nil {
+		logger.WithError(err).Fatal("Failed to load JWT signing keys")
 	}
 
+	// Фоновая очистка истёкших refresh-токенов и denylist, ротация ключей
+	purgeCtx, stopPurge := context.WithCancel(context.Background())
+	defer stopPurge()
+	go server.purgeExpiredTokens(purgeCtx, time.Hour)
+	go server.rotateSigningKeys(purgeCtx, time.Minute)
+	go server.purgeOldLogs(purgeCtx, time.Hour)
+
 	srv := &http.Server{
-    	Addr:    ":" + port,
-    	Handler: enableCORS(rateLimitedMux),
+		Addr:    ":" + cfg.Port, // Render передаёт порт через PORT
+		Handler: server.routes(),
 	}
 
 	// Канал для получения сигналов завершения
@@ -279,7 +197,7 @@ func main() {
 	go func() {
 		logger.Info("Server is starting...")
 		if err := srv.ListenAndServe(); err != nil && err != http.ErrServerClosed {
-			logger.Fatalf("ListenAndServe(): %v", err)
+			logger.WithError(err).Fatal("Server failed")
 		}
 	}()
 	<-quit
@@ -289,19 +207,28 @@ func main() {
 
 	// Завершение работы сервера
 	if err := srv.Shutdown(ctx); err != nil {
-		logger.Fatalf("Server forced to shutdown: %v", err)
+		logger.WithError(err).Fatal("Server forced to shutdown")
 	}
 
 	logger.Info("Server exited gracefully.")
+	// Дописываем накопленные строки журнала в базу
+	if err := dbHook.Close(ctx); err != nil {
+		fmt.Fprintln(os.Stderr, "failed to flush logs:", err)
+	}
+	// Отправляем оставшиеся spans
+	if server.traces != nil {
+		if err := server.traces.Shutdown(ctx); err != nil {
+			fmt.Fprintln(os.Stderr, "failed to flush traces:", err)
+		}
+	}
 }
 
 // Обработчик для загрузки fantasy.html с карточками
-func getFantasyBooks(w http.ResponseWriter, r *http.Request) {
-	var books []Fantasy
-
+func (s *Server) getFantasyBooks(w http.ResponseWriter, r *http.Request) {
 	// Извлечение данных из таблицы fantasy
-	if err := db.Table("fantasy").Limit(30).Find(&books).Error; err != nil {
-		logger.WithError(err).Error("Failed to fetch fantasy books")
+	books, err := s.books.ListFantasy(r.Context(), 30)
+	if err != nil {
+		s.log(r.Context()).WithError(err).Error("Failed to fetch fantasy books")
 		http.Error(w, "Ошибка при получении данных", http.StatusInternalServerError)
 		return
 	}
@@ -319,62 +246,42 @@ func getFantasyBooks(w http.ResponseWriter, r *http.Request) {
 
 	tmpl, err := template.New("cards").Parse(cardTemplate)
 	if err != nil {
-		logger.WithError(err).Error("Template error")
+		s.log(r.Context()).WithError(err).Error("Template error")
 		http.Error(w, "Ошибка при создании шаблона карточек", http.StatusInternalServerError)
 		return
 	}
 
-	logger.WithFields(logrus.Fields{
+	s.log(r.Context()).WithFields(logrus.Fields{
 		"action": "fetch_fantasy_books",
 		"count":  len(books),
 	}).Info("Fetched fantasy books")
 
 	w.Header().Set("Content-Type", "text/html")
 	if err := tmpl.Execute(w, books); err != nil {
-		logger.WithError(err).Error("Template execution error")
+		s.log(r.Context()).WithError(err).Error("Template execution error")
 		http.Error(w, "Ошибка при выполнении шаблона карточек", http.StatusInternalServerError)
 	}
 }
 
 // Получение всех книг
-func getBooks(w http.ResponseWriter, r *http.Request) {
-	var books []Book
-	if err := db.Find(&books).Error; err != nil {
-		logger.WithError(err).Error("Failed to fetch books")
-		http.Error(w, err.Error(), http.StatusInternalServerError)
-		return
+func (s *Server) getBooks(w http.ResponseWriter, r *http.Request) {
+	// Параметры фильтрации и сортировки
+	filter := BookFilter{
+		Title:     r.URL.Query().Get("title"),
+		Author:    r.URL.Query().Get("author"),
+		Published: r.URL.Query().Get("published"),
+		SortBy:    r.URL.Query().Get("sortBy"),
+		SortOrder: r.URL.Query().Get("sortOrder"),
 	}
 
-	// Параметры фильтрации
-	title := r.URL.Query().Get("title")
-	author := r.URL.Query().Get("author")
-	published := r.URL.Query().Get("published")
-
-	query := db.Model(&Book{})
-
-	if title != "" {
-		query = query.Where("title LIKE ?", "%"+title+"%")
-	}
-	if author != "" {
-		query = query.Where("author LIKE ?", "%"+author+"%")
-	}
-	if published != "" {
-		query = query.Where("published LIKE ?", "%"+published+"%")
-	}
-
-	// Параметры сортировки
-	sortBy := r.URL.Query().Get("sortBy")
-	sortOrder := r.URL.Query().Get("sortOrder")
-	if sortBy != "" {
-		if sortOrder == "desc" {
-			query = query.Order(sortBy + " DESC")
-		} else {
-			query = query.Order(sortBy + " ASC")
-		}
-	}
 	// Выполнение запроса к базе данных
-	if err := query.Find(&books).Error; err != nil {
-		logger.WithError(err).Error("Failed to fetch books")
+	books, err := s.books.List(r.Context(), filter)
+	if errors.Is(err, ErrInvalidSort) {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	if err != nil {
+		s.log(r.Context()).WithError(err).Error("Failed to fetch books")
 		http.Error(w, err.Error(), http.StatusInternalServerError)
 		return
 	}
@@ -390,19 +297,18 @@ func getBooks(w http.ResponseWriter, r *http.Request) {
 }
 
 // Добавление книги
-func addBook(w http.ResponseWriter, r *http.Request) {
+func (s *Server) addBook(w http.ResponseWriter, r *http.Request) {
 	var book Book
 	// Декодируем JSON из тела запроса
 	if err := json.NewDecoder(r.Body).Decode(&book); err != nil {
-		logger.WithError(err).Error("Failed to decode request body")
+		s.log(r.Context()).WithError(err).Error("Failed to decode request body")
 		w.Header().Set("Content-Type", "application/json")
 		w.WriteHeader(http.StatusBadRequest)
 		json.NewEncoder(w).Encode(map[string]string{"error": "Invalid request"})
 		return
 	}
 	// Проверяем, есть ли книга с таким же названием
-	var existingBook Book
-	if err := db.Where("title = ?", book.Title).First(&existingBook).Error; err == nil {
+	if _, err := s.books.GetByTitle(r.Context(), book.Title); err == nil {
 		http.Error(w, "A book with this title already exists", http.StatusConflict)
 		return
 	}
@@ -410,16 +316,28 @@ func addBook(w http.ResponseWriter, r *http.Request) {
 	// Проверяем, заполнены ли обязательные поля
 	if book.Title == "" || book.Author == "" || book.Published == "" {
 		err := fmt.Errorf("missing required fields: Title, Author, and Published are mandatory")
-		logger.WithError(err).Error("Failed to add book due to missing fields")
+		s.log(r.Context()).WithError(err).Error("Failed to add book due to missing fields")
 		w.Header().Set("Content-Type", "application/json")
 		w.WriteHeader(http.StatusBadRequest)
 		json.NewEncoder(w).Encode(map[string]string{"error": "Missing required fields"})
 		return
 	}
+	// Slug из запроса приводится к тому же виду, что и сгенерированный
+	if book.Slug != "" {
+		slug, err := requestedSlug(book.Slug)
+		if err != nil {
+			http.Error(w, "Invalid slug: "+err.Error(), http.StatusBadRequest)
+			return
+		}
+		book.Slug = slug
+	}
 
 	// Сохраняем книгу в базе данных
-	if err := db.Create(&book).Error; err != nil {
-		logger.WithError(err).Error("Failed to add book")
+	if err := s.books.Create(r.Context(), &book); errors.Is(err, ErrDuplicate) {
+		http.Error(w, "Slug is already taken", http.StatusConflict)
+		return
+	} else if err != nil {
+		s.log(r.Context()).WithError(err).Error("Failed to add book")
 		w.Header().Set("Content-Type", "application/json")
 		w.WriteHeader(http.StatusInternalServerError)
 		json.NewEncoder(w).Encode(map[string]string{"error": "Failed to add book"})
@@ -427,7 +345,7 @@ func addBook(w http.ResponseWriter, r *http.Request) {
 	}
 
 	// Логируем успешное добавление книги
-	logger.WithFields(logrus.Fields{
+	s.log(r.Context()).WithFields(logrus.Fields{
 		"action":  "add_book",
 		"book_id": book.ID,
 		"title":   book.Title,
@@ -440,33 +358,80 @@ func addBook(w http.ResponseWriter, r *http.Request) {
 }
 
 // Обновление книги
-func updateBook(w http.ResponseWriter, r *http.Request) {
-	var book Book
-	if err := json.NewDecoder(r.Body).Decode(&book); err != nil {
-		logger.WithError(err).Error("Failed to decode book")
+// Поля, которых нет в запросе, остаются прежними: иначе правка названия сбросила бы Stock.
+// Slug не меняется никогда: на него ведут ссылки и поисковики
+func (s *Server) updateBook(w http.ResponseWriter, r *http.Request) {
+	body, err := io.ReadAll(r.Body)
+	if err != nil {
+		s.log(r.Context()).WithError(err).Error("Failed to read book")
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	var ref struct {
+		ID uint `json:"id"`
+	}
+	if err := json.Unmarshal(body, &ref); err != nil {
+		s.log(r.Context()).WithError(err).Error("Failed to decode book")
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	if ref.ID == 0 {
+		http.Error(w, "ID is required", http.StatusBadRequest)
+		return
+	}
+	book, err := s.books.Get(r.Context(), ref.ID)
+	if errors.Is(err, ErrNotFound) {
+		http.Error(w, "Book not found", http.StatusNotFound)
+		return
+	} else if err != nil {
+		s.log(r.Context()).WithError(err).Error("Failed to load book")
+		http.Error(w, "Internal server error", http.StatusInternalServerError)
+		return
+	}
+	slug := book.Slug
+	if err := json.Unmarshal(body, &book); err != nil {
+		s.log(r.Context()).WithError(err).Error("Failed to decode book")
 		http.Error(w, err.Error(), http.StatusBadRequest)
 		return
 	}
-	if err := db.Save(&book).Error; err != nil {
-		logger.WithError(err).Error("Failed to update book")
+	if book.Slug != "" && slugify(book.Slug) != slug {
+		http.Error(w, "Slug cannot be changed", http.StatusBadRequest)
+		return
+	}
+	book.Slug = slug
+	if err := s.books.Save(r.Context(), &book); err != nil {
+		s.log(r.Context()).WithError(err).Error("Failed to update book")
 		http.Error(w, err.Error(), http.StatusInternalServerError)
 		return
 	}
 	if err := json.NewEncoder(w).Encode(book); err != nil {
-		logger.WithError(err).Error("Failed to encode response")
+		s.log(r.Context()).WithError(err).Error("Failed to encode response")
 	}
 }
 
-// Удаление книги
-func deleteBook(w http.ResponseWriter, r *http.Request) {
+// parseID читает числовой параметр id из запроса
+func parseID(r *http.Request) (uint, error) {
 	id := r.URL.Query().Get("id")
 	if id == "" {
-		logger.Error("ID is required")
-		http.Error(w, "ID is required", http.StatusBadRequest)
+		return 0, fmt.Errorf("ID is required")
+	}
+	n, err := strconv.ParseUint(id, 10, 64)
+	if err != nil {
+		return 0, fmt.Errorf("ID must be a positive number")
+	}
+	return uint(n), nil
+}
+
+// Удаление книги
+func (s *Server) deleteBook(w http.ResponseWriter, r *http.Request) {
+	id, err := parseID(r)
+	if err != nil {
+		s.log(r.Context()).Error(err.Error())
+		http.Error(w, err.Error(), http.StatusBadRequest)
 		return
 	}
-	if err := db.Delete(&Book{}, id).Error; err != nil {
-		logger.WithError(err).Error("Failed to delete book")
+	if err := s.books.Delete(r.Context(), id); err != nil {
+		s.log(r.Context()).WithError(err).Error("Failed to delete book")
 		http.Error(w, err.Error(), http.StatusInternalServerError)
 		return
 	}
@@ -474,19 +439,19 @@ func deleteBook(w http.ResponseWriter, r *http.Request) {
 }
 
 // search
-func getBookByID(w http.ResponseWriter, r *http.Request) {
+func (s *Server) getBookByID(w http.ResponseWriter, r *http.Request) {
 	// Получение ID из параметров запроса
-	id := r.URL.Query().Get("id")
-	if id == "" {
-		logger.Error("ID is required")
-		http.Error(w, "ID is required", http.StatusBadRequest)
+	id, err := parseID(r)
+	if err != nil {
+		s.log(r.Context()).Error(err.Error())
+		http.Error(w, err.Error(), http.StatusBadRequest)
 		return
 	}
 
 	// Поиск книги в базе данных
-	var book Book
-	if err := db.First(&book, id).Error; err != nil {
-		logger.WithError(err).Error("Book not found")
+	book, err := s.books.Get(r.Context(), id)
+	if err != nil {
+		s.log(r.Context()).WithError(err).Error("Book not found")
 		http.Error(w, "Book not found", http.StatusNotFound)
 		return
 	}
@@ -494,17 +459,12 @@ func getBookByID(w http.ResponseWriter, r *http.Request) {
 	// Отправка результата в формате JSON
 	w.Header().Set("Content-Type", "application/json")
 	if err := json.NewEncoder(w).Encode(book); err != nil {
-		logger.WithError(err).Error("Failed to encode book")
+		s.log(r.Context()).WithError(err).Error("Failed to encode book")
 	}
 }
 
 // Функция для отправки email с вложением
-func sendEmailWithAttachment(toEmail, subject, message string, file multipart.File, fileHeader *multipart.FileHeader) error {
-	from := "bouquiniste19@gmail.com" // Ваш email
-	password := "fjnzynihbertfxye"    // Пароль от email
-	smtpHost := "smtp.gmail.com"      // SMTP-сервер
-	smtpPort := "587"                 // Порт SMTP
-
+func (s *Server) sendEmailWithAttachment(ctx context.Context, toEmail, subject, message string, file multipart.File, fileHeader *multipart.FileHeader) error {
 	// Создаем MIME-сообщение
 	mimeBoundary := "BOUNDARY_STRING"
 	mimeMessage := fmt.Sprintf(
@@ -531,13 +491,12 @@ func sendEmailWithAttachment(toEmail, subject, message string, file multipart.Fi
 	// Завершаем MIME-сообщение
 	mimeMessage += fmt.Sprintf("--%s--", mimeBoundary)
 
-	// Авторизация и отправка
-	auth := smtp.PlainAuth("", from, password, smtpHost)
-	return smtp.SendMail(smtpHost+":"+smtpPort, auth, from, []string{toEmail}, []byte(mimeMessage))
+	// Отправка
+	return s.sendMail(ctx, "message", []string{toEmail}, []byte(mimeMessage))
 }
 
 // Обработчик для отправки сообщения
-func handleSendMessage(w http.ResponseWriter, r *http.Request) {
+func (s *Server) handleSendMessage(w http.ResponseWriter, r *http.Request) {
 	if r.Method != http.MethodPost {
 		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
 		return
@@ -572,7 +531,7 @@ func handleSendMessage(w http.ResponseWriter, r *http.Request) {
 	}
 
 	// Отправка email
-	err = sendEmailWithAttachment(to, subject, message, attachment, fileHeader)
+	err = s.sendEmailWithAttachment(r.Context(), to, subject, message, attachment, fileHeader)
 	if err != nil {
 		http.Error(w, "Failed to send email", http.StatusInternalServerError)
 		return
@@ -585,9 +544,7 @@ func handleSendMessage(w http.ResponseWriter, r *http.Request) {
 
 //------------ РЕГИСТРАЦИЯ И АВТОРИЗАЦИЯ
 
-func registerHandler(w http.ResponseWriter, r *http.Request) {
-	fmt.Println("Запрос на /register:", r.Method)
-
+func (s *Server) registerHandler(w http.ResponseWriter, r *http.Request) {
 	if r.Method != http.MethodPost {
 		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
 		return
@@ -597,40 +554,34 @@ func registerHandler(w http.ResponseWriter, r *http.Request) {
 		Name     string `json:"name"`
 		Email    string `json:"email"`
 		Password string `json:"password"`
-		Role     string `json:"role"`
 	}
 
 	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
-		fmt.Println("Ошибка декодирования JSON:", err)
 		http.Error(w, "Invalid request", http.StatusBadRequest)
 		return
 	}
 
-	if req.Role != "admin" {
-        req.Role = "user" // По умолчанию все пользователи - user
-    }
-
-	fmt.Println("Полученные данные:", req)
-
 	if req.Name == "" || req.Email == "" || req.Password == "" {
-		fmt.Println("Ошибка: не все поля заполнены")
 		http.Error(w, "All fields are required", http.StatusBadRequest)
 		return
 	}
 
-	var user User
-	err := db.Where("email = ?", req.Email).First(&user).Error
+	if err := validatePassword(req.Password, req.Email); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	user, err := s.users.GetByEmail(r.Context(), req.Email)
 
 	if err == nil {
 		// Email уже есть в БД
-		fmt.Println("⚠ Email уже зарегистрирован:", req.Email)
+		s.log(r.Context()).WithField("user_id", user.ID).Info("Registration for an existing email")
 
 		if !user.Confirmed {
-			user.VerificationToken = generateVerificationCode()
-			db.Model(&user).Update("verification_token", user.VerificationToken)
-		
-			fmt.Println("📩 Повторная отправка верификационного письма:", req.Email, "Код:", user.VerificationToken)
-			go sendVerificationEmail(user.Email, user.VerificationToken)
+			if err := s.startEmailVerification(r.Context(), user); err != nil {
+				http.Error(w, "Failed to send verification email", http.StatusInternalServerError)
+				return
+			}
 		
 			json.NewEncoder(w).Encode(map[string]string{"message": "User already exists. Verification email resent."})
 			return
@@ -645,93 +596,82 @@ func registerHandler(w http.ResponseWriter, r *http.Request) {
 	// Если email не найден, создаём нового пользователя
 	passwordHash, err := bcrypt.GenerateFromPassword([]byte(req.Password), bcrypt.DefaultCost)
 	if err != nil {
-		fmt.Println("Ошибка хеширования пароля:", err)
+		s.log(r.Context()).WithError(err).Error("Failed to hash password")
 		http.Error(w, "Failed to hash password", http.StatusInternalServerError)
 		return
 	}
 
-	verificationToken := generateVerificationCode()
-
 	user = User{
 		Name:             req.Name,
 		Email:            req.Email,
 		PasswordHash:     string(passwordHash),
-		Role:             req.Role, // Сохраняем роль
+		Role:             "user", // Роль admin выдаётся только командой set-role или через OIDC
 		Confirmed:        false,
-		VerificationToken: verificationToken,
 		CreatedAt:        time.Now(),
 	}
 
-	fmt.Println("🛠 Сохраняем пользователя в БД:", user) // Логируем перед сохранением
-
-	if err := db.Create(&user).Error; err != nil {
-		fmt.Println("Ошибка создания пользователя:", err)
+	if err := s.users.Create(r.Context(), &user); err != nil {
+		s.log(r.Context()).WithError(err).Error("Failed to create user")
 		http.Error(w, "Failed to create user", http.StatusInternalServerError)
 		return
 	}
 
-	fmt.Println("Пользователь добавлен!")
+	s.log(r.Context()).WithField("user_id", user.ID).Info("User registered")
 
-	fmt.Println("📩 Отправка письма верификации для:", req.Email, "Код:", verificationToken)
-	go sendVerificationEmail(req.Email, verificationToken)
+	if err := s.startEmailVerification(r.Context(), user); err != nil {
+		http.Error(w, "Failed to send verification email", http.StatusInternalServerError)
+		return
+	}
 
 	w.WriteHeader(http.StatusCreated)
 	json.NewEncoder(w).Encode(map[string]string{"message": "User registered. Check your email for verification link."})
 }
 
 
-func verifyEmailHandler(w http.ResponseWriter, r *http.Request) {
+func (s *Server) verifyEmailHandler(w http.ResponseWriter, r *http.Request) {
 	token := r.URL.Query().Get("token")
 	if token == "" {
 		http.Error(w, "Token is required", http.StatusBadRequest)
 		return
 	}
 
-	var user User
-	if err := db.Where("verification_token = ?", token).First(&user).Error; err != nil {
-		http.Error(w, "Invalid or expired token", http.StatusBadRequest)
+	ip := s.clientIP(r)
+	if !s.verifyAttempts.Allow(ip) {
+		http.Error(w, "Too many attempts, try again later", http.StatusTooManyRequests)
 		return
 	}
 
-	user.Confirmed = true
-	user.VerificationToken = ""
-	db.Save(&user)
+	// Токен одноразовый и действует verificationLinkTTL
+	userToken, err := s.consumeUserToken(r.Context(), purposeVerifyEmail, token)
+	if err != nil {
+		s.verifyAttempts.Fail(ip)
+		http.Error(w, "Invalid or expired token", http.StatusBadRequest)
+		return
+	}
+	if err := s.confirmEmail(r.Context(), userToken.UserID); err != nil {
+		http.Error(w, "Failed to verify email", http.StatusInternalServerError)
+		return
+	}
+	s.verifyAttempts.Reset(ip)
 
 	w.WriteHeader(http.StatusOK)
 	fmt.Fprintf(w, "Email verified successfully. You can now log in.")
 }
 
-func generateVerificationCode() string {
-	n, _ := rand.Int(rand.Reader, big.NewInt(9000))
-	code := 1000 + int(n.Int64()) // Гарантируем 4 цифры
-	return fmt.Sprintf("%04d", code) // Преобразуем в строку с ведущими нулями, если нужно
-}
-
-func sendVerificationEmail(to, token string) {
-	
-	from := "bouquiniste19@gmail.com" // почта и пароль
-	password := "fjnzynihbertfxye" 
-	smtpHost := "smtp.gmail.com"
-	smtpPort := "587"
-
+func (s *Server) sendVerificationEmail(ctx context.Context, to, token, code string) {
 	subject := "Email Verification"
-	link := fmt.Sprintf("http://localhost:8080/verify?token=%s", token)
-	message := fmt.Sprintf("Click the link to verify your email: %s", link)
-
-	auth := smtp.PlainAuth("", from, password, smtpHost)
-	msg := []byte("Subject: " + subject + "\r\n" + "\r\n" + message)
+	link := fmt.Sprintf("%s/verify?token=%s", s.config.BaseURL(), token)
+	message := fmt.Sprintf("Click the link to verify your email: %s\r\n\r\nOr enter this code on the site: %s\r\nThe code expires in %d minutes.",
+		link, code, int(verificationCodeTTL.Minutes()))
 
-	fmt.Println("Отправка письма на:", to)
+	msg := []byte("To: " + to + "\r\n" + "Subject: " + subject + "\r\n" + "\r\n" + message)
 
-	err := smtp.SendMail(smtpHost+":"+smtpPort, auth, from, []string{to}, msg)
-	if err != nil {
-		fmt.Println("Ошибка отправки Email:", err)
-	} else {
-		fmt.Println("Письмо успешно отправлено!")
+	if err := s.sendMail(ctx, "verification", []string{to}, msg); err != nil {
+		s.log(ctx).WithError(err).Error("Failed to send verification email")
 	}
 }
 
-func loginHandler(w http.ResponseWriter, r *http.Request) {
+func (s *Server) loginHandler(w http.ResponseWriter, r *http.Request) {
     if r.Method != http.MethodPost {
         http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
         return
@@ -747,30 +687,60 @@ func loginHandler(w http.ResponseWriter, r *http.Request) {
         return
     }
 
-    var user User
-    err := db.Where("email = ?", req.Email).First(&user).Error
+    // Пауза после неудачных попыток: одинаковая для существующих и несуществующих аккаунтов
+    ip := s.clientIP(r)
+    if wait := s.loginRetryAfter(ip, req.Email); wait > 0 {
+        w.Header().Set("Retry-After", strconv.Itoa(int(math.Ceil(wait.Seconds()))))
+        http.Error(w, "Too many failed login attempts, try again later", http.StatusTooManyRequests)
+        return
+    }
+
+    user, err := s.users.GetByEmail(r.Context(), req.Email)
+    if errors.Is(err, ErrNotFound) {
+        bcrypt.CompareHashAndPassword(dummyPasswordHash, []byte(req.Password))
+        s.loginFailed(r.Context(), ip, req.Email, nil)
+        http.Error(w, "Invalid email or password", http.StatusUnauthorized)
+        return
+    }
     if err != nil {
-        http.Error(w, "User not found", http.StatusUnauthorized)
+        http.Error(w, "Failed to load user", http.StatusInternalServerError)
         return
     }
 
+    if err := bcrypt.CompareHashAndPassword([]byte(user.PasswordHash), []byte(req.Password)); err != nil {
+        s.loginFailed(r.Context(), ip, req.Email, &user)
+        http.Error(w, "Invalid email or password", http.StatusUnauthorized)
+        return
+    }
+    s.accountLogins.Reset(loginKey(req.Email))
+
+    // Статус подтверждения сообщаем только тому, кто знает пароль
     if !user.Confirmed {
         http.Error(w, "Email not verified", http.StatusForbidden)
         return
     }
 
-    if err := bcrypt.CompareHashAndPassword([]byte(user.PasswordHash), []byte(req.Password)); err != nil {
-        http.Error(w, "Invalid password", http.StatusUnauthorized)
+    // С включённой 2FA вместо токенов выдаётся токен второго шага для /login/2fa
+    twoFactor, err := s.twoFactorEnabled(r.Context(), user.ID)
+    if err != nil {
+        http.Error(w, "Failed to load two-factor settings", http.StatusInternalServerError)
+        return
+    }
+    if twoFactor {
+        challenge, err := s.startTwoFactorLogin(r.Context(), user)
+        if err != nil {
+            http.Error(w, "Failed to start two-factor login", http.StatusInternalServerError)
+            return
+        }
+        w.Header().Set("Content-Type", "application/json")
+        json.NewEncoder(w).Encode(challenge)
         return
     }
 
-    token, err := generateJWT(user)
-    if err != nil {
+    if err := s.signIn(w, r, user, false, wantsCookieSession(r)); err != nil {
         http.Error(w, "Failed to generate token", http.StatusInternalServerError)
         return
     }
-
-    json.NewEncoder(w).Encode(map[string]string{"token": token})
 }
 
 // func loginHandler(w http.ResponseWriter, r *http.Request) {
@@ -829,26 +799,40 @@ func loginHandler(w http.ResponseWriter, r *http.Request) {
 // }
 
 
-func generateJWT(user User) (string, error) {
-	expirationTime := time.Now().Add(24 * time.Hour) // Токен действует 24 часа
+func (s *Server) generateJWT(ctx context.Context, user User, session Session) (string, error) {
+	key, err := s.keys.signingKey(ctx)
+	if err != nil {
+		return "", err
+	}
+	jti, err := randomToken(16) // jti нужен, чтобы отозвать конкретный токен
+	if err != nil {
+		return "", err
+	}
+	expirationTime := time.Now().Add(s.config.JWT.AccessTTL) // Короткоживущий токен, продлевается через /token/refresh
 	claims := &Claims{
-		Email: user.Email,
-		Role:  user.Role, // Роль пользователя (admin или user)
+		Email:     user.Email,
+		Role:      user.Role, // Роль пользователя (admin или user)
+		SessionID: session.ID,
+		MFA:       session.MFA,
 		RegisteredClaims: jwt.RegisteredClaims{
+			ID:        jti,
+			Issuer:    s.config.JWT.Issuer,
+			Audience:  jwt.ClaimStrings{s.config.JWT.Audience},
+			Subject:   strconv.FormatUint(uint64(user.ID), 10),
+			IssuedAt:  jwt.NewNumericDate(time.Now()),
 			ExpiresAt: jwt.NewNumericDate(expirationTime), // Время истечения
 		},
 	}
 
-	fmt.Println("🛠 Генерируем токен для:", user.Email, "Роль:", user.Role)
-
-	// Генерируем токен
-	token := jwt.NewWithClaims(jwt.SigningMethodHS256, claims)
-	return token.SignedString(jwtKey) // jwtKey — секретный ключ
+	// Подписываем текущим ключом, kid нужен для проверки после ротации
+	token := jwt.NewWithClaims(jwt.GetSigningMethod(key.algorithm), claims)
+	token.Header["kid"] = key.id
+	return token.SignedString(key.signer)
 }
 
 
 
-func profileHandler(w http.ResponseWriter, r *http.Request) {
+func (s *Server) profileHandler(w http.ResponseWriter, r *http.Request) {
     user := r.Context().Value("user").(*Claims)
     w.Header().Set("Content-Type", "application/json")
     json.NewEncoder(w).Encode(map[string]string{
@@ -858,64 +842,57 @@ func profileHandler(w http.ResponseWriter, r *http.Request) {
 }
 
 
-func authMiddleware(next http.Handler) http.Handler {
+func (s *Server) authMiddleware(next http.Handler) http.Handler {
     return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
         tokenStr := r.Header.Get("Authorization")
-        fmt.Println("🔍 Получен заголовок Authorization:", tokenStr) // ЛОГ ДЛЯ ОТЛАДКИ
 
         if tokenStr == "" {
-            http.Error(w, "Unauthorized: No token", http.StatusUnauthorized)
+            // Страницы сайта входят по HttpOnly cookie, см. sessions.go
+            claims, err := s.resolveCookieSession(w, r)
+            if err != nil {
+                s.log(r.Context()).WithError(err).Error("Failed to check session cookie")
+                http.Error(w, "Internal server error", http.StatusInternalServerError)
+                return
+            }
+            if claims == nil {
+                http.Error(w, "Unauthorized: No token", http.StatusUnauthorized)
+                return
+            }
+            next.ServeHTTP(w, withClaims(r, claims))
             return
         }
 
         // Убираем "Bearer " из строки токена
         parts := strings.Split(tokenStr, " ")
         if len(parts) != 2 || parts[0] != "Bearer" {
-            fmt.Println("❌ Неправильный формат заголовка Authorization")
             http.Error(w, "Unauthorized: Invalid token format", http.StatusUnauthorized)
             return
         }
         tokenStr = parts[1]
+        if isAPIKey(tokenStr) {
+            s.apiKeyAuth(w, r, tokenStr, next)
+            return
+        }
 
-        claims := &Claims{}
-        token, err := jwt.ParseWithClaims(tokenStr, claims, func(token *jwt.Token) (interface{}, error) {
-            return jwtKey, nil
-        })
-
-        if err != nil || !token.Valid {
-            fmt.Println("❌ Ошибка парсинга токена:", err) // ЛОГ ОШИБКИ
+        claims, err := s.parseAccessToken(r.Context(), tokenStr)
+        if err != nil {
+            s.log(r.Context()).WithError(err).Debug("Rejected access token")
             http.Error(w, "Unauthorized: Invalid token", http.StatusUnauthorized)
             return
         }
 
-        fmt.Println("✅ Токен успешно распознан. Пользователь:", claims.Email) // ЛОГ УСПЕХА
-        ctx := context.WithValue(r.Context(), "user", claims)
-        next.ServeHTTP(w, r.WithContext(ctx))
-    })
-}
-
-
-func roleMiddleware(next http.Handler, requiredRole string) http.Handler {
-	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
-		role := r.Header.Get("Role") // Заголовок с ролью пользователя
-		if role != requiredRole {
-			http.Error(w, "Forbidden: Access is denied", http.StatusForbidden)
-			return
-		}
-		next.ServeHTTP(w, r)
-	})
-}
+        // Токен мог быть отозван через /logout или при повторном использовании refresh-токена
+        revoked, err := s.tokenRevoked(r.Context(), claims)
+        if err != nil {
+            s.log(r.Context()).WithError(err).Error("Failed to check token revocation")
+            http.Error(w, "Internal server error", http.StatusInternalServerError)
+            return
+        }
+        if revoked {
+            http.Error(w, "Unauthorized: Token revoked", http.StatusUnauthorized)
+            return
+        }
 
-// enableCORS добавляет заголовки для поддержки CORS
-func enableCORS(next http.Handler) http.Handler {
-	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
-		w.Header().Set("Access-Control-Allow-Origin", "*")
-		w.Header().Set("Access-Control-Allow-Methods", "GET, POST, PUT, DELETE, OPTIONS")
-		w.Header().Set("Access-Control-Allow-Headers", "Content-Type")
-		if r.Method == "OPTIONS" {
-			w.WriteHeader(http.StatusOK)
-			return
-		}
-		next.ServeHTTP(w, r)
-	})
+        next.ServeHTTP(w, withClaims(r, claims))
+    })
 }
@@ -1,30 +1,156 @@
 package main
 
 import (
-	"testing"
 	"bytes"
+	"context"
 	"encoding/json"
+	"fmt"
+	"io"
 	"net/http"
 	"net/http/httptest"
-	
+	"net/url"
+	"os"
+	"path/filepath"
+	"sync"
+	"testing"
+	"time"
 
+	"github.com/sirupsen/logrus"
 	"github.com/stretchr/testify/assert"
-	"gorm.io/driver/sqlite"
 	"gorm.io/gorm"
 )
 
-func setupTestDB() {
-	db, _ = gorm.Open(sqlite.Open("test_books.db"), &gorm.Config{})
-	db.AutoMigrate(&Book{})
+// discardLogger — логгер, который ничего не выводит
+func discardLogger() *logrus.Logger {
+	logger := logrus.New()
+	logger.SetOutput(io.Discard)
+	return logger
+}
+
+// newTestDB открывает пустую изолированную базу для одного теста:
+// отдельный SQLite-файл, а при TEST_DATABASE_DSN=postgres://... — отдельную схему PostgreSQL
+func newTestDB(t *testing.T) *gorm.DB {
+	t.Helper()
+	cfg := defaultConfig().Database
+
+	dsn := os.Getenv("TEST_DATABASE_DSN")
+	if dsn == "" {
+		cfg.DSN = Secret(filepath.Join(t.TempDir(), "test.db"))
+		conn, err := openDatabase(cfg, discardLogger())
+		if err != nil {
+			t.Fatalf("failed to open test database: %v", err)
+		}
+		t.Cleanup(func() {
+			if sqlDB, err := conn.DB(); err == nil {
+				sqlDB.Close()
+			}
+		})
+		return conn
+	}
+
+	cfg.DSN = Secret(dsn)
+	admin, err := openDatabase(cfg, discardLogger())
+	if err != nil {
+		t.Fatalf("failed to open test database: %v", err)
+	}
+	schema := fmt.Sprintf("test_%d", time.Now().UnixNano())
+	if err := admin.Exec("CREATE SCHEMA " + schema).Error; err != nil {
+		t.Fatalf("failed to create test schema: %v", err)
+	}
+
+	u, err := url.Parse(dsn)
+	if err != nil {
+		t.Fatalf("invalid TEST_DATABASE_DSN: %v", err)
+	}
+	q := u.Query()
+	q.Set("search_path", schema)
+	u.RawQuery = q.Encode()
+	cfg.DSN = Secret(u.String())
+
+	conn, err := openDatabase(cfg, discardLogger())
+	if err != nil {
+		t.Fatalf("failed to open test schema: %v", err)
+	}
+	t.Cleanup(func() {
+		if sqlDB, err := conn.DB(); err == nil {
+			sqlDB.Close()
+		}
+		admin.Exec("DROP SCHEMA " + schema + " CASCADE")
+		if sqlDB, err := admin.DB(); err == nil {
+			sqlDB.Close()
+		}
+	})
+	return conn
+}
+
+// newMigratedTestDB — изолированная база с применёнными миграциями
+func newMigratedTestDB(t *testing.T) *gorm.DB {
+	t.Helper()
+	conn := newTestDB(t)
+	if _, err := migrateUp(conn); err != nil {
+		t.Fatalf("failed to migrate test database: %v", err)
+	}
+	return conn
+}
+
+type sentMail struct {
+	To  []string
+	Msg string
+}
+
+// fakeMailer запоминает письма вместо отправки
+type fakeMailer struct {
+	mu   sync.Mutex
+	sent []sentMail
+}
+
+func (m *fakeMailer) SendMail(to []string, msg []byte) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.sent = append(m.sent, sentMail{To: to, Msg: string(msg)})
+	return nil
+}
+
+func (m *fakeMailer) Sent() []sentMail {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	return append([]sentMail(nil), m.sent...)
 }
 
-func teardownTestDB() {
-	db.Exec("DROP TABLE books")
+func testConfig() Config {
+	cfg := defaultConfig()
+	cfg.JWT.Secret = testJWTSecret
+	cfg.SiteURL = "http://localhost:8080"
+	cfg.RateLimit.RPS, cfg.RateLimit.Burst = 1000, 1000
+	cfg.RateLimit.Auth = RateLimitRule{RPS: 1000, Burst: 1000}
+	cfg.RateLimit.Books = RateLimitRule{RPS: 1000, Burst: 1000}
+	return cfg
+}
+
+func newTestServer(t *testing.T, books BookRepository, users UserRepository, tokens TokenRepository) *Server {
+	t.Helper()
+	logger := logrus.New()
+	logger.SetOutput(io.Discard)
+	return newServer(testConfig(), books, users, tokens, newMemoryLogRepository(), &fakeMailer{}, logger)
+}
+
+// newConfigTestServer — сервер с in-memory книгами и пользователями и заданной конфигурацией
+func newConfigTestServer(t *testing.T, cfg Config, tokens TokenRepository) *Server {
+	t.Helper()
+	logger := logrus.New()
+	logger.SetOutput(io.Discard)
+	return newServer(cfg, newMemoryBookRepository(), newMemoryUserRepository(), tokens, newMemoryLogRepository(), &fakeMailer{}, logger)
+}
+
+// newMemoryTestServer — сервер с in-memory репозиториями, безопасен для t.Parallel()
+func newMemoryTestServer(t *testing.T) *Server {
+	t.Helper()
+	return newTestServer(t, newMemoryBookRepository(), newMemoryUserRepository(), newMemoryTokenRepository())
 }
 
 func TestCreateBook(t *testing.T) {
-	setupTestDB()
-	defer teardownTestDB()
+	t.Parallel()
+	s := newMemoryTestServer(t)
 
 	book := Book{
 		Title:       "Test Book",
@@ -42,18 +168,18 @@ func TestCreateBook(t *testing.T) {
 	rr := httptest.NewRecorder()
 
 	// Тестируем обработчик
-	handler := http.HandlerFunc(addBook)
+	handler := http.HandlerFunc(s.addBook)
 	handler.ServeHTTP(rr, req)
 
 	assert.Equal(t, http.StatusCreated, rr.Code, "Expected 201 Created")
 }
 
 func TestGetBooks(t *testing.T) {
-	setupTestDB()
-	defer teardownTestDB()
+	t.Parallel()
+	s := newMemoryTestServer(t)
 
 	// тестовые данные
-	db.Create(&Book{
+	s.books.Create(context.Background(), &Book{
 		Title:       "Existing Book",
 		Author:      "Author B",
 		Published:   "2020",
@@ -66,16 +192,21 @@ func TestGetBooks(t *testing.T) {
 	rr := httptest.NewRecorder()
 
 	// Тестируем обработчик
-	handler := http.HandlerFunc(getBooks)
+	handler := http.HandlerFunc(s.getBooks)
 	handler.ServeHTTP(rr, req)
 
 	assert.Equal(t, http.StatusOK, rr.Code, "Expected 200 OK")
 	assert.Contains(t, rr.Body.String(), "Existing Book", "Expected book to be present in response")
+
+	req, _ = http.NewRequest("GET", "/books?sortBy=title%3BDROP", nil)
+	rr = httptest.NewRecorder()
+	handler.ServeHTTP(rr, req)
+	assert.Equal(t, http.StatusBadRequest, rr.Code, "Expected 400 for unknown sort field")
 }
 
 func TestUpdateBook(t *testing.T) {
-	setupTestDB()
-	defer teardownTestDB()
+	t.Parallel()
+	s := newMemoryTestServer(t)
 
 	// тестовые данные
 	book := Book{
@@ -86,7 +217,7 @@ func TestUpdateBook(t *testing.T) {
 		Price:       15.99,
 		ImageURL:    "https://example.com/image.png",
 	}
-	db.Create(&book)
+	s.books.Create(context.Background(), &book)
 
 	// Обновляем данные книги
 	book.Title = "New Title"
@@ -96,16 +227,48 @@ func TestUpdateBook(t *testing.T) {
 	rr := httptest.NewRecorder()
 
 	// Тестируем обработчик
-	handler := http.HandlerFunc(updateBook)
+	handler := http.HandlerFunc(s.updateBook)
 	handler.ServeHTTP(rr, req)
 
 	assert.Equal(t, http.StatusOK, rr.Code, "Expected 200 OK")
 	assert.Contains(t, rr.Body.String(), "New Title", "Expected title to be updated")
 }
 
+func TestUpdateBookKeepsSlugAndStock(t *testing.T) {
+	t.Parallel()
+	s := newMemoryTestServer(t)
+	book := Book{Title: "Dune", Author: "Frank Herbert", Stock: 4}
+	s.books.Create(context.Background(), &book)
+	other := Book{Title: "Emma", Author: "Jane Austen"}
+	s.books.Create(context.Background(), &other)
+
+	update := func(body string) *httptest.ResponseRecorder {
+		req, _ := http.NewRequest("PUT", "/books/update", bytes.NewBufferString(body))
+		rr := httptest.NewRecorder()
+		s.updateBook(rr, req)
+		return rr
+	}
+
+	// Так форма администратора отправляет правку: без slug и остатка
+	rr := update(`{"id": 1, "title": "Dune Messiah", "author": "Frank Herbert", "published": "1969"}`)
+	assert.Equal(t, http.StatusOK, rr.Code)
+	saved, err := s.books.Get(context.Background(), book.ID)
+	assert.NoError(t, err)
+	assert.Equal(t, "Dune Messiah", saved.Title)
+	assert.Equal(t, "1969", saved.Published)
+	assert.Equal(t, "dune-frank-herbert", saved.Slug, "Old /book/{slug} links keep working")
+	assert.Equal(t, 4, saved.Stock)
+
+	assert.Equal(t, http.StatusBadRequest, update(`{"id": 1, "slug": "`+other.Slug+`"}`).Code, "Slugs are stable")
+	assert.Equal(t, http.StatusBadRequest, update(`{"id": 1, "slug": "dune-messiah"}`).Code)
+	assert.Equal(t, http.StatusOK, update(`{"id": 1, "slug": "Dune Frank Herbert", "stock": 2}`).Code, "Sending the same slug is fine")
+	assert.Equal(t, http.StatusNotFound, update(`{"id": 99, "title": "Ghost"}`).Code)
+	assert.Equal(t, http.StatusBadRequest, update(`{"title": "No ID"}`).Code)
+}
+
 func TestDeleteBook(t *testing.T) {
-	setupTestDB()
-	defer teardownTestDB()
+	t.Parallel()
+	s := newMemoryTestServer(t)
 
 	// тестовые данные
 	book := Book{
@@ -116,15 +279,49 @@ func TestDeleteBook(t *testing.T) {
 		Price:       10.99,
 		ImageURL:    "https://example.com/image.png",
 	}
-	db.Create(&book)
+	s.books.Create(context.Background(), &book)
 
 	// Удаляем книгу
 	req, _ := http.NewRequest("DELETE", "/books/delete?id=1", nil)
 	rr := httptest.NewRecorder()
 
 	// Тестируем обработчик
-	handler := http.HandlerFunc(deleteBook)
+	handler := http.HandlerFunc(s.deleteBook)
 	handler.ServeHTTP(rr, req)
 
 	assert.Equal(t, http.StatusNoContent, rr.Code, "Expected 204 No Content")
+	_, err := s.books.Get(context.Background(), book.ID)
+	assert.ErrorIs(t, err, ErrNotFound)
+}
+
+func TestRegisterAndVerify(t *testing.T) {
+	t.Parallel()
+	s := newMemoryTestServer(t)
+	mailer := s.mailer.(*fakeMailer)
+
+	body := `{"name":"Reader","email":"reader@example.com","password":"turn-the-page-42","role":"admin"}`
+	req, _ := http.NewRequest("POST", "/register", bytes.NewBufferString(body))
+	rr := httptest.NewRecorder()
+	http.HandlerFunc(s.registerHandler).ServeHTTP(rr, req)
+	assert.Equal(t, http.StatusCreated, rr.Code, "Expected 201 Created")
+
+	user, err := s.users.GetByEmail(context.Background(), "reader@example.com")
+	assert.NoError(t, err)
+	assert.False(t, user.Confirmed)
+	assert.Equal(t, "user", user.Role, "Registration never grants admin")
+	token := mailedSecret(t, mailer, verifyLinkPattern)
+
+	req, _ = http.NewRequest("GET", "/verify?token="+token, nil)
+	rr = httptest.NewRecorder()
+	http.HandlerFunc(s.verifyEmailHandler).ServeHTTP(rr, req)
+	assert.Equal(t, http.StatusOK, rr.Code, "Expected 200 OK")
+
+	user, _ = s.users.GetByEmail(context.Background(), "reader@example.com")
+	assert.True(t, user.Confirmed)
+
+	// Ссылка одноразовая
+	req, _ = http.NewRequest("GET", "/verify?token="+token, nil)
+	rr = httptest.NewRecorder()
+	http.HandlerFunc(s.verifyEmailHandler).ServeHTTP(rr, req)
+	assert.Equal(t, http.StatusBadRequest, rr.Code, "Expected 400 Bad Request")
 }
@@ -0,0 +1,208 @@
+package main
+
+import (
+	"context"
+	"crypto/subtle"
+	"errors"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/collectors"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+	"gorm.io/gorm"
+)
+
+// Метрики Prometheus для GET /metrics. У каждого Server свой реестр, чтобы
+// серверы в тестах не мешали друг другу.
+
+type metrics struct {
+	registry        *prometheus.Registry
+	requests        *prometheus.CounterVec
+	requestDuration *prometheus.HistogramVec
+	rateLimited     *prometheus.CounterVec
+	dbQueries       *prometheus.HistogramVec
+	emails          *prometheus.CounterVec
+}
+
+func newMetrics(books BookRepository) *metrics {
+	m := &metrics{
+		registry: prometheus.NewRegistry(),
+		requests: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Name: "bookstore_http_requests_total",
+			Help: "HTTP requests by route pattern, method and status code.",
+		}, []string{"route", "method", "status"}),
+		requestDuration: prometheus.NewHistogramVec(prometheus.HistogramOpts{
+			Name:    "bookstore_http_request_duration_seconds",
+			Help:    "HTTP request latency by route pattern, method and status code.",
+			Buckets: prometheus.DefBuckets,
+		}, []string{"route", "method", "status"}),
+		rateLimited: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Name: "bookstore_rate_limit_rejections_total",
+			Help: "Requests rejected with 429 by rate limit policy.",
+		}, []string{"policy"}),
+		dbQueries: prometheus.NewHistogramVec(prometheus.HistogramOpts{
+			Name:    "bookstore_db_query_duration_seconds",
+			Help:    "Database query latency by operation, table and outcome.",
+			Buckets: []float64{.0005, .001, .0025, .005, .01, .025, .05, .1, .25, .5, 1, 2.5},
+		}, []string{"operation", "table", "result"}),
+		emails: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Name: "bookstore_emails_sent_total",
+			Help: "Outgoing emails by kind and result (success or failure).",
+		}, []string{"kind", "result"}),
+	}
+	m.registry.MustRegister(
+		collectors.NewGoCollector(),
+		collectors.NewProcessCollector(collectors.ProcessCollectorOpts{}),
+		m.requests, m.requestDuration, m.rateLimited, m.dbQueries, m.emails,
+		newCatalogCollector(books),
+	)
+	return m
+}
+
+// instrument считает запросы по шаблону маршрута из mux, а не по пути,
+// чтобы /book/{slug} и подобные не плодили метки
+func (s *Server) instrument(mux *http.ServeMux, next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		start := time.Now()
+		_, route := mux.Handler(r)
+		if route == "" {
+			route = "unmatched"
+		}
+		lw := &loggingResponseWriter{ResponseWriter: w}
+		next.ServeHTTP(lw, r)
+
+		if lw.status == 0 {
+			lw.status = http.StatusOK
+		}
+		labels := prometheus.Labels{"route": route, "method": r.Method, "status": strconv.Itoa(lw.status)}
+		s.metrics.requests.With(labels).Inc()
+		s.metrics.requestDuration.With(labels).Observe(time.Since(start).Seconds())
+	})
+}
+
+// metricsHandler отдаёт метрики; с METRICS_TOKEN — только по Bearer-токену
+func (s *Server) metricsHandler() http.Handler {
+	handler := promhttp.HandlerFor(s.metrics.registry, promhttp.HandlerOpts{})
+	token := s.config.Metrics.Token.Value()
+	if token == "" {
+		return handler
+	}
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		got, _ := strings.CutPrefix(r.Header.Get("Authorization"), "Bearer ")
+		if subtle.ConstantTimeCompare([]byte(got), []byte(token)) != 1 {
+			http.Error(w, "Unauthorized", http.StatusUnauthorized)
+			return
+		}
+		handler.ServeHTTP(w, r)
+	})
+}
+
+// catalogCollector считает остатки каталога в момент опроса Prometheus.
+// Gauge заказов по состояниям сюда не входит: в магазине пока нет ни модели
+// заказов, ни их состояний, и считать нечего. Он появится вместе с заказами
+type catalogCollector struct {
+	books  BookRepository
+	titles *prometheus.Desc
+	copies *prometheus.Desc
+	errors prometheus.Counter
+}
+
+func newCatalogCollector(books BookRepository) *catalogCollector {
+	return &catalogCollector{
+		books:  books,
+		titles: prometheus.NewDesc("bookstore_books_in_stock", "Titles with at least one copy in stock.", nil, nil),
+		copies: prometheus.NewDesc("bookstore_book_copies_in_stock", "Copies in stock across all titles.", nil, nil),
+		errors: prometheus.NewCounter(prometheus.CounterOpts{
+			Name: "bookstore_catalog_scrape_errors_total",
+			Help: "Failed catalogue queries while collecting metrics.",
+		}),
+	}
+}
+
+func (c *catalogCollector) Describe(ch chan<- *prometheus.Desc) {
+	ch <- c.titles
+	ch <- c.copies
+	c.errors.Describe(ch)
+}
+
+func (c *catalogCollector) Collect(ch chan<- prometheus.Metric) {
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	titles, copies, err := c.books.CountInStock(ctx)
+	if err != nil {
+		c.errors.Inc()
+	} else {
+		ch <- prometheus.MustNewConstMetric(c.titles, prometheus.GaugeValue, float64(titles))
+		ch <- prometheus.MustNewConstMetric(c.copies, prometheus.GaugeValue, float64(copies))
+	}
+	c.errors.Collect(ch)
+}
+
+// gormMetrics — плагин GORM, замеряющий каждый запрос к базе
+type gormMetrics struct {
+	queries *prometheus.HistogramVec
+}
+
+const gormMetricsStartKey = "metrics:start"
+
+func (p gormMetrics) Name() string { return "bookstore:metrics" }
+
+func (p gormMetrics) Initialize(db *gorm.DB) error {
+	return registerGormCallbacks(db, "metrics", p.before, p.after)
+}
+
+type gormCallback interface {
+	Register(name string, fn func(*gorm.DB)) error
+}
+
+// registerGormCallbacks вешает колбэки плагина вокруг каждой операции GORM
+func registerGormCallbacks(db *gorm.DB, plugin string, before, after func(operation string) func(*gorm.DB)) error {
+	cb := db.Callback()
+	hooks := []struct {
+		operation     string
+		before, after gormCallback
+	}{
+		{"create", cb.Create().Before("gorm:create"), cb.Create().After("gorm:create")},
+		{"query", cb.Query().Before("gorm:query"), cb.Query().After("gorm:query")},
+		{"update", cb.Update().Before("gorm:update"), cb.Update().After("gorm:update")},
+		{"delete", cb.Delete().Before("gorm:delete"), cb.Delete().After("gorm:delete")},
+		{"row", cb.Row().Before("gorm:row"), cb.Row().After("gorm:row")},
+		{"raw", cb.Raw().Before("gorm:raw"), cb.Raw().After("gorm:raw")},
+	}
+	var errs []error
+	for _, h := range hooks {
+		errs = append(errs,
+			h.before.Register(plugin+":before_"+h.operation, before(h.operation)),
+			h.after.Register(plugin+":after_"+h.operation, after(h.operation)),
+		)
+	}
+	return errors.Join(errs...)
+}
+
+func (p gormMetrics) before(string) func(*gorm.DB) {
+	return func(db *gorm.DB) {
+		db.InstanceSet(gormMetricsStartKey, time.Now())
+	}
+}
+
+func (p gormMetrics) after(operation string) func(*gorm.DB) {
+	return func(db *gorm.DB) {
+		v, ok := db.InstanceGet(gormMetricsStartKey)
+		if !ok {
+			return
+		}
+		start, _ := v.(time.Time)
+		result := "success"
+		if db.Error != nil && !errors.Is(db.Error, gorm.ErrRecordNotFound) {
+			result = "error"
+		}
+		table := db.Statement.Table
+		if table == "" {
+			table = "unknown"
+		}
+		p.queries.WithLabelValues(operation, table, result).Observe(time.Since(start).Seconds())
+	}
+}
@@ -0,0 +1,101 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"testing"
+
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+	"github.com/stretchr/testify/assert"
+)
+
+func scrapeMetrics(t *testing.T, handler http.Handler, token string) string {
+	t.Helper()
+	rr := getFrom(handler, "/metrics", "203.0.113.9", token)
+	assert.Equal(t, http.StatusOK, rr.Code, "Expected 200 OK")
+	return rr.Body.String()
+}
+
+func TestMetricsEndpoint(t *testing.T) {
+	t.Parallel()
+	s, handler := rateLimitTestServer(t)
+	ctx := context.Background()
+	assert.NoError(t, s.books.Create(ctx, &Book{Title: "Dune", Author: "Frank Herbert", Stock: 4}))
+	assert.NoError(t, s.books.Create(ctx, &Book{Title: "Emma", Author: "Jane Austen", Stock: 2}))
+	assert.NoError(t, s.books.Create(ctx, &Book{Title: "Sold Out", Author: "Nobody", Stock: 0}))
+
+	getFrom(handler, "/healthz", "203.0.113.1", "")
+	for range 4 {
+		getFrom(handler, "/books", "203.0.113.1", "")
+	}
+	withAPIKey(handler, "DELETE", "/api/admin/api-keys/5", "", "")
+
+	body := scrapeMetrics(t, handler, "")
+	assert.Contains(t, body, `bookstore_http_requests_total{method="GET",route="/healthz",status="200"} 1`)
+	assert.Contains(t, body, `bookstore_http_requests_total{method="GET",route="/books",status="200"} 3`)
+	assert.Contains(t, body, `bookstore_http_requests_total{method="GET",route="/books",status="429"} 1`)
+	assert.Contains(t, body, `bookstore_http_requests_total{method="DELETE",route="DELETE /api/admin/api-keys/{id}",status="401"} 1`, "Routes are labelled by pattern")
+	assert.Contains(t, body, `bookstore_http_request_duration_seconds_count{method="GET",route="/books",status="200"} 3`)
+	assert.Contains(t, body, `bookstore_rate_limit_rejections_total{policy="books"} 1`)
+	assert.Contains(t, body, "bookstore_books_in_stock 2")
+	assert.Contains(t, body, "bookstore_book_copies_in_stock 6")
+	assert.Contains(t, body, "bookstore_log_entries_dropped_total 0")
+	assert.Contains(t, body, "go_goroutines")
+
+	for range 5 {
+		getFrom(handler, "/metrics", "203.0.113.9", "")
+	}
+	assert.NotContains(t, scrapeMetrics(t, handler, ""), `route="GET /metrics",status="429"`, "Scrapes are not rate limited")
+}
+
+func TestMetricsToken(t *testing.T) {
+	t.Parallel()
+	cfg := testConfig()
+	cfg.Metrics.Token = "scrape-secret"
+	handler := newConfigTestServer(t, cfg, newMemoryTokenRepository()).routes()
+
+	assert.Equal(t, http.StatusUnauthorized, getFrom(handler, "/metrics", "203.0.113.9", "").Code)
+	assert.Equal(t, http.StatusUnauthorized, getFrom(handler, "/metrics", "203.0.113.9", "wrong").Code)
+	assert.Contains(t, scrapeMetrics(t, handler, "scrape-secret"), "bookstore_http_requests_total")
+}
+
+type failingMailer struct{}
+
+func (failingMailer) SendMail(to []string, msg []byte) error {
+	return errors.New("smtp: connection refused")
+}
+
+func TestEmailMetrics(t *testing.T) {
+	t.Parallel()
+	s := newMemoryTestServer(t)
+	handler := s.routes()
+
+	assert.NoError(t, s.sendPasswordResetEmail(context.Background(), "reader@example.com", "token"))
+	s.mailer = failingMailer{}
+	assert.Error(t, s.sendPasswordResetEmail(context.Background(), "reader@example.com", "token"))
+	s.sendVerificationEmail(context.Background(), "reader@example.com", "token", "123456")
+
+	body := scrapeMetrics(t, handler, "")
+	assert.Contains(t, body, `bookstore_emails_sent_total{kind="password_reset",result="success"} 1`)
+	assert.Contains(t, body, `bookstore_emails_sent_total{kind="password_reset",result="failure"} 1`)
+	assert.Contains(t, body, `bookstore_emails_sent_total{kind="verification",result="failure"} 1`)
+}
+
+func TestGormMetricsPlugin(t *testing.T) {
+	t.Parallel()
+	db := newMigratedTestDB(t)
+	m := newMetrics(newMemoryBookRepository())
+	assert.NoError(t, db.Use(gormMetrics{queries: m.dbQueries}))
+	repo := newGormBookRepository(db)
+	ctx := context.Background()
+
+	assert.NoError(t, repo.Create(ctx, &Book{Title: "Dune", Author: "Frank Herbert"}))
+	_, err := repo.Get(ctx, 999)
+	assert.ErrorIs(t, err, ErrNotFound)
+
+	body := scrapeMetrics(t, promhttp.HandlerFor(m.registry, promhttp.HandlerOpts{}), "")
+	assert.Contains(t, body, `bookstore_db_query_duration_seconds_count{operation="create",result="success",table="books"} 1`)
+	assert.Contains(t, body, `bookstore_db_query_duration_seconds_count{operation="query",result="success",table="books"}`, "A missing row is not a database error")
+	assert.NotContains(t, body, `result="error"`)
+}
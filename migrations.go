@@ -0,0 +1,622 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"strconv"
+	"text/tabwriter"
+	"time"
+
+	"gorm.io/gorm"
+)
+
+// Версионированные миграции схемы. Каждая миграция описывает таблицы
+// собственными структурами, чтобы не зависеть от текущих моделей.
+// Новые миграции добавляются только в конец списка.
+
+type migration struct {
+	Version int
+	Name    string
+	Up      func(tx *gorm.DB) error
+	Down    func(tx *gorm.DB) error
+}
+
+// SchemaMigration — запись о применённой миграции
+type SchemaMigration struct {
+	Version   int `gorm:"primaryKey;autoIncrement:false"`
+	Name      string
+	AppliedAt time.Time
+}
+
+var migrations = []migration{
+	{
+		Version: 1,
+		Name:    "baseline",
+		Up: func(tx *gorm.DB) error {
+			type Book struct {
+				ID          uint
+				Title       string
+				Author      string
+				Published   string
+				Description string
+				Price       float64
+				ImageURL    string
+			}
+			type Fantasy struct {
+				ID          uint
+				Title       string
+				Description string
+				Price       float64
+				ImageURL    string
+			}
+			type User struct {
+				ID                uint `gorm:"primaryKey"`
+				Name              string
+				Email             string `gorm:"unique"`
+				PasswordHash      string
+				Role              string
+				Confirmed         bool
+				VerificationToken string `gorm:"unique"`
+				CreatedAt         time.Time
+			}
+			// AutoMigrate, а не CreateTable: базы, созданные до миграций, уже содержат эти таблицы
+			return tx.AutoMigrate(&Book{}, &Fantasy{}, &User{})
+		},
+		Down: func(tx *gorm.DB) error {
+			return tx.Migrator().DropTable("users", "fantasies", "books")
+		},
+	},
+	{
+		Version: 2,
+		Name:    "book_stock_and_slug",
+		Up: func(tx *gorm.DB) error {
+			type Book struct {
+				ID    uint
+				Stock int    `gorm:"default:0"`
+				Slug  string `gorm:"index"`
+			}
+			m := tx.Migrator()
+			for _, column := range []string{"Stock", "Slug"} {
+				if !m.HasColumn(&Book{}, column) {
+					if err := m.AddColumn(&Book{}, column); err != nil {
+						return err
+					}
+				}
+			}
+			if !m.HasIndex(&Book{}, "Slug") {
+				if err := m.CreateIndex(&Book{}, "Slug"); err != nil {
+					return err
+				}
+			}
+			return backfillBookSlugs(tx)
+		},
+		Down: func(tx *gorm.DB) error {
+			type Book struct {
+				ID    uint
+				Stock int
+				Slug  string `gorm:"index"`
+			}
+			m := tx.Migrator()
+			if err := m.DropIndex(&Book{}, "Slug"); err != nil {
+				return err
+			}
+			if err := m.DropColumn(&Book{}, "Slug"); err != nil {
+				return err
+			}
+			return m.DropColumn(&Book{}, "Stock")
+		},
+	},
+	{
+		Version: 3,
+		Name:    "log_entries",
+		Up: func(tx *gorm.DB) error {
+			type LogEntry struct {
+				ID        uint `gorm:"primaryKey"`
+				Timestamp time.Time
+				Level     string
+				Message   string
+			}
+			return tx.AutoMigrate(&LogEntry{})
+		},
+		Down: func(tx *gorm.DB) error {
+			return tx.Migrator().DropTable("log_entries")
+		},
+	},
+	{
+		Version: 4,
+		Name:    "sessions_and_refresh_tokens",
+		Up: func(tx *gorm.DB) error {
+			type Session struct {
+				ID        string `gorm:"primaryKey"`
+				UserID    uint   `gorm:"index"`
+				CreatedAt time.Time
+				RevokedAt *time.Time
+			}
+			type RefreshToken struct {
+				ID        uint   `gorm:"primaryKey"`
+				SessionID string `gorm:"index"`
+				TokenHash string `gorm:"uniqueIndex"`
+				ExpiresAt time.Time
+				UsedAt    *time.Time
+				CreatedAt time.Time
+			}
+			type DeniedToken struct {
+				JTI       string    `gorm:"primaryKey"`
+				ExpiresAt time.Time `gorm:"index"`
+			}
+			return tx.AutoMigrate(&Session{}, &RefreshToken{}, &DeniedToken{})
+		},
+		Down: func(tx *gorm.DB) error {
+			return tx.Migrator().DropTable("denied_tokens", "refresh_tokens", "sessions")
+		},
+	},
+	{
+		Version: 5,
+		Name:    "signing_keys",
+		Up: func(tx *gorm.DB) error {
+			type SigningKey struct {
+				ID         string `gorm:"primaryKey"`
+				Algorithm  string
+				PrivateKey []byte
+				CreatedAt  time.Time `gorm:"index"`
+			}
+			return tx.AutoMigrate(&SigningKey{})
+		},
+		Down: func(tx *gorm.DB) error {
+			return tx.Migrator().DropTable("signing_keys")
+		},
+	},
+	{
+		Version: 6,
+		Name:    "user_identities",
+		Up: func(tx *gorm.DB) error {
+			type UserIdentity struct {
+				ID        uint   `gorm:"primaryKey"`
+				UserID    uint   `gorm:"index"`
+				Provider  string `gorm:"uniqueIndex:idx_identity"`
+				Subject   string `gorm:"uniqueIndex:idx_identity"`
+				Email     string
+				CreatedAt time.Time
+			}
+			return tx.AutoMigrate(&UserIdentity{})
+		},
+		Down: func(tx *gorm.DB) error {
+			return tx.Migrator().DropTable("user_identities")
+		},
+	},
+	{
+		Version: 7,
+		Name:    "user_tokens",
+		Up: func(tx *gorm.DB) error {
+			type UserToken struct {
+				ID        uint   `gorm:"primaryKey"`
+				UserID    uint   `gorm:"index"`
+				Purpose   string `gorm:"index"`
+				TokenHash string `gorm:"uniqueIndex"`
+				Attempts  int
+				ExpiresAt time.Time
+				UsedAt    *time.Time
+				CreatedAt time.Time
+			}
+			type User struct {
+				VerificationToken string `gorm:"unique"`
+			}
+			if err := tx.AutoMigrate(&UserToken{}); err != nil {
+				return err
+			}
+			// Старые 4-значные коды больше не принимаются: неподтверждённые
+			// пользователи получат новую ссылку при повторной регистрации
+			if !tx.Migrator().HasColumn(&User{}, "VerificationToken") {
+				return nil
+			}
+			// SQLite пересоздаёт таблицу при удалении колонки, поэтому сначала снимаем ограничение
+			if tx.Migrator().HasConstraint(&User{}, "uni_users_verification_token") {
+				if err := tx.Migrator().DropConstraint(&User{}, "uni_users_verification_token"); err != nil {
+					return err
+				}
+			}
+			return tx.Migrator().DropColumn(&User{}, "VerificationToken")
+		},
+		Down: func(tx *gorm.DB) error {
+			type User struct {
+				VerificationToken string
+			}
+			if err := tx.Migrator().AddColumn(&User{}, "VerificationToken"); err != nil {
+				return err
+			}
+			return tx.Migrator().DropTable("user_tokens")
+		},
+	},
+	{
+		Version: 8,
+		Name:    "two_factor",
+		Up: func(tx *gorm.DB) error {
+			type TwoFactor struct {
+				UserID    uint `gorm:"primaryKey"`
+				Secret    []byte
+				Enabled   bool
+				LastStep  int64
+				CreatedAt time.Time
+				EnabledAt *time.Time
+			}
+			type RecoveryCode struct {
+				ID       uint   `gorm:"primaryKey"`
+				UserID   uint   `gorm:"index"`
+				CodeHash string `gorm:"uniqueIndex"`
+				UsedAt   *time.Time
+			}
+			type Session struct {
+				MFA bool
+			}
+			if err := tx.AutoMigrate(&TwoFactor{}, &RecoveryCode{}); err != nil {
+				return err
+			}
+			return tx.Migrator().AddColumn(&Session{}, "MFA")
+		},
+		Down: func(tx *gorm.DB) error {
+			type Session struct {
+				MFA bool
+			}
+			if err := tx.Migrator().DropColumn(&Session{}, "MFA"); err != nil {
+				return err
+			}
+			return tx.Migrator().DropTable("recovery_codes", "two_factors")
+		},
+	},
+	{
+		Version: 9,
+		Name:    "passkeys",
+		Up: func(tx *gorm.DB) error {
+			type Passkey struct {
+				ID              uint   `gorm:"primaryKey"`
+				UserID          uint   `gorm:"index"`
+				CredentialID    []byte `gorm:"uniqueIndex"`
+				PublicKey       []byte
+				AttestationType string
+				AAGUID          []byte
+				SignCount       uint32
+				Transports      string
+				BackupEligible  bool
+				BackupState     bool
+				Name            string
+				CreatedAt       time.Time
+				LastUsedAt      *time.Time
+			}
+			type WebAuthnSession struct {
+				ID        string `gorm:"primaryKey"`
+				UserID    uint
+				Data      []byte
+				ExpiresAt time.Time `gorm:"index"`
+			}
+			return tx.AutoMigrate(&Passkey{}, &WebAuthnSession{})
+		},
+		Down: func(tx *gorm.DB) error {
+			return tx.Migrator().DropTable("web_authn_sessions", "passkeys")
+		},
+	},
+	{
+		Version: 10,
+		Name:    "pending_email",
+		Up: func(tx *gorm.DB) error {
+			type User struct {
+				PendingEmail string
+			}
+			return tx.Migrator().AddColumn(&User{}, "PendingEmail")
+		},
+		Down: func(tx *gorm.DB) error {
+			type User struct {
+				PendingEmail string
+			}
+			return tx.Migrator().DropColumn(&User{}, "PendingEmail")
+		},
+	},
+	{
+		Version: 11,
+		Name:    "cookie_sessions",
+		Up: func(tx *gorm.DB) error {
+			type Session struct {
+				Cookie     bool
+				LastSeenAt *time.Time
+			}
+			if err := tx.Migrator().AddColumn(&Session{}, "Cookie"); err != nil {
+				return err
+			}
+			return tx.Migrator().AddColumn(&Session{}, "LastSeenAt")
+		},
+		Down: func(tx *gorm.DB) error {
+			type Session struct {
+				Cookie     bool
+				LastSeenAt *time.Time
+			}
+			if err := tx.Migrator().DropColumn(&Session{}, "LastSeenAt"); err != nil {
+				return err
+			}
+			return tx.Migrator().DropColumn(&Session{}, "Cookie")
+		},
+	},
+	{
+		Version: 12,
+		Name:    "api_keys",
+		Up: func(tx *gorm.DB) error {
+			type APIKey struct {
+				ID         uint `gorm:"primaryKey"`
+				Name       string
+				Prefix     string `gorm:"uniqueIndex"`
+				KeyHash    string
+				Scopes     string
+				CreatedBy  uint
+				CreatedAt  time.Time
+				ExpiresAt  *time.Time
+				LastUsedAt *time.Time
+				RevokedAt  *time.Time
+			}
+			return tx.AutoMigrate(&APIKey{})
+		},
+		Down: func(tx *gorm.DB) error {
+			return tx.Migrator().DropTable("api_keys")
+		},
+	},
+	{
+		Version: 13,
+		Name:    "log_entry_fields",
+		Up: func(tx *gorm.DB) error {
+			type LogEntry struct {
+				ID        uint      `gorm:"primaryKey"`
+				Timestamp time.Time `gorm:"index"`
+				Level     string
+				Message   string
+				Fields    string `gorm:"type:text"`
+			}
+			m := tx.Migrator()
+			if err := m.AddColumn(&LogEntry{}, "Fields"); err != nil {
+				return err
+			}
+			return m.CreateIndex(&LogEntry{}, "Timestamp")
+		},
+		Down: func(tx *gorm.DB) error {
+			type LogEntry struct {
+				ID        uint      `gorm:"primaryKey"`
+				Timestamp time.Time `gorm:"index"`
+				Fields    string    `gorm:"type:text"`
+			}
+			m := tx.Migrator()
+			if err := m.DropIndex(&LogEntry{}, "Timestamp"); err != nil {
+				return err
+			}
+			return m.DropColumn(&LogEntry{}, "Fields")
+		},
+	},
+	{
+		Version: 14,
+		Name:    "unique_book_slug",
+		Up: func(tx *gorm.DB) error {
+			if err := dedupeBookSlugs(tx); err != nil {
+				return err
+			}
+			type Book struct {
+				ID   uint
+				Slug string `gorm:"uniqueIndex"`
+			}
+			m := tx.Migrator()
+			if err := m.DropIndex(&Book{}, "Slug"); err != nil {
+				return err
+			}
+			return m.CreateIndex(&Book{}, "Slug")
+		},
+		Down: func(tx *gorm.DB) error {
+			type Book struct {
+				ID   uint
+				Slug string `gorm:"index"`
+			}
+			m := tx.Migrator()
+			if err := m.DropIndex(&Book{}, "Slug"); err != nil {
+				return err
+			}
+			return m.CreateIndex(&Book{}, "Slug")
+		},
+	},
+	{
+		Version: 15,
+		Name:    "identity_linked_by_email",
+		Up: func(tx *gorm.DB) error {
+			type UserIdentity struct {
+				ID            uint
+				UserID        uint
+				LinkedByEmail bool `gorm:"not null;default:false"`
+				CreatedAt     time.Time
+			}
+			type User struct {
+				ID        uint
+				CreatedAt time.Time
+			}
+			if err := tx.Migrator().AddColumn(&UserIdentity{}, "LinkedByEmail"); err != nil {
+				return err
+			}
+			// Раньше способ привязки не сохранялся: учётная запись, созданная
+			// заметно раньше привязки, была найдена по email
+			var identities []UserIdentity
+			if err := tx.Find(&identities).Error; err != nil {
+				return err
+			}
+			for _, identity := range identities {
+				var user User
+				err := tx.Take(&user, identity.UserID).Error
+				if errors.Is(err, gorm.ErrRecordNotFound) {
+					continue
+				}
+				if err != nil {
+					return err
+				}
+				if identity.CreatedAt.Sub(user.CreatedAt) > time.Minute {
+					if err := tx.Model(&identity).Update("LinkedByEmail", true).Error; err != nil {
+						return err
+					}
+				}
+			}
+			return nil
+		},
+		Down: func(tx *gorm.DB) error {
+			type UserIdentity struct {
+				LinkedByEmail bool
+			}
+			return tx.Migrator().DropColumn(&UserIdentity{}, "LinkedByEmail")
+		},
+	},
+}
+
+// latestSchemaVersion — версия схемы, которую ожидает код
+func latestSchemaVersion() int {
+	return migrations[len(migrations)-1].Version
+}
+
+// appliedMigrations читает schema_migrations, ничего не меняя в базе:
+// без этой таблицы не применена ни одна миграция
+func appliedMigrations(conn *gorm.DB) (map[int]SchemaMigration, error) {
+	if !conn.Migrator().HasTable(&SchemaMigration{}) {
+		return map[int]SchemaMigration{}, nil
+	}
+	var rows []SchemaMigration
+	if err := conn.Order("version").Find(&rows).Error; err != nil {
+		return nil, err
+	}
+	applied := make(map[int]SchemaMigration, len(rows))
+	for _, row := range rows {
+		applied[row.Version] = row
+	}
+	return applied, nil
+}
+
+// migrateUp применяет все неприменённые миграции по порядку, каждую в своей транзакции
+func migrateUp(conn *gorm.DB) ([]migration, error) {
+	if err := conn.AutoMigrate(&SchemaMigration{}); err != nil {
+		return nil, fmt.Errorf("failed to create schema_migrations table: %w", err)
+	}
+	applied, err := appliedMigrations(conn)
+	if err != nil {
+		return nil, err
+	}
+
+	var done []migration
+	for _, m := range migrations {
+		if _, ok := applied[m.Version]; ok {
+			continue
+		}
+		err := conn.Transaction(func(tx *gorm.DB) error {
+			if err := m.Up(tx); err != nil {
+				return err
+			}
+			return tx.Create(&SchemaMigration{Version: m.Version, Name: m.Name, AppliedAt: time.Now()}).Error
+		})
+		if err != nil {
+			return done, fmt.Errorf("migration %d (%s) failed: %w", m.Version, m.Name, err)
+		}
+		done = append(done, m)
+	}
+	return done, nil
+}
+
+// migrateDown откатывает последние steps применённых миграций
+func migrateDown(conn *gorm.DB, steps int) ([]migration, error) {
+	applied, err := appliedMigrations(conn)
+	if err != nil {
+		return nil, err
+	}
+
+	var done []migration
+	for i := len(migrations) - 1; i >= 0 && len(done) < steps; i-- {
+		m := migrations[i]
+		if _, ok := applied[m.Version]; !ok {
+			continue
+		}
+		err := conn.Transaction(func(tx *gorm.DB) error {
+			if err := m.Down(tx); err != nil {
+				return err
+			}
+			return tx.Delete(&SchemaMigration{}, m.Version).Error
+		})
+		if err != nil {
+			return done, fmt.Errorf("rollback of migration %d (%s) failed: %w", m.Version, m.Name, err)
+		}
+		done = append(done, m)
+	}
+	return done, nil
+}
+
+// pendingMigrations возвращает миграции, которые ещё не применены
+func pendingMigrations(conn *gorm.DB) ([]migration, error) {
+	applied, err := appliedMigrations(conn)
+	if err != nil {
+		return nil, err
+	}
+	var pending []migration
+	for _, m := range migrations {
+		if _, ok := applied[m.Version]; !ok {
+			pending = append(pending, m)
+		}
+	}
+	return pending, nil
+}
+
+// checkSchemaCurrent не даёт запустить сервер на устаревшей схеме
+func checkSchemaCurrent(conn *gorm.DB) error {
+	pending, err := pendingMigrations(conn)
+	if err != nil {
+		return err
+	}
+	if len(pending) > 0 {
+		return fmt.Errorf("database schema is behind: %d pending migration(s), starting with %d (%s); run `migrate up`",
+			len(pending), pending[0].Version, pending[0].Name)
+	}
+	return nil
+}
+
+// runMigrateCommand выполняет подкоманду: migrate up | down [N] | status
+func runMigrateCommand(conn *gorm.DB, args []string) error {
+	if len(args) == 0 {
+		return fmt.Errorf("usage: migrate up | down [N] | status")
+	}
+
+	switch args[0] {
+	case "up":
+		done, err := migrateUp(conn)
+		for _, m := range done {
+			fmt.Printf("✅ applied %d %s\n", m.Version, m.Name)
+		}
+		if err == nil && len(done) == 0 {
+			fmt.Println("Schema is up to date")
+		}
+		return err
+	case "down":
+		steps := 1
+		if len(args) > 1 {
+			n, err := strconv.Atoi(args[1])
+			if err != nil || n < 1 {
+				return fmt.Errorf("down expects a positive number of steps, got %q", args[1])
+			}
+			steps = n
+		}
+		done, err := migrateDown(conn, steps)
+		for _, m := range done {
+			fmt.Printf("↩️ rolled back %d %s\n", m.Version, m.Name)
+		}
+		return err
+	case "status":
+		applied, err := appliedMigrations(conn)
+		if err != nil {
+			return err
+		}
+		w := tabwriter.NewWriter(os.Stdout, 0, 4, 2, ' ', 0)
+		fmt.Fprintln(w, "VERSION\tNAME\tAPPLIED AT")
+		for _, m := range migrations {
+			appliedAt := "pending"
+			if row, ok := applied[m.Version]; ok {
+				appliedAt = row.AppliedAt.Format(time.RFC3339)
+			}
+			fmt.Fprintf(w, "%d\t%s\t%s\n", m.Version, m.Name, appliedAt)
+		}
+		return w.Flush()
+	default:
+		return fmt.Errorf("unknown migrate command %q; use up, down [N] or status", args[0])
+	}
+}
@@ -0,0 +1,128 @@
+package main
+
+import (
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestMigrateUpAndDown(t *testing.T) {
+	t.Parallel()
+	conn := newTestDB(t)
+
+	assert.ErrorContains(t, checkSchemaCurrent(conn), fmt.Sprintf("%d pending migration(s)", len(migrations)), "Empty database must be reported as behind")
+	assert.False(t, conn.Migrator().HasTable(&SchemaMigration{}), "The check does not change the schema")
+
+	done, err := migrateUp(conn)
+	assert.NoError(t, err)
+	assert.Len(t, done, len(migrations))
+	assert.NoError(t, checkSchemaCurrent(conn))
+	assert.True(t, conn.Migrator().HasColumn(&Book{}, "Slug"))
+	assert.True(t, conn.Migrator().HasTable(&LogEntry{}))
+	assert.True(t, conn.Migrator().HasTable(&RefreshToken{}))
+	assert.True(t, conn.Migrator().HasTable(&SigningKey{}))
+	assert.True(t, conn.Migrator().HasTable(&UserIdentity{}))
+	assert.True(t, conn.Migrator().HasTable(&UserToken{}))
+	assert.False(t, conn.Migrator().HasColumn("users", "verification_token"))
+	assert.True(t, conn.Migrator().HasTable(&TwoFactor{}))
+	assert.True(t, conn.Migrator().HasColumn(&Session{}, "MFA"))
+	assert.True(t, conn.Migrator().HasTable(&Passkey{}))
+	assert.True(t, conn.Migrator().HasTable(&WebAuthnSession{}))
+	assert.True(t, conn.Migrator().HasColumn(&User{}, "PendingEmail"))
+	assert.True(t, conn.Migrator().HasColumn(&Session{}, "LastSeenAt"))
+	assert.True(t, conn.Migrator().HasTable(&APIKey{}))
+	assert.True(t, conn.Migrator().HasColumn(&LogEntry{}, "Fields"))
+	assert.True(t, conn.Migrator().HasIndex(&LogEntry{}, "Timestamp"))
+	assert.NoError(t, conn.Exec("INSERT INTO books (title, slug) VALUES (?, ?)", "Dune", "dune").Error)
+	assert.Error(t, conn.Exec("INSERT INTO books (title, slug) VALUES (?, ?)", "Dune", "dune").Error, "Slugs are unique")
+
+	// Повторный запуск ничего не делает
+	done, err = migrateUp(conn)
+	assert.NoError(t, err)
+	assert.Empty(t, done)
+
+	done, err = migrateDown(conn, 1)
+	assert.NoError(t, err)
+	assert.Equal(t, latestSchemaVersion(), done[0].Version)
+	assert.False(t, conn.Migrator().HasColumn(&UserIdentity{}, "LinkedByEmail"))
+	assert.ErrorContains(t, checkSchemaCurrent(conn), "1 pending migration(s)")
+	_, err = migrateUp(conn)
+	assert.NoError(t, err)
+
+	done, err = migrateDown(conn, 2)
+	assert.NoError(t, err)
+	assert.Equal(t, latestSchemaVersion()-1, done[1].Version)
+	assert.True(t, conn.Migrator().HasIndex(&Book{}, "Slug"))
+	assert.NoError(t, conn.Exec("INSERT INTO books (title, slug) VALUES (?, ?)", "Dune", "dune").Error)
+	assert.True(t, conn.Migrator().HasColumn(&LogEntry{}, "Fields"))
+	assert.ErrorContains(t, checkSchemaCurrent(conn), "2 pending migration(s)")
+
+	_, err = migrateUp(conn)
+	assert.NoError(t, err, "Duplicate slugs are renamed before the unique index is created")
+	var slugs []string
+	conn.Table("books").Order("id").Pluck("slug", &slugs)
+	assert.Equal(t, []string{"dune", "dune-2"}, slugs)
+
+	done, err = migrateDown(conn, 3)
+	assert.NoError(t, err)
+	assert.Equal(t, latestSchemaVersion()-2, done[2].Version)
+	assert.False(t, conn.Migrator().HasColumn(&LogEntry{}, "Fields"))
+	assert.False(t, conn.Migrator().HasIndex(&LogEntry{}, "Timestamp"))
+	assert.True(t, conn.Migrator().HasTable(&APIKey{}))
+	assert.ErrorContains(t, checkSchemaCurrent(conn), "3 pending migration(s)")
+
+	_, err = migrateUp(conn)
+	assert.NoError(t, err)
+	assert.NoError(t, checkSchemaCurrent(conn))
+}
+
+func TestMigrationBackfillsSlugs(t *testing.T) {
+	t.Parallel()
+	conn := newTestDB(t)
+
+	// База в состоянии до миграции 2: книги без slug
+	assert.NoError(t, conn.Transaction(migrations[0].Up))
+	conn.AutoMigrate(&SchemaMigration{})
+	conn.Create(&SchemaMigration{Version: 1, Name: migrations[0].Name})
+	conn.Exec("INSERT INTO books (title, author) VALUES (?, ?)", "Old Book", "Old Author")
+
+	_, err := migrateUp(conn)
+	assert.NoError(t, err)
+
+	var book Book
+	conn.First(&book)
+	assert.Equal(t, "old-book-old-author", book.Slug)
+	assert.Equal(t, 0, book.Stock)
+}
+
+func TestMigrationMarksEmailLinkedIdentities(t *testing.T) {
+	t.Parallel()
+	conn := newTestDB(t)
+
+	// База в состоянии до миграции 15: способ привязки не сохранялся
+	conn.AutoMigrate(&SchemaMigration{})
+	for _, m := range migrations[:14] {
+		assert.NoError(t, conn.Transaction(m.Up))
+		conn.Create(&SchemaMigration{Version: m.Version, Name: m.Name})
+	}
+	now := time.Now()
+	conn.Exec("INSERT INTO users (id, email, created_at) VALUES (1, ?, ?), (2, ?, ?)", "old@example.com", now.Add(-time.Hour), "new@example.com", now)
+	conn.Exec("INSERT INTO user_identities (user_id, provider, subject, created_at) VALUES (1, 'google', '1', ?), (2, 'google', '2', ?)", now, now)
+
+	_, err := migrateUp(conn)
+	assert.NoError(t, err)
+
+	var linked []bool
+	conn.Table("user_identities").Order("user_id").Pluck("linked_by_email", &linked)
+	assert.Equal(t, []bool{true, false}, linked, "Only the account that existed before the identity was linked by email")
+}
+
+func TestRunMigrateCommandRejectsUnknown(t *testing.T) {
+	t.Parallel()
+	conn := newTestDB(t)
+	assert.Error(t, runMigrateCommand(conn, nil))
+	assert.Error(t, runMigrateCommand(conn, []string{"sideways"}))
+	assert.Error(t, runMigrateCommand(conn, []string{"down", "zero"}))
+}
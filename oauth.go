@@ -0,0 +1,505 @@
+package main
+
+import (
+	"context"
+	"crypto/subtle"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/url"
+	"slices"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/coreos/go-oidc/v3/oidc"
+	"github.com/sirupsen/logrus"
+	"golang.org/x/oauth2"
+)
+
+// Вход через внешних OpenID Connect провайдеров. Адреса авторизации, токенов и
+// ключей берутся из discovery-документа издателя, поэтому в тестах вместо
+// Google можно подставить поддельный провайдер.
+
+// UserIdentity связывает пользователя с учётной записью у провайдера
+type UserIdentity struct {
+	ID       uint   `gorm:"primaryKey"`
+	UserID   uint   `gorm:"index"`
+	Provider string `gorm:"uniqueIndex:idx_identity"`
+	Subject  string `gorm:"uniqueIndex:idx_identity"`
+	Email    string
+	// LinkedByEmail — учётная запись уже была и привязана по email, а не
+	// создана этим провайдером или привязана владельцем (см. applyOIDCRole)
+	LinkedByEmail bool
+	CreatedAt     time.Time
+}
+
+type oidcProvider struct {
+	name        string
+	label       string
+	issuer      string
+	oauth       oauth2.Config
+	roleClaim   string
+	roleMapping []OIDCRoleMapping
+	defaultRole string
+	trustEmail  bool
+
+	mu       sync.Mutex
+	ready    bool
+	verifier *oidc.IDTokenVerifier
+}
+
+func newOIDCProvider(cfg OIDCProviderConfig, baseURL string) *oidcProvider {
+	oauthCfg := oauth2.Config{
+		ClientID:     cfg.ClientID,
+		ClientSecret: cfg.ClientSecret.Value(),
+		RedirectURL:  cfg.RedirectURL,
+		Scopes:       cfg.Scopes,
+	}
+	if oauthCfg.RedirectURL == "" {
+		oauthCfg.RedirectURL = baseURL + "/auth/" + cfg.Name + "/callback"
+	}
+	if len(oauthCfg.Scopes) == 0 {
+		oauthCfg.Scopes = []string{oidc.ScopeOpenID, "email", "profile"}
+	}
+	label := cfg.Label
+	if label == "" {
+		label = cfg.Name
+	}
+	return &oidcProvider{
+		name:        cfg.Name,
+		label:       label,
+		issuer:      cfg.Issuer,
+		oauth:       oauthCfg,
+		roleClaim:   cfg.RoleClaim,
+		roleMapping: cfg.RoleMapping,
+		defaultRole: cfg.DefaultRole,
+		trustEmail:  cfg.TrustEmail,
+	}
+}
+
+func newGoogleProvider(cfg Config) *oidcProvider {
+	return newOIDCProvider(OIDCProviderConfig{
+		Name:         "google",
+		Label:        "Google",
+		Issuer:       cfg.Google.Issuer,
+		ClientID:     cfg.Google.ClientID,
+		ClientSecret: cfg.Google.ClientSecret,
+		RedirectURL:  cfg.Google.RedirectURL,
+		Scopes:       googleOauthConfig.Scopes,
+		TrustEmail:   cfg.Google.TrustEmail,
+	}, cfg.BaseURL())
+}
+
+// newOIDCProviders собирает Google и провайдеров из секции oidc
+func newOIDCProviders(cfg Config) map[string]*oidcProvider {
+	providers := map[string]*oidcProvider{}
+	if cfg.Google.ClientID != "" {
+		providers["google"] = newGoogleProvider(cfg)
+	}
+	for _, p := range cfg.OIDC {
+		providers[p.Name] = newOIDCProvider(p, cfg.BaseURL())
+	}
+	return providers
+}
+
+// role вычисляет роль по claim провайдера; false — если провайдер ролями не управляет
+func (p *oidcProvider) role(claims map[string]any) (string, bool) {
+	if p.roleClaim == "" {
+		return "", false
+	}
+	values := claimValues(claims, p.roleClaim)
+	for _, m := range p.roleMapping {
+		if slices.Contains(values, m.Value) {
+			return m.Role, true
+		}
+	}
+	if p.defaultRole != "" {
+		return p.defaultRole, true
+	}
+	return "user", true
+}
+
+// claimValues достаёт строку или список строк по пути вида realm_access.roles
+func claimValues(claims map[string]any, path string) []string {
+	var current any = claims
+	for _, part := range strings.Split(path, ".") {
+		m, ok := current.(map[string]any)
+		if !ok {
+			return nil
+		}
+		current = m[part]
+	}
+	switch v := current.(type) {
+	case string:
+		return []string{v}
+	case []any:
+		values := make([]string, 0, len(v))
+		for _, item := range v {
+			if s, ok := item.(string); ok {
+				values = append(values, s)
+			}
+		}
+		return values
+	}
+	return nil
+}
+
+type oidcProviderInfo struct {
+	Name     string `json:"name"`
+	Label    string `json:"label"`
+	LoginURL string `json:"login_url"`
+}
+
+// Список включённых провайдеров для кнопок входа
+func (s *Server) oauthProvidersHandler(w http.ResponseWriter, r *http.Request) {
+	providers := []oidcProviderInfo{}
+	for _, p := range s.oidc {
+		providers = append(providers, oidcProviderInfo{Name: p.name, Label: p.label, LoginURL: "/auth/" + p.name + "/login"})
+	}
+	sort.Slice(providers, func(i, j int) bool { return providers[i].Label < providers[j].Label })
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(providers)
+}
+
+// discover загружает discovery-документ при первом входе; при ошибке попробуем снова
+func (p *oidcProvider) discover(ctx context.Context) (oauth2.Config, *oidc.IDTokenVerifier, error) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if !p.ready {
+		provider, err := oidc.NewProvider(ctx, p.issuer)
+		if err != nil {
+			return oauth2.Config{}, nil, err
+		}
+		p.oauth.Endpoint = provider.Endpoint()
+		p.verifier = provider.Verifier(&oidc.Config{ClientID: p.oauth.ClientID})
+		p.ready = true
+	}
+	return p.oauth, p.verifier, nil
+}
+
+// Состояние входа (state, PKCE verifier, nonce) живёт в cookie до возврата от провайдера
+func oauthCookieName(provider string) string {
+	return "oauth_" + provider
+}
+
+func (s *Server) secureCookies(r *http.Request) bool {
+	return r.TLS != nil || strings.HasPrefix(s.config.SiteURL, "https://")
+}
+
+// Перенаправление на страницу входа провайдера
+func (s *Server) oauthLoginHandler(w http.ResponseWriter, r *http.Request) {
+	p, ok := s.oidc[r.PathValue("provider")]
+	if !ok {
+		http.NotFound(w, r)
+		return
+	}
+	authURL, ok := s.startOIDCFlow(w, r, p, "")
+	if ok {
+		http.Redirect(w, r, authURL, http.StatusFound)
+	}
+}
+
+// Связывание по запросу владельца: POST /api/auth/{provider}/link возвращает
+// адрес провайдера, а callback привязывает его учётную запись к пользователю
+// cookie-сессии, начавшему связывание. Так подключают провайдеров, которым не
+// доверено связывание по email
+func (s *Server) oauthLinkHandler(w http.ResponseWriter, r *http.Request) {
+	p, ok := s.oidc[r.PathValue("provider")]
+	if !ok {
+		http.NotFound(w, r)
+		return
+	}
+	// Callback — переход браузера, он узнаёт владельца только по cookie сессии
+	if !authenticatedByCookie(r) {
+		http.Error(w, "Linking requires a browser session", http.StatusBadRequest)
+		return
+	}
+	claims := r.Context().Value("user").(*Claims)
+	authURL, ok := s.startOIDCFlow(w, r, p, claims.Subject)
+	if ok {
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(map[string]string{"url": authURL})
+	}
+}
+
+// startOIDCFlow сохраняет state, PKCE verifier, nonce и (для связывания) ID
+// владельца в cookie и возвращает адрес страницы входа провайдера
+func (s *Server) startOIDCFlow(w http.ResponseWriter, r *http.Request, p *oidcProvider, owner string) (string, bool) {
+	oauthCfg, _, err := p.discover(r.Context())
+	if err != nil {
+		s.log(r.Context()).WithError(err).WithField("provider", p.name).Error("OIDC discovery failed")
+		http.Error(w, "Identity provider is unavailable", http.StatusBadGateway)
+		return "", false
+	}
+
+	state, err := randomToken(16)
+	if err != nil {
+		http.Error(w, "Failed to start login", http.StatusInternalServerError)
+		return "", false
+	}
+	nonce, err := randomToken(16)
+	if err != nil {
+		http.Error(w, "Failed to start login", http.StatusInternalServerError)
+		return "", false
+	}
+	verifier := oauth2.GenerateVerifier()
+
+	value := state + "." + verifier + "." + nonce
+	if owner != "" {
+		value += "." + owner
+	}
+	http.SetCookie(w, &http.Cookie{
+		Name:     oauthCookieName(p.name),
+		Value:    value,
+		Path:     "/auth/" + p.name + "/",
+		MaxAge:   600,
+		HttpOnly: true,
+		Secure:   s.secureCookies(r),
+		SameSite: http.SameSiteLaxMode,
+	})
+	return oauthCfg.AuthCodeURL(state, oauth2.S256ChallengeOption(verifier), oidc.Nonce(nonce)), true
+}
+
+// Возврат от провайдера: проверка state, обмен кода с PKCE, проверка ID-токена
+func (s *Server) oauthCallbackHandler(w http.ResponseWriter, r *http.Request) {
+	p, ok := s.oidc[r.PathValue("provider")]
+	if !ok {
+		http.NotFound(w, r)
+		return
+	}
+
+	cookie, err := r.Cookie(oauthCookieName(p.name))
+	http.SetCookie(w, &http.Cookie{Name: oauthCookieName(p.name), Path: "/auth/" + p.name + "/", MaxAge: -1})
+	if err != nil {
+		http.Error(w, "Login session expired, please try again", http.StatusBadRequest)
+		return
+	}
+	parts := strings.Split(cookie.Value, ".")
+	query := r.URL.Query()
+	if (len(parts) != 3 && len(parts) != 4) || subtle.ConstantTimeCompare([]byte(parts[0]), []byte(query.Get("state"))) != 1 {
+		http.Error(w, "Invalid OAuth state", http.StatusBadRequest)
+		return
+	}
+	verifier, nonce := parts[1], parts[2]
+
+	if msg := query.Get("error"); msg != "" {
+		http.Error(w, "Login was cancelled: "+msg, http.StatusUnauthorized)
+		return
+	}
+
+	oauthCfg, idVerifier, err := p.discover(r.Context())
+	if err != nil {
+		s.log(r.Context()).WithError(err).WithField("provider", p.name).Error("OIDC discovery failed")
+		http.Error(w, "Identity provider is unavailable", http.StatusBadGateway)
+		return
+	}
+	token, err := oauthCfg.Exchange(r.Context(), query.Get("code"), oauth2.VerifierOption(verifier))
+	if err != nil {
+		s.log(r.Context()).WithError(err).WithField("provider", p.name).Warn("OAuth code exchange failed")
+		http.Error(w, "Unauthorized: code exchange failed", http.StatusUnauthorized)
+		return
+	}
+	rawIDToken, ok := token.Extra("id_token").(string)
+	if !ok {
+		http.Error(w, "Unauthorized: no ID token", http.StatusUnauthorized)
+		return
+	}
+	idToken, err := idVerifier.Verify(r.Context(), rawIDToken)
+	if err != nil || subtle.ConstantTimeCompare([]byte(idToken.Nonce), []byte(nonce)) != 1 {
+		http.Error(w, "Unauthorized: invalid ID token", http.StatusUnauthorized)
+		return
+	}
+
+	var claims oidcClaims
+	var raw map[string]any
+	if err := idToken.Claims(&claims); err != nil || idToken.Claims(&raw) != nil {
+		http.Error(w, "Unauthorized: invalid ID token", http.StatusUnauthorized)
+		return
+	}
+	if len(parts) == 4 {
+		s.finishOIDCLink(w, r, p, parts[3], idToken.Subject, claims)
+		return
+	}
+	user, identity, err := s.linkOIDCUser(r.Context(), p, idToken.Subject, claims)
+	if err == nil {
+		user, err = s.applyOIDCRole(r.Context(), p, user, identity, raw)
+	}
+	if errors.Is(err, errEmailNotVerified) {
+		http.Error(w, "Email is not verified by the identity provider", http.StatusForbidden)
+		return
+	}
+	if errors.Is(err, errAccountExists) {
+		http.Error(w, "An account with this email already exists. Sign in and link "+p.label+" on your profile page", http.StatusConflict)
+		return
+	}
+	if err != nil {
+		s.log(r.Context()).WithError(err).WithField("provider", p.name).Error("Failed to link OIDC user")
+		http.Error(w, "Failed to log in", http.StatusInternalServerError)
+		return
+	}
+
+	// Вход через провайдера не заменяет второй фактор
+	twoFactor, err := s.twoFactorEnabled(r.Context(), user.ID)
+	if err != nil {
+		http.Error(w, "Failed to load two-factor settings", http.StatusInternalServerError)
+		return
+	}
+	if twoFactor {
+		challenge, err := s.startTwoFactorLogin(r.Context(), user)
+		if err != nil {
+			http.Error(w, "Failed to start two-factor login", http.StatusInternalServerError)
+			return
+		}
+		fragment := url.Values{"two_factor_token": {challenge.TwoFactorToken}}
+		http.Redirect(w, r, "/signin.html#"+fragment.Encode(), http.StatusFound)
+		return
+	}
+
+	// Страница получает HttpOnly cookie, а не токены: скрипты их не увидят
+	if err := s.startCookieSession(w, r, user, false); err != nil {
+		http.Error(w, "Failed to start session", http.StatusInternalServerError)
+		return
+	}
+	s.log(r.Context()).WithFields(logrus.Fields{
+		"action":   "oidc_login",
+		"provider": p.name,
+		"user_id":  user.ID,
+		"role":     user.Role,
+	}).Info("User logged in")
+
+	http.Redirect(w, r, "/me.html#session=cookie", http.StatusFound)
+}
+
+type oidcClaims struct {
+	Email         string `json:"email"`
+	EmailVerified bool   `json:"email_verified"`
+	Name          string `json:"name"`
+}
+
+var (
+	errEmailNotVerified = errors.New("email not verified by provider")
+	errAccountExists    = errors.New("account with this email exists and the provider is not trusted to link it")
+)
+
+// linkOIDCUser находит пользователя по учётной записи провайдера, иначе по
+// подтверждённому email (только у провайдеров с trust_email), иначе создаёт нового
+func (s *Server) linkOIDCUser(ctx context.Context, p *oidcProvider, subject string, claims oidcClaims) (User, UserIdentity, error) {
+	identity, err := s.users.GetIdentity(ctx, p.name, subject)
+	if err == nil {
+		user, err := s.users.Get(ctx, identity.UserID)
+		return user, identity, err
+	}
+	if !errors.Is(err, ErrNotFound) {
+		return User{}, UserIdentity{}, err
+	}
+	if claims.Email == "" || !claims.EmailVerified {
+		return User{}, UserIdentity{}, errEmailNotVerified
+	}
+
+	identity = UserIdentity{
+		Provider:  p.name,
+		Subject:   subject,
+		Email:     claims.Email,
+		CreatedAt: time.Now(),
+	}
+	user, err := s.users.GetByEmail(ctx, claims.Email)
+	switch {
+	case err == nil && !p.trustEmail:
+		// email_verified любого IdP не доказывает, что это владелец учётной
+		// записи: связать её может только он сам через oauthLinkHandler
+		return User{}, UserIdentity{}, errAccountExists
+	case err == nil && !user.Confirmed:
+		// Неподтверждённую регистрацию мог сделать кто угодно: почту подтвердил
+		// провайдер, а пароль того, кто регистрировался, больше не действует
+		user.Confirmed = true
+		user.PasswordHash = ""
+		if err := s.users.Save(ctx, &user); err != nil {
+			return User{}, UserIdentity{}, err
+		}
+		identity.LinkedByEmail = true
+	case err == nil:
+		identity.LinkedByEmail = true
+	case errors.Is(err, ErrNotFound):
+		name := claims.Name
+		if name == "" {
+			name = claims.Email
+		}
+		user = User{
+			Name:      name,
+			Email:     claims.Email,
+			Role:      "user",
+			Confirmed: true,
+			CreatedAt: time.Now(),
+		}
+		if err := s.users.Create(ctx, &user); err != nil {
+			return User{}, UserIdentity{}, err
+		}
+	default:
+		return User{}, UserIdentity{}, err
+	}
+
+	identity.UserID = user.ID
+	err = s.users.CreateIdentity(ctx, &identity)
+	return user, identity, err
+}
+
+// finishOIDCLink привязывает учётную запись провайдера к владельцу, начавшему
+// связывание; он должен быть всё ещё вошедшим в этом браузере
+func (s *Server) finishOIDCLink(w http.ResponseWriter, r *http.Request, p *oidcProvider, owner, subject string, claims oidcClaims) {
+	session, err := s.cookieSessionClaims(w, r)
+	if err != nil {
+		http.Error(w, "Failed to load session", http.StatusInternalServerError)
+		return
+	}
+	if session == nil || session.Subject != owner {
+		http.Error(w, "Sign in again to link the account", http.StatusUnauthorized)
+		return
+	}
+	userID := claimsUserID(session)
+
+	existing, err := s.users.GetIdentity(r.Context(), p.name, subject)
+	switch {
+	case err == nil && existing.UserID != userID:
+		http.Error(w, "This "+p.label+" account is already linked to another user", http.StatusConflict)
+		return
+	case errors.Is(err, ErrNotFound):
+		err = s.users.CreateIdentity(r.Context(), &UserIdentity{
+			UserID:    userID,
+			Provider:  p.name,
+			Subject:   subject,
+			Email:     claims.Email,
+			CreatedAt: time.Now(),
+		})
+	}
+	if err != nil {
+		s.log(r.Context()).WithError(err).WithField("provider", p.name).Error("Failed to link OIDC identity")
+		http.Error(w, "Failed to link the account", http.StatusInternalServerError)
+		return
+	}
+	s.log(r.Context()).WithFields(logrus.Fields{
+		"action":   "oidc_link",
+		"provider": p.name,
+		"user_id":  userID,
+	}).Info("Identity provider account linked")
+
+	http.Redirect(w, r, "/me.html#linked="+url.QueryEscape(p.name), http.StatusFound)
+}
+
+// applyOIDCRole синхронизирует роль с провайдером при каждом входе: IdP — источник правды.
+// Роль учётной записи, привязанной по email, ведётся локально (set-role), и IdP её не меняет
+func (s *Server) applyOIDCRole(ctx context.Context, p *oidcProvider, user User, identity UserIdentity, claims map[string]any) (User, error) {
+	role, ok := p.role(claims)
+	if !ok || role == user.Role || identity.LinkedByEmail {
+		return user, nil
+	}
+	s.log(ctx).WithFields(logrus.Fields{
+		"provider": p.name,
+		"user_id":  user.ID,
+		"from":     user.Role,
+		"to":       role,
+	}).Info("User role updated from identity provider")
+	user.Role = role
+	return user, s.users.Save(ctx, &user)
+}
@@ -0,0 +1,437 @@
+package main
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/golang-jwt/jwt/v4"
+	"github.com/stretchr/testify/assert"
+)
+
+// fakeOIDCProvider — минимальный OpenID Connect провайдер для тестов без сети:
+// discovery, /authorize (сразу «входит» пользователем user), /token с проверкой PKCE и JWKS
+type fakeOIDCProvider struct {
+	*httptest.Server
+	key      loadedKey
+	clientID string
+
+	mu    sync.Mutex
+	user  jwt.MapClaims
+	codes map[string]fakeAuthCode
+}
+
+type fakeAuthCode struct {
+	challenge string
+	nonce     string
+	user      jwt.MapClaims
+}
+
+func newFakeOIDCProvider(t *testing.T, clientID string) *fakeOIDCProvider {
+	t.Helper()
+	rsaKey, err := rsa.GenerateKey(rand.Reader, 2048)
+	assert.NoError(t, err)
+	p := &fakeOIDCProvider{
+		key:      loadedKey{id: "fake-key", algorithm: "RS256", signer: rsaKey},
+		clientID: clientID,
+		codes:    map[string]fakeAuthCode{},
+	}
+
+	mux := http.NewServeMux()
+	mux.HandleFunc("/.well-known/openid-configuration", func(w http.ResponseWriter, r *http.Request) {
+		json.NewEncoder(w).Encode(map[string]any{
+			"issuer":                                p.URL,
+			"authorization_endpoint":                p.URL + "/authorize",
+			"token_endpoint":                        p.URL + "/token",
+			"jwks_uri":                              p.URL + "/jwks",
+			"id_token_signing_alg_values_supported": []string{"RS256"},
+		})
+	})
+	mux.HandleFunc("/jwks", func(w http.ResponseWriter, r *http.Request) {
+		k, _ := publicJWK(p.key)
+		json.NewEncoder(w).Encode(map[string][]jwk{"keys": {k}})
+	})
+	mux.HandleFunc("/authorize", p.authorize)
+	mux.HandleFunc("/token", p.token)
+	p.Server = httptest.NewServer(mux)
+	t.Cleanup(p.Close)
+	return p
+}
+
+// signIn задаёт, каким пользователем провайдер «войдёт» при следующем /authorize
+func (p *fakeOIDCProvider) signIn(claims jwt.MapClaims) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.user = claims
+}
+
+func (p *fakeOIDCProvider) authorize(w http.ResponseWriter, r *http.Request) {
+	q := r.URL.Query()
+	if q.Get("client_id") != p.clientID || q.Get("code_challenge_method") != "S256" || q.Get("code_challenge") == "" {
+		http.Error(w, "invalid authorization request", http.StatusBadRequest)
+		return
+	}
+	code, _ := randomToken(8)
+	p.mu.Lock()
+	p.codes[code] = fakeAuthCode{challenge: q.Get("code_challenge"), nonce: q.Get("nonce"), user: p.user}
+	p.mu.Unlock()
+
+	back, _ := url.Parse(q.Get("redirect_uri"))
+	back.RawQuery = url.Values{"code": {code}, "state": {q.Get("state")}}.Encode()
+	http.Redirect(w, r, back.String(), http.StatusFound)
+}
+
+func (p *fakeOIDCProvider) token(w http.ResponseWriter, r *http.Request) {
+	r.ParseForm()
+	p.mu.Lock()
+	grant, ok := p.codes[r.PostForm.Get("code")]
+	delete(p.codes, r.PostForm.Get("code"))
+	p.mu.Unlock()
+
+	sum := sha256.Sum256([]byte(r.PostForm.Get("code_verifier")))
+	if !ok || base64.RawURLEncoding.EncodeToString(sum[:]) != grant.challenge {
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusBadRequest)
+		json.NewEncoder(w).Encode(map[string]string{"error": "invalid_grant"})
+		return
+	}
+
+	claims := jwt.MapClaims{
+		"iss":   p.URL,
+		"aud":   p.clientID,
+		"iat":   time.Now().Unix(),
+		"exp":   time.Now().Add(time.Hour).Unix(),
+		"nonce": grant.nonce,
+	}
+	for k, v := range grant.user {
+		claims[k] = v
+	}
+	idToken := jwt.NewWithClaims(jwt.SigningMethodRS256, claims)
+	idToken.Header["kid"] = p.key.id
+	signed, _ := idToken.SignedString(p.key.signer)
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]any{
+		"access_token": "fake-access-token",
+		"token_type":   "Bearer",
+		"expires_in":   3600,
+		"id_token":     signed,
+	})
+}
+
+func newOAuthTestServer(t *testing.T) (*Server, *fakeOIDCProvider) {
+	t.Helper()
+	provider := newFakeOIDCProvider(t, "bookstore-client")
+	cfg := testConfig()
+	cfg.SiteURL = "http://bookstore.test"
+	cfg.Google = GoogleConfig{ClientID: "bookstore-client", ClientSecret: "client-secret", Issuer: provider.URL}
+	return newConfigTestServer(t, cfg, newMemoryTokenRepository()), provider
+}
+
+// oauthLogin проходит весь вход: /auth/google/login → провайдер → callback
+func oauthLogin(t *testing.T, s *Server, provider *fakeOIDCProvider, tamper func(callback *http.Request)) *httptest.ResponseRecorder {
+	t.Helper()
+	return oidcLogin(t, s, "google", provider, tamper)
+}
+
+func oidcLogin(t *testing.T, s *Server, name string, provider *fakeOIDCProvider, tamper func(callback *http.Request)) *httptest.ResponseRecorder {
+	t.Helper()
+	handler := s.routes()
+
+	req, _ := http.NewRequest("GET", "/auth/"+name+"/login", nil)
+	rr := httptest.NewRecorder()
+	handler.ServeHTTP(rr, req)
+	assert.Equal(t, http.StatusFound, rr.Code, "Expected redirect to the provider")
+	authURL := rr.Header().Get("Location")
+	assert.True(t, strings.HasPrefix(authURL, provider.URL+"/authorize?"), authURL)
+	cookies := rr.Result().Cookies()
+
+	req, _ = http.NewRequest("GET", providerCallback(t, name, authURL), nil)
+	for _, c := range cookies {
+		req.AddCookie(c)
+	}
+	if tamper != nil {
+		tamper(req)
+	}
+	rr = httptest.NewRecorder()
+	handler.ServeHTTP(rr, req)
+	return rr
+}
+
+// providerCallback «входит» у провайдера и возвращает адрес callback с кодом
+func providerCallback(t *testing.T, name, authURL string) string {
+	t.Helper()
+	client := &http.Client{CheckRedirect: func(*http.Request, []*http.Request) error { return http.ErrUseLastResponse }}
+	resp, err := client.Get(authURL)
+	assert.NoError(t, err)
+	resp.Body.Close()
+	callback, _ := url.Parse(resp.Header.Get("Location"))
+	assert.Equal(t, "/auth/"+name+"/callback", callback.Path)
+	return callback.RequestURI()
+}
+
+// oidcLink проходит привязку из личного кабинета: POST /api/auth/{name}/link →
+// провайдер → callback, куда браузер приносит cookie сессии callbackSession
+func oidcLink(t *testing.T, s *Server, name string, session, callbackSession *http.Cookie) *httptest.ResponseRecorder {
+	t.Helper()
+	handler := s.routes()
+
+	rr := cookieRequest(handler, "POST", "/api/auth/"+name+"/link", session)
+	assert.Equal(t, http.StatusOK, rr.Code, rr.Body.String())
+	var body struct {
+		URL string `json:"url"`
+	}
+	assert.NoError(t, json.NewDecoder(rr.Body).Decode(&body))
+
+	req, _ := http.NewRequest("GET", providerCallback(t, name, body.URL), nil)
+	for _, c := range rr.Result().Cookies() {
+		req.AddCookie(c)
+	}
+	if callbackSession != nil {
+		req.AddCookie(callbackSession)
+	}
+	rr = httptest.NewRecorder()
+	handler.ServeHTTP(rr, req)
+	return rr
+}
+
+// oauthSession возвращает claims сессии, которую callback выдал браузеру в cookie
+func oauthSession(t *testing.T, s *Server, rr *httptest.ResponseRecorder) *Claims {
+	t.Helper()
+	location, _ := url.Parse(rr.Header().Get("Location"))
+	assert.Equal(t, "/me.html", location.Path)
+	assert.Equal(t, "session=cookie", location.Fragment, "No tokens in the URL")
+
+	req, _ := http.NewRequest("GET", "/api/profile", nil)
+	for _, c := range rr.Result().Cookies() {
+		if c.Name == s.config.Session.CookieName {
+			assert.True(t, c.HttpOnly)
+			req.AddCookie(c)
+		}
+	}
+	claims, err := s.cookieSessionClaims(httptest.NewRecorder(), req)
+	assert.NoError(t, err)
+	if !assert.NotNil(t, claims, "Expected a cookie session") {
+		return &Claims{}
+	}
+	return claims
+}
+
+func TestGoogleLoginCreatesUser(t *testing.T) {
+	t.Parallel()
+	s, provider := newOAuthTestServer(t)
+	provider.signIn(jwt.MapClaims{"sub": "google-1", "email": "reader@gmail.com", "email_verified": true, "name": "Reader"})
+
+	rr := oauthLogin(t, s, provider, nil)
+	assert.Equal(t, http.StatusFound, rr.Code, rr.Body.String())
+	assert.Equal(t, "reader@gmail.com", oauthSession(t, s, rr).Email)
+
+	user, err := s.users.GetByEmail(context.Background(), "reader@gmail.com")
+	assert.NoError(t, err)
+	assert.True(t, user.Confirmed)
+	assert.Equal(t, "user", user.Role)
+
+	// Повторный вход находит ту же учётную запись по sub, даже если email сменился
+	provider.signIn(jwt.MapClaims{"sub": "google-1", "email": "renamed@gmail.com", "email_verified": true})
+	rr = oauthLogin(t, s, provider, nil)
+	assert.Equal(t, "reader@gmail.com", oauthSession(t, s, rr).Email)
+}
+
+func TestGoogleLoginLinksUnconfirmedAccount(t *testing.T) {
+	t.Parallel()
+	s, provider := newOAuthTestServer(t)
+	s.oidc["google"].trustEmail = true
+	ctx := context.Background()
+	existing := User{Email: "victim@gmail.com", PasswordHash: "attacker-chosen", Role: "user"}
+	assert.NoError(t, s.users.Create(ctx, &existing))
+
+	provider.signIn(jwt.MapClaims{"sub": "google-2", "email": "victim@gmail.com", "email_verified": true})
+	rr := oauthLogin(t, s, provider, nil)
+	assert.Equal(t, http.StatusFound, rr.Code, rr.Body.String())
+
+	user, _ := s.users.Get(ctx, existing.ID)
+	assert.True(t, user.Confirmed)
+	assert.Empty(t, user.PasswordHash, "Password of an unconfirmed registration must not survive linking")
+}
+
+func TestGoogleLoginRejections(t *testing.T) {
+	t.Parallel()
+	s, provider := newOAuthTestServer(t)
+
+	provider.signIn(jwt.MapClaims{"sub": "google-3", "email": "unverified@example.com", "email_verified": false})
+	rr := oauthLogin(t, s, provider, nil)
+	assert.Equal(t, http.StatusForbidden, rr.Code, "Unverified email must not log in")
+
+	provider.signIn(jwt.MapClaims{"sub": "google-4", "email": "a@gmail.com", "email_verified": true})
+	rr = oauthLogin(t, s, provider, func(req *http.Request) {
+		q := req.URL.Query()
+		q.Set("state", "forged")
+		req.URL.RawQuery = q.Encode()
+	})
+	assert.Equal(t, http.StatusBadRequest, rr.Code, "State mismatch must be rejected")
+
+	rr = oauthLogin(t, s, provider, func(req *http.Request) {
+		cookie, _ := req.Cookie(oauthCookieName("google"))
+		parts := strings.Split(cookie.Value, ".")
+		req.Header.Del("Cookie")
+		req.AddCookie(&http.Cookie{Name: cookie.Name, Value: parts[0] + ".wrong-verifier." + parts[2]})
+	})
+	assert.Equal(t, http.StatusUnauthorized, rr.Code, "Wrong PKCE verifier must fail the code exchange")
+
+	req, _ := http.NewRequest("GET", "/auth/github/login", nil)
+	rr = httptest.NewRecorder()
+	s.routes().ServeHTTP(rr, req)
+	assert.Equal(t, http.StatusNotFound, rr.Code, "Unknown provider")
+}
+
+func TestOIDCProviderRoleMapping(t *testing.T) {
+	t.Parallel()
+	google := newFakeOIDCProvider(t, "bookstore-client")
+	staff := newFakeOIDCProvider(t, "staff-client")
+	cfg := testConfig()
+	cfg.SiteURL = "http://bookstore.test"
+	cfg.Google = GoogleConfig{ClientID: "bookstore-client", ClientSecret: "client-secret", Issuer: google.URL}
+	cfg.OIDC = []OIDCProviderConfig{{
+		Name:      "company",
+		Label:     "Company SSO",
+		Issuer:    staff.URL,
+		ClientID:  "staff-client",
+		RoleClaim: "realm_access.roles",
+		RoleMapping: []OIDCRoleMapping{
+			{Value: "bookstore-admins", Role: "admin"},
+			{Value: "bookstore-staff", Role: "staff"},
+		},
+		DefaultRole: "user",
+	}}
+	s := newConfigTestServer(t, cfg, newMemoryTokenRepository())
+
+	// Оба провайдера включены одновременно, у каждого своя кнопка
+	req, _ := http.NewRequest("GET", "/auth/providers", nil)
+	rr := httptest.NewRecorder()
+	s.routes().ServeHTTP(rr, req)
+	var providers []oidcProviderInfo
+	assert.NoError(t, json.NewDecoder(rr.Body).Decode(&providers))
+	assert.Equal(t, []oidcProviderInfo{
+		{Name: "company", Label: "Company SSO", LoginURL: "/auth/company/login"},
+		{Name: "google", Label: "Google", LoginURL: "/auth/google/login"},
+	}, providers)
+
+	login := func(roles ...string) *Claims {
+		staff.signIn(jwt.MapClaims{
+			"sub":            "staff-1",
+			"email":          "clerk@company.example",
+			"email_verified": true,
+			"realm_access":   map[string]any{"roles": roles},
+		})
+		rr := oidcLogin(t, s, "company", staff, nil)
+		assert.Equal(t, http.StatusFound, rr.Code, rr.Body.String())
+		return oauthSession(t, s, rr)
+	}
+
+	assert.Equal(t, "admin", login("offline_access", "bookstore-admins", "bookstore-staff").Role)
+	// Роль пересчитывается при каждом входе
+	assert.Equal(t, "staff", login("bookstore-staff").Role)
+	assert.Equal(t, "user", login().Role)
+
+	// Провайдер без role_claim роль не меняет
+	google.signIn(jwt.MapClaims{"sub": "g-1", "email": "reader@gmail.com", "email_verified": true})
+	assert.Equal(t, http.StatusFound, oauthLogin(t, s, google, nil).Code)
+	user, _ := s.users.GetByEmail(context.Background(), "reader@gmail.com")
+	assert.Equal(t, "user", user.Role)
+}
+
+func TestOIDCLoginDoesNotLinkByEmailUnlessTrusted(t *testing.T) {
+	t.Parallel()
+	s, provider := newOAuthTestServer(t)
+	ctx := context.Background()
+	confirmed := User{Email: "owner@example.com", PasswordHash: "hash", Role: "admin", Confirmed: true}
+	unconfirmed := User{Email: "pending@example.com", PasswordHash: "hash", Role: "user"}
+	assert.NoError(t, s.users.Create(ctx, &confirmed))
+	assert.NoError(t, s.users.Create(ctx, &unconfirmed))
+
+	for i, user := range []User{confirmed, unconfirmed} {
+		provider.signIn(jwt.MapClaims{"sub": fmt.Sprintf("partner-%d", i), "email": user.Email, "email_verified": true})
+		rr := oauthLogin(t, s, provider, nil)
+		assert.Equal(t, http.StatusConflict, rr.Code, "An untrusted provider must not take over %s", user.Email)
+		stored, _ := s.users.Get(ctx, user.ID)
+		assert.Equal(t, user.PasswordHash, stored.PasswordHash)
+		identities, _ := s.users.ListIdentities(ctx, user.ID)
+		assert.Empty(t, identities)
+	}
+}
+
+func TestOIDCRoleOfEmailLinkedAccountStaysLocal(t *testing.T) {
+	t.Parallel()
+	staff := newFakeOIDCProvider(t, "staff-client")
+	cfg := testConfig()
+	cfg.SiteURL = "http://bookstore.test"
+	cfg.OIDC = []OIDCProviderConfig{{
+		Name:        "company",
+		Issuer:      staff.URL,
+		ClientID:    "staff-client",
+		RoleClaim:   "groups",
+		RoleMapping: []OIDCRoleMapping{{Value: "bookstore-admins", Role: "admin"}},
+		TrustEmail:  true,
+	}}
+	s := newConfigTestServer(t, cfg, newMemoryTokenRepository())
+	ctx := context.Background()
+	existing := User{Email: "clerk@company.example", Role: "user", Confirmed: true, CreatedAt: time.Now()}
+	assert.NoError(t, s.users.Create(ctx, &existing))
+
+	staff.signIn(jwt.MapClaims{"sub": "staff-9", "email": existing.Email, "email_verified": true, "groups": []string{"bookstore-admins"}})
+	rr := oidcLogin(t, s, "company", staff, nil)
+	assert.Equal(t, http.StatusFound, rr.Code, rr.Body.String())
+	assert.Equal(t, "user", oauthSession(t, s, rr).Role, "The IdP claim must not raise the role of an account linked by email")
+
+	identity, err := s.users.GetIdentity(ctx, "company", "staff-9")
+	assert.NoError(t, err)
+	assert.True(t, identity.LinkedByEmail)
+	user, _ := s.users.Get(ctx, existing.ID)
+	assert.Equal(t, "user", user.Role)
+}
+
+func TestOIDCLinkByOwner(t *testing.T) {
+	t.Parallel()
+	s, provider := newOAuthTestServer(t)
+	handler := s.routes()
+	session := loginWithCookie(t, s, handler)
+	owner, _ := s.users.GetByEmail(context.Background(), "reader@example.com")
+
+	provider.signIn(jwt.MapClaims{"sub": "google-owner", "email": "reader.personal@gmail.com", "email_verified": true})
+	rr := oidcLink(t, s, "google", session, session)
+	assert.Equal(t, http.StatusFound, rr.Code, rr.Body.String())
+	assert.Equal(t, "/me.html#linked=google", rr.Header().Get("Location"))
+
+	// Дальше вход через провайдера приводит в ту же учётную запись
+	rr = oauthLogin(t, s, provider, nil)
+	assert.Equal(t, strconv.FormatUint(uint64(owner.ID), 10), oauthSession(t, s, rr).Subject)
+
+	// Без cookie владельца на callback привязка не происходит
+	provider.signIn(jwt.MapClaims{"sub": "google-other", "email": "someone@gmail.com", "email_verified": true})
+	rr = oidcLink(t, s, "google", session, nil)
+	assert.Equal(t, http.StatusUnauthorized, rr.Code)
+	_, err := s.users.GetIdentity(context.Background(), "google", "google-other")
+	assert.ErrorIs(t, err, ErrNotFound)
+
+	// Учётная запись провайдера, уже привязанная к другому пользователю, не переезжает
+	other := User{Email: "other@example.com", Role: "user", Confirmed: true}
+	assert.NoError(t, s.users.Create(context.Background(), &other))
+	assert.NoError(t, s.users.CreateIdentity(context.Background(), &UserIdentity{UserID: other.ID, Provider: "google", Subject: "google-taken"}))
+	provider.signIn(jwt.MapClaims{"sub": "google-taken", "email": "taken@gmail.com", "email_verified": true})
+	assert.Equal(t, http.StatusConflict, oidcLink(t, s, "google", session, session).Code)
+
+	// Привязку начинает только браузер с cookie сессией
+	assert.Equal(t, http.StatusBadRequest, postJSON(handler, "/api/auth/google/link", adminAccessToken(t, s), nil).Code)
+}
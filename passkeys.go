@@ -0,0 +1,370 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/go-webauthn/webauthn/protocol"
+	"github.com/go-webauthn/webauthn/webauthn"
+	"github.com/sirupsen/logrus"
+)
+
+// Вход по passkey (WebAuthn) вместо пароля. Ключи создаются в личном кабинете,
+// вход — без email: аутентификатор сам предлагает подходящий ключ.
+
+// Passkey — открытый ключ и счётчик подписей одного аутентификатора пользователя
+type Passkey struct {
+	ID              uint       `gorm:"primaryKey" json:"id"`
+	UserID          uint       `gorm:"index" json:"-"`
+	CredentialID    []byte     `gorm:"uniqueIndex" json:"-"`
+	PublicKey       []byte     `json:"-"`
+	AttestationType string     `json:"-"`
+	AAGUID          []byte     `json:"-"`
+	SignCount       uint32     `json:"-"`
+	Transports      string     `json:"-"` // через запятую: usb,nfc,internal...
+	BackupEligible  bool       `json:"-"`
+	BackupState     bool       `json:"backed_up"`
+	Name            string     `json:"name"`
+	CreatedAt       time.Time  `json:"created_at"`
+	LastUsedAt      *time.Time `json:"last_used_at"`
+}
+
+// WebAuthnSession — данные незавершённой церемонии (challenge), живут несколько минут
+type WebAuthnSession struct {
+	ID        string `gorm:"primaryKey"`
+	UserID    uint
+	Data      []byte
+	ExpiresAt time.Time `gorm:"index"`
+}
+
+const webauthnCeremonyTTL = 5 * time.Minute
+
+// webauthnUser связывает User и его passkeys с интерфейсом webauthn.User
+type webauthnUser struct {
+	user     User
+	passkeys []Passkey
+}
+
+// webauthnUserHandle — user handle в аутентификаторе, по нему находим пользователя при входе
+func webauthnUserHandle(userID uint) []byte {
+	return []byte(strconv.FormatUint(uint64(userID), 10))
+}
+
+func (u webauthnUser) WebAuthnID() []byte { return webauthnUserHandle(u.user.ID) }
+
+func (u webauthnUser) WebAuthnName() string { return u.user.Email }
+
+func (u webauthnUser) WebAuthnDisplayName() string {
+	if u.user.Name != "" {
+		return u.user.Name
+	}
+	return u.user.Email
+}
+
+func (u webauthnUser) WebAuthnCredentials() []webauthn.Credential {
+	credentials := make([]webauthn.Credential, 0, len(u.passkeys))
+	for _, p := range u.passkeys {
+		var transports []protocol.AuthenticatorTransport
+		for _, t := range strings.Split(p.Transports, ",") {
+			if t != "" {
+				transports = append(transports, protocol.AuthenticatorTransport(t))
+			}
+		}
+		credentials = append(credentials, webauthn.Credential{
+			ID:              p.CredentialID,
+			PublicKey:       p.PublicKey,
+			AttestationType: p.AttestationType,
+			Transport:       transports,
+			Flags:           webauthn.CredentialFlags{BackupEligible: p.BackupEligible, BackupState: p.BackupState},
+			Authenticator:   webauthn.Authenticator{AAGUID: p.AAGUID, SignCount: p.SignCount},
+		})
+	}
+	return credentials
+}
+
+func newWebAuthn(cfg Config) (*webauthn.WebAuthn, error) {
+	rpID, origins := cfg.WebAuthnRP()
+	return webauthn.New(&webauthn.Config{
+		RPID:          rpID,
+		RPDisplayName: cfg.WebAuthn.RPName,
+		RPOrigins:     origins,
+	})
+}
+
+func (s *Server) loadWebAuthnUser(ctx context.Context, userID uint) (webauthnUser, error) {
+	user, err := s.users.Get(ctx, userID)
+	if err != nil {
+		return webauthnUser{}, err
+	}
+	passkeys, err := s.users.ListPasskeys(ctx, userID)
+	if err != nil {
+		return webauthnUser{}, err
+	}
+	return webauthnUser{user: user, passkeys: passkeys}, nil
+}
+
+// startCeremony сохраняет данные церемонии и возвращает её идентификатор для шага finish
+func (s *Server) startCeremony(ctx context.Context, userID uint, data any) (string, error) {
+	id, err := randomToken(16)
+	if err != nil {
+		return "", err
+	}
+	raw, err := json.Marshal(data)
+	if err != nil {
+		return "", err
+	}
+	return id, s.tokens.CreateWebAuthnSession(ctx, &WebAuthnSession{
+		ID:        id,
+		UserID:    userID,
+		Data:      raw,
+		ExpiresAt: time.Now().Add(webauthnCeremonyTTL),
+	})
+}
+
+// finishCeremony забирает данные церемонии; каждую можно завершить только один раз
+func (s *Server) finishCeremony(ctx context.Context, id string, userID uint, data any) error {
+	session, err := s.tokens.TakeWebAuthnSession(ctx, id)
+	if err != nil {
+		return err
+	}
+	if session.UserID != userID || time.Now().After(session.ExpiresAt) {
+		return ErrNotFound
+	}
+	return json.Unmarshal(session.Data, data)
+}
+
+func (s *Server) passkeysEnabled(w http.ResponseWriter) bool {
+	if s.webAuthn == nil {
+		http.Error(w, "Passkeys are not configured", http.StatusNotFound)
+		return false
+	}
+	return true
+}
+
+type passkeyRegistration struct {
+	Session webauthn.SessionData `json:"session"`
+	Name    string               `json:"name"`
+}
+
+// Начало регистрации passkey: POST /api/passkeys/register/begin {"name": "Ноутбук"}
+func (s *Server) passkeyRegisterBeginHandler(w http.ResponseWriter, r *http.Request) {
+	if !s.passkeysEnabled(w) {
+		return
+	}
+	userID := claimsUserID(r.Context().Value("user").(*Claims))
+	var req struct {
+		Name string `json:"name"`
+	}
+	json.NewDecoder(r.Body).Decode(&req)
+
+	wu, err := s.loadWebAuthnUser(r.Context(), userID)
+	if err != nil {
+		http.Error(w, "User not found", http.StatusUnauthorized)
+		return
+	}
+	creation, session, err := s.webAuthn.BeginRegistration(wu,
+		webauthn.WithExclusions(webauthn.Credentials(wu.WebAuthnCredentials()).CredentialDescriptors()),
+		webauthn.WithAuthenticatorSelection(protocol.AuthenticatorSelection{
+			RequireResidentKey: protocol.ResidentKeyRequired(),
+			ResidentKey:        protocol.ResidentKeyRequirementRequired,
+			UserVerification:   protocol.VerificationRequired,
+		}),
+	)
+	if err != nil {
+		http.Error(w, "Failed to start passkey registration", http.StatusInternalServerError)
+		return
+	}
+	id, err := s.startCeremony(r.Context(), userID, passkeyRegistration{Session: *session, Name: req.Name})
+	if err != nil {
+		http.Error(w, "Failed to start passkey registration", http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]any{"session": id, "options": creation})
+}
+
+// Завершение регистрации: POST /api/passkeys/register/finish?session=...
+// с ответом navigator.credentials.create() в теле
+func (s *Server) passkeyRegisterFinishHandler(w http.ResponseWriter, r *http.Request) {
+	if !s.passkeysEnabled(w) {
+		return
+	}
+	userID := claimsUserID(r.Context().Value("user").(*Claims))
+	var ceremony passkeyRegistration
+	if err := s.finishCeremony(r.Context(), r.URL.Query().Get("session"), userID, &ceremony); err != nil {
+		http.Error(w, "Registration session expired, start again", http.StatusBadRequest)
+		return
+	}
+	parsed, err := protocol.ParseCredentialCreationResponseBody(r.Body)
+	if err != nil {
+		http.Error(w, "Invalid credential", http.StatusBadRequest)
+		return
+	}
+	wu, err := s.loadWebAuthnUser(r.Context(), userID)
+	if err != nil {
+		http.Error(w, "User not found", http.StatusUnauthorized)
+		return
+	}
+	credential, err := s.webAuthn.CreateCredential(wu, ceremony.Session, parsed)
+	if err != nil {
+		s.log(r.Context()).WithError(err).WithField("user_id", userID).Warn("Passkey registration rejected")
+		http.Error(w, "Invalid credential", http.StatusBadRequest)
+		return
+	}
+
+	transports := make([]string, len(credential.Transport))
+	for i, t := range credential.Transport {
+		transports[i] = string(t)
+	}
+	name := strings.TrimSpace(ceremony.Name)
+	if name == "" {
+		name = "Passkey"
+	}
+	passkey := Passkey{
+		UserID:          userID,
+		CredentialID:    credential.ID,
+		PublicKey:       credential.PublicKey,
+		AttestationType: credential.AttestationType,
+		AAGUID:          credential.Authenticator.AAGUID,
+		SignCount:       credential.Authenticator.SignCount,
+		Transports:      strings.Join(transports, ","),
+		BackupEligible:  credential.Flags.BackupEligible,
+		BackupState:     credential.Flags.BackupState,
+		Name:            name,
+		CreatedAt:       time.Now(),
+	}
+	if err := s.users.CreatePasskey(r.Context(), &passkey); err != nil {
+		if errors.Is(err, ErrDuplicate) {
+			http.Error(w, "This passkey is already registered", http.StatusConflict)
+			return
+		}
+		http.Error(w, "Failed to save passkey", http.StatusInternalServerError)
+		return
+	}
+	s.log(r.Context()).WithField("user_id", userID).Info("Passkey registered")
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusCreated)
+	json.NewEncoder(w).Encode(passkey)
+}
+
+// Список passkeys пользователя: GET /api/passkeys
+func (s *Server) listPasskeysHandler(w http.ResponseWriter, r *http.Request) {
+	userID := claimsUserID(r.Context().Value("user").(*Claims))
+	passkeys, err := s.users.ListPasskeys(r.Context(), userID)
+	if err != nil {
+		http.Error(w, "Failed to load passkeys", http.StatusInternalServerError)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(passkeys)
+}
+
+// Удаление passkey: DELETE /api/passkeys/{id}
+func (s *Server) deletePasskeyHandler(w http.ResponseWriter, r *http.Request) {
+	userID := claimsUserID(r.Context().Value("user").(*Claims))
+	id, err := strconv.ParseUint(r.PathValue("id"), 10, 64)
+	if err != nil {
+		http.Error(w, "Invalid passkey ID", http.StatusBadRequest)
+		return
+	}
+	if err := s.users.DeletePasskey(r.Context(), userID, uint(id)); err != nil {
+		if errors.Is(err, ErrNotFound) {
+			http.Error(w, "Passkey not found", http.StatusNotFound)
+			return
+		}
+		http.Error(w, "Failed to delete passkey", http.StatusInternalServerError)
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// Начало входа: POST /auth/passkey/begin
+func (s *Server) passkeyLoginBeginHandler(w http.ResponseWriter, r *http.Request) {
+	if !s.passkeysEnabled(w) {
+		return
+	}
+	assertion, session, err := s.webAuthn.BeginDiscoverableLogin(webauthn.WithUserVerification(protocol.VerificationRequired))
+	if err != nil {
+		http.Error(w, "Failed to start passkey login", http.StatusInternalServerError)
+		return
+	}
+	id, err := s.startCeremony(r.Context(), 0, session)
+	if err != nil {
+		http.Error(w, "Failed to start passkey login", http.StatusInternalServerError)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]any{"session": id, "options": assertion})
+}
+
+// Завершение входа: POST /auth/passkey/finish?session=... с ответом navigator.credentials.get().
+// Passkey с проверкой пользователя (PIN, биометрия) считается вторым фактором
+func (s *Server) passkeyLoginFinishHandler(w http.ResponseWriter, r *http.Request) {
+	if !s.passkeysEnabled(w) {
+		return
+	}
+	var session webauthn.SessionData
+	if err := s.finishCeremony(r.Context(), r.URL.Query().Get("session"), 0, &session); err != nil {
+		http.Error(w, "Login session expired, start again", http.StatusBadRequest)
+		return
+	}
+	parsed, err := protocol.ParseCredentialRequestResponseBody(r.Body)
+	if err != nil {
+		http.Error(w, "Invalid passkey", http.StatusUnauthorized)
+		return
+	}
+
+	var found webauthnUser
+	handler := func(rawID, userHandle []byte) (webauthn.User, error) {
+		id, err := strconv.ParseUint(string(userHandle), 10, 64)
+		if err != nil {
+			return nil, ErrNotFound
+		}
+		found, err = s.loadWebAuthnUser(r.Context(), uint(id))
+		return found, err
+	}
+	_, credential, err := s.webAuthn.ValidatePasskeyLogin(handler, session, parsed)
+	if err != nil {
+		http.Error(w, "Invalid passkey", http.StatusUnauthorized)
+		return
+	}
+
+	passkey, err := s.users.GetPasskeyByCredentialID(r.Context(), credential.ID)
+	if err != nil {
+		http.Error(w, "Invalid passkey", http.StatusUnauthorized)
+		return
+	}
+	if credential.Authenticator.CloneWarning {
+		// Счётчик подписей не вырос: возможно, ключ скопирован
+		s.log(r.Context()).WithFields(logrus.Fields{
+			"user_id":    found.user.ID,
+			"passkey_id": passkey.ID,
+		}).Warn("Passkey sign counter went backwards, login rejected")
+		http.Error(w, "Invalid passkey", http.StatusUnauthorized)
+		return
+	}
+	now := time.Now()
+	passkey.SignCount = credential.Authenticator.SignCount
+	passkey.BackupState = credential.Flags.BackupState
+	passkey.LastUsedAt = &now
+	if err := s.users.SavePasskey(r.Context(), &passkey); err != nil {
+		http.Error(w, "Failed to update passkey", http.StatusInternalServerError)
+		return
+	}
+
+	if err := s.signIn(w, r, found.user, credential.Flags.UserVerified, wantsCookieSession(r)); err != nil {
+		http.Error(w, "Failed to generate token", http.StatusInternalServerError)
+		return
+	}
+	s.log(r.Context()).WithFields(logrus.Fields{
+		"action":  "passkey_login",
+		"user_id": found.user.ID,
+	}).Info("User logged in")
+}
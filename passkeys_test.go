@@ -0,0 +1,159 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"testing"
+
+	"github.com/descope/virtualwebauthn"
+	"github.com/stretchr/testify/assert"
+)
+
+// testRP совпадает с relying party сервера из testConfig (SITE_URL не задан, порт 8080)
+var testRP = virtualwebauthn.RelyingParty{Name: "Bookstore", ID: "localhost", Origin: "http://localhost:8080"}
+
+type ceremonyStart struct {
+	Session string          `json:"session"`
+	Options json.RawMessage `json:"options"`
+}
+
+func beginCeremony(t *testing.T, handler http.Handler, path, accessToken string, body any) ceremonyStart {
+	t.Helper()
+	rr := postJSON(handler, path, accessToken, body)
+	assert.Equal(t, http.StatusOK, rr.Code, "Expected 200 OK")
+	var start ceremonyStart
+	assert.NoError(t, json.NewDecoder(rr.Body).Decode(&start))
+	assert.NotEmpty(t, start.Session)
+	return start
+}
+
+// registerPasskey создаёт ключ в виртуальном аутентификаторе и регистрирует его на сервере
+func registerPasskey(t *testing.T, handler http.Handler, accessToken string, authenticator *virtualwebauthn.Authenticator, credential virtualwebauthn.Credential) {
+	t.Helper()
+	start := beginCeremony(t, handler, "/api/passkeys/register/begin", accessToken, map[string]string{"name": "Laptop"})
+	options, err := virtualwebauthn.ParseAttestationOptions(string(start.Options))
+	assert.NoError(t, err)
+	authenticator.Options.UserHandle = []byte(options.UserID)
+
+	response := virtualwebauthn.CreateAttestationResponse(testRP, *authenticator, credential, *options)
+	rr := postJSON(handler, "/api/passkeys/register/finish?session="+start.Session, accessToken, json.RawMessage(response))
+	assert.Equal(t, http.StatusCreated, rr.Code, "Expected 201 Created")
+	authenticator.AddCredential(credential)
+}
+
+// passkeyLogin проходит вход по passkey и возвращает ответ сервера
+func passkeyLogin(t *testing.T, handler http.Handler, rp virtualwebauthn.RelyingParty, authenticator virtualwebauthn.Authenticator, credential virtualwebauthn.Credential) *httptest.ResponseRecorder {
+	t.Helper()
+	start := beginCeremony(t, handler, "/auth/passkey/begin", "", nil)
+	options, err := virtualwebauthn.ParseAssertionOptions(string(start.Options))
+	assert.NoError(t, err)
+	assert.Empty(t, options.AllowCredentials, "Discoverable login must not reveal credentials")
+
+	response := virtualwebauthn.CreateAssertionResponse(rp, authenticator, credential, *options)
+	return postJSON(handler, "/auth/passkey/finish?session="+start.Session, "", json.RawMessage(response))
+}
+
+func TestPasskeyRegistrationAndLogin(t *testing.T) {
+	t.Parallel()
+	s := newMemoryTestServer(t)
+	handler := s.routes()
+	pair := loginTestUser(t, s, handler)
+
+	authenticator := virtualwebauthn.NewAuthenticator()
+	credential := virtualwebauthn.NewCredential(virtualwebauthn.KeyTypeEC2)
+	registerPasskey(t, handler, pair.AccessToken, &authenticator, credential)
+
+	req, _ := http.NewRequest("GET", "/api/passkeys", nil)
+	req.Header.Set("Authorization", "Bearer "+pair.AccessToken)
+	rr := httptest.NewRecorder()
+	handler.ServeHTTP(rr, req)
+	var passkeys []map[string]any
+	assert.NoError(t, json.NewDecoder(rr.Body).Decode(&passkeys))
+	assert.Len(t, passkeys, 1)
+	assert.Equal(t, "Laptop", passkeys[0]["name"])
+	assert.NotContains(t, passkeys[0], "public_key", "Key material must not be exposed")
+
+	rr = passkeyLogin(t, handler, testRP, authenticator, credential)
+	assert.Equal(t, http.StatusOK, rr.Code, "Expected 200 OK")
+	var tokens tokenPair
+	assert.NoError(t, json.NewDecoder(rr.Body).Decode(&tokens))
+	assert.Equal(t, http.StatusOK, getProfile(handler, tokens.AccessToken))
+	claims, err := s.parseAccessToken(context.Background(), tokens.AccessToken)
+	assert.NoError(t, err)
+	assert.True(t, claims.MFA, "Passkey with user verification counts as multi-factor")
+
+	stored, _ := s.users.ListPasskeys(context.Background(), claimsUserID(claims))
+	assert.NotNil(t, stored[0].LastUsedAt)
+}
+
+func TestPasskeyLoginRejected(t *testing.T) {
+	t.Parallel()
+	s := newMemoryTestServer(t)
+	handler := s.routes()
+	pair := loginTestUser(t, s, handler)
+
+	authenticator := virtualwebauthn.NewAuthenticator()
+	credential := virtualwebauthn.NewCredential(virtualwebauthn.KeyTypeEC2)
+	credential.Counter = 5
+	registerPasskey(t, handler, pair.AccessToken, &authenticator, credential)
+
+	// Чужой сайт не может воспользоваться ключом
+	phishing := virtualwebauthn.RelyingParty{Name: "Bookstore", ID: "localhost", Origin: "http://evil.example"}
+	rr := passkeyLogin(t, handler, phishing, authenticator, credential)
+	assert.Equal(t, http.StatusUnauthorized, rr.Code, "Expected 401 for a foreign origin")
+
+	// Неизвестный ключ
+	stranger := virtualwebauthn.NewCredential(virtualwebauthn.KeyTypeEC2)
+	rr = passkeyLogin(t, handler, testRP, authenticator, stranger)
+	assert.Equal(t, http.StatusUnauthorized, rr.Code, "Expected 401 for an unknown passkey")
+
+	credential.Counter = 6
+	rr = passkeyLogin(t, handler, testRP, authenticator, credential)
+	assert.Equal(t, http.StatusOK, rr.Code, "Expected 200 OK")
+
+	// Счётчик не вырос — похоже на клон ключа
+	credential.Counter = 3
+	rr = passkeyLogin(t, handler, testRP, authenticator, credential)
+	assert.Equal(t, http.StatusUnauthorized, rr.Code, "Expected 401 for a cloned passkey")
+
+	// Церемонию нельзя завершить дважды
+	start := beginCeremony(t, handler, "/auth/passkey/begin", "", nil)
+	options, _ := virtualwebauthn.ParseAssertionOptions(string(start.Options))
+	credential.Counter = 7
+	response := json.RawMessage(virtualwebauthn.CreateAssertionResponse(testRP, authenticator, credential, *options))
+	rr = postJSON(handler, "/auth/passkey/finish?session="+start.Session, "", response)
+	assert.Equal(t, http.StatusOK, rr.Code, "Expected 200 OK")
+	rr = postJSON(handler, "/auth/passkey/finish?session="+start.Session, "", response)
+	assert.Equal(t, http.StatusBadRequest, rr.Code, "Expected 400 for a replayed ceremony")
+}
+
+func TestDeletePasskey(t *testing.T) {
+	t.Parallel()
+	s := newMemoryTestServer(t)
+	handler := s.routes()
+	pair := loginTestUser(t, s, handler)
+
+	authenticator := virtualwebauthn.NewAuthenticator()
+	credential := virtualwebauthn.NewCredential(virtualwebauthn.KeyTypeEC2)
+	registerPasskey(t, handler, pair.AccessToken, &authenticator, credential)
+
+	claims, _ := s.parseAccessToken(context.Background(), pair.AccessToken)
+	passkeys, _ := s.users.ListPasskeys(context.Background(), claimsUserID(claims))
+	assert.Len(t, passkeys, 1)
+
+	deletePasskey := func(path string) int {
+		req, _ := http.NewRequest("DELETE", path, nil)
+		req.Header.Set("Authorization", "Bearer "+pair.AccessToken)
+		rr := httptest.NewRecorder()
+		handler.ServeHTTP(rr, req)
+		return rr.Code
+	}
+	assert.Equal(t, http.StatusNotFound, deletePasskey("/api/passkeys/999"))
+	assert.Equal(t, http.StatusNoContent, deletePasskey("/api/passkeys/"+strconv.Itoa(int(passkeys[0].ID))))
+
+	rr := passkeyLogin(t, handler, testRP, authenticator, credential)
+	assert.Equal(t, http.StatusUnauthorized, rr.Code, "Deleted passkey must not log in")
+}
@@ -0,0 +1,199 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"strings"
+	"time"
+	"unicode"
+
+	"golang.org/x/crypto/bcrypt"
+)
+
+// Парольная политика и сброс пароля по ссылке из письма
+
+const (
+	minPasswordLength = 10
+	// bcrypt молча отбрасывает всё после 72 байт
+	maxPasswordBytes = 72
+
+	purposeResetPassword = "reset_password"
+	passwordResetTTL     = time.Hour
+)
+
+// commonPasswords — самые частые пароли из утечек, которые проходят по длине
+var commonPasswords = map[string]bool{
+	"1234567890":  true,
+	"0987654321":  true,
+	"1q2w3e4r5t":  true,
+	"password123": true,
+	"password1!":  true,
+	"qwerty1234":  true,
+	"qwertyuiop":  true,
+	"iloveyou12":  true,
+	"letmein123":  true,
+	"welcome123":  true,
+	"admin12345":  true,
+	"bookstore1":  true,
+}
+
+// validatePassword — общая политика для регистрации и сброса пароля
+func validatePassword(password, email string) error {
+	if len([]rune(password)) < minPasswordLength {
+		return fmt.Errorf("password must be at least %d characters long", minPasswordLength)
+	}
+	if len(password) > maxPasswordBytes {
+		return fmt.Errorf("password must be at most %d bytes long", maxPasswordBytes)
+	}
+
+	var letters, others bool
+	for _, r := range password {
+		if unicode.IsLetter(r) {
+			letters = true
+		} else if !unicode.IsSpace(r) {
+			others = true
+		}
+	}
+	if !letters || !others {
+		return errors.New("password must contain letters and at least one digit or symbol")
+	}
+
+	lower := strings.ToLower(password)
+	if commonPasswords[lower] {
+		return errors.New("password is too common")
+	}
+	if local, _, _ := strings.Cut(strings.ToLower(email), "@"); len(local) >= 3 && strings.Contains(lower, local) {
+		return errors.New("password must not contain your email address")
+	}
+	return nil
+}
+
+// Запрос сброса: POST /password/forgot {"email": "..."}
+// Ответ одинаковый, есть такой пользователь или нет
+func (s *Server) forgotPasswordHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	var req struct {
+		Email string `json:"email"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil || req.Email == "" {
+		http.Error(w, "Email is required", http.StatusBadRequest)
+		return
+	}
+
+	// В фоне, чтобы по времени ответа нельзя было понять, есть ли пользователь
+	ctx := context.WithoutCancel(r.Context())
+	go func() {
+		if err := s.startPasswordReset(ctx, req.Email); err != nil {
+			s.log(ctx).WithError(err).Error("Failed to start password reset")
+		}
+	}()
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusAccepted)
+	json.NewEncoder(w).Encode(map[string]string{
+		"message": "If an account with this email exists, a password reset link has been sent.",
+	})
+}
+
+func (s *Server) startPasswordReset(ctx context.Context, email string) error {
+	user, err := s.users.GetByEmail(ctx, email)
+	if errors.Is(err, ErrNotFound) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	token, err := s.issueUserToken(ctx, user.ID, purposeResetPassword, passwordResetTTL)
+	if err != nil {
+		return err
+	}
+	return s.sendPasswordResetEmail(ctx, user.Email, token)
+}
+
+func (s *Server) sendPasswordResetEmail(ctx context.Context, to, token string) error {
+	// Токен во фрагменте не попадает в логи сервера и заголовок Referer
+	link := fmt.Sprintf("%s/reset-password.html#token=%s", s.config.BaseURL(), token)
+	message := fmt.Sprintf("Someone asked to reset the password for your account.\r\n\r\n"+
+		"Follow the link to choose a new password: %s\r\nThe link expires in %d minutes and works once.\r\n\r\n"+
+		"If it wasn't you, ignore this email: your password stays the same.",
+		link, int(passwordResetTTL.Minutes()))
+	msg := []byte("To: " + to + "\r\n" + "Subject: Password reset\r\n" + "\r\n" + message)
+
+	return s.sendMail(ctx, "password_reset", []string{to}, msg)
+}
+
+// Новый пароль: POST /password/reset {"token": "...", "password": "..."}
+// Все сессии пользователя завершаются
+func (s *Server) resetPasswordHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	ip := s.clientIP(r)
+	if !s.verifyAttempts.Allow(ip) {
+		http.Error(w, "Too many attempts, try again later", http.StatusTooManyRequests)
+		return
+	}
+
+	var req struct {
+		Token    string `json:"token"`
+		Password string `json:"password"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil || req.Token == "" {
+		http.Error(w, "Token and password are required", http.StatusBadRequest)
+		return
+	}
+
+	// Пароль проверяем до того, как погасить токен, чтобы можно было попробовать снова
+	token, err := s.tokens.GetUserToken(r.Context(), purposeResetPassword, hashToken(req.Token))
+	if err != nil {
+		s.verifyAttempts.Fail(ip)
+		http.Error(w, "Invalid or expired token", http.StatusBadRequest)
+		return
+	}
+	user, err := s.users.Get(r.Context(), token.UserID)
+	if err != nil {
+		http.Error(w, "Invalid or expired token", http.StatusBadRequest)
+		return
+	}
+	if err := validatePassword(req.Password, user.Email); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	if err := s.useUserToken(r.Context(), token); err != nil {
+		s.verifyAttempts.Fail(ip)
+		http.Error(w, "Invalid or expired token", http.StatusBadRequest)
+		return
+	}
+	s.verifyAttempts.Reset(ip)
+
+	hash, err := bcrypt.GenerateFromPassword([]byte(req.Password), bcrypt.DefaultCost)
+	if err != nil {
+		http.Error(w, "Failed to hash password", http.StatusInternalServerError)
+		return
+	}
+	user.PasswordHash = string(hash)
+	// Письмо со ссылкой дошло, значит и адрес подтверждён
+	user.Confirmed = true
+	if err := s.users.Save(r.Context(), &user); err != nil {
+		http.Error(w, "Failed to update password", http.StatusInternalServerError)
+		return
+	}
+	if err := s.tokens.RevokeUserSessions(r.Context(), user.ID, time.Now()); err != nil {
+		http.Error(w, "Failed to revoke sessions", http.StatusInternalServerError)
+		return
+	}
+	// Владелец доказал доступ к почте — снимаем блокировку входа
+	s.accountLogins.Reset(loginKey(user.Email))
+	s.log(r.Context()).WithField("user_id", user.ID).Info("Password reset")
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]string{"message": "Password has been reset. You can now log in."})
+}
@@ -0,0 +1,100 @@
+package main
+
+import (
+	"context"
+	"net/http"
+	"regexp"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"golang.org/x/crypto/bcrypt"
+)
+
+var resetLinkPattern = regexp.MustCompile(`#token=([A-Za-z0-9_-]+)`)
+
+func TestValidatePassword(t *testing.T) {
+	t.Parallel()
+	assert.NoError(t, validatePassword("turn-the-page-42", "reader@example.com"))
+	assert.NoError(t, validatePassword("книжная полка 7", "reader@example.com"))
+
+	rejected := map[string]string{
+		"too short":       "abc12",
+		"only letters":    "readingbooks",
+		"only digits":     "12345678901",
+		"common":          "Password123",
+		"contains email":  "reader-2024!",
+		"over 72 bytes":   strings.Repeat("ab1", 25),
+		"letters and gap": "long words here",
+	}
+	for name, password := range rejected {
+		assert.Error(t, validatePassword(password, "reader@example.com"), name)
+	}
+}
+
+func TestRegisterRejectsWeakPassword(t *testing.T) {
+	t.Parallel()
+	s := newMemoryTestServer(t)
+	rr := postJSON(s.routes(), "/register", "", map[string]string{"name": "Reader", "email": "reader@example.com", "password": "secret"})
+	assert.Equal(t, http.StatusBadRequest, rr.Code, "Expected 400 Bad Request")
+	_, err := s.users.GetByEmail(context.Background(), "reader@example.com")
+	assert.ErrorIs(t, err, ErrNotFound)
+}
+
+func TestPasswordReset(t *testing.T) {
+	t.Parallel()
+	s := newMemoryTestServer(t)
+	handler := s.routes()
+	pair := loginTestUser(t, s, handler)
+	mailer := s.mailer.(*fakeMailer)
+
+	// Ответ не выдаёт, есть ли такой пользователь
+	unknown := postJSON(handler, "/password/forgot", "", map[string]string{"email": "nobody@example.com"})
+	known := postJSON(handler, "/password/forgot", "", map[string]string{"email": "reader@example.com"})
+	assert.Equal(t, http.StatusAccepted, known.Code, "Expected 202 Accepted")
+	assert.Equal(t, unknown.Code, known.Code)
+	assert.Equal(t, unknown.Body.String(), known.Body.String())
+
+	token := mailedSecret(t, mailer, resetLinkPattern)
+	assert.Len(t, mailer.Sent(), 1)
+
+	rr := postJSON(handler, "/password/reset", "", map[string]string{"token": token, "password": "short"})
+	assert.Equal(t, http.StatusBadRequest, rr.Code, "Weak password must be rejected")
+
+	// Слабый пароль не гасит токен
+	rr = postJSON(handler, "/password/reset", "", map[string]string{"token": token, "password": "a-brand-new-chapter-7"})
+	assert.Equal(t, http.StatusOK, rr.Code, "Expected 200 OK")
+
+	user, _ := s.users.GetByEmail(context.Background(), "reader@example.com")
+	assert.NoError(t, bcrypt.CompareHashAndPassword([]byte(user.PasswordHash), []byte("a-brand-new-chapter-7")))
+
+	// Все прежние сессии завершены
+	assert.Equal(t, http.StatusUnauthorized, getProfile(handler, pair.AccessToken))
+	rr = postJSON(handler, "/token/refresh", "", map[string]string{"refresh_token": pair.RefreshToken})
+	assert.Equal(t, http.StatusUnauthorized, rr.Code, "Expected 401 Unauthorized")
+
+	// Ссылка одноразовая
+	rr = postJSON(handler, "/password/reset", "", map[string]string{"token": token, "password": "yet-another-chapter-8"})
+	assert.Equal(t, http.StatusBadRequest, rr.Code, "Expected 400 Bad Request")
+
+	rr = postJSON(handler, "/login", "", map[string]string{"email": "reader@example.com", "password": "a-brand-new-chapter-7"})
+	assert.Equal(t, http.StatusOK, rr.Code, "Expected 200 OK")
+}
+
+func TestPasswordResetLinkExpires(t *testing.T) {
+	t.Parallel()
+	s := newMemoryTestServer(t)
+	user := User{Email: "reader@example.com", Confirmed: true}
+	assert.NoError(t, s.users.Create(context.Background(), &user))
+
+	token, err := s.issueUserToken(context.Background(), user.ID, purposeResetPassword, -time.Second)
+	assert.NoError(t, err)
+	rr := postJSON(s.routes(), "/password/reset", "", map[string]string{"token": token, "password": "a-brand-new-chapter-7"})
+	assert.Equal(t, http.StatusBadRequest, rr.Code, "Expected 400 Bad Request")
+
+	// Токен подтверждения email не подходит для сброса
+	token, _ = s.issueUserToken(context.Background(), user.ID, purposeVerifyEmail, time.Hour)
+	rr = postJSON(s.routes(), "/password/reset", "", map[string]string{"token": token, "password": "a-brand-new-chapter-7"})
+	assert.Equal(t, http.StatusBadRequest, rr.Code, "Expected 400 Bad Request")
+}
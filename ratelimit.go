@@ -0,0 +1,261 @@
+package main
+
+import (
+	"context"
+	"math"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/sirupsen/logrus"
+	"golang.org/x/time/rate"
+)
+
+// Ограничение частоты запросов: у каждого клиента (пользователя или IP) своё
+// ведро токенов на каждую группу маршрутов, так что один парсер не тормозит весь магазин
+
+// rateLimitPolicy — лимит для группы маршрутов
+type rateLimitPolicy struct {
+	name  string
+	rps   float64
+	burst int
+}
+
+// window — за сколько в среднем набирается burst запросов
+func (p rateLimitPolicy) window() time.Duration {
+	return time.Duration(float64(p.burst) / p.rps * float64(time.Second))
+}
+
+// rateLimitResult — ответ лимитера, из него строятся заголовки RateLimit-*
+type rateLimitResult struct {
+	allowed   bool
+	limit     int
+	remaining int
+	// reset — через сколько ведро снова будет полным
+	reset time.Duration
+	// retryAfter — через сколько можно повторить отклонённый запрос
+	retryAfter time.Duration
+}
+
+// rateLimiter считает запросы клиента по ключу в рамках политики
+type rateLimiter interface {
+	Allow(ctx context.Context, key string, policy rateLimitPolicy) (rateLimitResult, error)
+}
+
+type bucket struct {
+	limiter  *rate.Limiter
+	lastSeen time.Time
+}
+
+// memoryRateLimiter хранит вёдра в памяти процесса; вёдра, к которым не
+// обращались idleTTL, удаляются при очередном запросе
+type memoryRateLimiter struct {
+	idleTTL time.Duration
+	now     func() time.Time
+
+	mu        sync.Mutex
+	buckets   map[string]*bucket
+	lastSweep time.Time
+}
+
+func newMemoryRateLimiter(idleTTL time.Duration) *memoryRateLimiter {
+	return &memoryRateLimiter{idleTTL: idleTTL, now: time.Now, buckets: map[string]*bucket{}, lastSweep: time.Now()}
+}
+
+func (l *memoryRateLimiter) Allow(ctx context.Context, key string, policy rateLimitPolicy) (rateLimitResult, error) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	now := l.now()
+	if now.Sub(l.lastSweep) >= l.idleTTL {
+		l.sweep(now)
+	}
+
+	id := policy.name + ":" + key
+	b, ok := l.buckets[id]
+	if !ok {
+		b = &bucket{limiter: rate.NewLimiter(rate.Limit(policy.rps), policy.burst)}
+		l.buckets[id] = b
+	}
+	b.lastSeen = now
+
+	result := rateLimitResult{limit: policy.burst}
+	if b.limiter.AllowN(now, 1) {
+		result.allowed = true
+	} else {
+		// Сколько ждать следующего токена; резерв сразу отменяем
+		reservation := b.limiter.ReserveN(now, 1)
+		result.retryAfter = reservation.DelayFrom(now)
+		reservation.CancelAt(now)
+	}
+	tokens := max(b.limiter.TokensAt(now), 0)
+	result.remaining = int(tokens)
+	result.reset = time.Duration((float64(policy.burst) - tokens) / policy.rps * float64(time.Second))
+	return result, nil
+}
+
+// sweep удаляет простаивающие вёдра; вызывается под l.mu
+func (l *memoryRateLimiter) sweep(now time.Time) {
+	for id, b := range l.buckets {
+		if now.Sub(b.lastSeen) >= l.idleTTL {
+			delete(l.buckets, id)
+		}
+	}
+	l.lastSweep = now
+}
+
+// Len — число вёдер в памяти
+func (l *memoryRateLimiter) Len() int {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	return len(l.buckets)
+}
+
+// authRoutes — вход, регистрация и всё, что проверяет секреты: здесь лимит строже
+var authRoutes = []string{"/login", "/register", "/verify", "/password/", "/token/refresh", "/auth/", "/account/email/confirm"}
+
+// bookRoutes — каталог, его можно читать часто
+var bookRoutes = []string{"/books", "/book/", "/fantasy", "/sitemap.xml"}
+
+func newRateLimitPolicies(cfg RateLimitConfig) map[string]rateLimitPolicy {
+	return map[string]rateLimitPolicy{
+		"default": {name: "default", rps: cfg.RPS, burst: cfg.Burst},
+		"auth":    {name: "auth", rps: cfg.Auth.RPS, burst: cfg.Auth.Burst},
+		"books":   {name: "books", rps: cfg.Books.RPS, burst: cfg.Books.Burst},
+	}
+}
+
+func matchRoute(path string, routes []string) bool {
+	for _, route := range routes {
+		if path == route || (strings.HasSuffix(route, "/") && strings.HasPrefix(path, route)) ||
+			strings.HasPrefix(path, route+"/") {
+			return true
+		}
+	}
+	return false
+}
+
+// ratePolicy выбирает политику по пути; false — путь не ограничивается
+func (s *Server) ratePolicy(path string) (rateLimitPolicy, bool) {
+	switch {
+	case path == "/healthz" || path == "/metrics":
+		return rateLimitPolicy{}, false
+	case matchRoute(path, authRoutes):
+		return s.ratePolicies["auth"], true
+	case matchRoute(path, bookRoutes):
+		return s.ratePolicies["books"], true
+	default:
+		return s.ratePolicies["default"], true
+	}
+}
+
+// authLookup запоминает на время запроса найденный API-ключ и сессию из cookie:
+// их проверяет и rateLimitKey, и authMiddleware, а это запросы к базе
+type authLookup struct {
+	apiKeyRaw string
+	apiKey    APIKey
+	apiKeyErr error
+
+	cookieDone   bool
+	cookieClaims *Claims
+	cookieErr    error
+}
+
+type authLookupKey struct{}
+
+func withAuthLookup(r *http.Request) *http.Request {
+	if _, ok := r.Context().Value(authLookupKey{}).(*authLookup); ok {
+		return r
+	}
+	return r.WithContext(context.WithValue(r.Context(), authLookupKey{}, &authLookup{}))
+}
+
+// resolveAPIKey — lookupAPIKey, выполняемый не больше одного раза за запрос
+func (s *Server) resolveAPIKey(ctx context.Context, raw string) (APIKey, error) {
+	cache, ok := ctx.Value(authLookupKey{}).(*authLookup)
+	if !ok {
+		return s.lookupAPIKey(ctx, raw)
+	}
+	if cache.apiKeyRaw != raw {
+		cache.apiKeyRaw = raw
+		cache.apiKey, cache.apiKeyErr = s.lookupAPIKey(ctx, raw)
+	}
+	return cache.apiKey, cache.apiKeyErr
+}
+
+// resolveCookieSession — cookieSessionClaims, выполняемый не больше одного раза за запрос
+func (s *Server) resolveCookieSession(w http.ResponseWriter, r *http.Request) (*Claims, error) {
+	cache, ok := r.Context().Value(authLookupKey{}).(*authLookup)
+	if !ok {
+		return s.cookieSessionClaims(w, r)
+	}
+	if !cache.cookieDone {
+		cache.cookieDone = true
+		cache.cookieClaims, cache.cookieErr = s.cookieSessionClaims(w, r)
+	}
+	return cache.cookieClaims, cache.cookieErr
+}
+
+// rateLimitKey — кто делает запрос: пользователь с действующим access-токеном
+// или сессией из cookie, API-ключ или IP-адрес. Непроверенный токен не даёт
+// отдельного ведра, иначе лимит обходился бы случайными заголовками
+func (s *Server) rateLimitKey(w http.ResponseWriter, r *http.Request) string {
+	header := r.Header.Get("Authorization")
+	if token, ok := strings.CutPrefix(header, "Bearer "); ok {
+		if isAPIKey(token) {
+			if key, err := s.resolveAPIKey(r.Context(), token); err == nil {
+				return "apikey:" + key.Prefix
+			}
+		} else if claims, err := s.parseAccessToken(r.Context(), token); err == nil && claims.Subject != "" {
+			return "user:" + claims.Subject
+		}
+	} else if header == "" {
+		if claims, err := s.resolveCookieSession(w, r); err == nil && claims != nil {
+			return "user:" + claims.Subject
+		}
+	}
+	return "ip:" + s.clientIP(r)
+}
+
+func (s *Server) rateLimitMiddleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		r = withAuthLookup(r)
+		policy, limited := s.ratePolicy(r.URL.Path)
+		if !limited {
+			next.ServeHTTP(w, r)
+			return
+		}
+		key := s.rateLimitKey(w, r)
+		result, err := s.limiter.Allow(r.Context(), key, policy)
+		if err != nil {
+			// Лимитер недоступен — пропускаем запрос, а не роняем магазин
+			s.log(r.Context()).WithError(err).Error("Rate limiter failed")
+			next.ServeHTTP(w, r)
+			return
+		}
+
+		h := w.Header()
+		h.Set("RateLimit-Policy", strconv.Itoa(policy.burst)+";w="+strconv.Itoa(ceilSeconds(policy.window())))
+		h.Set("RateLimit-Limit", strconv.Itoa(result.limit))
+		h.Set("RateLimit-Remaining", strconv.Itoa(result.remaining))
+		h.Set("RateLimit-Reset", strconv.Itoa(ceilSeconds(result.reset)))
+		if !result.allowed {
+			h.Set("Retry-After", strconv.Itoa(max(ceilSeconds(result.retryAfter), 1)))
+			http.Error(w, "429 Too Many Requests: Rate limit exceeded", http.StatusTooManyRequests)
+			s.metrics.rateLimited.WithLabelValues(policy.name).Inc()
+			s.log(r.Context()).WithFields(logrus.Fields{
+				"path":   r.URL.Path,
+				"method": r.Method,
+				"client": key,
+				"policy": policy.name,
+			}).Warn("Rate limit exceeded")
+			return
+		}
+		next.ServeHTTP(w, r)
+	})
+}
+
+func ceilSeconds(d time.Duration) int {
+	return int(math.Ceil(d.Seconds()))
+}
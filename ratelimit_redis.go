@@ -0,0 +1,90 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"github.com/redis/go-redis/v9"
+)
+
+// redisRateLimiter хранит счётчики в Redis, поэтому лимит общий для всех
+// экземпляров за балансировщиком. Алгоритм — скользящее окно: в окне
+// burst/rps секунд допускается не больше burst запросов клиента; время берётся
+// у Redis, чтобы расхождение часов экземпляров не влияло на подсчёт
+type redisRateLimiter struct {
+	client redis.UniversalClient
+	prefix string
+}
+
+func newRedisRateLimiter(client redis.UniversalClient) *redisRateLimiter {
+	return &redisRateLimiter{client: client, prefix: "ratelimit:"}
+}
+
+// newRateLimiter выбирает хранилище счётчиков по RATE_LIMIT_BACKEND
+func newRateLimiter(cfg RateLimitConfig) (rateLimiter, error) {
+	if cfg.Backend != "redis" {
+		return newMemoryRateLimiter(cfg.IdleTTL), nil
+	}
+	opts, err := redis.ParseURL(cfg.RedisURL.Value())
+	if err != nil {
+		return nil, fmt.Errorf("invalid RATE_LIMIT_REDIS_URL: %w", err)
+	}
+	return newRedisRateLimiter(redis.NewClient(opts)), nil
+}
+
+// slidingWindowScript: в sorted set лежат отметки времени запросов (мкс).
+// Возвращает {допущен, запросов в окне, возраст самого старого, возраст самого нового}
+var slidingWindowScript = redis.NewScript(`
+local window = tonumber(ARGV[1])
+local limit = tonumber(ARGV[2])
+local t = redis.call('TIME')
+local now = tonumber(t[1]) * 1000000 + tonumber(t[2])
+
+redis.call('ZREMRANGEBYSCORE', KEYS[1], '-inf', now - window)
+local count = redis.call('ZCARD', KEYS[1])
+local allowed = 0
+if count < limit then
+	redis.call('ZADD', KEYS[1], now, ARGV[3])
+	count = count + 1
+	allowed = 1
+end
+redis.call('PEXPIRE', KEYS[1], math.ceil(window / 1000))
+
+local oldest = redis.call('ZRANGE', KEYS[1], 0, 0, 'WITHSCORES')
+local newest = redis.call('ZRANGE', KEYS[1], -1, -1, 'WITHSCORES')
+return {allowed, count, now - tonumber(oldest[2]), now - tonumber(newest[2])}
+`)
+
+func (l *redisRateLimiter) Allow(ctx context.Context, key string, policy rateLimitPolicy) (rateLimitResult, error) {
+	// Уникальная метка, иначе два запроса в одну микросекунду слились бы в один
+	member, err := randomToken(9)
+	if err != nil {
+		return rateLimitResult{}, err
+	}
+	window := policy.window()
+	values, err := slidingWindowScript.Run(ctx, l.client,
+		[]string{l.prefix + policy.name + ":" + key},
+		window.Microseconds(), policy.burst, member).Int64Slice()
+	if err != nil {
+		return rateLimitResult{}, fmt.Errorf("redis rate limiter: %w", err)
+	}
+	if len(values) != 4 {
+		return rateLimitResult{}, fmt.Errorf("redis rate limiter: unexpected reply %v", values)
+	}
+
+	oldestAge := time.Duration(values[2]) * time.Microsecond
+	newestAge := time.Duration(values[3]) * time.Microsecond
+	result := rateLimitResult{
+		allowed:   values[0] == 1,
+		limit:     policy.burst,
+		remaining: policy.burst - int(values[1]),
+		// Окно полностью освободится, когда из него выйдет последний запрос
+		reset: window - newestAge,
+	}
+	if !result.allowed {
+		// Место появится, когда выйдет самый старый
+		result.retryAfter = window - oldestAge
+	}
+	return result, nil
+}
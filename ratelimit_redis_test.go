@@ -0,0 +1,102 @@
+package main
+
+import (
+	"context"
+	"net/http"
+	"testing"
+	"time"
+
+	"github.com/alicebob/miniredis/v2"
+	"github.com/redis/go-redis/v9"
+	"github.com/stretchr/testify/assert"
+)
+
+func newTestRedisLimiter(t *testing.T) (*miniredis.Miniredis, *redisRateLimiter) {
+	t.Helper()
+	mr := miniredis.RunT(t)
+	client := redis.NewClient(&redis.Options{Addr: mr.Addr()})
+	t.Cleanup(func() { client.Close() })
+	return mr, newRedisRateLimiter(client)
+}
+
+func TestRedisRateLimiterSlidingWindow(t *testing.T) {
+	mr, limiter := newTestRedisLimiter(t)
+	start := time.Date(2025, 3, 1, 12, 0, 0, 0, time.UTC)
+	mr.SetTime(start)
+	policy := rateLimitPolicy{name: "books", rps: 1, burst: 3}
+	ctx := context.Background()
+
+	for i := range 3 {
+		mr.SetTime(start.Add(time.Duration(i) * time.Second))
+		result, err := limiter.Allow(ctx, "ip:203.0.113.1", policy)
+		assert.NoError(t, err)
+		assert.True(t, result.allowed)
+		assert.Equal(t, 3, result.limit)
+		assert.Equal(t, 2-i, result.remaining)
+	}
+
+	mr.SetTime(start.Add(2500 * time.Millisecond))
+	result, err := limiter.Allow(ctx, "ip:203.0.113.1", policy)
+	assert.NoError(t, err)
+	assert.False(t, result.allowed, "Expected a denial with 3 requests in the last 3 seconds")
+	assert.Equal(t, 0, result.remaining)
+	assert.Equal(t, 500*time.Millisecond, result.retryAfter, "A slot frees when the oldest request leaves the window")
+	assert.Equal(t, 2500*time.Millisecond, result.reset)
+
+	// Окно скользит: первый запрос вышел из него, остальные ещё считаются
+	mr.SetTime(start.Add(3 * time.Second))
+	result, err = limiter.Allow(ctx, "ip:203.0.113.1", policy)
+	assert.NoError(t, err)
+	assert.True(t, result.allowed)
+	assert.Equal(t, 0, result.remaining)
+
+	result, _ = limiter.Allow(ctx, "ip:203.0.113.2", policy)
+	assert.True(t, result.allowed, "Another client has its own window")
+}
+
+func TestRedisRateLimiterExpiresIdleKeys(t *testing.T) {
+	mr, limiter := newTestRedisLimiter(t)
+	policy := rateLimitPolicy{name: "auth", rps: 0.2, burst: 5}
+
+	_, err := limiter.Allow(context.Background(), "ip:203.0.113.1", policy)
+	assert.NoError(t, err)
+	assert.Equal(t, 25*time.Second, mr.TTL("ratelimit:auth:ip:203.0.113.1"))
+
+	mr.FastForward(25 * time.Second)
+	assert.False(t, mr.Exists("ratelimit:auth:ip:203.0.113.1"), "Idle windows expire on their own")
+}
+
+func TestRedisRateLimitIsSharedAcrossInstances(t *testing.T) {
+	mr := miniredis.RunT(t)
+	cfg := testConfig()
+	cfg.RateLimit.Books = RateLimitRule{RPS: 1, Burst: 2}
+	cfg.RateLimit.Backend = "redis"
+	cfg.RateLimit.RedisURL = Secret("redis://" + mr.Addr())
+	assert.NoError(t, cfg.Validate())
+
+	first := newConfigTestServer(t, cfg, newMemoryTokenRepository()).routes()
+	second := newConfigTestServer(t, cfg, newMemoryTokenRepository()).routes()
+
+	getFrom(first, "/books", "203.0.113.1", "")
+	rr := getFrom(second, "/books", "203.0.113.1", "")
+	assert.Equal(t, "0", rr.Header().Get("RateLimit-Remaining"))
+	rr = getFrom(first, "/books", "203.0.113.1", "")
+	assert.Equal(t, http.StatusTooManyRequests, rr.Code, "Instances share the client's window")
+	assert.Equal(t, "2", rr.Header().Get("Retry-After"))
+
+	// Без Redis запросы пропускаются, а не отклоняются
+	mr.Close()
+	rr = getFrom(second, "/books", "203.0.113.1", "")
+	assert.NotEqual(t, http.StatusTooManyRequests, rr.Code)
+	assert.Empty(t, rr.Header().Get("RateLimit-Limit"))
+}
+
+func TestRateLimitBackendValidation(t *testing.T) {
+	cfg := testConfig()
+	cfg.RateLimit.Backend = "memcached"
+	assert.ErrorContains(t, cfg.Validate(), "RATE_LIMIT_BACKEND must be memory or redis")
+
+	cfg.RateLimit.Backend = "redis"
+	cfg.RateLimit.RedisURL = "localhost:6379"
+	assert.ErrorContains(t, cfg.Validate(), "RATE_LIMIT_REDIS_URL must be a redis:// URL")
+}
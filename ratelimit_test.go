@@ -0,0 +1,200 @@
+package main
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func rateLimitTestServer(t *testing.T) (*Server, http.Handler) {
+	t.Helper()
+	cfg := testConfig()
+	cfg.RateLimit.Auth = RateLimitRule{RPS: 0.5, Burst: 2}
+	cfg.RateLimit.Books = RateLimitRule{RPS: 1, Burst: 3}
+	s := newConfigTestServer(t, cfg, newMemoryTokenRepository())
+	return s, s.routes()
+}
+
+func getFrom(handler http.Handler, path, ip, accessToken string) *httptest.ResponseRecorder {
+	req, _ := http.NewRequest("GET", path, nil)
+	req.RemoteAddr = ip + ":52100"
+	if accessToken != "" {
+		req.Header.Set("Authorization", "Bearer "+accessToken)
+	}
+	rr := httptest.NewRecorder()
+	handler.ServeHTTP(rr, req)
+	return rr
+}
+
+func TestRateLimitPerClient(t *testing.T) {
+	t.Parallel()
+	_, handler := rateLimitTestServer(t)
+
+	for i := range 3 {
+		rr := getFrom(handler, "/books", "203.0.113.1", "")
+		assert.NotEqual(t, http.StatusTooManyRequests, rr.Code)
+		assert.Equal(t, "3", rr.Header().Get("RateLimit-Limit"))
+		assert.Equal(t, strconv.Itoa(2-i), rr.Header().Get("RateLimit-Remaining"))
+	}
+
+	rr := getFrom(handler, "/books", "203.0.113.1", "")
+	assert.Equal(t, http.StatusTooManyRequests, rr.Code, "Expected 429 after the burst")
+	assert.Equal(t, "1", rr.Header().Get("Retry-After"))
+	assert.Equal(t, "0", rr.Header().Get("RateLimit-Remaining"))
+	assert.Equal(t, "3", rr.Header().Get("RateLimit-Reset"))
+
+	// Другой клиент не делит ведро с первым
+	rr = getFrom(handler, "/books", "203.0.113.2", "")
+	assert.NotEqual(t, http.StatusTooManyRequests, rr.Code, "Another IP has its own bucket")
+}
+
+func TestClientIPBehindTrustedProxy(t *testing.T) {
+	t.Parallel()
+	cfg := testConfig()
+	cfg.Security.TrustedProxies = []string{"10.0.0.0/8", "192.0.2.10"}
+	s := newConfigTestServer(t, cfg, newMemoryTokenRepository())
+
+	ip := func(remote string, forwardedFor ...string) string {
+		req, _ := http.NewRequest("GET", "/books", nil)
+		req.RemoteAddr = remote
+		for _, value := range forwardedFor {
+			req.Header.Add("X-Forwarded-For", value)
+		}
+		return s.clientIP(req)
+	}
+	assert.Equal(t, "203.0.113.7", ip("10.1.2.3:443", "203.0.113.7"))
+	assert.Equal(t, "203.0.113.7", ip("10.1.2.3:443", "198.51.100.1, 203.0.113.7, 192.0.2.10"), "Trusted hops are skipped from the right")
+	assert.Equal(t, "203.0.113.7", ip("10.1.2.3:443", "198.51.100.1", "203.0.113.7"), "Several headers form one list")
+	assert.Equal(t, "10.1.2.3", ip("10.1.2.3:443"), "Without the header the proxy is the client")
+	assert.Equal(t, "10.1.2.3", ip("10.1.2.3:443", "not-an-ip"))
+	assert.Equal(t, "198.51.100.9", ip("198.51.100.9:5000", "203.0.113.7"), "Untrusted peers cannot choose their address")
+	assert.Equal(t, "::1", ip("[::1]:5000", "203.0.113.7"))
+
+	// За балансировщиком у каждого клиента своё ведро и свой счётчик входов
+	handler := s.routes()
+	login := func(forwardedFor string) int {
+		body := `{"email":"` + forwardedFor + `@example.com","password":"wrong-password"}`
+		req, _ := http.NewRequest("POST", "/login", strings.NewReader(body))
+		req.Header.Set("Content-Type", "application/json")
+		req.RemoteAddr = "10.0.0.1:443"
+		req.Header.Set("X-Forwarded-For", forwardedFor)
+		rr := httptest.NewRecorder()
+		handler.ServeHTTP(rr, req)
+		return rr.Code
+	}
+	limited := false
+	for range 50 {
+		if limited = login("203.0.113.1") == http.StatusTooManyRequests; limited {
+			break
+		}
+	}
+	assert.True(t, limited)
+	assert.Equal(t, http.StatusUnauthorized, login("203.0.113.2"), "Another client behind the balancer is not limited")
+}
+
+func TestRateLimitPerRoute(t *testing.T) {
+	t.Parallel()
+	_, handler := rateLimitTestServer(t)
+
+	for range 2 {
+		getFrom(handler, "/login", "203.0.113.1", "")
+	}
+	rr := getFrom(handler, "/register", "203.0.113.1", "")
+	assert.Equal(t, http.StatusTooManyRequests, rr.Code, "Login and registration share the strict bucket")
+	assert.Equal(t, "2", rr.Header().Get("Retry-After"))
+
+	rr = getFrom(handler, "/books", "203.0.113.1", "")
+	assert.NotEqual(t, http.StatusTooManyRequests, rr.Code, "The catalogue has its own bucket")
+
+	rr = getFrom(handler, "/healthz", "203.0.113.1", "")
+	assert.Empty(t, rr.Header().Get("RateLimit-Limit"), "Health checks are not limited")
+}
+
+func TestRateLimitPerUser(t *testing.T) {
+	t.Parallel()
+	s, handler := rateLimitTestServer(t)
+	pair := loginTestUser(t, s, handler)
+
+	// Пользователь со своим ведром не зависит от соседей по NAT
+	for range 3 {
+		getFrom(handler, "/books", "203.0.113.1", "")
+	}
+	rr := getFrom(handler, "/books", "203.0.113.1", pair.AccessToken)
+	assert.NotEqual(t, http.StatusTooManyRequests, rr.Code, "Signed-in users are counted by account")
+
+	// С поддельным токеном запрос считается по IP
+	rr = getFrom(handler, "/books", "203.0.113.1", "forged")
+	assert.Equal(t, http.StatusTooManyRequests, rr.Code, "An invalid token does not get a bucket")
+
+	for range 2 {
+		getFrom(handler, "/books", "198.51.100.7", pair.AccessToken)
+	}
+	rr = getFrom(handler, "/books", "198.51.100.8", pair.AccessToken)
+	assert.Equal(t, http.StatusTooManyRequests, rr.Code, "The user's bucket follows them across addresses")
+}
+
+func TestRateLimitPerCookieSession(t *testing.T) {
+	t.Parallel()
+	s, handler := rateLimitTestServer(t)
+	cookie := loginWithCookie(t, s, handler)
+
+	for range 3 {
+		getFrom(handler, "/books", "203.0.113.1", "")
+	}
+	req, _ := http.NewRequest("GET", "/books", nil)
+	req.RemoteAddr = "203.0.113.1:52100"
+	req.AddCookie(cookie)
+	rr := httptest.NewRecorder()
+	handler.ServeHTTP(rr, req)
+	assert.NotEqual(t, http.StatusTooManyRequests, rr.Code, "Cookie sessions are counted by account, not by NAT address")
+	assert.Equal(t, "2", rr.Header().Get("RateLimit-Remaining"))
+}
+
+func TestMemoryRateLimiterEviction(t *testing.T) {
+	limiter := newMemoryRateLimiter(10 * time.Minute)
+	now := time.Now()
+	limiter.now = func() time.Time { return now }
+	policy := rateLimitPolicy{name: "default", rps: 1, burst: 1}
+	ctx := context.Background()
+
+	limiter.Allow(ctx, "ip:203.0.113.1", policy)
+	now = now.Add(5 * time.Minute)
+	limiter.Allow(ctx, "ip:203.0.113.2", policy)
+	assert.Equal(t, 2, limiter.Len())
+
+	now = now.Add(6 * time.Minute)
+	result, err := limiter.Allow(ctx, "ip:203.0.113.3", policy)
+	assert.NoError(t, err)
+	assert.True(t, result.allowed)
+	assert.Equal(t, 2, limiter.Len(), "The idle bucket is evicted")
+
+	now = now.Add(10 * time.Minute)
+	limiter.Allow(ctx, "ip:203.0.113.3", policy)
+	assert.Equal(t, 1, limiter.Len())
+}
+
+func TestRatePolicy(t *testing.T) {
+	s := newMemoryTestServer(t)
+	for path, want := range map[string]string{
+		"/login":                 "auth",
+		"/login/2fa":             "auth",
+		"/password/reset":        "auth",
+		"/auth/google/callback":  "auth",
+		"/books":                 "books",
+		"/books/search":          "books",
+		"/book/the-hobbit":       "books",
+		"/bookstore":             "default",
+		"/api/profile":           "default",
+		"/registered-trademarks": "default",
+	} {
+		policy, limited := s.ratePolicy(path)
+		assert.True(t, limited, path)
+		assert.Equal(t, want, policy.name, path)
+	}
+}
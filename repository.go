@@ -0,0 +1,168 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"time"
+)
+
+// Ошибки репозиториев, не зависящие от конкретной базы
+var (
+	ErrNotFound    = errors.New("record not found")
+	ErrDuplicate   = errors.New("record already exists")
+	ErrInvalidSort = errors.New("invalid sort field")
+)
+
+// BookFilter — параметры фильтрации и сортировки для /books
+type BookFilter struct {
+	Title     string
+	Author    string
+	Published string
+	SortBy    string
+	SortOrder string
+}
+
+// bookSortFields — поля, по которым разрешена сортировка
+var bookSortFields = map[string]bool{
+	"id":        true,
+	"title":     true,
+	"author":    true,
+	"published": true,
+	"price":     true,
+	"stock":     true,
+}
+
+func (f BookFilter) validate() error {
+	if f.SortBy != "" && !bookSortFields[f.SortBy] {
+		return fmt.Errorf("%w: %q", ErrInvalidSort, f.SortBy)
+	}
+	return nil
+}
+
+type BookRepository interface {
+	List(ctx context.Context, filter BookFilter) ([]Book, error)
+	ListInStock(ctx context.Context) ([]Book, error)
+	// CountInStock считает книги в наличии и их экземпляры, не загружая строки
+	CountInStock(ctx context.Context) (titles, copies int64, err error)
+	ListFantasy(ctx context.Context, limit int) ([]Fantasy, error)
+	Get(ctx context.Context, id uint) (Book, error)
+	GetBySlug(ctx context.Context, slug string) (Book, error)
+	GetByTitle(ctx context.Context, title string) (Book, error)
+	Create(ctx context.Context, book *Book) error
+	// Save создаёт книгу или перезаписывает существующую целиком
+	Save(ctx context.Context, book *Book) error
+	Delete(ctx context.Context, id uint) error
+}
+
+type UserRepository interface {
+	Get(ctx context.Context, id uint) (User, error)
+	GetByEmail(ctx context.Context, email string) (User, error)
+	Create(ctx context.Context, user *User) error
+	Save(ctx context.Context, user *User) error
+	// Delete стирает пользователя вместе с его входами через провайдеров, 2FA и passkeys
+	Delete(ctx context.Context, id uint) error
+	GetIdentity(ctx context.Context, provider, subject string) (UserIdentity, error)
+	CreateIdentity(ctx context.Context, identity *UserIdentity) error
+	ListIdentities(ctx context.Context, userID uint) ([]UserIdentity, error)
+	GetTwoFactor(ctx context.Context, userID uint) (TwoFactor, error)
+	SaveTwoFactor(ctx context.Context, tf *TwoFactor) error
+	// DeleteTwoFactor удаляет секрет вместе с кодами восстановления
+	DeleteTwoFactor(ctx context.Context, userID uint) error
+	// UseTOTPStep запоминает принятый интервал; false, если он не новее прежнего
+	UseTOTPStep(ctx context.Context, userID uint, step int64) (bool, error)
+	ReplaceRecoveryCodes(ctx context.Context, userID uint, hashes []string) error
+	UseRecoveryCode(ctx context.Context, userID uint, hash string, at time.Time) (bool, error)
+	CountRecoveryCodes(ctx context.Context, userID uint) (int, error)
+	ListPasskeys(ctx context.Context, userID uint) ([]Passkey, error)
+	GetPasskeyByCredentialID(ctx context.Context, credentialID []byte) (Passkey, error)
+	CreatePasskey(ctx context.Context, passkey *Passkey) error
+	SavePasskey(ctx context.Context, passkey *Passkey) error
+	// DeletePasskey удаляет ключ, только если он принадлежит пользователю
+	DeletePasskey(ctx context.Context, userID, id uint) error
+}
+
+// TokenRepository хранит сессии, хеши refresh-токенов, denylist access-токенов, ключи подписи и API-ключи
+type TokenRepository interface {
+	CreateSession(ctx context.Context, session *Session) error
+	GetSession(ctx context.Context, id string) (Session, error)
+	RevokeSession(ctx context.Context, id string, at time.Time) error
+	// TouchSession запоминает время последнего запроса в сессии
+	TouchSession(ctx context.Context, id string, at time.Time) error
+	RevokeUserSessions(ctx context.Context, userID uint, at time.Time) error
+	ListUserSessions(ctx context.Context, userID uint) ([]Session, error)
+	// DeleteUserData удаляет сессии, refresh-токены и одноразовые токены пользователя
+	DeleteUserData(ctx context.Context, userID uint) error
+	CreateRefreshToken(ctx context.Context, token *RefreshToken) error
+	GetRefreshToken(ctx context.Context, hash string) (RefreshToken, error)
+	// UseRefreshToken помечает токен использованным; false — если он уже был использован
+	UseRefreshToken(ctx context.Context, id uint, at time.Time) (bool, error)
+	DenyToken(ctx context.Context, jti string, expiresAt time.Time) error
+	IsTokenDenied(ctx context.Context, jti string) (bool, error)
+	// DeleteExpired удаляет истёкшие записи, а также сессии и токены уже удалённых пользователей
+	DeleteExpired(ctx context.Context, now time.Time) error
+	// ListSigningKeys возвращает ключи подписи JWT, новые первыми
+	ListSigningKeys(ctx context.Context) ([]SigningKey, error)
+	CreateSigningKey(ctx context.Context, key *SigningKey) error
+	DeleteSigningKeysBefore(ctx context.Context, t time.Time) error
+	CreateUserToken(ctx context.Context, token *UserToken) error
+	GetUserToken(ctx context.Context, purpose, hash string) (UserToken, error)
+	// GetActiveUserToken возвращает последний неиспользованный токен пользователя
+	GetActiveUserToken(ctx context.Context, userID uint, purpose string) (UserToken, error)
+	AddUserTokenAttempt(ctx context.Context, id uint) error
+	UseUserToken(ctx context.Context, id uint, at time.Time) (bool, error)
+	DeleteUserTokens(ctx context.Context, userID uint, purpose string) error
+	CreateWebAuthnSession(ctx context.Context, session *WebAuthnSession) error
+	// TakeWebAuthnSession возвращает и сразу удаляет данные церемонии
+	TakeWebAuthnSession(ctx context.Context, id string) (WebAuthnSession, error)
+	CreateAPIKey(ctx context.Context, key *APIKey) error
+	GetAPIKeyByPrefix(ctx context.Context, prefix string) (APIKey, error)
+	// ListAPIKeys возвращает все ключи, включая отозванные, новые первыми
+	ListAPIKeys(ctx context.Context) ([]APIKey, error)
+	RevokeAPIKey(ctx context.Context, id uint, at time.Time) error
+	TouchAPIKey(ctx context.Context, id uint, at time.Time) error
+}
+
+// LogFilter — условия выборки журнала для /admin/logs. From включительно, To
+// исключительно; Field — имя поля записи, Value — его значение (пустое — любое)
+type LogFilter struct {
+	Levels   []string
+	From     time.Time
+	To       time.Time
+	Field    string
+	Value    string
+	Limit    int
+	BeforeID uint
+}
+
+type LogRepository interface {
+	CreateLogEntries(ctx context.Context, entries []LogEntry) error
+	// ListLogEntries возвращает записи по фильтру, новые первыми
+	ListLogEntries(ctx context.Context, filter LogFilter) ([]LogEntry, error)
+	DeleteLogEntriesBefore(ctx context.Context, t time.Time) error
+}
+
+// Pinger реализуют репозитории, у которых есть соединение для проверки в /healthz
+type Pinger interface {
+	Ping(ctx context.Context) error
+}
+
+// uniqueSlug строит slug из названия и автора и добавляет суффикс, пока taken сообщает о совпадении
+func uniqueSlug(book *Book, taken func(slug string) (bool, error)) (string, error) {
+	base := slugify(book.Title + " " + book.Author)
+	if base == "" {
+		base = "book"
+	}
+
+	slug := base
+	for i := 2; ; i++ {
+		exists, err := taken(slug)
+		if err != nil {
+			return "", err
+		}
+		if !exists {
+			return slug, nil
+		}
+		slug = fmt.Sprintf("%s-%d", base, i)
+	}
+}
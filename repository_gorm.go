@@ -0,0 +1,517 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"strings"
+	"time"
+
+	"gorm.io/gorm"
+)
+
+// Реализации репозиториев поверх GORM (SQLite или PostgreSQL)
+
+func gormError(err error) error {
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		return ErrNotFound
+	}
+	if errors.Is(err, gorm.ErrDuplicatedKey) {
+		return ErrDuplicate
+	}
+	return err
+}
+
+type gormBookRepository struct {
+	db *gorm.DB
+}
+
+func newGormBookRepository(db *gorm.DB) *gormBookRepository {
+	return &gormBookRepository{db: db}
+}
+
+func (r *gormBookRepository) Ping(ctx context.Context) error {
+	return pingDatabase(ctx, r.db)
+}
+
+func (r *gormBookRepository) List(ctx context.Context, filter BookFilter) ([]Book, error) {
+	if err := filter.validate(); err != nil {
+		return nil, err
+	}
+
+	query := r.db.WithContext(ctx).Model(&Book{})
+
+	// LOWER(...) — чтобы поиск был без учёта регистра и в SQLite, и в PostgreSQL
+	if filter.Title != "" {
+		query = query.Where("LOWER(title) LIKE LOWER(?)", "%"+filter.Title+"%")
+	}
+	if filter.Author != "" {
+		query = query.Where("LOWER(author) LIKE LOWER(?)", "%"+filter.Author+"%")
+	}
+	if filter.Published != "" {
+		query = query.Where("LOWER(published) LIKE LOWER(?)", "%"+filter.Published+"%")
+	}
+
+	if filter.SortBy != "" {
+		if strings.EqualFold(filter.SortOrder, "desc") {
+			query = query.Order(filter.SortBy + " DESC")
+		} else {
+			query = query.Order(filter.SortBy + " ASC")
+		}
+	} else {
+		query = query.Order("id")
+	}
+
+	var books []Book
+	if err := query.Find(&books).Error; err != nil {
+		return nil, err
+	}
+	return books, nil
+}
+
+func (r *gormBookRepository) ListInStock(ctx context.Context) ([]Book, error) {
+	var books []Book
+	err := r.db.WithContext(ctx).Where("stock > ?", 0).Order("id").Find(&books).Error
+	return books, err
+}
+
+func (r *gormBookRepository) CountInStock(ctx context.Context) (titles, copies int64, err error) {
+	var totals struct {
+		Titles int64
+		Copies int64
+	}
+	err = r.db.WithContext(ctx).Model(&Book{}).Where("stock > ?", 0).
+		Select("COUNT(*) AS titles, COALESCE(SUM(stock), 0) AS copies").Scan(&totals).Error
+	return totals.Titles, totals.Copies, err
+}
+
+func (r *gormBookRepository) ListFantasy(ctx context.Context, limit int) ([]Fantasy, error) {
+	var books []Fantasy
+	err := r.db.WithContext(ctx).Table("fantasy").Limit(limit).Find(&books).Error
+	return books, err
+}
+
+func (r *gormBookRepository) Get(ctx context.Context, id uint) (Book, error) {
+	var book Book
+	err := r.db.WithContext(ctx).First(&book, id).Error
+	return book, gormError(err)
+}
+
+func (r *gormBookRepository) GetBySlug(ctx context.Context, slug string) (Book, error) {
+	var book Book
+	err := r.db.WithContext(ctx).Where("slug = ?", slug).First(&book).Error
+	return book, gormError(err)
+}
+
+func (r *gormBookRepository) GetByTitle(ctx context.Context, title string) (Book, error) {
+	var book Book
+	err := r.db.WithContext(ctx).Where("title = ?", title).First(&book).Error
+	return book, gormError(err)
+}
+
+func (r *gormBookRepository) Create(ctx context.Context, book *Book) error {
+	return gormError(r.db.WithContext(ctx).Create(book).Error)
+}
+
+func (r *gormBookRepository) Save(ctx context.Context, book *Book) error {
+	return gormError(r.db.WithContext(ctx).Save(book).Error)
+}
+
+func (r *gormBookRepository) Delete(ctx context.Context, id uint) error {
+	return r.db.WithContext(ctx).Delete(&Book{}, id).Error
+}
+
+type gormUserRepository struct {
+	db *gorm.DB
+}
+
+func newGormUserRepository(db *gorm.DB) *gormUserRepository {
+	return &gormUserRepository{db: db}
+}
+
+func (r *gormUserRepository) Get(ctx context.Context, id uint) (User, error) {
+	var user User
+	err := r.db.WithContext(ctx).First(&user, id).Error
+	return user, gormError(err)
+}
+
+func (r *gormUserRepository) GetByEmail(ctx context.Context, email string) (User, error) {
+	var user User
+	err := r.db.WithContext(ctx).Where("email = ?", email).First(&user).Error
+	return user, gormError(err)
+}
+
+func (r *gormUserRepository) Create(ctx context.Context, user *User) error {
+	return gormError(r.db.WithContext(ctx).Create(user).Error)
+}
+
+func (r *gormUserRepository) Save(ctx context.Context, user *User) error {
+	return gormError(r.db.WithContext(ctx).Save(user).Error)
+}
+
+func (r *gormUserRepository) Delete(ctx context.Context, id uint) error {
+	return r.db.WithContext(ctx).Transaction(func(tx *gorm.DB) error {
+		for _, owned := range []any{&UserIdentity{}, &RecoveryCode{}, &TwoFactor{}, &Passkey{}} {
+			if err := tx.Where("user_id = ?", id).Delete(owned).Error; err != nil {
+				return err
+			}
+		}
+		result := tx.Delete(&User{}, id)
+		if result.Error == nil && result.RowsAffected == 0 {
+			return ErrNotFound
+		}
+		return result.Error
+	})
+}
+
+func (r *gormUserRepository) GetIdentity(ctx context.Context, provider, subject string) (UserIdentity, error) {
+	var identity UserIdentity
+	err := r.db.WithContext(ctx).Where("provider = ? AND subject = ?", provider, subject).First(&identity).Error
+	return identity, gormError(err)
+}
+
+func (r *gormUserRepository) CreateIdentity(ctx context.Context, identity *UserIdentity) error {
+	return gormError(r.db.WithContext(ctx).Create(identity).Error)
+}
+
+func (r *gormUserRepository) ListIdentities(ctx context.Context, userID uint) ([]UserIdentity, error) {
+	var identities []UserIdentity
+	err := r.db.WithContext(ctx).Where("user_id = ?", userID).Order("id").Find(&identities).Error
+	return identities, err
+}
+
+func (r *gormUserRepository) GetTwoFactor(ctx context.Context, userID uint) (TwoFactor, error) {
+	var tf TwoFactor
+	err := r.db.WithContext(ctx).Where("user_id = ?", userID).First(&tf).Error
+	return tf, gormError(err)
+}
+
+func (r *gormUserRepository) SaveTwoFactor(ctx context.Context, tf *TwoFactor) error {
+	return gormError(r.db.WithContext(ctx).Save(tf).Error)
+}
+
+func (r *gormUserRepository) DeleteTwoFactor(ctx context.Context, userID uint) error {
+	return r.db.WithContext(ctx).Transaction(func(tx *gorm.DB) error {
+		if err := tx.Where("user_id = ?", userID).Delete(&RecoveryCode{}).Error; err != nil {
+			return err
+		}
+		return tx.Where("user_id = ?", userID).Delete(&TwoFactor{}).Error
+	})
+}
+
+func (r *gormUserRepository) UseTOTPStep(ctx context.Context, userID uint, step int64) (bool, error) {
+	result := r.db.WithContext(ctx).Model(&TwoFactor{}).
+		Where("user_id = ? AND last_step < ?", userID, step).Update("last_step", step)
+	return result.RowsAffected == 1, result.Error
+}
+
+func (r *gormUserRepository) ReplaceRecoveryCodes(ctx context.Context, userID uint, hashes []string) error {
+	return r.db.WithContext(ctx).Transaction(func(tx *gorm.DB) error {
+		if err := tx.Where("user_id = ?", userID).Delete(&RecoveryCode{}).Error; err != nil {
+			return err
+		}
+		codes := make([]RecoveryCode, len(hashes))
+		for i, hash := range hashes {
+			codes[i] = RecoveryCode{UserID: userID, CodeHash: hash}
+		}
+		return gormError(tx.Create(&codes).Error)
+	})
+}
+
+func (r *gormUserRepository) UseRecoveryCode(ctx context.Context, userID uint, hash string, at time.Time) (bool, error) {
+	result := r.db.WithContext(ctx).Model(&RecoveryCode{}).
+		Where("user_id = ? AND code_hash = ? AND used_at IS NULL", userID, hash).Update("used_at", at)
+	return result.RowsAffected == 1, result.Error
+}
+
+func (r *gormUserRepository) CountRecoveryCodes(ctx context.Context, userID uint) (int, error) {
+	var count int64
+	err := r.db.WithContext(ctx).Model(&RecoveryCode{}).Where("user_id = ? AND used_at IS NULL", userID).Count(&count).Error
+	return int(count), err
+}
+
+func (r *gormUserRepository) ListPasskeys(ctx context.Context, userID uint) ([]Passkey, error) {
+	var passkeys []Passkey
+	err := r.db.WithContext(ctx).Where("user_id = ?", userID).Order("id").Find(&passkeys).Error
+	return passkeys, err
+}
+
+func (r *gormUserRepository) GetPasskeyByCredentialID(ctx context.Context, credentialID []byte) (Passkey, error) {
+	var passkey Passkey
+	err := r.db.WithContext(ctx).Where("credential_id = ?", credentialID).First(&passkey).Error
+	return passkey, gormError(err)
+}
+
+func (r *gormUserRepository) CreatePasskey(ctx context.Context, passkey *Passkey) error {
+	return gormError(r.db.WithContext(ctx).Create(passkey).Error)
+}
+
+func (r *gormUserRepository) SavePasskey(ctx context.Context, passkey *Passkey) error {
+	return gormError(r.db.WithContext(ctx).Save(passkey).Error)
+}
+
+func (r *gormUserRepository) DeletePasskey(ctx context.Context, userID, id uint) error {
+	result := r.db.WithContext(ctx).Where("id = ? AND user_id = ?", id, userID).Delete(&Passkey{})
+	if result.Error != nil {
+		return result.Error
+	}
+	if result.RowsAffected == 0 {
+		return ErrNotFound
+	}
+	return nil
+}
+
+type gormTokenRepository struct {
+	db *gorm.DB
+}
+
+func newGormTokenRepository(db *gorm.DB) *gormTokenRepository {
+	return &gormTokenRepository{db: db}
+}
+
+func (r *gormTokenRepository) CreateSession(ctx context.Context, session *Session) error {
+	return gormError(r.db.WithContext(ctx).Create(session).Error)
+}
+
+func (r *gormTokenRepository) GetSession(ctx context.Context, id string) (Session, error) {
+	var session Session
+	err := r.db.WithContext(ctx).Where("id = ?", id).First(&session).Error
+	return session, gormError(err)
+}
+
+func (r *gormTokenRepository) RevokeSession(ctx context.Context, id string, at time.Time) error {
+	return r.db.WithContext(ctx).Model(&Session{}).
+		Where("id = ? AND revoked_at IS NULL", id).Update("revoked_at", at).Error
+}
+
+func (r *gormTokenRepository) TouchSession(ctx context.Context, id string, at time.Time) error {
+	return r.db.WithContext(ctx).Model(&Session{}).Where("id = ?", id).Update("last_seen_at", at).Error
+}
+
+func (r *gormTokenRepository) RevokeUserSessions(ctx context.Context, userID uint, at time.Time) error {
+	return r.db.WithContext(ctx).Model(&Session{}).
+		Where("user_id = ? AND revoked_at IS NULL", userID).Update("revoked_at", at).Error
+}
+
+func (r *gormTokenRepository) ListUserSessions(ctx context.Context, userID uint) ([]Session, error) {
+	var sessions []Session
+	err := r.db.WithContext(ctx).Where("user_id = ?", userID).Order("created_at").Find(&sessions).Error
+	return sessions, err
+}
+
+func (r *gormTokenRepository) DeleteUserData(ctx context.Context, userID uint) error {
+	return r.db.WithContext(ctx).Transaction(func(tx *gorm.DB) error {
+		sessions := tx.Model(&Session{}).Select("id").Where("user_id = ?", userID)
+		if err := tx.Where("session_id IN (?)", sessions).Delete(&RefreshToken{}).Error; err != nil {
+			return err
+		}
+		if err := tx.Where("user_id = ?", userID).Delete(&Session{}).Error; err != nil {
+			return err
+		}
+		if err := tx.Where("user_id = ?", userID).Delete(&WebAuthnSession{}).Error; err != nil {
+			return err
+		}
+		return tx.Where("user_id = ?", userID).Delete(&UserToken{}).Error
+	})
+}
+
+func (r *gormTokenRepository) CreateRefreshToken(ctx context.Context, token *RefreshToken) error {
+	return gormError(r.db.WithContext(ctx).Create(token).Error)
+}
+
+func (r *gormTokenRepository) GetRefreshToken(ctx context.Context, hash string) (RefreshToken, error) {
+	var token RefreshToken
+	err := r.db.WithContext(ctx).Where("token_hash = ?", hash).First(&token).Error
+	return token, gormError(err)
+}
+
+func (r *gormTokenRepository) UseRefreshToken(ctx context.Context, id uint, at time.Time) (bool, error) {
+	// Условное обновление: из двух одновременных запросов выиграет только один
+	result := r.db.WithContext(ctx).Model(&RefreshToken{}).
+		Where("id = ? AND used_at IS NULL", id).Update("used_at", at)
+	return result.RowsAffected == 1, result.Error
+}
+
+func (r *gormTokenRepository) DenyToken(ctx context.Context, jti string, expiresAt time.Time) error {
+	return r.db.WithContext(ctx).Save(&DeniedToken{JTI: jti, ExpiresAt: expiresAt}).Error
+}
+
+func (r *gormTokenRepository) IsTokenDenied(ctx context.Context, jti string) (bool, error) {
+	var count int64
+	err := r.db.WithContext(ctx).Model(&DeniedToken{}).Where("jti = ?", jti).Count(&count).Error
+	return count > 0, err
+}
+
+func (r *gormTokenRepository) DeleteExpired(ctx context.Context, now time.Time) error {
+	if err := r.db.WithContext(ctx).Where("expires_at < ?", now).Delete(&DeniedToken{}).Error; err != nil {
+		return err
+	}
+	if err := r.db.WithContext(ctx).Where("expires_at < ?", now).Delete(&UserToken{}).Error; err != nil {
+		return err
+	}
+	if err := r.db.WithContext(ctx).Where("expires_at < ?", now).Delete(&WebAuthnSession{}).Error; err != nil {
+		return err
+	}
+	if err := r.db.WithContext(ctx).Where("expires_at < ?", now).Delete(&RefreshToken{}).Error; err != nil {
+		return err
+	}
+	return r.deleteOrphans(ctx)
+}
+
+// deleteOrphans удаляет данные пользователей, которых уже нет: eraseUser
+// удаляет токены после пользователя и при сбое оставляет их здесь
+func (r *gormTokenRepository) deleteOrphans(ctx context.Context) error {
+	return r.db.WithContext(ctx).Transaction(func(tx *gorm.DB) error {
+		users := tx.Model(&User{}).Select("id")
+		sessions := tx.Model(&Session{}).Select("id").Where("user_id NOT IN (?)", users)
+		if err := tx.Where("session_id IN (?)", sessions).Delete(&RefreshToken{}).Error; err != nil {
+			return err
+		}
+		if err := tx.Where("user_id NOT IN (?)", users).Delete(&Session{}).Error; err != nil {
+			return err
+		}
+		return tx.Where("user_id NOT IN (?)", users).Delete(&UserToken{}).Error
+	})
+}
+
+func (r *gormTokenRepository) ListSigningKeys(ctx context.Context) ([]SigningKey, error) {
+	var keys []SigningKey
+	err := r.db.WithContext(ctx).Order("created_at DESC").Find(&keys).Error
+	return keys, err
+}
+
+func (r *gormTokenRepository) CreateSigningKey(ctx context.Context, key *SigningKey) error {
+	return gormError(r.db.WithContext(ctx).Create(key).Error)
+}
+
+func (r *gormTokenRepository) DeleteSigningKeysBefore(ctx context.Context, t time.Time) error {
+	return r.db.WithContext(ctx).Where("created_at < ?", t).Delete(&SigningKey{}).Error
+}
+
+func (r *gormTokenRepository) CreateUserToken(ctx context.Context, token *UserToken) error {
+	return gormError(r.db.WithContext(ctx).Create(token).Error)
+}
+
+func (r *gormTokenRepository) GetUserToken(ctx context.Context, purpose, hash string) (UserToken, error) {
+	var token UserToken
+	err := r.db.WithContext(ctx).Where("purpose = ? AND token_hash = ?", purpose, hash).First(&token).Error
+	return token, gormError(err)
+}
+
+func (r *gormTokenRepository) GetActiveUserToken(ctx context.Context, userID uint, purpose string) (UserToken, error) {
+	var token UserToken
+	err := r.db.WithContext(ctx).
+		Where("user_id = ? AND purpose = ? AND used_at IS NULL", userID, purpose).
+		Order("id DESC").First(&token).Error
+	return token, gormError(err)
+}
+
+func (r *gormTokenRepository) AddUserTokenAttempt(ctx context.Context, id uint) error {
+	return r.db.WithContext(ctx).Model(&UserToken{}).Where("id = ?", id).
+		Update("attempts", gorm.Expr("attempts + 1")).Error
+}
+
+func (r *gormTokenRepository) UseUserToken(ctx context.Context, id uint, at time.Time) (bool, error) {
+	result := r.db.WithContext(ctx).Model(&UserToken{}).
+		Where("id = ? AND used_at IS NULL", id).Update("used_at", at)
+	return result.RowsAffected == 1, result.Error
+}
+
+func (r *gormTokenRepository) DeleteUserTokens(ctx context.Context, userID uint, purpose string) error {
+	return r.db.WithContext(ctx).Where("user_id = ? AND purpose = ?", userID, purpose).Delete(&UserToken{}).Error
+}
+
+func (r *gormTokenRepository) CreateWebAuthnSession(ctx context.Context, session *WebAuthnSession) error {
+	return gormError(r.db.WithContext(ctx).Create(session).Error)
+}
+
+func (r *gormTokenRepository) TakeWebAuthnSession(ctx context.Context, id string) (WebAuthnSession, error) {
+	var session WebAuthnSession
+	err := r.db.WithContext(ctx).Transaction(func(tx *gorm.DB) error {
+		if err := tx.Where("id = ?", id).First(&session).Error; err != nil {
+			return err
+		}
+		// Удаление решает гонку: завершить церемонию сможет только один запрос
+		result := tx.Where("id = ?", id).Delete(&WebAuthnSession{})
+		if result.Error == nil && result.RowsAffected == 0 {
+			return gorm.ErrRecordNotFound
+		}
+		return result.Error
+	})
+	return session, gormError(err)
+}
+
+func (r *gormTokenRepository) CreateAPIKey(ctx context.Context, key *APIKey) error {
+	return gormError(r.db.WithContext(ctx).Create(key).Error)
+}
+
+func (r *gormTokenRepository) GetAPIKeyByPrefix(ctx context.Context, prefix string) (APIKey, error) {
+	var key APIKey
+	err := r.db.WithContext(ctx).Where("prefix = ?", prefix).First(&key).Error
+	return key, gormError(err)
+}
+
+func (r *gormTokenRepository) ListAPIKeys(ctx context.Context) ([]APIKey, error) {
+	var keys []APIKey
+	err := r.db.WithContext(ctx).Order("id DESC").Find(&keys).Error
+	return keys, err
+}
+
+func (r *gormTokenRepository) RevokeAPIKey(ctx context.Context, id uint, at time.Time) error {
+	var key APIKey
+	if err := r.db.WithContext(ctx).First(&key, id).Error; err != nil {
+		return gormError(err)
+	}
+	if key.RevokedAt != nil {
+		return nil
+	}
+	return r.db.WithContext(ctx).Model(&key).Update("revoked_at", at).Error
+}
+
+func (r *gormTokenRepository) TouchAPIKey(ctx context.Context, id uint, at time.Time) error {
+	return r.db.WithContext(ctx).Model(&APIKey{}).Where("id = ?", id).Update("last_used_at", at).Error
+}
+
+type gormLogRepository struct {
+	db *gorm.DB
+}
+
+func newGormLogRepository(db *gorm.DB) *gormLogRepository {
+	return &gormLogRepository{db: db}
+}
+
+func (r *gormLogRepository) CreateLogEntries(ctx context.Context, entries []LogEntry) error {
+	return r.db.WithContext(ctx).CreateInBatches(entries, 100).Error
+}
+
+// ListLogEntries ищет поле по JSON-тексту через LIKE, поэтому работает и в
+// SQLite, и в PostgreSQL без JSON-функций конкретной базы
+func (r *gormLogRepository) ListLogEntries(ctx context.Context, filter LogFilter) ([]LogEntry, error) {
+	q := r.db.WithContext(ctx).Order("id DESC").Limit(filter.Limit)
+	if len(filter.Levels) > 0 {
+		q = q.Where("level IN ?", filter.Levels)
+	}
+	if !filter.From.IsZero() {
+		q = q.Where("timestamp >= ?", filter.From.UTC())
+	}
+	if !filter.To.IsZero() {
+		q = q.Where("timestamp < ?", filter.To.UTC())
+	}
+	if filter.BeforeID != 0 {
+		q = q.Where("id < ?", filter.BeforeID)
+	}
+	if filter.Field != "" {
+		patterns := logFieldPatterns(filter.Field, filter.Value)
+		cond := r.db.Where(`fields LIKE ? ESCAPE '\'`, patterns[0])
+		for _, p := range patterns[1:] {
+			cond = cond.Or(`fields LIKE ? ESCAPE '\'`, p)
+		}
+		q = q.Where(cond)
+	}
+	var entries []LogEntry
+	err := q.Find(&entries).Error
+	return entries, err
+}
+
+func (r *gormLogRepository) DeleteLogEntriesBefore(ctx context.Context, t time.Time) error {
+	return r.db.WithContext(ctx).Where("timestamp < ?", t.UTC()).Delete(&LogEntry{}).Error
+}
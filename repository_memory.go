@@ -0,0 +1,931 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"slices"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+// In-memory реализации репозиториев: для тестов и запуска без базы.
+// Повторяют поведение GORM-реализаций, включая генерацию slug.
+
+type memoryBookRepository struct {
+	mu      sync.RWMutex
+	nextID  uint
+	books   map[uint]Book
+	fantasy []Fantasy
+}
+
+func newMemoryBookRepository() *memoryBookRepository {
+	return &memoryBookRepository{nextID: 1, books: map[uint]Book{}}
+}
+
+func containsFold(s, substr string) bool {
+	return strings.Contains(strings.ToLower(s), strings.ToLower(substr))
+}
+
+func (r *memoryBookRepository) List(ctx context.Context, filter BookFilter) ([]Book, error) {
+	if err := filter.validate(); err != nil {
+		return nil, err
+	}
+
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+
+	books := []Book{}
+	for _, book := range r.books {
+		if filter.Title != "" && !containsFold(book.Title, filter.Title) {
+			continue
+		}
+		if filter.Author != "" && !containsFold(book.Author, filter.Author) {
+			continue
+		}
+		if filter.Published != "" && !containsFold(book.Published, filter.Published) {
+			continue
+		}
+		books = append(books, book)
+	}
+
+	less := func(a, b Book) bool { return a.ID < b.ID }
+	switch filter.SortBy {
+	case "title":
+		less = func(a, b Book) bool { return a.Title < b.Title }
+	case "author":
+		less = func(a, b Book) bool { return a.Author < b.Author }
+	case "published":
+		less = func(a, b Book) bool { return a.Published < b.Published }
+	case "price":
+		less = func(a, b Book) bool { return a.Price < b.Price }
+	case "stock":
+		less = func(a, b Book) bool { return a.Stock < b.Stock }
+	}
+	desc := filter.SortBy != "" && strings.EqualFold(filter.SortOrder, "desc")
+	sort.SliceStable(books, func(i, j int) bool {
+		if desc {
+			return less(books[j], books[i])
+		}
+		return less(books[i], books[j])
+	})
+	return books, nil
+}
+
+func (r *memoryBookRepository) ListInStock(ctx context.Context) ([]Book, error) {
+	all, _ := r.List(ctx, BookFilter{})
+	books := []Book{}
+	for _, book := range all {
+		if book.Stock > 0 {
+			books = append(books, book)
+		}
+	}
+	return books, nil
+}
+
+func (r *memoryBookRepository) CountInStock(ctx context.Context) (titles, copies int64, err error) {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+	for _, book := range r.books {
+		if book.Stock > 0 {
+			titles++
+			copies += int64(book.Stock)
+		}
+	}
+	return titles, copies, nil
+}
+
+func (r *memoryBookRepository) ListFantasy(ctx context.Context, limit int) ([]Fantasy, error) {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+	if limit > len(r.fantasy) {
+		limit = len(r.fantasy)
+	}
+	return append([]Fantasy(nil), r.fantasy[:limit]...), nil
+}
+
+func (r *memoryBookRepository) Get(ctx context.Context, id uint) (Book, error) {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+	book, ok := r.books[id]
+	if !ok {
+		return Book{}, ErrNotFound
+	}
+	return book, nil
+}
+
+func (r *memoryBookRepository) find(match func(Book) bool) (Book, error) {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+	for id := uint(1); id < r.nextID; id++ {
+		if book, ok := r.books[id]; ok && match(book) {
+			return book, nil
+		}
+	}
+	return Book{}, ErrNotFound
+}
+
+func (r *memoryBookRepository) GetBySlug(ctx context.Context, slug string) (Book, error) {
+	return r.find(func(b Book) bool { return b.Slug == slug })
+}
+
+func (r *memoryBookRepository) GetByTitle(ctx context.Context, title string) (Book, error) {
+	return r.find(func(b Book) bool { return b.Title == title })
+}
+
+// assignSlug вызывается под r.mu
+func (r *memoryBookRepository) assignSlug(book *Book) error {
+	if book.Slug != "" {
+		// как уникальный индекс в базе
+		for id, other := range r.books {
+			if id != book.ID && other.Slug == book.Slug {
+				return ErrDuplicate
+			}
+		}
+		return nil
+	}
+	slug, err := uniqueSlug(book, func(slug string) (bool, error) {
+		for id, other := range r.books {
+			if id != book.ID && other.Slug == slug {
+				return true, nil
+			}
+		}
+		return false, nil
+	})
+	if err != nil {
+		return fmt.Errorf("failed to generate slug: %w", err)
+	}
+	book.Slug = slug
+	return nil
+}
+
+func (r *memoryBookRepository) Create(ctx context.Context, book *Book) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if book.ID != 0 {
+		if _, ok := r.books[book.ID]; ok {
+			return ErrDuplicate
+		}
+	} else {
+		book.ID = r.nextID
+	}
+	if err := r.assignSlug(book); err != nil {
+		return err
+	}
+	if book.ID >= r.nextID {
+		r.nextID = book.ID + 1
+	}
+	r.books[book.ID] = *book
+	return nil
+}
+
+func (r *memoryBookRepository) Save(ctx context.Context, book *Book) error {
+	if book.ID == 0 {
+		return r.Create(ctx, book)
+	}
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if err := r.assignSlug(book); err != nil {
+		return err
+	}
+	if book.ID >= r.nextID {
+		r.nextID = book.ID + 1
+	}
+	r.books[book.ID] = *book
+	return nil
+}
+
+func (r *memoryBookRepository) Delete(ctx context.Context, id uint) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	delete(r.books, id)
+	return nil
+}
+
+type memoryUserRepository struct {
+	mu         sync.RWMutex
+	nextID     uint
+	users      map[uint]User
+	identities []UserIdentity
+	twoFactor  map[uint]TwoFactor
+	recovery   []RecoveryCode
+	passkeys   map[uint]Passkey
+	nextKeyID  uint
+}
+
+func newMemoryUserRepository() *memoryUserRepository {
+	return &memoryUserRepository{
+		nextID:    1,
+		users:     map[uint]User{},
+		twoFactor: map[uint]TwoFactor{},
+		passkeys:  map[uint]Passkey{},
+		nextKeyID: 1,
+	}
+}
+
+func (r *memoryUserRepository) find(match func(User) bool) (User, error) {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+	for _, user := range r.users {
+		if match(user) {
+			return user, nil
+		}
+	}
+	return User{}, ErrNotFound
+}
+
+func (r *memoryUserRepository) Get(ctx context.Context, id uint) (User, error) {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+	user, ok := r.users[id]
+	if !ok {
+		return User{}, ErrNotFound
+	}
+	return user, nil
+}
+
+func (r *memoryUserRepository) GetByEmail(ctx context.Context, email string) (User, error) {
+	return r.find(func(u User) bool { return u.Email == email })
+}
+
+// conflicts проверяет уникальные поля, как это делают ограничения в базе; вызывается под r.mu
+func (r *memoryUserRepository) conflicts(user *User) bool {
+	for id, other := range r.users {
+		if id == user.ID {
+			continue
+		}
+		if other.Email == user.Email {
+			return true
+		}
+	}
+	return false
+}
+
+func (r *memoryUserRepository) Create(ctx context.Context, user *User) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if user.ID == 0 {
+		user.ID = r.nextID
+	}
+	if _, ok := r.users[user.ID]; ok || r.conflicts(user) {
+		return ErrDuplicate
+	}
+	if user.ID >= r.nextID {
+		r.nextID = user.ID + 1
+	}
+	r.users[user.ID] = *user
+	return nil
+}
+
+func (r *memoryUserRepository) Save(ctx context.Context, user *User) error {
+	if user.ID == 0 {
+		return r.Create(ctx, user)
+	}
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if r.conflicts(user) {
+		return ErrDuplicate
+	}
+	if user.ID >= r.nextID {
+		r.nextID = user.ID + 1
+	}
+	r.users[user.ID] = *user
+	return nil
+}
+
+func (r *memoryUserRepository) Delete(ctx context.Context, id uint) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if _, ok := r.users[id]; !ok {
+		return ErrNotFound
+	}
+	delete(r.users, id)
+	delete(r.twoFactor, id)
+	r.deleteRecoveryCodes(id)
+	kept := r.identities[:0]
+	for _, identity := range r.identities {
+		if identity.UserID != id {
+			kept = append(kept, identity)
+		}
+	}
+	r.identities = kept
+	for key, passkey := range r.passkeys {
+		if passkey.UserID == id {
+			delete(r.passkeys, key)
+		}
+	}
+	return nil
+}
+
+func (r *memoryUserRepository) GetIdentity(ctx context.Context, provider, subject string) (UserIdentity, error) {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+	for _, identity := range r.identities {
+		if identity.Provider == provider && identity.Subject == subject {
+			return identity, nil
+		}
+	}
+	return UserIdentity{}, ErrNotFound
+}
+
+func (r *memoryUserRepository) CreateIdentity(ctx context.Context, identity *UserIdentity) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	for _, other := range r.identities {
+		if other.Provider == identity.Provider && other.Subject == identity.Subject {
+			return ErrDuplicate
+		}
+	}
+	identity.ID = uint(len(r.identities) + 1)
+	r.identities = append(r.identities, *identity)
+	return nil
+}
+
+func (r *memoryUserRepository) ListIdentities(ctx context.Context, userID uint) ([]UserIdentity, error) {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+	var identities []UserIdentity
+	for _, identity := range r.identities {
+		if identity.UserID == userID {
+			identities = append(identities, identity)
+		}
+	}
+	return identities, nil
+}
+
+func (r *memoryUserRepository) GetTwoFactor(ctx context.Context, userID uint) (TwoFactor, error) {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+	tf, ok := r.twoFactor[userID]
+	if !ok {
+		return TwoFactor{}, ErrNotFound
+	}
+	return tf, nil
+}
+
+func (r *memoryUserRepository) SaveTwoFactor(ctx context.Context, tf *TwoFactor) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.twoFactor[tf.UserID] = *tf
+	return nil
+}
+
+func (r *memoryUserRepository) DeleteTwoFactor(ctx context.Context, userID uint) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	delete(r.twoFactor, userID)
+	r.deleteRecoveryCodes(userID)
+	return nil
+}
+
+func (r *memoryUserRepository) UseTOTPStep(ctx context.Context, userID uint, step int64) (bool, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	tf, ok := r.twoFactor[userID]
+	if !ok || tf.LastStep >= step {
+		return false, nil
+	}
+	tf.LastStep = step
+	r.twoFactor[userID] = tf
+	return true, nil
+}
+
+// deleteRecoveryCodes вызывается под r.mu
+func (r *memoryUserRepository) deleteRecoveryCodes(userID uint) {
+	kept := r.recovery[:0]
+	for _, code := range r.recovery {
+		if code.UserID != userID {
+			kept = append(kept, code)
+		}
+	}
+	r.recovery = kept
+}
+
+func (r *memoryUserRepository) ReplaceRecoveryCodes(ctx context.Context, userID uint, hashes []string) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.deleteRecoveryCodes(userID)
+	for _, hash := range hashes {
+		r.recovery = append(r.recovery, RecoveryCode{ID: uint(len(r.recovery) + 1), UserID: userID, CodeHash: hash})
+	}
+	return nil
+}
+
+func (r *memoryUserRepository) UseRecoveryCode(ctx context.Context, userID uint, hash string, at time.Time) (bool, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	for i, code := range r.recovery {
+		if code.UserID == userID && code.CodeHash == hash && code.UsedAt == nil {
+			r.recovery[i].UsedAt = &at
+			return true, nil
+		}
+	}
+	return false, nil
+}
+
+func (r *memoryUserRepository) CountRecoveryCodes(ctx context.Context, userID uint) (int, error) {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+	count := 0
+	for _, code := range r.recovery {
+		if code.UserID == userID && code.UsedAt == nil {
+			count++
+		}
+	}
+	return count, nil
+}
+
+func (r *memoryUserRepository) ListPasskeys(ctx context.Context, userID uint) ([]Passkey, error) {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+	var passkeys []Passkey
+	for _, passkey := range r.passkeys {
+		if passkey.UserID == userID {
+			passkeys = append(passkeys, passkey)
+		}
+	}
+	sort.Slice(passkeys, func(i, j int) bool { return passkeys[i].ID < passkeys[j].ID })
+	return passkeys, nil
+}
+
+func (r *memoryUserRepository) GetPasskeyByCredentialID(ctx context.Context, credentialID []byte) (Passkey, error) {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+	for _, passkey := range r.passkeys {
+		if bytes.Equal(passkey.CredentialID, credentialID) {
+			return passkey, nil
+		}
+	}
+	return Passkey{}, ErrNotFound
+}
+
+func (r *memoryUserRepository) CreatePasskey(ctx context.Context, passkey *Passkey) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	for _, other := range r.passkeys {
+		if bytes.Equal(other.CredentialID, passkey.CredentialID) {
+			return ErrDuplicate
+		}
+	}
+	passkey.ID = r.nextKeyID
+	r.nextKeyID++
+	r.passkeys[passkey.ID] = *passkey
+	return nil
+}
+
+func (r *memoryUserRepository) SavePasskey(ctx context.Context, passkey *Passkey) error {
+	if passkey.ID == 0 {
+		return r.CreatePasskey(ctx, passkey)
+	}
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.passkeys[passkey.ID] = *passkey
+	return nil
+}
+
+func (r *memoryUserRepository) DeletePasskey(ctx context.Context, userID, id uint) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	passkey, ok := r.passkeys[id]
+	if !ok || passkey.UserID != userID {
+		return ErrNotFound
+	}
+	delete(r.passkeys, id)
+	return nil
+}
+
+type memoryTokenRepository struct {
+	mu       sync.Mutex
+	nextID   uint
+	sessions map[string]Session
+	refresh  map[uint]RefreshToken
+	denied   map[string]time.Time
+	keys     []SigningKey
+	user     map[uint]UserToken
+	webauthn map[string]WebAuthnSession
+	apiKeys  map[uint]APIKey
+}
+
+func newMemoryTokenRepository() *memoryTokenRepository {
+	return &memoryTokenRepository{
+		nextID:   1,
+		sessions: map[string]Session{},
+		refresh:  map[uint]RefreshToken{},
+		denied:   map[string]time.Time{},
+		user:     map[uint]UserToken{},
+		webauthn: map[string]WebAuthnSession{},
+		apiKeys:  map[uint]APIKey{},
+	}
+}
+
+func (r *memoryTokenRepository) CreateSession(ctx context.Context, session *Session) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if _, ok := r.sessions[session.ID]; ok {
+		return ErrDuplicate
+	}
+	r.sessions[session.ID] = *session
+	return nil
+}
+
+func (r *memoryTokenRepository) GetSession(ctx context.Context, id string) (Session, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	session, ok := r.sessions[id]
+	if !ok {
+		return Session{}, ErrNotFound
+	}
+	return session, nil
+}
+
+func (r *memoryTokenRepository) RevokeSession(ctx context.Context, id string, at time.Time) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if session, ok := r.sessions[id]; ok && session.RevokedAt == nil {
+		session.RevokedAt = &at
+		r.sessions[id] = session
+	}
+	return nil
+}
+
+func (r *memoryTokenRepository) TouchSession(ctx context.Context, id string, at time.Time) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if session, ok := r.sessions[id]; ok {
+		session.LastSeenAt = &at
+		r.sessions[id] = session
+	}
+	return nil
+}
+
+func (r *memoryTokenRepository) RevokeUserSessions(ctx context.Context, userID uint, at time.Time) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	for id, session := range r.sessions {
+		if session.UserID == userID && session.RevokedAt == nil {
+			session.RevokedAt = &at
+			r.sessions[id] = session
+		}
+	}
+	return nil
+}
+
+func (r *memoryTokenRepository) ListUserSessions(ctx context.Context, userID uint) ([]Session, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	var sessions []Session
+	for _, session := range r.sessions {
+		if session.UserID == userID {
+			sessions = append(sessions, session)
+		}
+	}
+	sort.Slice(sessions, func(i, j int) bool { return sessions[i].CreatedAt.Before(sessions[j].CreatedAt) })
+	return sessions, nil
+}
+
+func (r *memoryTokenRepository) DeleteUserData(ctx context.Context, userID uint) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	for id, session := range r.sessions {
+		if session.UserID != userID {
+			continue
+		}
+		for tokenID, token := range r.refresh {
+			if token.SessionID == id {
+				delete(r.refresh, tokenID)
+			}
+		}
+		delete(r.sessions, id)
+	}
+	for id, session := range r.webauthn {
+		if session.UserID == userID {
+			delete(r.webauthn, id)
+		}
+	}
+	for id, token := range r.user {
+		if token.UserID == userID {
+			delete(r.user, id)
+		}
+	}
+	return nil
+}
+
+func (r *memoryTokenRepository) CreateRefreshToken(ctx context.Context, token *RefreshToken) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	for _, other := range r.refresh {
+		if other.TokenHash == token.TokenHash {
+			return ErrDuplicate
+		}
+	}
+	token.ID = r.nextID
+	r.nextID++
+	r.refresh[token.ID] = *token
+	return nil
+}
+
+func (r *memoryTokenRepository) GetRefreshToken(ctx context.Context, hash string) (RefreshToken, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	for _, token := range r.refresh {
+		if token.TokenHash == hash {
+			return token, nil
+		}
+	}
+	return RefreshToken{}, ErrNotFound
+}
+
+func (r *memoryTokenRepository) UseRefreshToken(ctx context.Context, id uint, at time.Time) (bool, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	token, ok := r.refresh[id]
+	if !ok || token.UsedAt != nil {
+		return false, nil
+	}
+	token.UsedAt = &at
+	r.refresh[id] = token
+	return true, nil
+}
+
+func (r *memoryTokenRepository) DenyToken(ctx context.Context, jti string, expiresAt time.Time) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.denied[jti] = expiresAt
+	return nil
+}
+
+func (r *memoryTokenRepository) IsTokenDenied(ctx context.Context, jti string) (bool, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	_, ok := r.denied[jti]
+	return ok, nil
+}
+
+// DeleteExpired: осиротевших записей в памяти не бывает, DeleteUserData здесь не отказывает
+func (r *memoryTokenRepository) DeleteExpired(ctx context.Context, now time.Time) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	for jti, expiresAt := range r.denied {
+		if expiresAt.Before(now) {
+			delete(r.denied, jti)
+		}
+	}
+	for id, token := range r.refresh {
+		if token.ExpiresAt.Before(now) {
+			delete(r.refresh, id)
+		}
+	}
+	for id, token := range r.user {
+		if token.ExpiresAt.Before(now) {
+			delete(r.user, id)
+		}
+	}
+	for id, session := range r.webauthn {
+		if session.ExpiresAt.Before(now) {
+			delete(r.webauthn, id)
+		}
+	}
+	return nil
+}
+
+func (r *memoryTokenRepository) ListSigningKeys(ctx context.Context) ([]SigningKey, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	keys := append([]SigningKey(nil), r.keys...)
+	sort.Slice(keys, func(i, j int) bool { return keys[i].CreatedAt.After(keys[j].CreatedAt) })
+	return keys, nil
+}
+
+func (r *memoryTokenRepository) CreateSigningKey(ctx context.Context, key *SigningKey) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	for _, other := range r.keys {
+		if other.ID == key.ID {
+			return ErrDuplicate
+		}
+	}
+	r.keys = append(r.keys, *key)
+	return nil
+}
+
+func (r *memoryTokenRepository) DeleteSigningKeysBefore(ctx context.Context, t time.Time) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	kept := r.keys[:0]
+	for _, key := range r.keys {
+		if !key.CreatedAt.Before(t) {
+			kept = append(kept, key)
+		}
+	}
+	r.keys = kept
+	return nil
+}
+
+func (r *memoryTokenRepository) CreateUserToken(ctx context.Context, token *UserToken) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	for _, other := range r.user {
+		if other.TokenHash == token.TokenHash {
+			return ErrDuplicate
+		}
+	}
+	token.ID = r.nextID
+	r.nextID++
+	r.user[token.ID] = *token
+	return nil
+}
+
+func (r *memoryTokenRepository) GetUserToken(ctx context.Context, purpose, hash string) (UserToken, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	for _, token := range r.user {
+		if token.Purpose == purpose && token.TokenHash == hash {
+			return token, nil
+		}
+	}
+	return UserToken{}, ErrNotFound
+}
+
+func (r *memoryTokenRepository) GetActiveUserToken(ctx context.Context, userID uint, purpose string) (UserToken, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	var latest UserToken
+	for _, token := range r.user {
+		if token.UserID == userID && token.Purpose == purpose && token.UsedAt == nil && token.ID > latest.ID {
+			latest = token
+		}
+	}
+	if latest.ID == 0 {
+		return UserToken{}, ErrNotFound
+	}
+	return latest, nil
+}
+
+func (r *memoryTokenRepository) AddUserTokenAttempt(ctx context.Context, id uint) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if token, ok := r.user[id]; ok {
+		token.Attempts++
+		r.user[id] = token
+	}
+	return nil
+}
+
+func (r *memoryTokenRepository) UseUserToken(ctx context.Context, id uint, at time.Time) (bool, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	token, ok := r.user[id]
+	if !ok || token.UsedAt != nil {
+		return false, nil
+	}
+	token.UsedAt = &at
+	r.user[id] = token
+	return true, nil
+}
+
+func (r *memoryTokenRepository) DeleteUserTokens(ctx context.Context, userID uint, purpose string) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	for id, token := range r.user {
+		if token.UserID == userID && token.Purpose == purpose {
+			delete(r.user, id)
+		}
+	}
+	return nil
+}
+
+func (r *memoryTokenRepository) CreateWebAuthnSession(ctx context.Context, session *WebAuthnSession) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if _, ok := r.webauthn[session.ID]; ok {
+		return ErrDuplicate
+	}
+	r.webauthn[session.ID] = *session
+	return nil
+}
+
+func (r *memoryTokenRepository) TakeWebAuthnSession(ctx context.Context, id string) (WebAuthnSession, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	session, ok := r.webauthn[id]
+	if !ok {
+		return WebAuthnSession{}, ErrNotFound
+	}
+	delete(r.webauthn, id)
+	return session, nil
+}
+
+func (r *memoryTokenRepository) CreateAPIKey(ctx context.Context, key *APIKey) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	for _, other := range r.apiKeys {
+		if other.Prefix == key.Prefix {
+			return ErrDuplicate
+		}
+	}
+	key.ID = r.nextID
+	r.nextID++
+	r.apiKeys[key.ID] = *key
+	return nil
+}
+
+func (r *memoryTokenRepository) GetAPIKeyByPrefix(ctx context.Context, prefix string) (APIKey, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	for _, key := range r.apiKeys {
+		if key.Prefix == prefix {
+			return key, nil
+		}
+	}
+	return APIKey{}, ErrNotFound
+}
+
+func (r *memoryTokenRepository) ListAPIKeys(ctx context.Context) ([]APIKey, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	keys := make([]APIKey, 0, len(r.apiKeys))
+	for _, key := range r.apiKeys {
+		keys = append(keys, key)
+	}
+	sort.Slice(keys, func(i, j int) bool { return keys[i].ID > keys[j].ID })
+	return keys, nil
+}
+
+func (r *memoryTokenRepository) RevokeAPIKey(ctx context.Context, id uint, at time.Time) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	key, ok := r.apiKeys[id]
+	if !ok {
+		return ErrNotFound
+	}
+	if key.RevokedAt == nil {
+		key.RevokedAt = &at
+		r.apiKeys[id] = key
+	}
+	return nil
+}
+
+func (r *memoryTokenRepository) TouchAPIKey(ctx context.Context, id uint, at time.Time) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if key, ok := r.apiKeys[id]; ok {
+		key.LastUsedAt = &at
+		r.apiKeys[id] = key
+	}
+	return nil
+}
+
+type memoryLogRepository struct {
+	mu      sync.Mutex
+	nextID  uint
+	entries []LogEntry
+}
+
+func newMemoryLogRepository() *memoryLogRepository {
+	return &memoryLogRepository{nextID: 1}
+}
+
+func (r *memoryLogRepository) CreateLogEntries(ctx context.Context, entries []LogEntry) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	for i := range entries {
+		entries[i].ID = r.nextID
+		r.nextID++
+		r.entries = append(r.entries, entries[i])
+	}
+	return nil
+}
+
+func (r *memoryLogRepository) ListLogEntries(ctx context.Context, filter LogFilter) ([]LogEntry, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	var entries []LogEntry
+	for i := len(r.entries) - 1; i >= 0 && len(entries) < filter.Limit; i-- {
+		entry := r.entries[i]
+		switch {
+		case len(filter.Levels) > 0 && !slices.Contains(filter.Levels, entry.Level),
+			!filter.From.IsZero() && entry.Timestamp.Before(filter.From),
+			!filter.To.IsZero() && !entry.Timestamp.Before(filter.To),
+			filter.BeforeID != 0 && entry.ID >= filter.BeforeID,
+			filter.Field != "" && !entry.Fields.matches(filter.Field, filter.Value):
+			continue
+		}
+		entries = append(entries, entry)
+	}
+	return entries, nil
+}
+
+func (r *memoryLogRepository) DeleteLogEntriesBefore(ctx context.Context, t time.Time) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	kept := r.entries[:0]
+	for _, entry := range r.entries {
+		if !entry.Timestamp.Before(t) {
+			kept = append(kept, entry)
+		}
+	}
+	r.entries = kept
+	return nil
+}
@@ -0,0 +1,536 @@
+package main
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// Одни и те же проверки для in-memory и GORM-реализаций (SQLite или TEST_DATABASE_DSN)
+
+func forEachBookRepository(t *testing.T, test func(t *testing.T, repo BookRepository)) {
+	t.Run("memory", func(t *testing.T) {
+		t.Parallel()
+		test(t, newMemoryBookRepository())
+	})
+	t.Run("gorm", func(t *testing.T) {
+		t.Parallel()
+		test(t, newGormBookRepository(newMigratedTestDB(t)))
+	})
+}
+
+func forEachUserRepository(t *testing.T, test func(t *testing.T, repo UserRepository)) {
+	t.Run("memory", func(t *testing.T) {
+		t.Parallel()
+		test(t, newMemoryUserRepository())
+	})
+	t.Run("gorm", func(t *testing.T) {
+		t.Parallel()
+		test(t, newGormUserRepository(newMigratedTestDB(t)))
+	})
+}
+
+func forEachTokenRepository(t *testing.T, test func(t *testing.T, repo TokenRepository)) {
+	t.Run("memory", func(t *testing.T) {
+		t.Parallel()
+		test(t, newMemoryTokenRepository())
+	})
+	t.Run("gorm", func(t *testing.T) {
+		t.Parallel()
+		test(t, newGormTokenRepository(newMigratedTestDB(t)))
+	})
+}
+
+func TestBookRepositoryCRUD(t *testing.T) {
+	t.Parallel()
+	forEachBookRepository(t, func(t *testing.T, repo BookRepository) {
+		ctx := context.Background()
+
+		book := Book{Title: "Dune", Author: "Frank Herbert", Published: "1965", Price: 12}
+		assert.NoError(t, repo.Create(ctx, &book))
+		assert.NotZero(t, book.ID)
+
+		got, err := repo.Get(ctx, book.ID)
+		assert.NoError(t, err)
+		assert.Equal(t, "Dune", got.Title)
+
+		got, err = repo.GetByTitle(ctx, "Dune")
+		assert.NoError(t, err)
+		assert.Equal(t, book.ID, got.ID)
+
+		book.Price = 15
+		assert.NoError(t, repo.Save(ctx, &book))
+		got, _ = repo.Get(ctx, book.ID)
+		assert.Equal(t, 15.0, got.Price)
+
+		assert.NoError(t, repo.Delete(ctx, book.ID))
+		_, err = repo.Get(ctx, book.ID)
+		assert.ErrorIs(t, err, ErrNotFound)
+		_, err = repo.GetBySlug(ctx, "missing")
+		assert.ErrorIs(t, err, ErrNotFound)
+	})
+}
+
+func TestBookRepositoryFilterAndSort(t *testing.T) {
+	t.Parallel()
+	forEachBookRepository(t, func(t *testing.T, repo BookRepository) {
+		ctx := context.Background()
+		for _, b := range []Book{
+			{Title: "Harry Potter", Author: "J.K. Rowling", Published: "1997", Price: 20, Stock: 2},
+			{Title: "The Hobbit", Author: "J.R.R. Tolkien", Published: "1937", Price: 15},
+			{Title: "HP Fan Guide", Author: "Someone", Published: "2001", Price: 5, Stock: 1},
+		} {
+			assert.NoError(t, repo.Create(ctx, &b))
+		}
+
+		books, err := repo.List(ctx, BookFilter{Title: "hp"})
+		assert.NoError(t, err)
+		assert.Len(t, books, 1, "Filter must be case-insensitive")
+
+		books, _ = repo.List(ctx, BookFilter{SortBy: "price", SortOrder: "desc"})
+		assert.Equal(t, []string{"Harry Potter", "The Hobbit", "HP Fan Guide"}, titles(books))
+
+		books, _ = repo.List(ctx, BookFilter{Author: "TOLKIEN"})
+		assert.Equal(t, []string{"The Hobbit"}, titles(books))
+
+		books, _ = repo.ListInStock(ctx)
+		assert.Equal(t, []string{"Harry Potter", "HP Fan Guide"}, titles(books))
+		inStock, copies, err := repo.CountInStock(ctx)
+		assert.NoError(t, err)
+		assert.EqualValues(t, 2, inStock)
+		assert.EqualValues(t, 3, copies)
+
+		_, err = repo.List(ctx, BookFilter{SortBy: "price; DROP TABLE books"})
+		assert.ErrorIs(t, err, ErrInvalidSort)
+	})
+}
+
+func TestBookSlugIsStableAndUnique(t *testing.T) {
+	t.Parallel()
+	forEachBookRepository(t, func(t *testing.T, repo BookRepository) {
+		ctx := context.Background()
+		first := Book{Title: "Dune", Author: "Frank Herbert", Published: "1965"}
+		second := Book{Title: "Dune", Author: "Frank Herbert", Published: "1984"}
+		repo.Create(ctx, &first)
+		repo.Create(ctx, &second)
+
+		assert.Equal(t, "dune-frank-herbert", first.Slug)
+		assert.Equal(t, "dune-frank-herbert-2", second.Slug)
+
+		first.Title = "Dune (Deluxe)"
+		repo.Save(ctx, &first)
+		assert.Equal(t, "dune-frank-herbert", first.Slug, "Slug must not change on update")
+
+		got, err := repo.GetBySlug(ctx, "dune-frank-herbert-2")
+		assert.NoError(t, err)
+		assert.Equal(t, second.ID, got.ID)
+	})
+}
+
+func TestUserRepository(t *testing.T) {
+	t.Parallel()
+	forEachUserRepository(t, func(t *testing.T, repo UserRepository) {
+		ctx := context.Background()
+
+		user := User{Name: "Reader", Email: "reader@example.com", Role: "user"}
+		assert.NoError(t, repo.Create(ctx, &user))
+		assert.NotZero(t, user.ID)
+
+		got, err := repo.Get(ctx, user.ID)
+		assert.NoError(t, err)
+		assert.Equal(t, "reader@example.com", got.Email)
+
+		duplicate := User{Name: "Other", Email: "reader@example.com"}
+		assert.ErrorIs(t, repo.Create(ctx, &duplicate), ErrDuplicate)
+
+		got.Confirmed = true
+		assert.NoError(t, repo.Save(ctx, &got))
+		got, _ = repo.GetByEmail(ctx, "reader@example.com")
+		assert.True(t, got.Confirmed)
+
+		_, err = repo.GetByEmail(ctx, "nobody@example.com")
+		assert.ErrorIs(t, err, ErrNotFound)
+	})
+}
+
+func TestTwoFactorRepository(t *testing.T) {
+	t.Parallel()
+	forEachUserRepository(t, func(t *testing.T, repo UserRepository) {
+		ctx := context.Background()
+
+		_, err := repo.GetTwoFactor(ctx, 1)
+		assert.ErrorIs(t, err, ErrNotFound)
+		assert.NoError(t, repo.SaveTwoFactor(ctx, &TwoFactor{UserID: 1, Secret: []byte("sealed")}))
+		tf, err := repo.GetTwoFactor(ctx, 1)
+		assert.NoError(t, err)
+		assert.False(t, tf.Enabled)
+		tf.Enabled = true
+		assert.NoError(t, repo.SaveTwoFactor(ctx, &tf))
+		tf, _ = repo.GetTwoFactor(ctx, 1)
+		assert.True(t, tf.Enabled)
+
+		// Интервал TOTP принимается только если он новее прежнего
+		fresh, err := repo.UseTOTPStep(ctx, 1, 100)
+		assert.NoError(t, err)
+		assert.True(t, fresh)
+		fresh, _ = repo.UseTOTPStep(ctx, 1, 100)
+		assert.False(t, fresh)
+		fresh, _ = repo.UseTOTPStep(ctx, 1, 99)
+		assert.False(t, fresh)
+
+		assert.NoError(t, repo.ReplaceRecoveryCodes(ctx, 1, []string{"h1", "h2"}))
+		assert.NoError(t, repo.ReplaceRecoveryCodes(ctx, 1, []string{"h3", "h4"}))
+		used, _ := repo.UseRecoveryCode(ctx, 1, "h1", time.Now())
+		assert.False(t, used, "Replaced codes must not work")
+		used, _ = repo.UseRecoveryCode(ctx, 2, "h3", time.Now())
+		assert.False(t, used, "Codes belong to their user")
+		used, _ = repo.UseRecoveryCode(ctx, 1, "h3", time.Now())
+		assert.True(t, used)
+		used, _ = repo.UseRecoveryCode(ctx, 1, "h3", time.Now())
+		assert.False(t, used)
+		left, _ := repo.CountRecoveryCodes(ctx, 1)
+		assert.Equal(t, 1, left)
+
+		assert.NoError(t, repo.DeleteTwoFactor(ctx, 1))
+		_, err = repo.GetTwoFactor(ctx, 1)
+		assert.ErrorIs(t, err, ErrNotFound)
+		left, _ = repo.CountRecoveryCodes(ctx, 1)
+		assert.Zero(t, left)
+	})
+}
+
+func TestPasskeyRepository(t *testing.T) {
+	t.Parallel()
+	forEachUserRepository(t, func(t *testing.T, repo UserRepository) {
+		ctx := context.Background()
+
+		first := Passkey{UserID: 1, CredentialID: []byte("cred-1"), PublicKey: []byte("pk"), Name: "Laptop"}
+		assert.NoError(t, repo.CreatePasskey(ctx, &first))
+		assert.NoError(t, repo.CreatePasskey(ctx, &Passkey{UserID: 1, CredentialID: []byte("cred-2")}))
+		assert.ErrorIs(t, repo.CreatePasskey(ctx, &Passkey{UserID: 2, CredentialID: []byte("cred-1")}), ErrDuplicate)
+
+		got, err := repo.GetPasskeyByCredentialID(ctx, []byte("cred-1"))
+		assert.NoError(t, err)
+		assert.Equal(t, first.ID, got.ID)
+		_, err = repo.GetPasskeyByCredentialID(ctx, []byte("missing"))
+		assert.ErrorIs(t, err, ErrNotFound)
+
+		got.SignCount = 7
+		assert.NoError(t, repo.SavePasskey(ctx, &got))
+		passkeys, err := repo.ListPasskeys(ctx, 1)
+		assert.NoError(t, err)
+		assert.Len(t, passkeys, 2)
+		assert.Equal(t, uint32(7), passkeys[0].SignCount)
+
+		// Удалить можно только свой ключ
+		assert.ErrorIs(t, repo.DeletePasskey(ctx, 2, first.ID), ErrNotFound)
+		assert.NoError(t, repo.DeletePasskey(ctx, 1, first.ID))
+		passkeys, _ = repo.ListPasskeys(ctx, 1)
+		assert.Len(t, passkeys, 1)
+	})
+}
+
+func TestTokenRepository(t *testing.T) {
+	t.Parallel()
+	forEachTokenRepository(t, func(t *testing.T, repo TokenRepository) {
+		ctx := context.Background()
+		now := time.Now()
+
+		assert.NoError(t, repo.CreateSession(ctx, &Session{ID: "s1", UserID: 1, CreatedAt: now}))
+		token := RefreshToken{SessionID: "s1", TokenHash: hashToken("raw"), ExpiresAt: now.Add(time.Hour)}
+		assert.NoError(t, repo.CreateRefreshToken(ctx, &token))
+		assert.ErrorIs(t, repo.CreateRefreshToken(ctx, &RefreshToken{SessionID: "s1", TokenHash: hashToken("raw")}), ErrDuplicate)
+
+		got, err := repo.GetRefreshToken(ctx, hashToken("raw"))
+		assert.NoError(t, err)
+		assert.Equal(t, token.ID, got.ID)
+
+		// Обменять токен можно только один раз
+		fresh, err := repo.UseRefreshToken(ctx, token.ID, now)
+		assert.NoError(t, err)
+		assert.True(t, fresh)
+		fresh, err = repo.UseRefreshToken(ctx, token.ID, now)
+		assert.NoError(t, err)
+		assert.False(t, fresh)
+
+		assert.NoError(t, repo.TouchSession(ctx, "s1", now.Add(time.Minute)))
+		session, err := repo.GetSession(ctx, "s1")
+		assert.NoError(t, err)
+		if assert.NotNil(t, session.LastSeenAt) {
+			assert.WithinDuration(t, now.Add(time.Minute), *session.LastSeenAt, time.Second)
+		}
+
+		assert.NoError(t, repo.RevokeUserSessions(ctx, 1, now))
+		session, err = repo.GetSession(ctx, "s1")
+		assert.NoError(t, err)
+		assert.NotNil(t, session.RevokedAt)
+
+		assert.NoError(t, repo.DenyToken(ctx, "jti-1", now.Add(-time.Minute)))
+		denied, _ := repo.IsTokenDenied(ctx, "jti-1")
+		assert.True(t, denied)
+
+		assert.NoError(t, repo.DeleteExpired(ctx, now.Add(2*time.Hour)))
+		denied, _ = repo.IsTokenDenied(ctx, "jti-1")
+		assert.False(t, denied)
+		_, err = repo.GetRefreshToken(ctx, hashToken("raw"))
+		assert.ErrorIs(t, err, ErrNotFound)
+	})
+}
+
+func TestUserTokenRepository(t *testing.T) {
+	t.Parallel()
+	forEachTokenRepository(t, func(t *testing.T, repo TokenRepository) {
+		ctx := context.Background()
+		now := time.Now()
+
+		older := UserToken{UserID: 1, Purpose: purposeVerifyEmail, TokenHash: hashToken("a"), ExpiresAt: now.Add(time.Hour)}
+		newer := UserToken{UserID: 1, Purpose: purposeVerifyEmail, TokenHash: hashToken("b"), ExpiresAt: now.Add(time.Hour)}
+		assert.NoError(t, repo.CreateUserToken(ctx, &older))
+		assert.NoError(t, repo.CreateUserToken(ctx, &newer))
+		assert.ErrorIs(t, repo.CreateUserToken(ctx, &UserToken{UserID: 2, TokenHash: hashToken("a")}), ErrDuplicate)
+
+		got, err := repo.GetUserToken(ctx, purposeVerifyEmail, hashToken("a"))
+		assert.NoError(t, err)
+		assert.Equal(t, older.ID, got.ID)
+		_, err = repo.GetUserToken(ctx, purposeVerifyEmailCode, hashToken("a"))
+		assert.ErrorIs(t, err, ErrNotFound)
+
+		active, err := repo.GetActiveUserToken(ctx, 1, purposeVerifyEmail)
+		assert.NoError(t, err)
+		assert.Equal(t, newer.ID, active.ID)
+
+		assert.NoError(t, repo.AddUserTokenAttempt(ctx, newer.ID))
+		active, _ = repo.GetActiveUserToken(ctx, 1, purposeVerifyEmail)
+		assert.Equal(t, 1, active.Attempts)
+
+		// Токен используется только один раз
+		fresh, err := repo.UseUserToken(ctx, newer.ID, now)
+		assert.NoError(t, err)
+		assert.True(t, fresh)
+		fresh, _ = repo.UseUserToken(ctx, newer.ID, now)
+		assert.False(t, fresh)
+		active, _ = repo.GetActiveUserToken(ctx, 1, purposeVerifyEmail)
+		assert.Equal(t, older.ID, active.ID)
+
+		assert.NoError(t, repo.DeleteExpired(ctx, now.Add(2*time.Hour)))
+		_, err = repo.GetUserToken(ctx, purposeVerifyEmail, hashToken("a"))
+		assert.ErrorIs(t, err, ErrNotFound)
+
+		assert.NoError(t, repo.CreateUserToken(ctx, &UserToken{UserID: 1, Purpose: purposeVerifyEmail, TokenHash: hashToken("c"), ExpiresAt: now.Add(time.Hour)}))
+		assert.NoError(t, repo.DeleteUserTokens(ctx, 1, purposeVerifyEmail))
+		_, err = repo.GetActiveUserToken(ctx, 1, purposeVerifyEmail)
+		assert.ErrorIs(t, err, ErrNotFound)
+	})
+}
+
+func titles(books []Book) []string {
+	result := []string{}
+	for _, b := range books {
+		result = append(result, b.Title)
+	}
+	return result
+}
+
+func TestWebAuthnSessionRepository(t *testing.T) {
+	t.Parallel()
+	forEachTokenRepository(t, func(t *testing.T, repo TokenRepository) {
+		ctx := context.Background()
+		now := time.Now()
+
+		assert.NoError(t, repo.CreateWebAuthnSession(ctx, &WebAuthnSession{ID: "w1", UserID: 1, Data: []byte("{}"), ExpiresAt: now.Add(time.Minute)}))
+		assert.NoError(t, repo.CreateWebAuthnSession(ctx, &WebAuthnSession{ID: "w2", ExpiresAt: now.Add(-time.Minute)}))
+
+		session, err := repo.TakeWebAuthnSession(ctx, "w1")
+		assert.NoError(t, err)
+		assert.Equal(t, uint(1), session.UserID)
+		_, err = repo.TakeWebAuthnSession(ctx, "w1")
+		assert.ErrorIs(t, err, ErrNotFound, "A ceremony can be finished only once")
+
+		assert.NoError(t, repo.DeleteExpired(ctx, now))
+		_, err = repo.TakeWebAuthnSession(ctx, "w2")
+		assert.ErrorIs(t, err, ErrNotFound)
+	})
+}
+
+func forEachLogRepository(t *testing.T, test func(t *testing.T, repo LogRepository)) {
+	t.Run("memory", func(t *testing.T) {
+		t.Parallel()
+		test(t, newMemoryLogRepository())
+	})
+	t.Run("gorm", func(t *testing.T) {
+		t.Parallel()
+		test(t, newGormLogRepository(newMigratedTestDB(t)))
+	})
+}
+
+func TestLogRepository(t *testing.T) {
+	t.Parallel()
+	forEachLogRepository(t, func(t *testing.T, repo LogRepository) {
+		ctx := context.Background()
+		now := time.Now().UTC().Truncate(time.Second)
+
+		assert.NoError(t, repo.CreateLogEntries(ctx, []LogEntry{
+			{Timestamp: now.Add(-2 * time.Hour), Level: "info", Message: "Old", Fields: logFields{"user_id": 7}},
+			{Timestamp: now.Add(-time.Hour), Level: "error", Message: "Failed", Fields: logFields{"user_id": 17, "path": "/books_list"}},
+			{Timestamp: now, Level: "warning", Message: "Odd", Fields: logFields{"note": `50% "off"`, "flag": true}},
+			{Timestamp: now, Level: "info", Message: "Plain"},
+		}))
+
+		all, err := repo.ListLogEntries(ctx, LogFilter{Limit: 10})
+		assert.NoError(t, err)
+		if assert.Len(t, all, 4) {
+			assert.Equal(t, "Plain", all[0].Message, "Newest first")
+			assert.EqualValues(t, 17, all[2].Fields["user_id"], "Fields survive the round trip")
+		}
+
+		messages := func(filter LogFilter) []string {
+			filter.Limit = 10
+			entries, err := repo.ListLogEntries(ctx, filter)
+			assert.NoError(t, err)
+			var names []string
+			for _, e := range entries {
+				names = append(names, e.Message)
+			}
+			return names
+		}
+		assert.Equal(t, []string{"Odd", "Failed"}, messages(LogFilter{Levels: []string{"error", "warning"}}))
+		assert.Equal(t, []string{"Failed"}, messages(LogFilter{From: now.Add(-90 * time.Minute), To: now}))
+		assert.Equal(t, []string{"Old"}, messages(LogFilter{Field: "user_id", Value: "7"}), "A number does not match as a prefix")
+		assert.Equal(t, []string{"Failed", "Old"}, messages(LogFilter{Field: "user_id"}))
+		assert.Equal(t, []string{"Failed"}, messages(LogFilter{Field: "path", Value: "/books_list"}))
+		assert.Empty(t, messages(LogFilter{Field: "path", Value: "/booksXlist"}), "LIKE wildcards are escaped")
+		assert.Equal(t, []string{"Odd"}, messages(LogFilter{Field: "note", Value: `50% "off"`}))
+		assert.Equal(t, []string{"Odd"}, messages(LogFilter{Field: "flag", Value: "true"}))
+		assert.Equal(t, []string{"Odd", "Failed", "Old"}, messages(LogFilter{BeforeID: all[0].ID}))
+
+		assert.NoError(t, repo.DeleteLogEntriesBefore(ctx, now.Add(-90*time.Minute)))
+		assert.Equal(t, []string{"Plain", "Odd", "Failed"}, messages(LogFilter{}))
+	})
+}
+
+func TestAPIKeyRepository(t *testing.T) {
+	t.Parallel()
+	forEachTokenRepository(t, func(t *testing.T, repo TokenRepository) {
+		ctx := context.Background()
+		now := time.Now()
+
+		first := APIKey{Name: "Import script", Prefix: "0a1b2c3d", KeyHash: "hash-1", Scopes: "catalog:write"}
+		assert.NoError(t, repo.CreateAPIKey(ctx, &first))
+		assert.NotZero(t, first.ID)
+		assert.ErrorIs(t, repo.CreateAPIKey(ctx, &APIKey{Prefix: "0a1b2c3d", KeyHash: "hash-2"}), ErrDuplicate)
+		second := APIKey{Name: "Marketplace", Prefix: "4e5f6a7b", KeyHash: "hash-3", Scopes: "catalog:read catalog:write"}
+		assert.NoError(t, repo.CreateAPIKey(ctx, &second))
+
+		key, err := repo.GetAPIKeyByPrefix(ctx, "0a1b2c3d")
+		assert.NoError(t, err)
+		assert.Equal(t, "hash-1", key.KeyHash)
+		_, err = repo.GetAPIKeyByPrefix(ctx, "ffffffff")
+		assert.ErrorIs(t, err, ErrNotFound)
+
+		assert.NoError(t, repo.TouchAPIKey(ctx, first.ID, now))
+		assert.NoError(t, repo.RevokeAPIKey(ctx, second.ID, now))
+		assert.NoError(t, repo.RevokeAPIKey(ctx, second.ID, now.Add(time.Hour)), "Revoking twice is harmless")
+		assert.ErrorIs(t, repo.RevokeAPIKey(ctx, 999, now), ErrNotFound)
+
+		keys, err := repo.ListAPIKeys(ctx)
+		assert.NoError(t, err)
+		if assert.Len(t, keys, 2) {
+			assert.Equal(t, "Marketplace", keys[0].Name, "Newest first")
+			assert.WithinDuration(t, now, *keys[0].RevokedAt, time.Second)
+			assert.WithinDuration(t, now, *keys[1].LastUsedAt, time.Second)
+			assert.Nil(t, keys[1].RevokedAt)
+		}
+	})
+}
+
+func TestDeleteUserRepository(t *testing.T) {
+	t.Parallel()
+	forEachUserRepository(t, func(t *testing.T, repo UserRepository) {
+		ctx := context.Background()
+		user := User{Email: "gone@example.com"}
+		other := User{Email: "stays@example.com"}
+		assert.NoError(t, repo.Create(ctx, &user))
+		assert.NoError(t, repo.Create(ctx, &other))
+		assert.NoError(t, repo.CreateIdentity(ctx, &UserIdentity{UserID: user.ID, Provider: "google", Subject: "1"}))
+		assert.NoError(t, repo.CreateIdentity(ctx, &UserIdentity{UserID: other.ID, Provider: "google", Subject: "2"}))
+		assert.NoError(t, repo.SaveTwoFactor(ctx, &TwoFactor{UserID: user.ID}))
+		assert.NoError(t, repo.ReplaceRecoveryCodes(ctx, user.ID, []string{"h1"}))
+		assert.NoError(t, repo.CreatePasskey(ctx, &Passkey{UserID: user.ID, CredentialID: []byte("c1")}))
+
+		identities, err := repo.ListIdentities(ctx, user.ID)
+		assert.NoError(t, err)
+		assert.Len(t, identities, 1)
+
+		assert.NoError(t, repo.Delete(ctx, user.ID))
+		assert.ErrorIs(t, repo.Delete(ctx, user.ID), ErrNotFound)
+		_, err = repo.Get(ctx, user.ID)
+		assert.ErrorIs(t, err, ErrNotFound)
+		identities, _ = repo.ListIdentities(ctx, user.ID)
+		assert.Empty(t, identities)
+		_, err = repo.GetTwoFactor(ctx, user.ID)
+		assert.ErrorIs(t, err, ErrNotFound)
+		left, _ := repo.CountRecoveryCodes(ctx, user.ID)
+		assert.Zero(t, left)
+		passkeys, _ := repo.ListPasskeys(ctx, user.ID)
+		assert.Empty(t, passkeys)
+
+		identities, _ = repo.ListIdentities(ctx, other.ID)
+		assert.Len(t, identities, 1, "Other users' data stays")
+	})
+}
+
+func TestDeleteUserData(t *testing.T) {
+	t.Parallel()
+	forEachTokenRepository(t, func(t *testing.T, repo TokenRepository) {
+		ctx := context.Background()
+		now := time.Now()
+
+		assert.NoError(t, repo.CreateSession(ctx, &Session{ID: "mine", UserID: 1, CreatedAt: now}))
+		assert.NoError(t, repo.CreateSession(ctx, &Session{ID: "theirs", UserID: 2, CreatedAt: now}))
+		assert.NoError(t, repo.CreateRefreshToken(ctx, &RefreshToken{SessionID: "mine", TokenHash: hashToken("r1"), ExpiresAt: now.Add(time.Hour)}))
+		assert.NoError(t, repo.CreateRefreshToken(ctx, &RefreshToken{SessionID: "theirs", TokenHash: hashToken("r2"), ExpiresAt: now.Add(time.Hour)}))
+		assert.NoError(t, repo.CreateUserToken(ctx, &UserToken{UserID: 1, Purpose: purposeVerifyEmail, TokenHash: hashToken("u1"), ExpiresAt: now.Add(time.Hour)}))
+
+		sessions, err := repo.ListUserSessions(ctx, 1)
+		assert.NoError(t, err)
+		assert.Len(t, sessions, 1)
+
+		assert.NoError(t, repo.DeleteUserData(ctx, 1))
+		sessions, _ = repo.ListUserSessions(ctx, 1)
+		assert.Empty(t, sessions)
+		_, err = repo.GetRefreshToken(ctx, hashToken("r1"))
+		assert.ErrorIs(t, err, ErrNotFound)
+		_, err = repo.GetUserToken(ctx, purposeVerifyEmail, hashToken("u1"))
+		assert.ErrorIs(t, err, ErrNotFound)
+
+		_, err = repo.GetRefreshToken(ctx, hashToken("r2"))
+		assert.NoError(t, err, "Other users' sessions stay")
+	})
+}
+
+func TestDeleteExpiredPurgesOrphans(t *testing.T) {
+	t.Parallel()
+	db := newMigratedTestDB(t)
+	users, repo := newGormUserRepository(db), newGormTokenRepository(db)
+	ctx := context.Background()
+	now := time.Now()
+
+	kept := User{Email: "stays@example.com"}
+	assert.NoError(t, users.Create(ctx, &kept))
+	assert.NoError(t, repo.CreateSession(ctx, &Session{ID: "kept", UserID: kept.ID, CreatedAt: now}))
+	assert.NoError(t, repo.CreateSession(ctx, &Session{ID: "orphan", UserID: kept.ID + 1, CreatedAt: now}))
+	assert.NoError(t, repo.CreateRefreshToken(ctx, &RefreshToken{SessionID: "orphan", TokenHash: hashToken("r1"), ExpiresAt: now.Add(time.Hour)}))
+	assert.NoError(t, repo.CreateUserToken(ctx, &UserToken{UserID: kept.ID + 1, Purpose: purposeVerifyEmail, TokenHash: hashToken("u1"), ExpiresAt: now.Add(time.Hour)}))
+
+	assert.NoError(t, repo.DeleteExpired(ctx, now))
+	_, err := repo.GetSession(ctx, "orphan")
+	assert.ErrorIs(t, err, ErrNotFound, "Sessions of erased users are purged")
+	_, err = repo.GetRefreshToken(ctx, hashToken("r1"))
+	assert.ErrorIs(t, err, ErrNotFound)
+	_, err = repo.GetUserToken(ctx, purposeVerifyEmail, hashToken("u1"))
+	assert.ErrorIs(t, err, ErrNotFound)
+	_, err = repo.GetSession(ctx, "kept")
+	assert.NoError(t, err)
+}
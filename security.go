@@ -0,0 +1,139 @@
+package main
+
+import (
+	"context"
+	"crypto/subtle"
+	"html/template"
+	"mime"
+	"net/http"
+	"path/filepath"
+	"strconv"
+	"strings"
+)
+
+// Заголовки безопасности и защита от CSRF
+
+type cspNonceKey struct{}
+
+// cspNonce — nonce текущего запроса для <script nonce="...">
+func cspNonce(r *http.Request) string {
+	nonce, _ := r.Context().Value(cspNonceKey{}).(string)
+	return nonce
+}
+
+// securityHeaders добавляет CSP с одноразовым nonce, HSTS и запрет встраивания во фреймы
+func (s *Server) securityHeaders(next http.Handler) http.Handler {
+	cfg := s.config.Security
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		h := w.Header()
+		h.Set("X-Content-Type-Options", "nosniff")
+		h.Set("X-Frame-Options", "DENY")
+		h.Set("Referrer-Policy", "strict-origin-when-cross-origin")
+		if cfg.HSTSMaxAge > 0 && s.secureCookies(r) {
+			h.Set("Strict-Transport-Security", "max-age="+strconv.Itoa(int(cfg.HSTSMaxAge.Seconds()))+"; includeSubDomains")
+		}
+		if cfg.ContentSecurityPolicy != "" {
+			nonce, err := randomToken(16)
+			if err != nil {
+				s.log(r.Context()).WithError(err).Error("Failed to generate CSP nonce")
+				http.Error(w, "Internal server error", http.StatusInternalServerError)
+				return
+			}
+			h.Set("Content-Security-Policy", strings.ReplaceAll(cfg.ContentSecurityPolicy, "{nonce}", nonce))
+			r = r.WithContext(context.WithValue(r.Context(), cspNonceKey{}, nonce))
+		}
+		next.ServeHTTP(w, r)
+	})
+}
+
+// Страница с встроенным скриптом отдаётся через шаблон, чтобы подставить nonce
+func (s *Server) fantasyPageHandler(w http.ResponseWriter, r *http.Request) {
+	tmpl, err := template.ParseFiles(filepath.Join(staticDir, "fantasy.html"))
+	if err != nil {
+		s.log(r.Context()).WithError(err).Error("Failed to parse fantasy.html")
+		http.Error(w, "Internal server error", http.StatusInternalServerError)
+		return
+	}
+	w.Header().Set("Content-Type", "text/html; charset=utf-8")
+	if err := tmpl.Execute(w, map[string]string{"Nonce": cspNonce(r)}); err != nil {
+		s.log(r.Context()).WithError(err).Error("Failed to render fantasy.html")
+	}
+}
+
+// CSRF: double-submit cookie. Сервер выдаёт случайный csrf_token в cookie,
+// скрипт сайта читает его и повторяет в заголовке X-CSRF-Token (или поле формы
+// csrf_token). Чужой сайт cookie прочитать не может.
+const (
+	csrfCookieName = "csrf_token"
+	csrfHeaderName = "X-CSRF-Token"
+)
+
+// csrfRequired — нужен ли запросу CSRF-токен. Запросы с Authorization чужая
+// страница не подделает, GET/HEAD/OPTIONS ничего не меняют. Остальные
+// методы с cookie сессии проверяются всегда: браузер приложит SameSite=Lax
+// cookie и к переходу с чужого сайта. Без сессии проверяется то, что чужая
+// страница может отправить без preflight: POST формы или fetch в режиме no-cors
+func (s *Server) csrfRequired(r *http.Request) bool {
+	switch r.Method {
+	case http.MethodGet, http.MethodHead, http.MethodOptions:
+		return false
+	}
+	if r.Header.Get("Authorization") != "" {
+		return false
+	}
+	if _, err := r.Cookie(s.config.Session.CookieName); err == nil {
+		return true
+	}
+	if r.Method != http.MethodPost {
+		return false
+	}
+	contentType := r.Header.Get("Content-Type")
+	if contentType == "" {
+		return true
+	}
+	mediaType, _, err := mime.ParseMediaType(contentType)
+	if err != nil {
+		return true
+	}
+	switch mediaType {
+	case "application/x-www-form-urlencoded", "multipart/form-data", "text/plain":
+		return true
+	}
+	return false
+}
+
+func (s *Server) csrfMiddleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		cookie, err := r.Cookie(csrfCookieName)
+		if err != nil || cookie.Value == "" {
+			token, err := randomToken(32)
+			if err != nil {
+				s.log(r.Context()).WithError(err).Error("Failed to generate CSRF token")
+				http.Error(w, "Internal server error", http.StatusInternalServerError)
+				return
+			}
+			// Не HttpOnly: скрипт сайта должен прочитать значение
+			http.SetCookie(w, &http.Cookie{
+				Name:     csrfCookieName,
+				Value:    token,
+				Path:     "/",
+				Secure:   s.secureCookies(r),
+				SameSite: http.SameSiteStrictMode,
+			})
+			cookie = nil
+		}
+
+		if s.csrfRequired(r) {
+			sent := r.Header.Get(csrfHeaderName)
+			if sent == "" {
+				sent = r.FormValue(csrfCookieName)
+			}
+			if cookie == nil || sent == "" || subtle.ConstantTimeCompare([]byte(sent), []byte(cookie.Value)) != 1 {
+				s.log(r.Context()).WithField("path", r.URL.Path).Warn("CSRF token missing or invalid")
+				http.Error(w, "Invalid CSRF token", http.StatusForbidden)
+				return
+			}
+		}
+		next.ServeHTTP(w, r)
+	})
+}
@@ -0,0 +1,163 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+var cspNoncePattern = regexp.MustCompile(`'nonce-([A-Za-z0-9_-]+)'`)
+
+func TestSecurityHeaders(t *testing.T) {
+	t.Parallel()
+	handler := newMemoryTestServer(t).routes()
+
+	rr := getFrom(handler, "/healthz", "203.0.113.1", "")
+	assert.Equal(t, "DENY", rr.Header().Get("X-Frame-Options"))
+	assert.Equal(t, "nosniff", rr.Header().Get("X-Content-Type-Options"))
+	assert.Equal(t, "strict-origin-when-cross-origin", rr.Header().Get("Referrer-Policy"))
+	assert.Contains(t, rr.Header().Get("Content-Security-Policy"), "frame-ancestors 'none'")
+	assert.Regexp(t, cspNoncePattern, rr.Header().Get("Content-Security-Policy"))
+	assert.Contains(t, rr.Header().Get("Content-Security-Policy"), " "+bootstrapBundleURL+"; ")
+	assert.NotContains(t, rr.Header().Get("Content-Security-Policy"), "https://cdn.jsdelivr.net;", "Scripts are not allowed from the whole CDN")
+	assert.Empty(t, rr.Header().Get("Strict-Transport-Security"), "HSTS is only sent over HTTPS")
+
+	cfg := testConfig()
+	cfg.SiteURL = "https://books.example.com"
+	cfg.Security.ContentSecurityPolicy = ""
+	handler = newConfigTestServer(t, cfg, newMemoryTokenRepository()).routes()
+	rr = getFrom(handler, "/healthz", "203.0.113.1", "")
+	assert.Equal(t, "max-age=31536000; includeSubDomains", rr.Header().Get("Strict-Transport-Security"))
+	assert.Empty(t, rr.Header().Get("Content-Security-Policy"), "An empty policy turns CSP off")
+}
+
+// Страницы подключают именно тот скрипт Bootstrap, который разрешает CSP
+func TestPagesLoadPinnedBootstrap(t *testing.T) {
+	t.Parallel()
+	pages, err := filepath.Glob(filepath.Join(staticDir, "*.html"))
+	assert.NoError(t, err)
+	external := regexp.MustCompile(`<script src="(https?://[^"]+)"`)
+	for _, page := range pages {
+		content, err := os.ReadFile(page)
+		assert.NoError(t, err)
+		for _, match := range external.FindAllStringSubmatch(string(content), -1) {
+			assert.Equal(t, bootstrapBundleURL, match[1], page)
+		}
+	}
+}
+
+func TestFantasyPageNonce(t *testing.T) {
+	t.Parallel()
+	handler := newMemoryTestServer(t).routes()
+
+	var nonces []string
+	for _, path := range []string{"/fantasy", "/fantasy.html"} {
+		rr := getFrom(handler, path, "203.0.113.1", "")
+		assert.Equal(t, http.StatusOK, rr.Code)
+		match := cspNoncePattern.FindStringSubmatch(rr.Header().Get("Content-Security-Policy"))
+		assert.Len(t, match, 2)
+		assert.Contains(t, rr.Body.String(), `<script nonce="`+match[1]+`">`, "The inline script carries the nonce from the policy")
+		nonces = append(nonces, match[1])
+	}
+	assert.NotEqual(t, nonces[0], nonces[1], "Every response gets a fresh nonce")
+}
+
+func TestStaticFilesOnly(t *testing.T) {
+	t.Parallel()
+	handler := newMemoryTestServer(t).routes()
+
+	for _, path := range []string{"/", "/style.css", "/script.js", "/signin.html", "/favicon.png"} {
+		assert.Equal(t, http.StatusOK, getFrom(handler, path, "203.0.113.1", "").Code, path)
+	}
+	for _, path := range []string{"/.env", "/books.db", "/config.example.yaml", "/.git/config", "/go.mod", "/main.go"} {
+		assert.Equal(t, http.StatusNotFound, getFrom(handler, path, "203.0.113.1", "").Code, path)
+	}
+}
+
+func TestCSRFDoubleSubmit(t *testing.T) {
+	t.Parallel()
+	handler := newMemoryTestServer(t).routes()
+
+	rr := getFrom(handler, "/", "203.0.113.1", "")
+	var csrf *http.Cookie
+	for _, c := range rr.Result().Cookies() {
+		if c.Name == csrfCookieName {
+			csrf = c
+		}
+	}
+	if !assert.NotNil(t, csrf, "The first response issues a CSRF cookie") {
+		return
+	}
+	assert.False(t, csrf.HttpOnly, "Scripts must be able to read the token")
+	assert.Equal(t, http.SameSiteStrictMode, csrf.SameSite)
+
+	post := func(contentType, body, header string, cookie *http.Cookie) int {
+		req, _ := http.NewRequest("POST", "/fantasy", strings.NewReader(body))
+		if contentType != "" {
+			req.Header.Set("Content-Type", contentType)
+		}
+		if header != "" {
+			req.Header.Set(csrfHeaderName, header)
+		}
+		if cookie != nil {
+			req.AddCookie(cookie)
+		}
+		rr := httptest.NewRecorder()
+		handler.ServeHTTP(rr, req)
+		return rr.Code
+	}
+
+	assert.Equal(t, http.StatusForbidden, post("", "", "", csrf), "Expected 403 without the header")
+	assert.Equal(t, http.StatusForbidden, post("", "", csrf.Value, nil), "Expected 403 without the cookie")
+	assert.Equal(t, http.StatusForbidden, post("text/plain", "", "forged", csrf), "Expected 403 for a mismatch")
+	assert.Equal(t, http.StatusOK, post("", "", csrf.Value, csrf))
+
+	form := url.Values{csrfCookieName: {csrf.Value}}.Encode()
+	assert.Equal(t, http.StatusOK, post("application/x-www-form-urlencoded", form, "", csrf), "A form field works too")
+
+	// JSON и запросы с Authorization чужой сайт без preflight не отправит
+	assert.Equal(t, http.StatusUnauthorized, postJSON(handler, "/login", "", map[string]string{"email": "nobody@example.com", "password": "secret123"}).Code)
+}
+
+func TestCSRFWithCookieSession(t *testing.T) {
+	t.Parallel()
+	s := newMemoryTestServer(t)
+	handler := s.routes()
+	cookie := loginWithCookie(t, s, handler)
+
+	send := func(method, path string, csrf bool) int {
+		req, _ := http.NewRequest(method, path, strings.NewReader(`{"name":"Bilbo"}`))
+		req.Header.Set("Content-Type", "application/json")
+		req.AddCookie(cookie)
+		if csrf {
+			withCSRF(req)
+		}
+		rr := httptest.NewRecorder()
+		handler.ServeHTTP(rr, req)
+		return rr.Code
+	}
+
+	// Под cookie сессии токен нужен и JSON, и PUT/PATCH/DELETE
+	assert.Equal(t, http.StatusForbidden, send("PATCH", "/api/account", false))
+	assert.Equal(t, http.StatusForbidden, send("DELETE", "/api/sessions", false))
+	assert.Equal(t, http.StatusForbidden, send("POST", "/logout", false))
+	assert.Equal(t, http.StatusOK, send("PATCH", "/api/account", true))
+	assert.Equal(t, http.StatusOK, send("GET", "/api/profile", false), "Safe methods need no token")
+
+	// Переход по ссылке с чужого сайта не удаляет книги: удаление — только DELETE
+	key := issueAPIKey(t, handler, adminAccessToken(t, s), map[string]any{"name": "Admin", "scopes": []string{"catalog:write"}})
+	book := Book{Title: "Dune", Author: "Frank Herbert"}
+	assert.NoError(t, s.books.Create(context.Background(), &book))
+	withAPIKey(handler, "GET", fmt.Sprintf("/books/delete?id=%d", book.ID), key.Key, "")
+	_, err := s.books.Get(context.Background(), book.ID)
+	assert.NoError(t, err, "GET does not reach deleteBook")
+}
@@ -0,0 +1,266 @@
+package main
+
+import (
+	"encoding/xml"
+	"errors"
+	"fmt"
+	"html/template"
+	"net/http"
+	"strings"
+	"unicode"
+	"unicode/utf8"
+
+	"github.com/sirupsen/logrus"
+	"gorm.io/gorm"
+)
+
+// Страница книги: /book/{slug} с Open Graph, canonical и JSON-LD (schema.org Book/Offer)
+
+const bookPageTemplate = `<!DOCTYPE html>
+<html lang="en">
+<head>
+    <meta charset="UTF-8">
+    <meta name="viewport" content="width=device-width, initial-scale=1.0">
+    <title>{{.Book.Title}} by {{.Book.Author}} | Bookstore</title>
+    <meta name="description" content="{{.Description}}">
+    <link rel="canonical" href="{{.CanonicalURL}}">
+    <meta property="og:type" content="book">
+    <meta property="og:title" content="{{.Book.Title}}">
+    <meta property="og:description" content="{{.Description}}">
+    <meta property="og:url" content="{{.CanonicalURL}}">
+    {{if .ImageURL}}<meta property="og:image" content="{{.ImageURL}}">{{end}}
+    <meta property="book:author" content="{{.Book.Author}}">
+    <link rel="stylesheet" href="/style.css">
+    <link rel="icon" href="/favicon.png" type="image/png">
+    <script type="application/ld+json">{{.JSONLD}}</script>
+</head>
+<body>
+    <main class="container">
+        <div class="book-card">
+            {{if .ImageURL}}<img src="{{.ImageURL}}" alt="{{.Book.Title}}" class="book-image">{{end}}
+            <h1 class="book-title">{{.Book.Title}}</h1>
+            <p class="book-author">{{.Book.Author}}{{if .Book.Published}}, {{.Book.Published}}{{end}}</p>
+            <p class="book-description">{{.Book.Description}}</p>
+            <p class="book-price">Price: ${{printf "%.2f" .Book.Price}}</p>
+            <p class="book-stock">{{if gt .Book.Stock 0}}In stock{{else}}Out of stock{{end}}</p>
+        </div>
+    </main>
+</body>
+</html>
+`
+
+var bookPageTmpl = template.Must(template.New("book").Parse(bookPageTemplate))
+
+// slugify превращает строку в часть URL: нижний регистр, буквы и цифры, остальное — "-"
+func slugify(s string) string {
+	var b strings.Builder
+	dash := false
+	for _, r := range strings.ToLower(s) {
+		if unicode.IsLetter(r) || unicode.IsDigit(r) {
+			b.WriteRune(r)
+			dash = false
+		} else if !dash && b.Len() > 0 {
+			b.WriteByte('-')
+			dash = true
+		}
+	}
+	return strings.TrimSuffix(b.String(), "-")
+}
+
+// maxSlugLength ограничивает slug, заданный вручную
+const maxSlugLength = 100
+
+// requestedSlug проверяет slug из запроса и приводит его к виду slugify
+func requestedSlug(raw string) (string, error) {
+	slug := slugify(raw)
+	if slug == "" {
+		return "", errors.New("slug must contain letters or digits")
+	}
+	if utf8.RuneCountInString(slug) > maxSlugLength {
+		return "", fmt.Errorf("slug must be at most %d characters", maxSlugLength)
+	}
+	return slug, nil
+}
+
+// uniqueBookSlug подбирает свободный slug для книги в таблице books
+func uniqueBookSlug(tx *gorm.DB, book *Book) (string, error) {
+	return uniqueSlug(book, func(slug string) (bool, error) {
+		var count int64
+		err := tx.Table("books").Where("slug = ? AND id <> ?", slug, book.ID).Count(&count).Error
+		return count > 0, err
+	})
+}
+
+// BeforeSave назначает slug один раз, чтобы ссылки на книгу не менялись
+func (b *Book) BeforeSave(tx *gorm.DB) error {
+	if b.Slug != "" {
+		return nil
+	}
+	slug, err := uniqueBookSlug(tx.Session(&gorm.Session{NewDB: true}), b)
+	if err != nil {
+		return fmt.Errorf("failed to generate slug: %w", err)
+	}
+	b.Slug = slug
+	return nil
+}
+
+// backfillBookSlugs проставляет slug книгам, созданным до появления колонки.
+// Вызывается из миграции, поэтому читает только нужные колонки
+func backfillBookSlugs(db *gorm.DB) error {
+	var books []Book
+	if err := db.Table("books").Select("id", "title", "author").Where("slug = ? OR slug IS NULL", "").Find(&books).Error; err != nil {
+		return err
+	}
+	for i := range books {
+		slug, err := uniqueBookSlug(db, &books[i])
+		if err != nil {
+			return err
+		}
+		if err := db.Table("books").Where("id = ?", books[i].ID).UpdateColumn("slug", slug).Error; err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// dedupeBookSlugs выдаёт новый slug всем книгам с повторяющимся slug, кроме первой.
+// Вызывается из миграции перед созданием уникального индекса
+func dedupeBookSlugs(db *gorm.DB) error {
+	var books []Book
+	err := db.Table("books").Select("id", "title", "author").
+		Where("slug IN (?) AND id NOT IN (?)",
+			db.Table("books").Select("slug").Group("slug").Having("COUNT(*) > 1"),
+			db.Table("books").Select("MIN(id)").Group("slug")).
+		Find(&books).Error
+	if err != nil {
+		return err
+	}
+	for i := range books {
+		slug, err := uniqueBookSlug(db, &books[i])
+		if err != nil {
+			return err
+		}
+		if err := db.Table("books").Where("id = ?", books[i].ID).UpdateColumn("slug", slug).Error; err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func absoluteURL(base, path string) string {
+	if path == "" || strings.HasPrefix(path, "http://") || strings.HasPrefix(path, "https://") {
+		return path
+	}
+	return base + "/" + strings.TrimPrefix(path, "/")
+}
+
+func bookJSONLD(book Book, canonicalURL, imageURL string) map[string]interface{} {
+	availability := "https://schema.org/OutOfStock"
+	if book.Stock > 0 {
+		availability = "https://schema.org/InStock"
+	}
+
+	data := map[string]interface{}{
+		"@context":    "https://schema.org",
+		"@type":       "Book",
+		"name":        book.Title,
+		"author":      map[string]string{"@type": "Person", "name": book.Author},
+		"description": book.Description,
+		"url":         canonicalURL,
+		"offers": map[string]interface{}{
+			"@type":         "Offer",
+			"price":         fmt.Sprintf("%.2f", book.Price),
+			"priceCurrency": "USD",
+			"availability":  availability,
+			"url":           canonicalURL,
+		},
+	}
+	if book.Published != "" {
+		data["datePublished"] = book.Published
+	}
+	if imageURL != "" {
+		data["image"] = imageURL
+	}
+	return data
+}
+
+// Обработчик страницы книги
+func (s *Server) bookPageHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet && r.Method != http.MethodHead {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	book, err := s.books.GetBySlug(r.Context(), r.PathValue("slug"))
+	if errors.Is(err, ErrNotFound) {
+		http.Error(w, "Book not found", http.StatusNotFound)
+		return
+	}
+	if err != nil {
+		s.log(r.Context()).WithError(err).Error("Failed to fetch book")
+		http.Error(w, "Failed to fetch book", http.StatusInternalServerError)
+		return
+	}
+
+	base := s.config.BaseURL()
+	canonicalURL := base + "/book/" + book.Slug
+	imageURL := absoluteURL(base, book.ImageURL)
+
+	description := book.Description
+	if len([]rune(description)) > 160 {
+		description = string([]rune(description)[:157]) + "..."
+	}
+
+	data := struct {
+		Book         Book
+		Description  string
+		CanonicalURL string
+		ImageURL     string
+		JSONLD       map[string]interface{}
+	}{book, description, canonicalURL, imageURL, bookJSONLD(book, canonicalURL, imageURL)}
+
+	w.Header().Set("Content-Type", "text/html; charset=utf-8")
+	if err := bookPageTmpl.Execute(w, data); err != nil {
+		s.log(r.Context()).WithError(err).Error("Failed to render book page")
+	}
+}
+
+type sitemapURL struct {
+	Loc string `xml:"loc"`
+}
+
+type sitemapURLSet struct {
+	XMLName xml.Name     `xml:"urlset"`
+	Xmlns   string       `xml:"xmlns,attr"`
+	URLs    []sitemapURL `xml:"url"`
+}
+
+// Генерация sitemap.xml по всем книгам в наличии
+func (s *Server) sitemapHandler(w http.ResponseWriter, r *http.Request) {
+	books, err := s.books.ListInStock(r.Context())
+	if err != nil {
+		s.log(r.Context()).WithError(err).Error("Failed to fetch books for sitemap")
+		http.Error(w, "Failed to build sitemap", http.StatusInternalServerError)
+		return
+	}
+
+	base := s.config.BaseURL()
+	urlset := sitemapURLSet{Xmlns: "http://www.sitemaps.org/schemas/sitemap/0.9"}
+	for _, page := range []string{"/", "/bouquiniste", "/fantasy"} {
+		urlset.URLs = append(urlset.URLs, sitemapURL{Loc: base + page})
+	}
+	for _, book := range books {
+		urlset.URLs = append(urlset.URLs, sitemapURL{Loc: base + "/book/" + book.Slug})
+	}
+
+	s.log(r.Context()).WithFields(logrus.Fields{
+		"action": "sitemap",
+		"count":  len(books),
+	}).Info("Sitemap generated")
+
+	w.Header().Set("Content-Type", "application/xml; charset=utf-8")
+	w.Write([]byte(xml.Header))
+	if err := xml.NewEncoder(w).Encode(urlset); err != nil {
+		s.log(r.Context()).WithError(err).Error("Failed to encode sitemap")
+	}
+}
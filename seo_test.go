@@ -0,0 +1,104 @@
+package main
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestSlugify(t *testing.T) {
+	t.Parallel()
+	assert.Equal(t, "harry-potter-j-k-rowling", slugify("Harry Potter  J.K. Rowling!"))
+	assert.Equal(t, "мастер-и-маргарита", slugify("Мастер и Маргарита"))
+	assert.Equal(t, "", slugify("!!!"))
+}
+
+func TestAddBookSlug(t *testing.T) {
+	t.Parallel()
+	s := newMemoryTestServer(t)
+	add := func(body string) *httptest.ResponseRecorder {
+		req, _ := http.NewRequest("POST", "/books/add", strings.NewReader(body))
+		rr := httptest.NewRecorder()
+		s.addBook(rr, req)
+		return rr
+	}
+
+	rr := add(`{"title": "Dune", "author": "Frank Herbert", "published": "1965", "slug": "  Dune: First Edition! "}`)
+	assert.Equal(t, http.StatusCreated, rr.Code, rr.Body.String())
+	book, err := s.books.GetBySlug(context.Background(), "dune-first-edition")
+	assert.NoError(t, err, "A requested slug goes through slugify")
+	assert.Equal(t, "Dune", book.Title)
+
+	assert.Equal(t, http.StatusBadRequest, add(`{"title": "Emma", "author": "Jane Austen", "published": "1815", "slug": "../../"}`).Code)
+	assert.Equal(t, http.StatusBadRequest, add(`{"title": "Emma", "author": "Jane Austen", "published": "1815", "slug": "`+strings.Repeat("a", maxSlugLength+1)+`"}`).Code)
+	assert.Equal(t, http.StatusConflict, add(`{"title": "Emma", "author": "Jane Austen", "published": "1815", "slug": "Dune First Edition"}`).Code)
+}
+
+// seoTestServer — сервер с публичным адресом https://shop.example
+func seoTestServer(t *testing.T) *Server {
+	t.Helper()
+	cfg := testConfig()
+	cfg.SiteURL = "https://shop.example/"
+	return newConfigTestServer(t, cfg, newMemoryTokenRepository())
+}
+
+func TestBookPage(t *testing.T) {
+	t.Parallel()
+	s := seoTestServer(t)
+
+	book := Book{
+		Title:       "Emma",
+		Author:      "Jane Austen",
+		Published:   "1815",
+		Description: "A novel about youthful hubris.",
+		Price:       12.5,
+		ImageURL:    "/emma.jpg",
+		Stock:       3,
+	}
+	s.books.Create(context.Background(), &book)
+
+	mux := http.NewServeMux()
+	mux.HandleFunc("/book/{slug}", s.bookPageHandler)
+
+	// Host и X-Forwarded-Proto задаёт клиент: в ссылки попадает только SITE_URL
+	req, _ := http.NewRequest("GET", "/book/emma-jane-austen", nil)
+	req.Host = "evil.example"
+	req.Header.Set("X-Forwarded-Proto", "http")
+	rr := httptest.NewRecorder()
+	mux.ServeHTTP(rr, req)
+
+	assert.Equal(t, http.StatusOK, rr.Code, "Expected 200 OK")
+	body := rr.Body.String()
+	assert.Contains(t, body, `<link rel="canonical" href="https://shop.example/book/emma-jane-austen">`)
+	assert.Contains(t, body, `<meta property="og:image" content="https://shop.example/emma.jpg">`)
+	assert.NotContains(t, body, "evil.example")
+	assert.Contains(t, body, `"@type":"Book"`)
+	assert.Contains(t, body, `"availability":"https://schema.org/InStock"`)
+
+	req, _ = http.NewRequest("GET", "/book/missing", nil)
+	rr = httptest.NewRecorder()
+	mux.ServeHTTP(rr, req)
+	assert.Equal(t, http.StatusNotFound, rr.Code, "Expected 404 Not Found")
+}
+
+func TestSitemap(t *testing.T) {
+	t.Parallel()
+	s := seoTestServer(t)
+
+	s.books.Create(context.Background(), &Book{Title: "In Stock", Author: "A", Published: "2001", Stock: 1})
+	s.books.Create(context.Background(), &Book{Title: "Sold Out", Author: "B", Published: "2002", Stock: 0})
+
+	req, _ := http.NewRequest("GET", "/sitemap.xml", nil)
+	req.Host = "evil.example"
+	rr := httptest.NewRecorder()
+	http.HandlerFunc(s.sitemapHandler).ServeHTTP(rr, req)
+
+	assert.Equal(t, http.StatusOK, rr.Code, "Expected 200 OK")
+	assert.Contains(t, rr.Body.String(), "<loc>https://shop.example/book/in-stock-a</loc>")
+	assert.NotContains(t, rr.Body.String(), "evil.example")
+	assert.NotContains(t, rr.Body.String(), "sold-out-b")
+}
@@ -0,0 +1,236 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/netip"
+	"net/smtp"
+	"path/filepath"
+	"time"
+
+	"github.com/go-webauthn/webauthn/webauthn"
+	"github.com/gorilla/sessions"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/sirupsen/logrus"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	semconv "go.opentelemetry.io/otel/semconv/v1.37.0"
+	"go.opentelemetry.io/otel/trace"
+	"go.opentelemetry.io/otel/trace/noop"
+)
+
+// Mailer отправляет готовое письмо (заголовки + тело)
+type Mailer interface {
+	SendMail(to []string, msg []byte) error
+}
+
+// smtpMailer отправляет письма через SMTP-сервер из конфигурации
+type smtpMailer struct {
+	cfg SMTPConfig
+}
+
+func (m smtpMailer) SendMail(to []string, msg []byte) error {
+	auth := smtp.PlainAuth("", m.cfg.Username, m.cfg.Password.Value(), m.cfg.Host)
+	return smtp.SendMail(m.cfg.Addr(), auth, m.cfg.Sender(), to, msg)
+}
+
+// Server хранит зависимости обработчиков: репозитории, логгер, почту и настройки
+type Server struct {
+	books   BookRepository
+	users   UserRepository
+	tokens  TokenRepository
+	logs    LogRepository
+	mailer  Mailer
+	logger  *logrus.Logger
+	metrics *metrics
+	traces  *sdktrace.TracerProvider // nil, если трассировка выключена
+	tracer  trace.Tracer
+	config  Config
+	limiter rateLimiter
+	keys    *keyManager
+	oidc    map[string]*oidcProvider
+
+	ratePolicies   map[string]rateLimitPolicy
+	cookies        *sessions.CookieStore
+	webAuthn       *webauthn.WebAuthn
+	verifyAttempts *attemptLimiter
+	accountLogins  *loginThrottle
+	ipLogins       *loginThrottle
+	trustedProxies []netip.Prefix
+}
+
+func newServer(cfg Config, books BookRepository, users UserRepository, tokens TokenRepository, logs LogRepository, mailer Mailer, logger *logrus.Logger) *Server {
+	limiter, err := newRateLimiter(cfg.RateLimit)
+	if err != nil {
+		logger.WithError(err).Error("Falling back to per-process rate limits")
+		limiter = newMemoryRateLimiter(cfg.RateLimit.IdleTTL)
+	}
+	s := &Server{
+		books:   books,
+		users:   users,
+		tokens:  tokens,
+		logs:    logs,
+		mailer:  mailer,
+		logger:  logger,
+		metrics: newMetrics(books),
+		tracer:  noop.NewTracerProvider().Tracer(tracerName),
+		config:  cfg,
+		limiter: limiter,
+		keys:    newKeyManager(cfg.JWT, tokens),
+		oidc:    newOIDCProviders(cfg),
+
+		ratePolicies:   newRateLimitPolicies(cfg.RateLimit),
+		cookies:        newSessionStore(cfg),
+		verifyAttempts: newAttemptLimiter(10, 15*time.Minute),
+		accountLogins:  newLoginThrottle(accountLoginPolicy),
+		ipLogins:       newLoginThrottle(ipLoginPolicy),
+	}
+	// Config.Validate уже отверг неверные записи
+	s.trustedProxies, _ = parseTrustedProxies(cfg.Security.TrustedProxies)
+	s.metrics.registry.MustRegister(prometheus.NewCounterFunc(prometheus.CounterOpts{
+		Name: "bookstore_log_entries_dropped_total",
+		Help: "Log lines that did not make it into the log_entries table.",
+	}, func() float64 { return float64(s.droppedLogEntries()) }))
+	webAuthn, err := newWebAuthn(cfg)
+	if err != nil {
+		logger.WithError(err).Error("Passkeys disabled: invalid WebAuthn configuration")
+	} else {
+		s.webAuthn = webAuthn
+	}
+	exporter, err := newSpanExporter(context.Background(), cfg.Tracing)
+	if err != nil {
+		logger.WithError(err).Error("Tracing disabled: failed to create span exporter")
+	} else if exporter != nil {
+		s.useTracerProvider(newTracerProvider(cfg.Tracing, exporter))
+	}
+	return s
+}
+
+// sendMail отправляет письмо в span "smtp send" и учитывает результат в
+// bookstore_emails_sent_total. Адреса получателей в трассу не попадают
+func (s *Server) sendMail(ctx context.Context, kind string, to []string, msg []byte) error {
+	_, span := s.tracer.Start(ctx, "smtp send", trace.WithSpanKind(trace.SpanKindClient), trace.WithAttributes(
+		attribute.String("email.kind", kind),
+		attribute.Int("email.recipients", len(to)),
+		semconv.ServerAddress(s.config.SMTP.Host),
+		semconv.ServerPort(s.config.SMTP.Port),
+	))
+	defer span.End()
+
+	err := s.mailer.SendMail(to, msg)
+	result := "success"
+	if err != nil {
+		result = "failure"
+		span.RecordError(err)
+		span.SetStatus(codes.Error, "failed to send email")
+	}
+	s.metrics.emails.WithLabelValues(kind, result).Inc()
+	return err
+}
+
+// staticDir — каталог со страницами, скриптами, стилями и картинками сайта.
+// Наружу отдаётся только он: в рабочем каталоге лежат .env, база и .git
+const staticDir = "static"
+
+// routes регистрирует все маршруты и оборачивает их в middleware
+func (s *Server) routes() http.Handler {
+	mux := http.NewServeMux()
+
+	// Обслуживание HTML-страниц
+	mux.Handle("/", http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == "/" {
+			http.ServeFile(w, r, filepath.Join(staticDir, "index.html"))
+		} else {
+			http.FileServer(http.Dir(staticDir)).ServeHTTP(w, r)
+		}
+	}))
+	mux.HandleFunc("/profile", func(w http.ResponseWriter, r *http.Request) {
+		http.ServeFile(w, r, filepath.Join(staticDir, "profile.html"))
+	})
+
+	mux.HandleFunc("/fantasy", func(w http.ResponseWriter, r *http.Request) {
+		if r.Method == http.MethodGet {
+			s.fantasyPageHandler(w, r)
+		} else if r.Method == http.MethodPost {
+			s.getFantasyBooks(w, r)
+		}
+	})
+	mux.HandleFunc("GET /fantasy.html", s.fantasyPageHandler)
+	mux.HandleFunc("/bouquiniste", func(w http.ResponseWriter, r *http.Request) {
+		http.ServeFile(w, r, filepath.Join(staticDir, "bouquiniste.html"))
+	})
+	mux.HandleFunc("/account", func(w http.ResponseWriter, r *http.Request) {
+		http.ServeFile(w, r, filepath.Join(staticDir, "account.html"))
+	})
+
+	mux.Handle("/api/profile", s.authMiddleware(http.HandlerFunc(s.profileHandler)))
+
+	mux.Handle("/admin", s.authMiddleware(s.requireRole("admin", http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		http.ServeFile(w, r, filepath.Join(staticDir, "profile.html"))
+	}))))
+
+	mux.HandleFunc("POST /login/2fa", s.loginTwoFactorHandler)
+	mux.Handle("GET /api/2fa", s.authMiddleware(http.HandlerFunc(s.twoFactorStatusHandler)))
+	mux.Handle("POST /api/2fa/setup", s.authMiddleware(http.HandlerFunc(s.twoFactorSetupHandler)))
+	mux.Handle("POST /api/2fa/enable", s.authMiddleware(http.HandlerFunc(s.twoFactorEnableHandler)))
+	mux.Handle("POST /api/2fa/recovery-codes", s.authMiddleware(http.HandlerFunc(s.twoFactorRecoveryCodesHandler)))
+	mux.Handle("POST /api/2fa/disable", s.authMiddleware(http.HandlerFunc(s.twoFactorDisableHandler)))
+
+	mux.Handle("GET /api/account", s.authMiddleware(http.HandlerFunc(s.accountHandler)))
+	mux.Handle("PATCH /api/account", s.authMiddleware(http.HandlerFunc(s.updateAccountHandler)))
+	mux.Handle("DELETE /api/account", s.authMiddleware(http.HandlerFunc(s.deleteAccountHandler)))
+	mux.Handle("POST /api/account/email", s.authMiddleware(http.HandlerFunc(s.changeEmailHandler)))
+	mux.Handle("POST /api/account/password", s.authMiddleware(http.HandlerFunc(s.changePasswordHandler)))
+	mux.Handle("GET /api/account/export", s.authMiddleware(http.HandlerFunc(s.exportAccountHandler)))
+	mux.HandleFunc("GET /account/email/confirm", s.confirmEmailChangeHandler)
+
+	mux.Handle("GET /api/passkeys", s.authMiddleware(http.HandlerFunc(s.listPasskeysHandler)))
+	mux.Handle("POST /api/passkeys/register/begin", s.authMiddleware(http.HandlerFunc(s.passkeyRegisterBeginHandler)))
+	mux.Handle("POST /api/passkeys/register/finish", s.authMiddleware(http.HandlerFunc(s.passkeyRegisterFinishHandler)))
+	mux.Handle("DELETE /api/passkeys/{id}", s.authMiddleware(http.HandlerFunc(s.deletePasskeyHandler)))
+	mux.HandleFunc("POST /auth/passkey/begin", s.passkeyLoginBeginHandler)
+	mux.HandleFunc("POST /auth/passkey/finish", s.passkeyLoginFinishHandler)
+
+	mux.HandleFunc("/check_country", func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodPost {
+			http.Error(w, "Method Not Allowed", http.StatusMethodNotAllowed)
+			return
+		}
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(map[string]string{"message": "Country check success!"})
+	})
+
+	// Каталог читают все, меняют администраторы и интеграции с API-ключом (apikeys.go)
+	mux.Handle("/books", s.allowAPIKey(scopeCatalogRead, http.HandlerFunc(s.getBooks)))
+	mux.Handle("POST /books/add", s.requireScope(scopeCatalogWrite, http.HandlerFunc(s.addBook)))
+	mux.Handle("PUT /books/update", s.requireScope(scopeCatalogWrite, http.HandlerFunc(s.updateBook)))
+	mux.Handle("DELETE /books/delete", s.requireScope(scopeCatalogWrite, http.HandlerFunc(s.deleteBook)))
+	mux.Handle("/books/search", s.allowAPIKey(scopeCatalogRead, http.HandlerFunc(s.getBookByID)))
+	mux.Handle("GET /admin/logs", s.authMiddleware(s.requireRole("admin", http.HandlerFunc(s.listLogsHandler))))
+	mux.Handle("GET /api/admin/api-keys", s.authMiddleware(s.requireRole("admin", http.HandlerFunc(s.listAPIKeysHandler))))
+	mux.Handle("POST /api/admin/api-keys", s.authMiddleware(s.requireRole("admin", http.HandlerFunc(s.createAPIKeyHandler))))
+	mux.Handle("DELETE /api/admin/api-keys/{id}", s.authMiddleware(s.requireRole("admin", http.HandlerFunc(s.revokeAPIKeyHandler))))
+	mux.HandleFunc("/send-message", s.handleSendMessage)
+	mux.HandleFunc("/register", s.registerHandler)
+	mux.HandleFunc("/verify", s.verifyEmailHandler)
+	mux.HandleFunc("/verify/code", s.verifyEmailCodeHandler)
+	mux.HandleFunc("/login", s.loginHandler)
+	mux.HandleFunc("/password/forgot", s.forgotPasswordHandler)
+	mux.HandleFunc("/password/reset", s.resetPasswordHandler)
+	mux.HandleFunc("/token/refresh", s.refreshHandler)
+	mux.HandleFunc("GET /auth/providers", s.oauthProvidersHandler)
+	mux.HandleFunc("GET /auth/{provider}/login", s.oauthLoginHandler)
+	mux.HandleFunc("GET /auth/{provider}/callback", s.oauthCallbackHandler)
+	mux.Handle("POST /api/auth/{provider}/link", s.authMiddleware(http.HandlerFunc(s.oauthLinkHandler)))
+	mux.Handle("/logout", s.authMiddleware(http.HandlerFunc(s.logoutHandler)))
+	mux.Handle("DELETE /api/sessions", s.authMiddleware(http.HandlerFunc(s.logoutAllHandler)))
+	mux.HandleFunc("/book/{slug}", s.bookPageHandler)
+	mux.HandleFunc("/sitemap.xml", s.sitemapHandler)
+	mux.HandleFunc("/healthz", s.healthHandler)
+	mux.Handle("GET /metrics", s.metricsHandler())
+	mux.HandleFunc("/.well-known/jwks.json", s.jwksHandler)
+
+	return s.traceRequests(mux, s.requestLogging(s.instrument(mux, s.securityHeaders(s.corsMiddleware(mux, s.rateLimitMiddleware(s.csrfMiddleware(mux)))))))
+}
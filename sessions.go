@@ -0,0 +1,217 @@
+package main
+
+import (
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/golang-jwt/jwt/v4"
+	"github.com/gorilla/sessions"
+	"github.com/sirupsen/logrus"
+)
+
+// Вход по cookie для страниц сайта: токены не попадают в JavaScript и их
+// нельзя украсть через XSS. Браузер хранит HttpOnly cookie с подписанным и
+// зашифрованным ID сессии, а сама сессия (отзыв, последняя активность) лежит
+// в таблице sessions рядом с сессиями refresh-токенов. authMiddleware
+// принимает cookie, когда нет заголовка Authorization.
+
+// sessionTouchInterval — как часто записывать время последнего запроса
+const sessionTouchInterval = time.Minute
+
+// newSessionStore создаёт хранилище cookie; ключи выводятся из JWT_SECRET
+func newSessionStore(cfg Config) *sessions.CookieStore {
+	derive := func(purpose string) []byte {
+		mac := hmac.New(sha256.New, []byte(cfg.JWT.Secret.Value()))
+		mac.Write([]byte(purpose))
+		return mac.Sum(nil)
+	}
+	store := sessions.NewCookieStore(derive("session cookie signing"), derive("session cookie encryption"))
+	store.MaxAge(int(cfg.Session.MaxAge.Seconds()))
+	store.Options.HttpOnly = true
+	store.Options.SameSite = http.SameSiteLaxMode
+	return store
+}
+
+// wantsCookieSession — страница сайта просит вход по cookie: POST /login?mode=cookie
+func wantsCookieSession(r *http.Request) bool {
+	return r.URL.Query().Get("mode") == "cookie"
+}
+
+// authenticatedByCookie — запрос прошёл authMiddleware по cookie, а не по Bearer-токену
+func authenticatedByCookie(r *http.Request) bool {
+	return r.Header.Get("Authorization") == ""
+}
+
+type cookieSignIn struct {
+	Session                string `json:"session"`
+	ExpiresIn              int    `json:"expires_in"`
+	TwoFactorSetupRequired bool   `json:"two_factor_setup_required,omitempty"`
+}
+
+// signIn завершает вход: отдаёт пару токенов или, если cookie == true, ставит cookie сессии
+func (s *Server) signIn(w http.ResponseWriter, r *http.Request, user User, mfa, cookie bool) error {
+	// Администратор без 2FA должен её подключить, иначе админские страницы закрыты
+	setupRequired := user.Role == "admin" && !mfa
+	var body any
+	if cookie {
+		if err := s.startCookieSession(w, r, user, mfa); err != nil {
+			return err
+		}
+		body = cookieSignIn{Session: "cookie", ExpiresIn: int(s.config.Session.IdleTimeout.Seconds()), TwoFactorSetupRequired: setupRequired}
+	} else {
+		pair, err := s.issueTokens(r.Context(), user, mfa)
+		if err != nil {
+			return err
+		}
+		pair.TwoFactorSetupRequired = setupRequired
+		body = pair
+	}
+	w.Header().Set("Content-Type", "application/json")
+	return json.NewEncoder(w).Encode(body)
+}
+
+func (s *Server) startCookieSession(w http.ResponseWriter, r *http.Request, user User, mfa bool) error {
+	ctx := r.Context()
+	now := time.Now()
+	// Прежняя сессия этого браузера больше не нужна
+	if old := s.cookieSessionID(r); old != "" {
+		if err := s.tokens.RevokeSession(ctx, old, now); err != nil {
+			return err
+		}
+	}
+
+	id, err := randomToken(16)
+	if err != nil {
+		return err
+	}
+	session := Session{ID: id, UserID: user.ID, MFA: mfa, Cookie: true, CreatedAt: now, LastSeenAt: &now}
+	if err := s.tokens.CreateSession(ctx, &session); err != nil {
+		return err
+	}
+	cookie, _ := s.cookies.New(r, s.config.Session.CookieName)
+	cookie.Values["sid"] = id
+	cookie.Options.Secure = s.secureCookies(r)
+	return cookie.Save(r, w)
+}
+
+// cookieSessionID — ID сессии из cookie; "" для отсутствующей или подделанной cookie
+func (s *Server) cookieSessionID(r *http.Request) string {
+	if _, err := r.Cookie(s.config.Session.CookieName); err != nil {
+		return ""
+	}
+	cookie, err := s.cookies.Get(r, s.config.Session.CookieName)
+	if err != nil {
+		return ""
+	}
+	id, _ := cookie.Values["sid"].(string)
+	return id
+}
+
+func (s *Server) clearSessionCookie(w http.ResponseWriter, r *http.Request) {
+	if _, err := r.Cookie(s.config.Session.CookieName); err != nil {
+		return
+	}
+	http.SetCookie(w, &http.Cookie{
+		Name:     s.config.Session.CookieName,
+		Path:     "/",
+		MaxAge:   -1,
+		HttpOnly: true,
+		Secure:   s.secureCookies(r),
+		SameSite: http.SameSiteLaxMode,
+	})
+}
+
+// cookieSessionClaims проверяет сессию из cookie и возвращает claims как у access-токена.
+// nil без ошибки — cookie нет, сессия отозвана или истекла
+func (s *Server) cookieSessionClaims(w http.ResponseWriter, r *http.Request) (*Claims, error) {
+	id := s.cookieSessionID(r)
+	if id == "" {
+		return nil, nil
+	}
+	ctx := r.Context()
+	session, err := s.tokens.GetSession(ctx, id)
+	if errors.Is(err, ErrNotFound) {
+		s.clearSessionCookie(w, r)
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	if !session.Cookie || session.RevokedAt != nil {
+		s.clearSessionCookie(w, r)
+		return nil, nil
+	}
+
+	now := time.Now()
+	lastSeen := session.CreatedAt
+	if session.LastSeenAt != nil {
+		lastSeen = *session.LastSeenAt
+	}
+	if now.Sub(lastSeen) > s.config.Session.IdleTimeout || now.Sub(session.CreatedAt) > s.config.Session.MaxAge {
+		if err := s.tokens.RevokeSession(ctx, id, now); err != nil {
+			return nil, err
+		}
+		s.clearSessionCookie(w, r)
+		return nil, nil
+	}
+
+	user, err := s.users.Get(ctx, session.UserID)
+	if errors.Is(err, ErrNotFound) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	if now.Sub(lastSeen) >= sessionTouchInterval {
+		if err := s.tokens.TouchSession(ctx, id, now); err != nil {
+			s.log(ctx).WithError(err).Error("Failed to update session activity")
+		}
+	}
+	return &Claims{
+		Email:     user.Email,
+		Role:      user.Role,
+		SessionID: session.ID,
+		MFA:       session.MFA,
+		RegisteredClaims: jwt.RegisteredClaims{
+			Subject: strconv.FormatUint(uint64(user.ID), 10),
+		},
+	}, nil
+}
+
+// Выход на всех устройствах: DELETE /api/sessions отзывает все сессии
+// пользователя — и cookie, и refresh-токенов
+func (s *Server) logoutAllHandler(w http.ResponseWriter, r *http.Request) {
+	claims := r.Context().Value("user").(*Claims)
+	if err := s.revokeAllSessions(r.Context(), claims); err != nil {
+		s.log(r.Context()).WithError(err).Error("Failed to revoke sessions")
+		http.Error(w, "Failed to log out", http.StatusInternalServerError)
+		return
+	}
+	s.clearSessionCookie(w, r)
+
+	s.log(r.Context()).WithFields(logrus.Fields{
+		"action":  "logout_all",
+		"user_id": claimsUserID(claims),
+	}).Info("User logged out on all devices")
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]string{"message": "Logged out on all devices"})
+}
+
+func (s *Server) revokeAllSessions(ctx context.Context, claims *Claims) error {
+	now := time.Now()
+	if err := s.tokens.RevokeUserSessions(ctx, claimsUserID(claims), now); err != nil {
+		return err
+	}
+	// Токен без сессии (выпущенный до появления сессий) отзываем отдельно
+	if claims.ID != "" && claims.ExpiresAt != nil {
+		return s.tokens.DenyToken(ctx, claims.ID, claims.ExpiresAt.Time)
+	}
+	return nil
+}
@@ -0,0 +1,179 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"golang.org/x/crypto/bcrypt"
+)
+
+// loginWithCookie входит как reader@example.com через POST /login?mode=cookie
+func loginWithCookie(t *testing.T, s *Server, handler http.Handler) *http.Cookie {
+	t.Helper()
+	hash, _ := bcrypt.GenerateFromPassword([]byte("secret123"), bcrypt.MinCost)
+	user := User{Email: "reader@example.com", PasswordHash: string(hash), Role: "user", Confirmed: true}
+	assert.NoError(t, s.users.Create(context.Background(), &user))
+
+	rr := postJSON(handler, "/login?mode=cookie", "", map[string]string{"email": user.Email, "password": "secret123"})
+	assert.Equal(t, http.StatusOK, rr.Code, "Expected 200 OK")
+	assert.NotContains(t, rr.Body.String(), `"token"`, "Tokens must not reach JavaScript")
+	var body cookieSignIn
+	assert.NoError(t, json.NewDecoder(rr.Body).Decode(&body))
+	assert.Equal(t, "cookie", body.Session)
+
+	for _, c := range rr.Result().Cookies() {
+		if c.Name == s.config.Session.CookieName {
+			return c
+		}
+	}
+	t.Fatal("Expected a session cookie")
+	return nil
+}
+
+// withCSRF повторяет CSRF-cookie в заголовке, как это делает script.js
+func withCSRF(req *http.Request) {
+	req.AddCookie(&http.Cookie{Name: csrfCookieName, Value: "csrf-test-token"})
+	req.Header.Set(csrfHeaderName, "csrf-test-token")
+}
+
+func cookieRequest(handler http.Handler, method, path string, cookies ...*http.Cookie) *httptest.ResponseRecorder {
+	req, _ := http.NewRequest(method, path, nil)
+	for _, c := range cookies {
+		req.AddCookie(c)
+	}
+	if method != http.MethodGet {
+		withCSRF(req)
+	}
+	rr := httptest.NewRecorder()
+	handler.ServeHTTP(rr, req)
+	return rr
+}
+
+func cookieSession(t *testing.T, s *Server) Session {
+	t.Helper()
+	user, _ := s.users.GetByEmail(context.Background(), "reader@example.com")
+	sessions, err := s.tokens.ListUserSessions(context.Background(), user.ID)
+	assert.NoError(t, err)
+	for _, session := range sessions {
+		if session.Cookie {
+			return session
+		}
+	}
+	t.Fatal("Expected a cookie session")
+	return Session{}
+}
+
+func TestCookieSessionLogin(t *testing.T) {
+	t.Parallel()
+	s := newMemoryTestServer(t)
+	handler := s.routes()
+	cookie := loginWithCookie(t, s, handler)
+	assert.True(t, cookie.HttpOnly)
+	assert.Equal(t, http.SameSiteLaxMode, cookie.SameSite)
+	assert.NotEqual(t, cookieSession(t, s).ID, cookie.Value, "The cookie carries an encrypted session ID")
+
+	rr := cookieRequest(handler, "GET", "/api/profile", cookie)
+	assert.Equal(t, http.StatusOK, rr.Code, "The session cookie authenticates requests")
+
+	forged := *cookie
+	forged.Value = strings.ToUpper(cookie.Value)
+	assert.Equal(t, http.StatusUnauthorized, cookieRequest(handler, "GET", "/api/profile", &forged).Code)
+
+	// Явный Bearer-токен важнее cookie
+	req, _ := http.NewRequest("GET", "/api/profile", nil)
+	req.AddCookie(cookie)
+	req.Header.Set("Authorization", "Bearer invalid")
+	rr = httptest.NewRecorder()
+	handler.ServeHTTP(rr, req)
+	assert.Equal(t, http.StatusUnauthorized, rr.Code)
+
+	// Выход по cookie — POST без тела, поэтому нужен CSRF-токен
+	csrf := &http.Cookie{Name: csrfCookieName, Value: "csrf-value"}
+	assert.Equal(t, http.StatusForbidden, cookieRequest(handler, "POST", "/logout", cookie, csrf).Code)
+	req, _ = http.NewRequest("POST", "/logout", nil)
+	req.AddCookie(cookie)
+	req.AddCookie(csrf)
+	req.Header.Set(csrfHeaderName, csrf.Value)
+	rr = httptest.NewRecorder()
+	handler.ServeHTTP(rr, req)
+	assert.Equal(t, http.StatusOK, rr.Code)
+	assert.Contains(t, rr.Header().Get("Set-Cookie"), s.config.Session.CookieName+"=;")
+
+	assert.Equal(t, http.StatusUnauthorized, cookieRequest(handler, "GET", "/api/profile", cookie).Code, "The session ends on logout")
+}
+
+func TestCookieSessionIdleTimeout(t *testing.T) {
+	t.Parallel()
+	s := newMemoryTestServer(t)
+	handler := s.routes()
+	cookie := loginWithCookie(t, s, handler)
+	ctx := context.Background()
+	id := cookieSession(t, s).ID
+
+	// Активность продлевает сессию
+	assert.NoError(t, s.tokens.TouchSession(ctx, id, time.Now().Add(-29*time.Minute)))
+	assert.Equal(t, http.StatusOK, cookieRequest(handler, "GET", "/api/profile", cookie).Code)
+	session, _ := s.tokens.GetSession(ctx, id)
+	assert.WithinDuration(t, time.Now(), *session.LastSeenAt, time.Second)
+
+	assert.NoError(t, s.tokens.TouchSession(ctx, id, time.Now().Add(-31*time.Minute)))
+	assert.Equal(t, http.StatusUnauthorized, cookieRequest(handler, "GET", "/api/profile", cookie).Code, "Idle sessions expire")
+	session, _ = s.tokens.GetSession(ctx, id)
+	assert.NotNil(t, session.RevokedAt)
+}
+
+func TestLogoutAllDevices(t *testing.T) {
+	t.Parallel()
+	s := newMemoryTestServer(t)
+	handler := s.routes()
+	cookie := loginWithCookie(t, s, handler)
+
+	rr := postJSON(handler, "/login", "", map[string]string{"email": "reader@example.com", "password": "secret123"})
+	var pair tokenPair
+	assert.NoError(t, json.NewDecoder(rr.Body).Decode(&pair))
+	assert.Equal(t, http.StatusOK, getProfile(handler, pair.AccessToken))
+
+	rr = cookieRequest(handler, "DELETE", "/api/sessions", cookie)
+	assert.Equal(t, http.StatusOK, rr.Code, "Expected 200 OK")
+
+	assert.Equal(t, http.StatusUnauthorized, cookieRequest(handler, "GET", "/api/profile", cookie).Code)
+	assert.Equal(t, http.StatusUnauthorized, getProfile(handler, pair.AccessToken), "Other devices are signed out too")
+	rr = postJSON(handler, "/token/refresh", "", map[string]string{"refresh_token": pair.RefreshToken})
+	assert.Equal(t, http.StatusUnauthorized, rr.Code)
+}
+
+func TestChangePasswordKeepsCookieSession(t *testing.T) {
+	t.Parallel()
+	s := newMemoryTestServer(t)
+	handler := s.routes()
+	cookie := loginWithCookie(t, s, handler)
+	old := cookieSession(t, s).ID
+
+	req, _ := http.NewRequest("POST", "/api/account/password", strings.NewReader(`{"current_password":"secret123","new_password":"turn-the-page-42"}`))
+	req.Header.Set("Content-Type", "application/json")
+	req.AddCookie(cookie)
+	withCSRF(req)
+	rr := httptest.NewRecorder()
+	handler.ServeHTTP(rr, req)
+	assert.Equal(t, http.StatusOK, rr.Code, "Expected 200 OK")
+	assert.NotContains(t, rr.Body.String(), `"token"`)
+
+	var fresh *http.Cookie
+	for _, c := range rr.Result().Cookies() {
+		if c.Name == s.config.Session.CookieName {
+			fresh = c
+		}
+	}
+	if assert.NotNil(t, fresh, "The browser gets a new session cookie") {
+		assert.Equal(t, http.StatusOK, cookieRequest(handler, "GET", "/api/profile", fresh).Code)
+	}
+	assert.Equal(t, http.StatusUnauthorized, cookieRequest(handler, "GET", "/api/profile", cookie).Code)
+	session, _ := s.tokens.GetSession(context.Background(), old)
+	assert.NotNil(t, session.RevokedAt)
+}
@@ -0,0 +1,1131 @@
+console.log("JavaScript loaded"); // Проверка загрузки скрипта
+console.log("✅ script.js загружен!");
+
+document.addEventListener("DOMContentLoaded", function () {
+    console.log("✅ script.js загружен и выполняется");
+    const registerForm = document.getElementById("registerForm");
+    console.log("🔍 Найдена ли форма:", registerForm);
+
+
+    const name = document.getElementById("name").value;
+    const email = document.getElementById("email").value;
+    console.log("📤 Отправляем данные на сервер:", { name, email });
+
+    if (registerForm) {
+        console.log("✅ Форма регистрации найдена!");
+
+        registerForm.addEventListener("submit", async function (event) {
+            
+            console.log("✅ Обработчик submit сработал!");
+            const formData = new FormData(registerForm);
+            const data = Object.fromEntries(formData.entries());
+
+            if (data.password !== data.confirmPassword) {
+                alert("Passwords do not match");
+                return;
+            }
+
+            console.log("Отправка данных на сервер:", data);
+
+            try {
+                const response = await fetch("/register", {
+                    method: "POST",
+                    headers: jsonHeaders(),
+                    body: JSON.stringify({
+                        name: data.name,
+                        email: data.email,
+                        password: data.password,
+                    }),
+                });
+
+                const result = await response.json();
+                console.log("Ответ сервера:", result);
+
+                document.getElementById("statusMessage").innerText = result.message;
+            } catch (error) {
+                console.error("Ошибка регистрации:", error);
+            }
+        });
+    }
+
+    // Подтверждение email кодом из письма вместо ссылки
+    const verifyCodeForm = document.getElementById("verifyCodeForm");
+    if (verifyCodeForm) {
+        verifyCodeForm.addEventListener("submit", async function (event) {
+            event.preventDefault();
+            const status = document.getElementById("statusMessage");
+
+            const response = await fetch("/verify/code", {
+                method: "POST",
+                headers: jsonHeaders(),
+                body: JSON.stringify({
+                    email: document.getElementById("email").value,
+                    code: document.getElementById("verifyCode").value,
+                }),
+            });
+            if (response.ok) {
+                status.innerText = (await response.json()).message;
+            } else {
+                status.innerText = await response.text();
+            }
+        });
+    }
+});
+document.addEventListener("DOMContentLoaded", function () {
+    const loginForm = document.getElementById("loginForm");
+
+    if (loginForm) {
+        loginForm.addEventListener("submit", async function (event) {
+            event.preventDefault();
+
+            const email = document.getElementById("email").value;
+            const password = document.getElementById("password").value;
+
+            console.log("📤 Отправка запроса на сервер:", {
+                method: "POST",
+                url: "/login",
+                body: { email, password }
+            });
+
+            try {
+                const response = await fetch("/login?mode=cookie", {
+                    method: "POST",
+                    headers: jsonHeaders(),
+                    body: JSON.stringify({ email, password }),
+                });
+
+                console.log("📥 Ответ сервера:", response);
+
+                if (!response.ok) {
+                    // 401 — неверный email или пароль, 429 — слишком много неудачных попыток
+                    document.getElementById("statusMessage").innerText = "❌ " + await response.text();
+                    return;
+                }
+                const result = await response.json();
+                console.log("📩 JSON-ответ сервера:", result);
+
+                if (response.ok && result.two_factor_required) {
+                    showTwoFactorForm(result.two_factor_token);
+                } else {
+                    rememberCookieSession();
+                    document.getElementById("statusMessage").innerText = "✅ Login successful! Redirecting...";
+                    setTimeout(() => {
+                        window.location.href = "me.html";
+                    }, 1000);
+                }
+            } catch (error) {
+                console.error("❌ Ошибка входа:", error);
+                document.getElementById("statusMessage").innerText = "❌ Ошибка соединения с сервером";
+            }
+        });
+    }
+
+    // Второй шаг входа: код из приложения или код восстановления
+    const twoFactorForm = document.getElementById("twoFactorForm");
+    if (twoFactorForm) {
+        const fragment = new URLSearchParams(window.location.hash.slice(1));
+        if (fragment.has("two_factor_token")) {
+            // Вход через внешнего провайдера тоже требует второй фактор
+            showTwoFactorForm(fragment.get("two_factor_token"));
+            history.replaceState(null, "", window.location.pathname);
+        }
+
+        twoFactorForm.addEventListener("submit", async function (event) {
+            event.preventDefault();
+            const value = document.getElementById("twoFactorCode").value.trim();
+            const body = { two_factor_token: twoFactorForm.dataset.token };
+            if (/^\d{6}$/.test(value)) {
+                body.code = value;
+            } else {
+                body.recovery_code = value;
+            }
+
+            const response = await fetch("/login/2fa?mode=cookie", {
+                method: "POST",
+                headers: jsonHeaders(),
+                body: JSON.stringify(body),
+            });
+            if (!response.ok) {
+                document.getElementById("statusMessage").innerText = "❌ " + await response.text();
+                return;
+            }
+            rememberCookieSession();
+            window.location.href = "me.html";
+        });
+    }
+
+    // Вход по passkey: email не нужен, браузер сам предложит сохранённый ключ
+    const passkeyLoginButton = document.getElementById("passkeyLoginButton");
+    if (passkeyLoginButton && window.PublicKeyCredential) {
+        passkeyLoginButton.style.display = "inline-block";
+        passkeyLoginButton.addEventListener("click", async function () {
+            const status = document.getElementById("statusMessage");
+            try {
+                const begin = await fetch("/auth/passkey/begin", {
+                    method: "POST",
+                    headers: { "X-CSRF-Token": csrfToken() },
+                });
+                const { session, options } = await begin.json();
+                const publicKey = options.publicKey;
+                publicKey.challenge = base64urlToBuffer(publicKey.challenge);
+                (publicKey.allowCredentials || []).forEach((c) => (c.id = base64urlToBuffer(c.id)));
+
+                const credential = await navigator.credentials.get({ publicKey });
+                const response = await fetch(`/auth/passkey/finish?mode=cookie&session=${encodeURIComponent(session)}`, {
+                    method: "POST",
+                    headers: jsonHeaders(),
+                    body: JSON.stringify(credentialToJSON(credential)),
+                });
+                if (!response.ok) {
+                    status.innerText = "❌ " + await response.text();
+                    return;
+                }
+                rememberCookieSession();
+                window.location.href = "me.html";
+            } catch (error) {
+                console.error("❌ Ошибка входа по passkey:", error);
+                status.innerText = "❌ Passkey sign-in was cancelled or failed";
+            }
+        });
+    }
+
+    // Сброс пароля: ссылка приходит на почту, ответ сервера одинаковый для любого email
+    const forgotPasswordLink = document.getElementById("forgotPasswordLink");
+    if (forgotPasswordLink) {
+        forgotPasswordLink.addEventListener("click", async function (event) {
+            event.preventDefault();
+            const email = document.getElementById("email").value;
+            if (!email) {
+                document.getElementById("statusMessage").innerText = "Enter your email first";
+                return;
+            }
+            const response = await fetch("/password/forgot", {
+                method: "POST",
+                headers: jsonHeaders(),
+                body: JSON.stringify({ email }),
+            });
+            const result = await response.json();
+            document.getElementById("statusMessage").innerText = result.message;
+        });
+    }
+
+    const resetPasswordForm = document.getElementById("resetPasswordForm");
+    if (resetPasswordForm) {
+        resetPasswordForm.addEventListener("submit", async function (event) {
+            event.preventDefault();
+            const status = document.getElementById("statusMessage");
+            const password = document.getElementById("newPassword").value;
+            if (password !== document.getElementById("confirmNewPassword").value) {
+                status.innerText = "Passwords do not match";
+                return;
+            }
+            const token = new URLSearchParams(window.location.hash.slice(1)).get("token");
+
+            const response = await fetch("/password/reset", {
+                method: "POST",
+                headers: jsonHeaders(),
+                body: JSON.stringify({ token, password }),
+            });
+            if (response.ok) {
+                forgetSession();
+                status.innerText = (await response.json()).message;
+                setTimeout(() => {
+                    window.location.href = "signin.html";
+                }, 1500);
+            } else {
+                status.innerText = await response.text();
+            }
+        });
+    }
+
+    // Кнопки входа через внешних провайдеров
+    const ssoButtons = document.getElementById("ssoButtons");
+    if (ssoButtons) {
+        fetch("/auth/providers")
+            .then((response) => response.json())
+            .then((providers) => {
+                providers.forEach((provider) => {
+                    const link = document.createElement("a");
+                    link.href = provider.login_url;
+                    link.textContent = "Sign in with " + provider.label;
+                    ssoButtons.appendChild(link);
+                });
+            })
+            .catch((error) => console.error("❌ Не удалось загрузить список провайдеров:", error));
+    }
+
+    // Проверка токена и редирект
+    if (window.location.pathname.endsWith("me.html")) {
+        // После входа через Google и других провайдеров сервер уже выдал cookie сессии
+        const fragment = new URLSearchParams(window.location.hash.slice(1));
+        if (fragment.get("session") === "cookie") {
+            rememberCookieSession();
+            history.replaceState(null, "", window.location.pathname);
+        }
+        if (fragment.get("linked")) {
+            document.getElementById("linkStatus").innerText = "Аккаунт " + fragment.get("linked") + " привязан";
+            history.replaceState(null, "", window.location.pathname);
+        }
+        fetchProfile();
+        loadTwoFactor();
+        loadPasskeys();
+        loadLinkButtons();
+    }
+
+    // Обработчик выхода (Logout)
+    const logoutButton = document.getElementById("logoutButton");
+    if (logoutButton) {
+        logoutButton.addEventListener("click", async function () {
+            if (signedIn()) {
+                // Отзываем сессию на сервере, ошибки не мешают выходу
+                await fetch("/logout", {
+                    method: "POST",
+                    headers: { ...authHeaders(), "X-CSRF-Token": csrfToken() },
+                }).catch(() => {});
+            }
+            forgetSession();
+            window.location.href = "signin.html"; // Перенаправление на страницу входа
+        });
+    }
+
+    // Выход на всех устройствах: сервер отзывает все сессии пользователя
+    const logoutAllButton = document.getElementById("logoutAllButton");
+    if (logoutAllButton) {
+        logoutAllButton.addEventListener("click", async function () {
+            if (!confirm("Sign out on all devices?")) {
+                return;
+            }
+            await fetch("/api/sessions", { method: "DELETE", headers: authHeaders() }).catch(() => {});
+            forgetSession();
+            window.location.href = "signin.html";
+        });
+    }
+});
+
+// Функция загрузки профиля
+// async function fetchProfile() {
+//     const token = localStorage.getItem("token");
+
+//     if (!token) {
+//         console.log("❌ Пользователь не авторизован. Перенаправляем на страницу входа.");
+//         window.location.href = "account.html";
+//         return;
+//     }
+
+//     try {
+//         const response = await fetch("/api/profile", {
+//             method: "GET",
+//             headers: { Authorization: `Bearer ${token}` },
+//         });
+
+//         if (!response.ok) {
+//             console.log("❌ Ошибка загрузки профиля. Перенаправляем на вход...");
+//             localStorage.removeItem("token");
+//             window.location.href = "account.html";
+//             return;
+//         }
+
+//         const data = await response.json();
+//         console.log("✅ Данные профиля:", data);
+
+//         document.getElementById("profileInfo").innerText = `Добро пожаловать, ${data.message}`;
+
+//         // Проверяем роль пользователя
+//         if (data.role === "admin") {
+//             document.getElementById("adminSection").style.display = "block";
+//         } else {
+//             document.getElementById("adminSection").style.display = "none";
+//         }
+//     } catch (error) {
+//         console.error("❌ Ошибка загрузки профиля:", error);
+//         document.getElementById("profileInfo").innerText = "Ошибка загрузки профиля!";
+//     }
+//     document.addEventListener("DOMContentLoaded", fetchProfile);
+// }
+// Страницы сайта входят по HttpOnly cookie: в localStorage только отметка о входе
+function rememberCookieSession() {
+    localStorage.removeItem("token");
+    localStorage.removeItem("refresh_token");
+    localStorage.setItem("session", "cookie");
+}
+
+function forgetSession() {
+    localStorage.removeItem("session");
+    localStorage.removeItem("token");
+    localStorage.removeItem("refresh_token");
+}
+
+function signedIn() {
+    return localStorage.getItem("session") === "cookie" || localStorage.getItem("token") !== null;
+}
+
+// Заголовок Authorization нужен только для входа по токену, cookie браузер отправит сам
+// CSRF-токен нужен всем изменяющим запросам, которые входят по cookie сессии
+function authHeaders() {
+    const token = localStorage.getItem("token");
+    return token ? { Authorization: `Bearer ${token}` } : { "X-CSRF-Token": csrfToken() };
+}
+
+function jsonHeaders() {
+    return { "Content-Type": "application/json", "X-CSRF-Token": csrfToken() };
+}
+
+// Обмен refresh-токена на новую пару, когда access-токен истёк
+async function refreshTokens() {
+    const refreshToken = localStorage.getItem("refresh_token");
+    if (!refreshToken) {
+        return false;
+    }
+    const response = await fetch("/token/refresh", {
+        method: "POST",
+        headers: jsonHeaders(),
+        body: JSON.stringify({ refresh_token: refreshToken }),
+    });
+    if (!response.ok) {
+        localStorage.removeItem("refresh_token");
+        return false;
+    }
+    const result = await response.json();
+    localStorage.setItem("token", result.token);
+    localStorage.setItem("refresh_token", result.refresh_token);
+    return true;
+}
+
+async function fetchProfile(retried = false) {
+    if (!signedIn()) {
+        console.log("❌ Пользователь не авторизован. Перенаправляем на страницу входа.");
+        window.location.href = "account.html";
+        return;
+    }
+
+    try {
+        const response = await fetch("/api/profile", {
+            method: "GET",
+            headers: authHeaders(),
+        });
+
+        console.log("📡 Ответ сервера:", response.status); // Логируем статус ответа
+
+        if (response.status === 401 && !retried && await refreshTokens()) {
+            return fetchProfile(true);
+        }
+
+        if (!response.ok) {
+            console.log("❌ Ошибка загрузки профиля. Перенаправляем на вход...");
+            forgetSession();
+            window.location.href = "account.html";
+            return;
+        }
+
+        let data;
+        try {
+            data = await response.json();
+        } catch (jsonError) {
+            console.error("❌ Ошибка обработки JSON:", jsonError);
+            document.getElementById("profileInfo").innerText = "Ошибка загрузки профиля!";
+            return;
+        }
+
+        console.log("✅ Данные профиля:", data);
+
+        document.getElementById("profileInfo").innerText = `Добро пожаловать, ${data.message}`;
+
+        // Проверяем роль пользователя
+        const adminSection = document.getElementById("adminSection");
+        if (adminSection) {
+            adminSection.style.display = data.role === "admin" ? "block" : "none";
+        }
+    } catch (error) {
+        console.error("❌ Ошибка сети при загрузке профиля:", error);
+        document.getElementById("profileInfo").innerText = "Ошибка сети!";
+    }
+}
+
+function showTwoFactorForm(token) {
+    const loginForm = document.getElementById("loginForm");
+    const twoFactorForm = document.getElementById("twoFactorForm");
+    if (loginForm) {
+        loginForm.style.display = "none";
+    }
+    twoFactorForm.dataset.token = token;
+    twoFactorForm.style.display = "block";
+    document.getElementById("statusMessage").innerText = "Enter the code from your authenticator app";
+}
+
+async function twoFactorRequest(method, path, body) {
+    return fetch(path, {
+        method,
+        headers: {
+            "Content-Type": "application/json",
+            ...authHeaders(),
+        },
+        body: body ? JSON.stringify(body) : undefined,
+    });
+}
+
+// Подключение 2FA в личном кабинете
+async function loadTwoFactor() {
+    const status = document.getElementById("twoFactorStatus");
+    if (!status || !signedIn()) {
+        return;
+    }
+    const response = await twoFactorRequest("GET", "/api/2fa");
+    if (!response.ok) {
+        return;
+    }
+    const data = await response.json();
+    const setupButton = document.getElementById("twoFactorSetupButton");
+    if (data.enabled) {
+        status.innerText = `Включена. Осталось кодов восстановления: ${data.recovery_codes_left}`;
+        setupButton.style.display = "none";
+        return;
+    }
+    status.innerText = data.required
+        ? "Для администраторов двухфакторная аутентификация обязательна"
+        : "Выключена";
+    setupButton.style.display = "inline-block";
+    setupButton.onclick = async function () {
+        const setup = await twoFactorRequest("POST", "/api/2fa/setup");
+        if (!setup.ok) {
+            status.innerText = await setup.text();
+            return;
+        }
+        const result = await setup.json();
+        document.getElementById("twoFactorQR").src = result.qr_code;
+        document.getElementById("twoFactorSecret").innerText = result.secret;
+        document.getElementById("twoFactorEnroll").style.display = "block";
+    };
+    document.getElementById("twoFactorEnableButton").onclick = async function () {
+        const code = document.getElementById("twoFactorEnableCode").value.trim();
+        const enable = await twoFactorRequest("POST", "/api/2fa/enable", { code });
+        if (!enable.ok) {
+            status.innerText = await enable.text();
+            return;
+        }
+        const result = await enable.json();
+        document.getElementById("twoFactorEnroll").style.display = "none";
+        document.getElementById("recoveryCodes").innerText =
+            "Сохраните коды восстановления, они показываются один раз:\n" + result.recovery_codes.join("\n");
+        loadTwoFactor();
+    };
+}
+
+// CSRF-токен из cookie; нужен для POST без JSON и без Authorization
+function csrfToken() {
+    const cookie = document.cookie.split("; ").find((c) => c.startsWith("csrf_token="));
+    return cookie ? cookie.slice("csrf_token=".length) : "";
+}
+
+// WebAuthn API работает с ArrayBuffer, сервер — с base64url
+function base64urlToBuffer(value) {
+    const base64 = value.replace(/-/g, "+").replace(/_/g, "/");
+    const binary = atob(base64.padEnd(base64.length + (4 - base64.length % 4) % 4, "="));
+    return Uint8Array.from(binary, (c) => c.charCodeAt(0)).buffer;
+}
+
+function bufferToBase64url(buffer) {
+    const binary = String.fromCharCode(...new Uint8Array(buffer));
+    return btoa(binary).replace(/\+/g, "-").replace(/\//g, "_").replace(/=+$/, "");
+}
+
+function credentialToJSON(credential) {
+    const response = { clientDataJSON: bufferToBase64url(credential.response.clientDataJSON) };
+    if (credential.response.attestationObject) {
+        response.attestationObject = bufferToBase64url(credential.response.attestationObject);
+        response.transports = credential.response.getTransports ? credential.response.getTransports() : [];
+    } else {
+        response.authenticatorData = bufferToBase64url(credential.response.authenticatorData);
+        response.signature = bufferToBase64url(credential.response.signature);
+        if (credential.response.userHandle) {
+            response.userHandle = bufferToBase64url(credential.response.userHandle);
+        }
+    }
+    return {
+        id: credential.id,
+        rawId: bufferToBase64url(credential.rawId),
+        type: credential.type,
+        response,
+        clientExtensionResults: credential.getClientExtensionResults(),
+    };
+}
+
+// Ключи доступа в личном кабинете
+async function loadPasskeys() {
+    const section = document.getElementById("passkeySection");
+    if (!section || !window.PublicKeyCredential || !signedIn()) {
+        return;
+    }
+    const status = document.getElementById("passkeyStatus");
+    const response = await twoFactorRequest("GET", "/api/passkeys");
+    if (!response.ok) {
+        return;
+    }
+    section.style.display = "block";
+    const list = document.getElementById("passkeyList");
+    list.innerHTML = "";
+    (await response.json()).forEach((passkey) => {
+        const item = document.createElement("li");
+        const lastUsed = passkey.last_used_at ? new Date(passkey.last_used_at).toLocaleString() : "не использовался";
+        item.textContent = `${passkey.name} (добавлен ${new Date(passkey.created_at).toLocaleDateString()}, вход: ${lastUsed}) `;
+        const remove = document.createElement("button");
+        remove.textContent = "Удалить";
+        remove.onclick = async function () {
+            await twoFactorRequest("DELETE", `/api/passkeys/${passkey.id}`);
+            loadPasskeys();
+        };
+        item.appendChild(remove);
+        list.appendChild(item);
+    });
+
+    document.getElementById("passkeyAddButton").onclick = async function () {
+        try {
+            const name = document.getElementById("passkeyName").value.trim();
+            const begin = await twoFactorRequest("POST", "/api/passkeys/register/begin", { name });
+            if (!begin.ok) {
+                status.innerText = await begin.text();
+                return;
+            }
+            const { session, options } = await begin.json();
+            const publicKey = options.publicKey;
+            publicKey.challenge = base64urlToBuffer(publicKey.challenge);
+            publicKey.user.id = base64urlToBuffer(publicKey.user.id);
+            (publicKey.excludeCredentials || []).forEach((c) => (c.id = base64urlToBuffer(c.id)));
+
+            const credential = await navigator.credentials.create({ publicKey });
+            const finish = await twoFactorRequest("POST",
+                `/api/passkeys/register/finish?session=${encodeURIComponent(session)}`, credentialToJSON(credential));
+            status.innerText = finish.ok ? "Ключ добавлен" : await finish.text();
+            loadPasskeys();
+        } catch (error) {
+            console.error("❌ Ошибка регистрации passkey:", error);
+            status.innerText = "Не удалось добавить ключ";
+        }
+    };
+}
+
+// Привязка внешних провайдеров: сервер связывает учётную запись провайдера
+// с вошедшим пользователем, только если связывание начал он сам
+async function loadLinkButtons() {
+    const section = document.getElementById("linkedSection");
+    if (!section || localStorage.getItem("session") !== "cookie") {
+        return;
+    }
+    const response = await fetch("/auth/providers");
+    if (!response.ok) {
+        return;
+    }
+    const providers = await response.json();
+    if (providers.length === 0) {
+        return;
+    }
+    section.style.display = "block";
+    const buttons = document.getElementById("linkButtons");
+    buttons.innerHTML = "";
+    providers.forEach((provider) => {
+        const button = document.createElement("button");
+        button.textContent = "Привязать " + provider.label;
+        button.onclick = async function () {
+            const link = await twoFactorRequest("POST", `/api/auth/${encodeURIComponent(provider.name)}/link`);
+            if (!link.ok) {
+                document.getElementById("linkStatus").innerText = await link.text();
+                return;
+            }
+            window.location.href = (await link.json()).url;
+        };
+        buttons.appendChild(button);
+    });
+}
+
+// Управление аккаунтом на profile.html
+async function loadAccountSettings() {
+    const section = document.getElementById("accountSettings");
+    if (!section || !signedIn()) {
+        return;
+    }
+    const status = document.getElementById("accountStatus");
+    const response = await twoFactorRequest("GET", "/api/account");
+    if (!response.ok) {
+        return;
+    }
+    const account = await response.json();
+    section.style.display = "block";
+    document.getElementById("accountName").value = account.name;
+    document.getElementById("accountEmail").innerText = account.email;
+    document.getElementById("accountPendingEmail").innerText = account.pending_email
+        ? `(waiting for confirmation of ${account.pending_email})`
+        : "";
+
+    document.getElementById("accountNameForm").onsubmit = async function (event) {
+        event.preventDefault();
+        const update = await twoFactorRequest("PATCH", "/api/account", {
+            name: document.getElementById("accountName").value,
+        });
+        status.innerText = update.ok ? "Saved" : await update.text();
+    };
+    document.getElementById("accountEmailForm").onsubmit = async function (event) {
+        event.preventDefault();
+        const change = await twoFactorRequest("POST", "/api/account/email", {
+            email: document.getElementById("accountNewEmail").value,
+            password: document.getElementById("accountEmailPassword").value,
+        });
+        status.innerText = change.ok ? (await change.json()).message : await change.text();
+        loadAccountSettings();
+    };
+    document.getElementById("accountPasswordForm").onsubmit = async function (event) {
+        event.preventDefault();
+        const change = await twoFactorRequest("POST", "/api/account/password", {
+            current_password: document.getElementById("accountCurrentPassword").value,
+            new_password: document.getElementById("accountNewPassword").value,
+        });
+        if (!change.ok) {
+            status.innerText = await change.text();
+            return;
+        }
+        // Остальные сессии завершены, эта получает новую cookie или новые токены
+        const result = await change.json();
+        if (result.token) {
+            localStorage.setItem("token", result.token);
+            localStorage.setItem("refresh_token", result.refresh_token);
+        }
+        status.innerText = "Password changed";
+    };
+    document.getElementById("accountExportButton").onclick = async function () {
+        const exported = await twoFactorRequest("GET", "/api/account/export");
+        if (!exported.ok) {
+            status.innerText = await exported.text();
+            return;
+        }
+        const link = document.createElement("a");
+        link.href = URL.createObjectURL(await exported.blob());
+        link.download = `bookstore-account-${account.id}.json`;
+        link.click();
+        URL.revokeObjectURL(link.href);
+    };
+    document.getElementById("accountDeleteForm").onsubmit = async function (event) {
+        event.preventDefault();
+        if (!confirm("Delete your account and all its data? This cannot be undone.")) {
+            return;
+        }
+        const removed = await twoFactorRequest("DELETE", "/api/account", {
+            password: document.getElementById("accountDeletePassword").value,
+        });
+        if (!removed.ok) {
+            status.innerText = await removed.text();
+            return;
+        }
+        forgetSession();
+        window.location.href = "index.html";
+    };
+}
+
+// Вызываем `fetchProfile()` при загрузке страницы
+document.addEventListener("DOMContentLoaded", fetchProfile);
+
+
+
+
+// Находим кнопку и меню
+const accountToggle = document.querySelector('.account-toggle');
+const accountMenu = document.querySelector('.account-menu');
+
+// Добавляем обработчик клика
+accountToggle.addEventListener('click', (e) => {
+  e.preventDefault(); // Предотвращаем переход по ссылке
+  
+  // Получаем размеры и положение кнопки
+  const rect = accountToggle.getBoundingClientRect();
+  
+  // Устанавливаем позицию меню относительно кнопки
+  accountMenu.style.left = `${rect.left}px`;  // Выравнивание по левому краю кнопки
+  accountMenu.style.top = `${rect.bottom + window.scrollY}px`;  // Вычисление нижнего отступа
+  
+  // Переключаем видимость меню
+  accountMenu.style.display = accountMenu.style.display === 'block' ? 'none' : 'block';
+});
+
+// Закрытие меню при клике вне его области
+document.addEventListener('click', (e) => {
+  if (!accountToggle.contains(e.target) && !accountMenu.contains(e.target)) {
+    accountMenu.style.display = 'none';
+  }
+});
+
+
+////
+// Получение элементов
+const createBookBtn = document.getElementById('create-book-btn');
+const readBooksBtn = document.getElementById('read-books-btn');
+const updateBookBtn = document.getElementById('update-book-btn');
+const deleteBookBtn = document.getElementById('delete-book-btn');
+const booksTable = document.getElementById('books-table').getElementsByTagName('tbody')[0];
+
+// Функция для обновления таблицы
+function updateBooksTable(books) {
+    booksTable.innerHTML = ''; // Очистить таблицу
+    books.forEach(book => {
+        const row = booksTable.insertRow();
+        row.innerHTML = `
+            <td>${book.id}</td>
+            <td>${book.title}</td>
+            <td>${book.author}</td>
+            <td>${book.published}</td>
+        `;
+    });
+}
+
+// Функция для получения всех книг
+async function fetchBooks() {
+    try {
+        const response = await fetch('/books'); // Адрес вашего сервера
+        const books = await response.json();
+        updateBooksTable(books);
+    } catch (error) {
+        console.error('Error fetching books:', error);
+    }
+}
+
+// Обработчик для кнопки "View All Books"
+readBooksBtn.addEventListener('click', fetchBooks);
+
+// Обработчик для кнопки "Add a New Book"
+createBookBtn.addEventListener('click', async () => {
+    const title = prompt("Enter book title:");
+    const author = prompt("Enter book author:");
+    const published = prompt("Enter book published date (YYYY-MM-DD):");
+
+    const errorContainer = document.getElementById('error-container'); // Контейнер для ошибок
+
+    // Очищаем ошибки перед новой проверкой
+    errorContainer.textContent = '';
+    errorContainer.style.display = 'none';
+
+    // Проверка, заполнены ли все поля
+    if (!title || !author || !published) {
+        showError("All fields are required!");
+        return;
+    }
+
+    const newBook = { title, author, published };
+
+    try {
+        const response = await fetch('/books/add', {
+            method: 'POST',
+            headers: {
+                'Content-Type': 'application/json',
+                ...authHeaders(), // каталог меняют только администраторы
+            },
+            body: JSON.stringify(newBook),
+        });
+
+        if (response.ok) {
+            alert('Book added successfully!');
+            fetchBooks(); // Обновить список книг
+        } else {
+            // Если сервер вернул ошибку, извлекаем её из JSON-ответа
+            const errorData = await response.json();
+            showError(`Failed to add the book: ${errorData.error}`);
+        }
+    } catch (error) {
+        console.error('Error adding book:', error);
+        showError('An unexpected error occurred while adding the book.');
+    }
+});
+
+// Функция для отображения ошибок
+function showError(message) {
+    const errorContainer = document.getElementById('error-container');
+    errorContainer.textContent = message;
+    errorContainer.style.display = 'block';
+}
+
+// Обработчик для кнопки "Update Book"
+updateBookBtn.addEventListener('click', async () => {
+    const id = prompt("Enter book ID to update:");
+    const title = prompt("Enter new book title:");
+    const author = prompt("Enter new book author:");
+    const published = prompt("Enter new published date (YYYY-MM-DD):");
+
+    if (id && title && author && published) {
+        // Добавляем id в объект
+        const updatedBook = { id: parseInt(id), title, author, published };
+
+        try {
+            const response = await fetch("/books/update", {
+                method: 'PUT', // Метод PUT для обновления
+                headers: {
+                    'Content-Type': 'application/json',
+                    ...authHeaders(),
+                },
+                body: JSON.stringify(updatedBook), // Тело запроса
+            });
+
+            if (response.ok) {
+                alert('Book updated successfully!');
+                fetchBooks(); // Обновить список книг
+            } else {
+                const errorText = await response.text();
+                alert("Failed to update the book: ${errorText}");
+            }
+        } catch (error) {
+            console.error('Error updating book:', error);
+        }
+    } else {
+        alert('All fields are required!');
+    }
+});
+
+// Обработчик для кнопки "Delete Book"
+deleteBookBtn.addEventListener('click', async () => {
+    const id = prompt("Enter book ID to delete:");
+
+    if (id) {
+        try {
+            const response = await fetch(`/books/delete?id=${id}`, {
+                method: 'DELETE',
+                headers: authHeaders(),
+            });
+
+            if (response.ok) {
+                alert('Book deleted successfully!');
+                fetchBooks(); // Обновить список книг
+            } else {
+                alert('Failed to delete the book.');
+            }
+        } catch (error) {
+            console.error('Error deleting book:', error);
+        }
+    } else {
+        alert('Book ID is required!');
+    }
+});
+searchBookBtn.addEventListener('click', async () => {
+    const id = prompt("Enter book ID to search:");
+
+    if (id) {
+        try {
+            const response = await fetch(`/books/search?id=${id}`);
+
+            if (response.ok) {
+                const book = await response.json();
+                alert(`Book found: \nTitle: ${book.title}\nAuthor: ${book.author}\nPublished: ${book.published}`);
+            } else {
+                alert('Book not found.');
+            }
+        } catch (error) {
+            console.error('Error searching for book:', error);
+        }
+    } else {
+        alert('ID is required!');
+    }
+});
+
+document.getElementById('apply-filters').addEventListener('click', async () => {
+    const title = document.getElementById('filter-title').value;
+    const author = document.getElementById('filter-author').value;
+    const published = document.getElementById('filter-published').value;
+    const sortBy = document.getElementById('sort-by').value;
+    const sortOrder = document.getElementById('sort-order').value;
+
+    const params = new URLSearchParams();
+    if (title) params.append('title', title);
+    if (author) params.append('author', author);
+    if (published) params.append('published', published);
+    if (sortBy) params.append('sortBy', sortBy);
+    if (sortOrder) params.append('sortOrder', sortOrder);
+
+    try {
+        const response = await fetch(`/books?${params.toString()}`);
+        const books = await response.json();
+        updateBooksTable(books);  // Функция для обновления таблицы
+    } catch (error) {
+        console.error('Error fetching books with filters:', error);
+    }
+});
+
+
+// Получение элементов
+
+
+// Получение данных из API и отображение карточек на странице
+document.addEventListener('DOMContentLoaded', () => {
+    const container = document.getElementById('fantasy-books-container');
+
+    // Функция для создания карточки книги
+    const createBookCard = (book) => {
+        const card = document.createElement('div');
+        card.className = 'book-card';
+
+        card.innerHTML = `
+            <img src="${book.image_url}" alt="${book.title}" class="book-image">
+            <h3 class="book-title">${book.title}</h3>
+            <p class="book-description">${book.description}</p>
+            <p class="book-price">Price: $${book.price.toFixed(2)}</p>
+        `;
+
+        return card;
+    };
+
+    // Функция для загрузки данных из API
+    const loadFantasyBooks = async () => {
+        try {
+            const response = await fetch('/fantasy/books');
+            if (!response.ok) {
+                throw new Error('Failed to fetch fantasy books');
+            }
+
+            const books = await response.json();
+            books.forEach((book) => {
+                const bookCard = createBookCard(book);
+                container.appendChild(bookCard);
+            });
+        } catch (error) {
+            console.error('Error loading fantasy books:', error);
+            container.innerHTML = '<p>Failed to load fantasy books. Please try again later.</p>';
+        }
+    };
+
+    loadFantasyBooks();
+});
+
+// Функция для отправки сообщения
+async function sendMessage(event) {
+    console.log("SendMessage function triggered"); 
+    event.preventDefault(); 
+
+    const form = event.target; 
+    const formData = new FormData(form); 
+    const notification = document.getElementById('notification'); 
+
+    try {
+        // Отправляем запрос на сервер
+        const response = await fetch(form.action, { 
+            method: form.method, 
+            headers: { "X-CSRF-Token": csrfToken() },
+            body: formData,
+        });
+
+        if (response.ok) {
+            const data = await response.json(); 
+            notification.textContent = data.status; 
+            notification.style.color = 'green'; 
+            form.reset(); 
+        } else {
+            const errorData = await response.text(); 
+            notification.textContent = `Failed to send message: ${errorData}`; 
+            notification.style.color = 'red'; 
+        }
+    } catch (error) {
+        console.error('Error sending message:', error); 
+        notification.textContent = 'An unexpected error occurred while sending the message.'; 
+        notification.style.color = 'red'; 
+
+    // Показываем уведомление
+    notification.style.display = 'block';
+
+    // Скрываем уведомление через 5 секунд (по желанию)
+    setTimeout(() => {
+        notification.style.display = 'none';
+    }, 5000);
+
+    // Привязываем обработчик события submit
+document.addEventListener('DOMContentLoaded', () => {
+    console.log("DOM fully loaded and parsed"); // Для отладки
+    const sendMessageForm = document.getElementById('sendMessageForm');
+    if (sendMessageForm) {
+        console.log("Form found and event listener added"); 
+        sendMessageForm.addEventListener('submit', sendMessage);
+    } else {
+        console.error("Form not found!"); 
+    }
+});
+}
+}
+
+document.addEventListener("DOMContentLoaded", function () {
+    loadAccountSettings();
+
+    // Получение списка пользователей
+    fetchUsers();
+
+    // Функция для получения списка пользователей
+    function fetchUsers() {
+        fetch("/admin/users", {
+            method: "GET",
+            headers: {
+                "Role": "admin" // Укажите здесь способ передачи роли
+            }
+        })
+            .then(response => {
+                if (!response.ok) {
+                    throw new Error("Failed to fetch users");
+                }
+                return response.json();
+            })
+            .then(users => {
+                const tbody = document.querySelector("#users-table tbody");
+                tbody.innerHTML = ""; // Очистка таблицы
+                users.forEach(user => {
+                    const row = document.createElement("tr");
+                    row.innerHTML = `
+                        <td>${user.id}</td>
+                        <td>${user.email}</td>
+                        <td>${user.role}</td>
+                        <td>
+                            <button onclick="changeRole(${user.id}, 'admin')">Make Admin</button>
+                            <button onclick="changeRole(${user.id}, 'user')">Make User</button>
+                            <button onclick="deleteUser(${user.id})">Delete</button>
+                        </td>
+                    `;
+                    tbody.appendChild(row);
+                });
+            })
+            .catch(error => console.error(error));
+    }
+
+    // Функция для изменения роли пользователя
+    window.changeRole = function (userId, newRole) {
+        fetch("/admin/users/update-role", {
+            method: "POST",
+            headers: {
+                "Content-Type": "application/json",
+                "Role": "admin"
+            },
+            body: JSON.stringify({ user_id: userId, role: newRole })
+        })
+            .then(response => {
+                if (!response.ok) {
+                    throw new Error("Failed to update user role");
+                }
+                return response.json();
+            })
+            .then(data => {
+                alert(data.message);
+                fetchUsers();
+            })
+            .catch(error => console.error(error));
+    };
+
+    // Функция для удаления пользователя
+    window.deleteUser = function (userId) {
+        fetch(`/admin/users/delete?id=${userId}`, {
+            method: "DELETE",
+            headers: {
+                "Role": "admin"
+            }
+        })
+            .then(response => {
+                if (!response.ok) {
+                    throw new Error("Failed to delete user");
+                }
+                return response.json();
+            })
+            .then(data => {
+                alert(data.message);
+                fetchUsers();
+            })
+            .catch(error => console.error(error));
+    };
+});
+////FOR ACCOUNT.HTML
+//////FOR SIGNIN
+// script.js
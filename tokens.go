@@ -0,0 +1,264 @@
+package main
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"time"
+
+	"github.com/sirupsen/logrus"
+)
+
+// Сессии и refresh-токены. Каждая сессия — это семейство refresh-токенов:
+// при обновлении старый токен помечается использованным и выдаётся новый.
+// Повторное использование старого токена отзывает всю сессию.
+
+// Session — семейство refresh-токенов, созданное одним входом
+type Session struct {
+	ID         string `gorm:"primaryKey"`
+	UserID     uint   `gorm:"index"`
+	MFA        bool   // вход подтверждён вторым фактором
+	Cookie     bool   // сессия страниц сайта по cookie, без refresh-токенов (sessions.go)
+	CreatedAt  time.Time
+	LastSeenAt *time.Time
+	RevokedAt  *time.Time
+}
+
+// RefreshToken хранится только в виде SHA-256 хеша
+type RefreshToken struct {
+	ID        uint   `gorm:"primaryKey"`
+	SessionID string `gorm:"index"`
+	TokenHash string `gorm:"uniqueIndex"`
+	ExpiresAt time.Time
+	UsedAt    *time.Time
+	CreatedAt time.Time
+}
+
+// DeniedToken — отозванный до истечения access-токен (по jti)
+type DeniedToken struct {
+	JTI       string    `gorm:"primaryKey"`
+	ExpiresAt time.Time `gorm:"index"`
+}
+
+var (
+	errInvalidRefreshToken = errors.New("invalid or expired refresh token")
+	errRefreshTokenReused  = errors.New("refresh token reuse detected")
+)
+
+// randomToken возвращает случайную строку из n байт в base64url
+func randomToken(n int) (string, error) {
+	b := make([]byte, n)
+	if _, err := rand.Read(b); err != nil {
+		return "", err
+	}
+	return base64.RawURLEncoding.EncodeToString(b), nil
+}
+
+// hashToken — хеш для хранения секретных токенов в базе
+func hashToken(token string) string {
+	sum := sha256.Sum256([]byte(token))
+	return hex.EncodeToString(sum[:])
+}
+
+type tokenPair struct {
+	AccessToken  string `json:"token"`
+	RefreshToken string `json:"refresh_token"`
+	ExpiresIn    int    `json:"expires_in"`
+	// Администратор без 2FA должен подключить её, иначе админские страницы закрыты
+	TwoFactorSetupRequired bool `json:"two_factor_setup_required,omitempty"`
+}
+
+// newRefreshToken создаёт refresh-токен в сессии и возвращает его открытое значение
+func (s *Server) newRefreshToken(ctx context.Context, sessionID string) (string, error) {
+	raw, err := randomToken(32)
+	if err != nil {
+		return "", err
+	}
+	err = s.tokens.CreateRefreshToken(ctx, &RefreshToken{
+		SessionID: sessionID,
+		TokenHash: hashToken(raw),
+		ExpiresAt: time.Now().Add(s.config.JWT.RefreshTTL),
+		CreatedAt: time.Now(),
+	})
+	return raw, err
+}
+
+// issueTokens начинает новую сессию и выдаёт access- и refresh-токены;
+// mfa отмечает, что вход подтверждён вторым фактором (см. twofactor.go)
+func (s *Server) issueTokens(ctx context.Context, user User, mfa bool) (tokenPair, error) {
+	sessionID, err := randomToken(16)
+	if err != nil {
+		return tokenPair{}, err
+	}
+	session := Session{ID: sessionID, UserID: user.ID, MFA: mfa, CreatedAt: time.Now()}
+	if err := s.tokens.CreateSession(ctx, &session); err != nil {
+		return tokenPair{}, err
+	}
+	return s.tokensForSession(ctx, user, session)
+}
+
+func (s *Server) tokensForSession(ctx context.Context, user User, session Session) (tokenPair, error) {
+	access, err := s.generateJWT(ctx, user, session)
+	if err != nil {
+		return tokenPair{}, err
+	}
+	refresh, err := s.newRefreshToken(ctx, session.ID)
+	if err != nil {
+		return tokenPair{}, err
+	}
+	return tokenPair{
+		AccessToken:  access,
+		RefreshToken: refresh,
+		ExpiresIn:    int(s.config.JWT.AccessTTL.Seconds()),
+	}, nil
+}
+
+// rotateRefreshToken меняет refresh-токен на новую пару токенов
+func (s *Server) rotateRefreshToken(ctx context.Context, raw string) (tokenPair, error) {
+	now := time.Now()
+	stored, err := s.tokens.GetRefreshToken(ctx, hashToken(raw))
+	if errors.Is(err, ErrNotFound) {
+		return tokenPair{}, errInvalidRefreshToken
+	}
+	if err != nil {
+		return tokenPair{}, err
+	}
+
+	session, err := s.tokens.GetSession(ctx, stored.SessionID)
+	if err != nil {
+		return tokenPair{}, errInvalidRefreshToken
+	}
+	if session.RevokedAt != nil {
+		return tokenPair{}, errInvalidRefreshToken
+	}
+
+	fresh, err := s.tokens.UseRefreshToken(ctx, stored.ID, now)
+	if err != nil {
+		return tokenPair{}, err
+	}
+	if !fresh {
+		// Токен уже обменивали: его украли или клиент сломан — отзываем всю сессию
+		s.tokens.RevokeSession(ctx, session.ID, now)
+		s.log(ctx).WithFields(logrus.Fields{
+			"user_id":    session.UserID,
+			"session_id": session.ID,
+		}).Warn("Refresh token reuse detected, session revoked")
+		return tokenPair{}, errRefreshTokenReused
+	}
+	if now.After(stored.ExpiresAt) {
+		return tokenPair{}, errInvalidRefreshToken
+	}
+
+	user, err := s.users.Get(ctx, session.UserID)
+	if err != nil {
+		return tokenPair{}, errInvalidRefreshToken
+	}
+	return s.tokensForSession(ctx, user, session)
+}
+
+// tokenRevoked проверяет denylist и отзыв сессии для access-токена
+func (s *Server) tokenRevoked(ctx context.Context, claims *Claims) (bool, error) {
+	if claims.ID != "" {
+		denied, err := s.tokens.IsTokenDenied(ctx, claims.ID)
+		if err != nil || denied {
+			return denied, err
+		}
+	}
+	if claims.SessionID != "" {
+		session, err := s.tokens.GetSession(ctx, claims.SessionID)
+		if errors.Is(err, ErrNotFound) {
+			return true, nil
+		}
+		if err != nil {
+			return false, err
+		}
+		return session.RevokedAt != nil, nil
+	}
+	return false, nil
+}
+
+// Обмен refresh-токена на новую пару токенов
+func (s *Server) refreshHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	var req struct {
+		RefreshToken string `json:"refresh_token"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil || req.RefreshToken == "" {
+		http.Error(w, "Invalid request", http.StatusBadRequest)
+		return
+	}
+
+	pair, err := s.rotateRefreshToken(r.Context(), req.RefreshToken)
+	if errors.Is(err, errInvalidRefreshToken) || errors.Is(err, errRefreshTokenReused) {
+		http.Error(w, "Unauthorized: "+err.Error(), http.StatusUnauthorized)
+		return
+	}
+	if err != nil {
+		s.log(r.Context()).WithError(err).Error("Failed to refresh token")
+		http.Error(w, "Failed to refresh token", http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(pair)
+}
+
+// Выход: отзывает текущую сессию и access-токен
+func (s *Server) logoutHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	claims := r.Context().Value("user").(*Claims)
+	now := time.Now()
+	if claims.SessionID != "" {
+		if err := s.tokens.RevokeSession(r.Context(), claims.SessionID, now); err != nil {
+			s.log(r.Context()).WithError(err).Error("Failed to revoke session")
+			http.Error(w, "Failed to log out", http.StatusInternalServerError)
+			return
+		}
+	}
+	if claims.ID != "" && claims.ExpiresAt != nil {
+		if err := s.tokens.DenyToken(r.Context(), claims.ID, claims.ExpiresAt.Time); err != nil {
+			s.log(r.Context()).WithError(err).Error("Failed to deny access token")
+			http.Error(w, "Failed to log out", http.StatusInternalServerError)
+			return
+		}
+	}
+
+	s.clearSessionCookie(w, r)
+
+	s.log(r.Context()).WithFields(logrus.Fields{
+		"action":  "logout",
+		"user_id": claimsUserID(claims),
+	}).Info("User logged out")
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]string{"message": "Logged out"})
+}
+
+// purgeExpiredTokens периодически удаляет истёкшие refresh-токены и записи denylist
+func (s *Server) purgeExpiredTokens(ctx context.Context, every time.Duration) {
+	ticker := time.NewTicker(every)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case now := <-ticker.C:
+			if err := s.tokens.DeleteExpired(ctx, now); err != nil {
+				s.logger.WithError(err).Error("Failed to purge expired tokens")
+			}
+		}
+	}
+}
@@ -0,0 +1,95 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"golang.org/x/crypto/bcrypt"
+)
+
+// loginTestUser создаёт подтверждённого пользователя и входит через /login
+func loginTestUser(t *testing.T, s *Server, handler http.Handler) tokenPair {
+	t.Helper()
+	hash, _ := bcrypt.GenerateFromPassword([]byte("secret123"), bcrypt.MinCost)
+	user := User{Email: "reader@example.com", PasswordHash: string(hash), Role: "user", Confirmed: true}
+	assert.NoError(t, s.users.Create(context.Background(), &user))
+
+	rr := postJSON(handler, "/login", "", map[string]string{"email": user.Email, "password": "secret123"})
+	assert.Equal(t, http.StatusOK, rr.Code, "Expected 200 OK")
+
+	var pair tokenPair
+	assert.NoError(t, json.NewDecoder(rr.Body).Decode(&pair))
+	assert.NotEmpty(t, pair.AccessToken)
+	assert.NotEmpty(t, pair.RefreshToken)
+	return pair
+}
+
+func postJSON(handler http.Handler, path, accessToken string, body any) *httptest.ResponseRecorder {
+	return requestJSON(handler, "POST", path, accessToken, body)
+}
+
+func requestJSON(handler http.Handler, method, path, accessToken string, body any) *httptest.ResponseRecorder {
+	data, _ := json.Marshal(body)
+	req, _ := http.NewRequest(method, path, bytes.NewReader(data))
+	req.Header.Set("Content-Type", "application/json")
+	if accessToken != "" {
+		req.Header.Set("Authorization", "Bearer "+accessToken)
+	}
+	rr := httptest.NewRecorder()
+	handler.ServeHTTP(rr, req)
+	return rr
+}
+
+func getProfile(handler http.Handler, accessToken string) int {
+	req, _ := http.NewRequest("GET", "/api/profile", nil)
+	req.Header.Set("Authorization", "Bearer "+accessToken)
+	rr := httptest.NewRecorder()
+	handler.ServeHTTP(rr, req)
+	return rr.Code
+}
+
+func TestRefreshTokenRotation(t *testing.T) {
+	t.Parallel()
+	s := newMemoryTestServer(t)
+	handler := s.routes()
+	pair := loginTestUser(t, s, handler)
+
+	rr := postJSON(handler, "/token/refresh", "", map[string]string{"refresh_token": pair.RefreshToken})
+	assert.Equal(t, http.StatusOK, rr.Code, "Expected 200 OK")
+	var rotated tokenPair
+	assert.NoError(t, json.NewDecoder(rr.Body).Decode(&rotated))
+	assert.NotEqual(t, pair.RefreshToken, rotated.RefreshToken)
+	assert.Equal(t, http.StatusOK, getProfile(handler, rotated.AccessToken))
+
+	// Повторное использование старого токена отзывает всю сессию
+	rr = postJSON(handler, "/token/refresh", "", map[string]string{"refresh_token": pair.RefreshToken})
+	assert.Equal(t, http.StatusUnauthorized, rr.Code, "Expected 401 on reuse")
+
+	rr = postJSON(handler, "/token/refresh", "", map[string]string{"refresh_token": rotated.RefreshToken})
+	assert.Equal(t, http.StatusUnauthorized, rr.Code, "Expected the whole family to be revoked")
+	assert.Equal(t, http.StatusUnauthorized, getProfile(handler, rotated.AccessToken))
+}
+
+func TestLogoutRevokesTokens(t *testing.T) {
+	t.Parallel()
+	s := newMemoryTestServer(t)
+	handler := s.routes()
+	pair := loginTestUser(t, s, handler)
+
+	assert.Equal(t, http.StatusOK, getProfile(handler, pair.AccessToken))
+
+	rr := postJSON(handler, "/logout", pair.AccessToken, nil)
+	assert.Equal(t, http.StatusOK, rr.Code, "Expected 200 OK")
+
+	assert.Equal(t, http.StatusUnauthorized, getProfile(handler, pair.AccessToken))
+	rr = postJSON(handler, "/token/refresh", "", map[string]string{"refresh_token": pair.RefreshToken})
+	assert.Equal(t, http.StatusUnauthorized, rr.Code, "Expected 401 after logout")
+
+	rr = postJSON(handler, "/token/refresh", "", map[string]string{"refresh_token": "garbage"})
+	assert.Equal(t, http.StatusUnauthorized, rr.Code)
+}
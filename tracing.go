@@ -0,0 +1,145 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"strings"
+
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/exporters/otlp/otlptrace/otlptracehttp"
+	"go.opentelemetry.io/otel/exporters/stdout/stdouttrace"
+	"go.opentelemetry.io/otel/propagation"
+	"go.opentelemetry.io/otel/sdk/resource"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	semconv "go.opentelemetry.io/otel/semconv/v1.37.0"
+	"go.opentelemetry.io/otel/trace"
+	"gorm.io/gorm"
+)
+
+// Трассировка OpenTelemetry: span на каждый входящий запрос, на каждый запрос
+// GORM и на отправку письма. Контекст вызывающего берётся из заголовков
+// traceparent/tracestate (W3C Trace Context), так что span запроса продолжает
+// его трассу, а trace_id попадает в журнал запроса.
+
+const tracerName = "bookstore-go"
+
+var tracePropagator = propagation.NewCompositeTextMapPropagator(propagation.TraceContext{}, propagation.Baggage{})
+
+// newSpanExporter создаёт экспортёр из конфигурации; nil — трассировка выключена.
+// Без TRACING_ENDPOINT OTLP-экспортёр читает стандартные OTEL_EXPORTER_OTLP_*
+func newSpanExporter(ctx context.Context, cfg TracingConfig) (sdktrace.SpanExporter, error) {
+	switch cfg.Exporter {
+	case "otlp":
+		var opts []otlptracehttp.Option
+		if cfg.Endpoint != "" {
+			opts = append(opts, otlptracehttp.WithEndpointURL(cfg.Endpoint))
+		}
+		return otlptracehttp.New(ctx, opts...)
+	case "stdout":
+		return stdouttrace.New()
+	default:
+		return nil, nil
+	}
+}
+
+func newTracerProvider(cfg TracingConfig, exporter sdktrace.SpanExporter) *sdktrace.TracerProvider {
+	return sdktrace.NewTracerProvider(
+		sdktrace.WithBatcher(exporter),
+		sdktrace.WithResource(resource.NewSchemaless(semconv.ServiceName(cfg.ServiceName))),
+		sdktrace.WithSampler(sdktrace.ParentBased(sdktrace.TraceIDRatioBased(cfg.SampleRatio))),
+	)
+}
+
+func (s *Server) useTracerProvider(tp *sdktrace.TracerProvider) {
+	s.traces = tp
+	s.tracer = tp.Tracer(tracerName)
+}
+
+// traceRequests открывает серверный span на каждый запрос; имя — метод и шаблон маршрута
+func (s *Server) traceRequests(mux *http.ServeMux, next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		ctx := tracePropagator.Extract(r.Context(), propagation.HeaderCarrier(r.Header))
+		_, route := mux.Handler(r)
+		if _, path, ok := strings.Cut(route, " "); ok {
+			route = path
+		}
+		ctx, span := s.tracer.Start(ctx, strings.TrimSpace(r.Method+" "+route),
+			trace.WithSpanKind(trace.SpanKindServer),
+			trace.WithAttributes(
+				semconv.HTTPRequestMethodKey.String(r.Method),
+				semconv.HTTPRoute(route),
+				semconv.URLPath(r.URL.Path),
+				semconv.ClientAddress(s.clientIP(r)),
+				semconv.UserAgentOriginal(r.UserAgent()),
+			))
+		defer span.End()
+
+		lw := &loggingResponseWriter{ResponseWriter: w}
+		next.ServeHTTP(lw, r.WithContext(ctx))
+
+		if lw.status == 0 {
+			lw.status = http.StatusOK
+		}
+		span.SetAttributes(semconv.HTTPResponseStatusCode(lw.status))
+		if lw.status >= http.StatusInternalServerError {
+			span.SetStatus(codes.Error, http.StatusText(lw.status))
+		}
+	})
+}
+
+// gormTracing — плагин GORM: span на каждый запрос к базе, дочерний к span
+// из контекста запроса (репозитории передают его через WithContext)
+type gormTracing struct {
+	tracer trace.Tracer
+}
+
+const gormSpanKey = "tracing:span"
+
+func (p gormTracing) Name() string { return "bookstore:tracing" }
+
+func (p gormTracing) Initialize(db *gorm.DB) error {
+	return registerGormCallbacks(db, "tracing", p.before, p.after)
+}
+
+func (p gormTracing) before(operation string) func(*gorm.DB) {
+	return func(db *gorm.DB) {
+		ctx := db.Statement.Context
+		if ctx == nil {
+			ctx = context.Background()
+		}
+		_, span := p.tracer.Start(ctx, "gorm "+operation, trace.WithSpanKind(trace.SpanKindClient))
+		db.InstanceSet(gormSpanKey, span)
+	}
+}
+
+func (p gormTracing) after(operation string) func(*gorm.DB) {
+	return func(db *gorm.DB) {
+		v, ok := db.InstanceGet(gormSpanKey)
+		if !ok {
+			return
+		}
+		span, ok := v.(trace.Span)
+		if !ok {
+			return
+		}
+		defer span.End()
+
+		table := db.Statement.Table
+		if table != "" {
+			span.SetName(operation + " " + table)
+		}
+		span.SetAttributes(
+			semconv.DBSystemNameKey.String(db.Dialector.Name()),
+			semconv.DBOperationName(operation),
+			semconv.DBCollectionName(table),
+			semconv.DBQueryText(db.Statement.SQL.String()),
+			attribute.Int64("db.rows_affected", db.RowsAffected),
+		)
+		if db.Error != nil && !errors.Is(db.Error, gorm.ErrRecordNotFound) {
+			span.RecordError(db.Error)
+			span.SetStatus(codes.Error, db.Error.Error())
+		}
+	}
+}
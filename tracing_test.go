@@ -0,0 +1,182 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+
+	"github.com/sirupsen/logrus/hooks/test"
+	"github.com/stretchr/testify/assert"
+	"go.opentelemetry.io/otel/exporters/stdout/stdouttrace"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+)
+
+// exportedSpan — span в формате stdout-экспортёра
+type exportedSpan struct {
+	Name        string
+	SpanContext struct{ TraceID, SpanID string }
+	Parent      struct{ TraceID, SpanID string }
+	Attributes  []struct {
+		Key   string
+		Value struct{ Value any }
+	}
+	Status struct{ Code string }
+}
+
+func (s exportedSpan) attr(key string) any {
+	for _, a := range s.Attributes {
+		if a.Key == key {
+			return a.Value.Value
+		}
+	}
+	return nil
+}
+
+// spanRecorder собирает вывод stdout-экспортёра
+type spanRecorder struct {
+	mu  sync.Mutex
+	buf bytes.Buffer
+}
+
+func (r *spanRecorder) Write(p []byte) (int, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return r.buf.Write(p)
+}
+
+func (r *spanRecorder) spans(t *testing.T) map[string]exportedSpan {
+	t.Helper()
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	spans := map[string]exportedSpan{}
+	dec := json.NewDecoder(bytes.NewReader(r.buf.Bytes()))
+	for {
+		var span exportedSpan
+		if err := dec.Decode(&span); err == io.EOF {
+			return spans
+		} else if !assert.NoError(t, err) {
+			return spans
+		}
+		spans[span.Name] = span
+	}
+}
+
+// newTestTracerProvider пишет spans синхронно, чтобы их можно было проверить сразу после вызова
+func newTestTracerProvider(t *testing.T) (*sdktrace.TracerProvider, *spanRecorder) {
+	t.Helper()
+	rec := &spanRecorder{}
+	exporter, err := stdouttrace.New(stdouttrace.WithWriter(rec))
+	assert.NoError(t, err)
+	tp := sdktrace.NewTracerProvider(sdktrace.WithSyncer(exporter))
+	t.Cleanup(func() { tp.Shutdown(context.Background()) })
+	return tp, rec
+}
+
+const testTraceID = "4bf92f3577b34da6a3ce929d0e0e4736"
+
+func TestTracingContinuesCallerTrace(t *testing.T) {
+	t.Parallel()
+	s := newMemoryTestServer(t)
+	tp, rec := newTestTracerProvider(t)
+	s.useTracerProvider(tp)
+	hook := test.NewLocal(s.logger)
+	handler := s.routes()
+
+	req, _ := http.NewRequest("GET", "/healthz", nil)
+	req.Header.Set("traceparent", "00-"+testTraceID+"-00f067aa0ba902b7-01")
+	rr := httptest.NewRecorder()
+	handler.ServeHTTP(rr, req)
+	assert.Equal(t, http.StatusOK, rr.Code)
+
+	span, ok := rec.spans(t)["GET /healthz"]
+	if assert.True(t, ok, "Expected a server span named after the route") {
+		assert.Equal(t, testTraceID, span.SpanContext.TraceID, "The span joins the caller's trace")
+		assert.Equal(t, "00f067aa0ba902b7", span.Parent.SpanID)
+		assert.Equal(t, "/healthz", span.attr("http.route"))
+		assert.EqualValues(t, http.StatusOK, span.attr("http.response.status_code"))
+		assert.Equal(t, "Unset", span.Status.Code)
+	}
+	if entry := accessLog(hook); assert.NotNil(t, entry) {
+		assert.Equal(t, testTraceID, entry.Data["trace_id"], "Logs carry the trace ID")
+	}
+}
+
+func TestTracingWithoutExporter(t *testing.T) {
+	t.Parallel()
+	s := newMemoryTestServer(t)
+	assert.Nil(t, s.traces, "Tracing is off by default")
+	hook := test.NewLocal(s.logger)
+
+	req, _ := http.NewRequest("GET", "/healthz", nil)
+	req.Header.Set("traceparent", "00-"+testTraceID+"-00f067aa0ba902b7-01")
+	s.routes().ServeHTTP(httptest.NewRecorder(), req)
+	assert.Equal(t, testTraceID, accessLog(hook).Data["trace_id"], "The caller's trace ID is still logged")
+
+	s.routes().ServeHTTP(httptest.NewRecorder(), httptest.NewRequest("GET", "/healthz", nil))
+	assert.NotContains(t, accessLog(hook).Data, "trace_id")
+}
+
+func TestTracingDatabaseAndEmail(t *testing.T) {
+	t.Parallel()
+	tp, rec := newTestTracerProvider(t)
+	db := newMigratedTestDB(t)
+	assert.NoError(t, db.Use(gormTracing{tracer: tp.Tracer(tracerName)}))
+	s := newTestServer(t, newGormBookRepository(db), newMemoryUserRepository(), newMemoryTokenRepository())
+	s.useTracerProvider(tp)
+
+	ctx, parent := s.tracer.Start(context.Background(), "checkout")
+	assert.NoError(t, s.books.Create(ctx, &Book{Title: "Dune", Author: "Frank Herbert", Stock: 1}))
+	s.mailer = failingMailer{}
+	assert.Error(t, s.sendPasswordResetEmail(ctx, "reader@example.com", "token"))
+	parent.End()
+
+	spans := rec.spans(t)
+	checkout := spans["checkout"]
+	insert, ok := spans["create books"]
+	if assert.True(t, ok, "Every GORM query gets a span") {
+		assert.Equal(t, checkout.SpanContext.SpanID, insert.Parent.SpanID)
+		assert.Equal(t, "sqlite", insert.attr("db.system.name"))
+		assert.Contains(t, insert.attr("db.query.text"), "INSERT INTO")
+		assert.NotContains(t, insert.attr("db.query.text"), "Herbert", "Query values are not recorded")
+	}
+	mail, ok := spans["smtp send"]
+	if assert.True(t, ok, "Sending email gets a span") {
+		assert.Equal(t, checkout.SpanContext.SpanID, mail.Parent.SpanID)
+		assert.Equal(t, "password_reset", mail.attr("email.kind"))
+		assert.Equal(t, "Error", mail.Status.Code)
+		assert.NotContains(t, rec.buf.String(), "reader@example.com", "Recipients stay out of traces")
+	}
+}
+
+func TestNewSpanExporter(t *testing.T) {
+	t.Parallel()
+	ctx := context.Background()
+	exporter, err := newSpanExporter(ctx, TracingConfig{Exporter: "none"})
+	assert.NoError(t, err)
+	assert.Nil(t, exporter)
+
+	for _, cfg := range []TracingConfig{
+		{Exporter: "stdout"},
+		{Exporter: "otlp", Endpoint: "http://localhost:4318"},
+	} {
+		exporter, err := newSpanExporter(ctx, cfg)
+		if assert.NoError(t, err, cfg.Exporter) && assert.NotNil(t, exporter) {
+			assert.NoError(t, exporter.Shutdown(ctx))
+		}
+	}
+
+	cfg := testConfig()
+	cfg.Tracing.Exporter = "stdout"
+	s := newConfigTestServer(t, cfg, newMemoryTokenRepository())
+	if assert.NotNil(t, s.traces) {
+		assert.NoError(t, s.traces.Shutdown(ctx))
+	}
+
+	cfg.Tracing = TracingConfig{Exporter: "otlp", Endpoint: "localhost:4318", SampleRatio: 1}
+	assert.ErrorContains(t, cfg.Validate(), "TRACING_ENDPOINT must be an http(s) URL")
+}
@@ -0,0 +1,428 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"crypto/rand"
+	"crypto/subtle"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"image/png"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/pquerna/otp"
+	"github.com/pquerna/otp/totp"
+)
+
+// Двухфакторная аутентификация: TOTP из приложения-аутентификатора
+// и одноразовые коды восстановления. Для роли admin 2FA обязательна.
+
+// TwoFactor — TOTP-секрет пользователя, зашифрованный так же, как ключи подписи
+type TwoFactor struct {
+	UserID    uint `gorm:"primaryKey"`
+	Secret    []byte
+	Enabled   bool
+	LastStep  int64 // последний принятый 30-секундный интервал, защита от повтора кода
+	CreatedAt time.Time
+	EnabledAt *time.Time
+}
+
+// RecoveryCode хранится только в виде хеша
+type RecoveryCode struct {
+	ID       uint   `gorm:"primaryKey"`
+	UserID   uint   `gorm:"index"`
+	CodeHash string `gorm:"uniqueIndex"`
+	UsedAt   *time.Time
+}
+
+const (
+	purposeLogin2FA   = "login_2fa"
+	twoFactorLoginTTL = 5 * time.Minute
+	totpPeriod        = 30
+	recoveryCodeCount = 10
+)
+
+var (
+	errTwoFactorNotEnabled = errors.New("two-factor authentication is not enabled")
+	errInvalidSecondFactor = errors.New("invalid two-factor code")
+)
+
+// twoFactorChallenge — ответ /login, когда нужен второй шаг
+type twoFactorChallenge struct {
+	TwoFactorRequired bool   `json:"two_factor_required"`
+	TwoFactorToken    string `json:"two_factor_token"`
+	ExpiresIn         int    `json:"expires_in"`
+}
+
+func (s *Server) twoFactorEnabled(ctx context.Context, userID uint) (bool, error) {
+	tf, err := s.users.GetTwoFactor(ctx, userID)
+	if errors.Is(err, ErrNotFound) {
+		return false, nil
+	}
+	return tf.Enabled, err
+}
+
+// startTwoFactorLogin выдаёт короткоживущий токен второго шага вместо access-токена
+func (s *Server) startTwoFactorLogin(ctx context.Context, user User) (twoFactorChallenge, error) {
+	token, err := s.issueUserToken(ctx, user.ID, purposeLogin2FA, twoFactorLoginTTL)
+	if err != nil {
+		return twoFactorChallenge{}, err
+	}
+	return twoFactorChallenge{
+		TwoFactorRequired: true,
+		TwoFactorToken:    token,
+		ExpiresIn:         int(twoFactorLoginTTL.Seconds()),
+	}, nil
+}
+
+// validateTOTP проверяет код для текущего интервала и соседних (расхождение часов)
+// и возвращает номер интервала, которому код соответствует
+func validateTOTP(secret, code string, now time.Time) (int64, bool) {
+	opts := totp.ValidateOpts{Period: totpPeriod, Digits: otp.DigitsSix, Algorithm: otp.AlgorithmSHA1}
+	step := now.Unix() / totpPeriod
+	for _, skew := range []int64{0, -1, 1} {
+		expected, err := totp.GenerateCodeCustom(secret, time.Unix((step+skew)*totpPeriod, 0), opts)
+		if err != nil {
+			return 0, false
+		}
+		if subtle.ConstantTimeCompare([]byte(expected), []byte(code)) == 1 {
+			return step + skew, true
+		}
+	}
+	return 0, false
+}
+
+// recoveryCodeHash привязывает код к пользователю, как userCodeHash
+func recoveryCodeHash(userID uint, code string) string {
+	code = strings.ToLower(strings.NewReplacer("-", "", " ", "").Replace(code))
+	return hashToken(strconv.FormatUint(uint64(userID), 10) + ":recovery:" + code)
+}
+
+// newRecoveryCodes генерирует коды вида xxxxx-xxxxx и сохраняет их хеши вместо прежних
+func (s *Server) newRecoveryCodes(ctx context.Context, userID uint) ([]string, error) {
+	// 32 символа без похожих i, l, o и 1: байт делится на них без перекоса
+	const alphabet = "abcdefghjkmnpqrstuvwxyz023456789"
+	codes := make([]string, recoveryCodeCount)
+	hashes := make([]string, recoveryCodeCount)
+	for i := range codes {
+		b := make([]byte, 10)
+		if _, err := rand.Read(b); err != nil {
+			return nil, err
+		}
+		for j := range b {
+			b[j] = alphabet[int(b[j])%len(alphabet)]
+		}
+		codes[i] = string(b[:5]) + "-" + string(b[5:])
+		hashes[i] = recoveryCodeHash(userID, codes[i])
+	}
+	return codes, s.users.ReplaceRecoveryCodes(ctx, userID, hashes)
+}
+
+// checkSecondFactor принимает TOTP-код или код восстановления; каждый срабатывает один раз
+func (s *Server) checkSecondFactor(ctx context.Context, userID uint, code, recoveryCode string) error {
+	tf, err := s.users.GetTwoFactor(ctx, userID)
+	if errors.Is(err, ErrNotFound) {
+		return errTwoFactorNotEnabled
+	}
+	if err != nil {
+		return err
+	}
+
+	if recoveryCode != "" {
+		if !tf.Enabled {
+			return errTwoFactorNotEnabled
+		}
+		fresh, err := s.users.UseRecoveryCode(ctx, userID, recoveryCodeHash(userID, recoveryCode), time.Now())
+		if err != nil {
+			return err
+		}
+		if !fresh {
+			return errInvalidSecondFactor
+		}
+		return nil
+	}
+
+	secret, err := s.keys.open(tf.Secret)
+	if err != nil {
+		return err
+	}
+	step, ok := validateTOTP(string(secret), code, time.Now())
+	if !ok {
+		return errInvalidSecondFactor
+	}
+	fresh, err := s.users.UseTOTPStep(ctx, userID, step)
+	if err != nil {
+		return err
+	}
+	if !fresh {
+		return errInvalidSecondFactor
+	}
+	return nil
+}
+
+// Второй шаг входа: POST /login/2fa {"two_factor_token": "...", "code": "123456"}
+// или {"two_factor_token": "...", "recovery_code": "xxxxx-xxxxx"}
+func (s *Server) loginTwoFactorHandler(w http.ResponseWriter, r *http.Request) {
+	var req struct {
+		TwoFactorToken string `json:"two_factor_token"`
+		Code           string `json:"code"`
+		RecoveryCode   string `json:"recovery_code"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil || req.TwoFactorToken == "" {
+		http.Error(w, "Invalid request", http.StatusBadRequest)
+		return
+	}
+	ip := s.clientIP(r)
+	if !s.verifyAttempts.Allow(ip) {
+		http.Error(w, "Too many attempts, try again later", http.StatusTooManyRequests)
+		return
+	}
+
+	// На один токен второго шага даётся maxCodeAttempts попыток
+	token, err := s.tokens.GetUserToken(r.Context(), purposeLogin2FA, hashToken(req.TwoFactorToken))
+	if err != nil || token.Attempts >= maxCodeAttempts {
+		s.verifyAttempts.Fail(ip)
+		http.Error(w, "Invalid or expired two-factor token", http.StatusUnauthorized)
+		return
+	}
+	if err := s.checkSecondFactor(r.Context(), token.UserID, req.Code, req.RecoveryCode); err != nil {
+		if !errors.Is(err, errInvalidSecondFactor) && !errors.Is(err, errTwoFactorNotEnabled) {
+			http.Error(w, "Failed to verify code", http.StatusInternalServerError)
+			return
+		}
+		s.verifyAttempts.Fail(ip)
+		s.tokens.AddUserTokenAttempt(r.Context(), token.ID)
+		http.Error(w, "Invalid code", http.StatusUnauthorized)
+		return
+	}
+	s.verifyAttempts.Reset(ip)
+	if err := s.useUserToken(r.Context(), token); err != nil {
+		http.Error(w, "Invalid or expired two-factor token", http.StatusUnauthorized)
+		return
+	}
+
+	user, err := s.users.Get(r.Context(), token.UserID)
+	if err != nil {
+		http.Error(w, "Invalid or expired two-factor token", http.StatusUnauthorized)
+		return
+	}
+	if err := s.signIn(w, r, user, true, wantsCookieSession(r)); err != nil {
+		http.Error(w, "Failed to generate token", http.StatusInternalServerError)
+	}
+}
+
+func claimsUserID(claims *Claims) uint {
+	id, _ := strconv.ParseUint(claims.Subject, 10, 64)
+	return uint(id)
+}
+
+// Состояние 2FA: GET /api/2fa
+func (s *Server) twoFactorStatusHandler(w http.ResponseWriter, r *http.Request) {
+	claims := r.Context().Value("user").(*Claims)
+	enabled, err := s.twoFactorEnabled(r.Context(), claimsUserID(claims))
+	if err != nil {
+		http.Error(w, "Failed to load two-factor settings", http.StatusInternalServerError)
+		return
+	}
+	left := 0
+	if enabled {
+		if left, err = s.users.CountRecoveryCodes(r.Context(), claimsUserID(claims)); err != nil {
+			http.Error(w, "Failed to load two-factor settings", http.StatusInternalServerError)
+			return
+		}
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]any{
+		"enabled":             enabled,
+		"required":            claims.Role == "admin",
+		"recovery_codes_left": left,
+	})
+}
+
+// Начало подключения: POST /api/2fa/setup возвращает секрет, otpauth:// URI и QR-код с ним
+func (s *Server) twoFactorSetupHandler(w http.ResponseWriter, r *http.Request) {
+	claims := r.Context().Value("user").(*Claims)
+	userID := claimsUserID(claims)
+	enabled, err := s.twoFactorEnabled(r.Context(), userID)
+	if err != nil {
+		http.Error(w, "Failed to load two-factor settings", http.StatusInternalServerError)
+		return
+	}
+	if enabled {
+		http.Error(w, "Two-factor authentication is already enabled", http.StatusConflict)
+		return
+	}
+
+	key, err := totp.Generate(totp.GenerateOpts{
+		Issuer:      s.config.JWT.Issuer,
+		AccountName: claims.Email,
+		Period:      totpPeriod,
+		Digits:      otp.DigitsSix,
+		Algorithm:   otp.AlgorithmSHA1,
+	})
+	if err != nil {
+		http.Error(w, "Failed to generate secret", http.StatusInternalServerError)
+		return
+	}
+	sealed, err := s.keys.seal([]byte(key.Secret()))
+	if err != nil {
+		http.Error(w, "Failed to generate secret", http.StatusInternalServerError)
+		return
+	}
+	if err := s.users.SaveTwoFactor(r.Context(), &TwoFactor{UserID: userID, Secret: sealed, CreatedAt: time.Now()}); err != nil {
+		http.Error(w, "Failed to save secret", http.StatusInternalServerError)
+		return
+	}
+
+	img, err := key.Image(240, 240)
+	if err != nil {
+		http.Error(w, "Failed to render QR code", http.StatusInternalServerError)
+		return
+	}
+	var qr bytes.Buffer
+	if err := png.Encode(&qr, img); err != nil {
+		http.Error(w, "Failed to render QR code", http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("Cache-Control", "no-store")
+	json.NewEncoder(w).Encode(map[string]string{
+		"secret":      key.Secret(),
+		"otpauth_url": key.URL(),
+		"qr_code":     "data:image/png;base64," + base64.StdEncoding.EncodeToString(qr.Bytes()),
+	})
+}
+
+// Подтверждение подключения кодом из приложения: POST /api/2fa/enable {"code": "123456"}
+// Коды восстановления показываются один раз
+func (s *Server) twoFactorEnableHandler(w http.ResponseWriter, r *http.Request) {
+	userID := claimsUserID(r.Context().Value("user").(*Claims))
+	var req struct {
+		Code string `json:"code"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil || req.Code == "" {
+		http.Error(w, "Code is required", http.StatusBadRequest)
+		return
+	}
+
+	tf, err := s.users.GetTwoFactor(r.Context(), userID)
+	if errors.Is(err, ErrNotFound) {
+		http.Error(w, "Start with /api/2fa/setup", http.StatusBadRequest)
+		return
+	}
+	if err != nil {
+		http.Error(w, "Failed to load two-factor settings", http.StatusInternalServerError)
+		return
+	}
+	if tf.Enabled {
+		http.Error(w, "Two-factor authentication is already enabled", http.StatusConflict)
+		return
+	}
+	if err := s.checkSecondFactor(r.Context(), userID, req.Code, ""); err != nil {
+		http.Error(w, "Invalid code", http.StatusBadRequest)
+		return
+	}
+
+	// LastStep обновился в checkSecondFactor, перечитываем запись
+	if tf, err = s.users.GetTwoFactor(r.Context(), userID); err != nil {
+		http.Error(w, "Failed to load two-factor settings", http.StatusInternalServerError)
+		return
+	}
+	now := time.Now()
+	tf.Enabled = true
+	tf.EnabledAt = &now
+	if err := s.users.SaveTwoFactor(r.Context(), &tf); err != nil {
+		http.Error(w, "Failed to enable two-factor authentication", http.StatusInternalServerError)
+		return
+	}
+	codes, err := s.newRecoveryCodes(r.Context(), userID)
+	if err != nil {
+		http.Error(w, "Failed to generate recovery codes", http.StatusInternalServerError)
+		return
+	}
+	s.log(r.Context()).WithField("user_id", userID).Info("Two-factor authentication enabled")
+
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("Cache-Control", "no-store")
+	json.NewEncoder(w).Encode(map[string]any{"recovery_codes": codes})
+}
+
+// Новые коды восстановления вместо прежних: POST /api/2fa/recovery-codes {"code": "123456"}
+func (s *Server) twoFactorRecoveryCodesHandler(w http.ResponseWriter, r *http.Request) {
+	userID := claimsUserID(r.Context().Value("user").(*Claims))
+	var req struct {
+		Code string `json:"code"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil || req.Code == "" {
+		http.Error(w, "Code is required", http.StatusBadRequest)
+		return
+	}
+	enabled, err := s.twoFactorEnabled(r.Context(), userID)
+	if err != nil || !enabled {
+		http.Error(w, "Two-factor authentication is not enabled", http.StatusBadRequest)
+		return
+	}
+	if err := s.checkSecondFactor(r.Context(), userID, req.Code, ""); err != nil {
+		http.Error(w, "Invalid code", http.StatusBadRequest)
+		return
+	}
+	codes, err := s.newRecoveryCodes(r.Context(), userID)
+	if err != nil {
+		http.Error(w, "Failed to generate recovery codes", http.StatusInternalServerError)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("Cache-Control", "no-store")
+	json.NewEncoder(w).Encode(map[string]any{"recovery_codes": codes})
+}
+
+// Отключение: POST /api/2fa/disable {"code": "123456"} или {"recovery_code": "..."}
+// Администраторы отключить 2FA не могут
+func (s *Server) twoFactorDisableHandler(w http.ResponseWriter, r *http.Request) {
+	claims := r.Context().Value("user").(*Claims)
+	userID := claimsUserID(claims)
+	if claims.Role == "admin" {
+		http.Error(w, "Two-factor authentication is required for administrators", http.StatusForbidden)
+		return
+	}
+	var req struct {
+		Code         string `json:"code"`
+		RecoveryCode string `json:"recovery_code"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil || (req.Code == "" && req.RecoveryCode == "") {
+		http.Error(w, "Code is required", http.StatusBadRequest)
+		return
+	}
+	if err := s.checkSecondFactor(r.Context(), userID, req.Code, req.RecoveryCode); err != nil {
+		http.Error(w, "Invalid code", http.StatusBadRequest)
+		return
+	}
+	if err := s.users.DeleteTwoFactor(r.Context(), userID); err != nil {
+		http.Error(w, "Failed to disable two-factor authentication", http.StatusInternalServerError)
+		return
+	}
+	s.log(r.Context()).WithField("user_id", userID).Info("Two-factor authentication disabled")
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// requireRole пропускает только пользователей с ролью role; ставится после authMiddleware.
+// Для admin нужен токен, полученный со вторым фактором
+func (s *Server) requireRole(role string, next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		claims, ok := r.Context().Value("user").(*Claims)
+		if !ok || claims.Role != role {
+			http.Error(w, "Forbidden: Access is denied", http.StatusForbidden)
+			return
+		}
+		if role == "admin" && !claims.MFA {
+			http.Error(w, "Forbidden: Two-factor authentication required", http.StatusForbidden)
+			return
+		}
+		next.ServeHTTP(w, r)
+	})
+}
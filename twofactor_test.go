@@ -0,0 +1,181 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"testing"
+	"time"
+
+	"github.com/golang-jwt/jwt/v4"
+	"github.com/pquerna/otp/totp"
+	"github.com/stretchr/testify/assert"
+	"golang.org/x/crypto/bcrypt"
+)
+
+// enrollTwoFactor подключает TOTP и возвращает секрет и коды восстановления
+func enrollTwoFactor(t *testing.T, handler http.Handler, accessToken string) (string, []string) {
+	t.Helper()
+	rr := postJSON(handler, "/api/2fa/setup", accessToken, nil)
+	assert.Equal(t, http.StatusOK, rr.Code, "Expected 200 OK")
+	var setup struct {
+		Secret     string `json:"secret"`
+		OTPAuthURL string `json:"otpauth_url"`
+		QRCode     string `json:"qr_code"`
+	}
+	assert.NoError(t, json.NewDecoder(rr.Body).Decode(&setup))
+	assert.Contains(t, setup.OTPAuthURL, "otpauth://totp/")
+	assert.Contains(t, setup.QRCode, "data:image/png;base64,")
+
+	code, _ := totp.GenerateCode(setup.Secret, time.Now())
+	rr = postJSON(handler, "/api/2fa/enable", accessToken, map[string]string{"code": code})
+	assert.Equal(t, http.StatusOK, rr.Code, "Expected 200 OK")
+	var enabled struct {
+		RecoveryCodes []string `json:"recovery_codes"`
+	}
+	assert.NoError(t, json.NewDecoder(rr.Body).Decode(&enabled))
+	assert.Len(t, enabled.RecoveryCodes, recoveryCodeCount)
+	return setup.Secret, enabled.RecoveryCodes
+}
+
+// passwordLogin выполняет первый шаг входа и возвращает токен второго шага
+func passwordLogin(t *testing.T, handler http.Handler, email, password string) string {
+	t.Helper()
+	rr := postJSON(handler, "/login", "", map[string]string{"email": email, "password": password})
+	assert.Equal(t, http.StatusOK, rr.Code, "Expected 200 OK")
+	var challenge twoFactorChallenge
+	assert.NoError(t, json.NewDecoder(rr.Body).Decode(&challenge))
+	assert.True(t, challenge.TwoFactorRequired)
+	assert.NotEmpty(t, challenge.TwoFactorToken)
+	return challenge.TwoFactorToken
+}
+
+func TestTwoFactorLogin(t *testing.T) {
+	t.Parallel()
+	s := newMemoryTestServer(t)
+	handler := s.routes()
+	pair := loginTestUser(t, s, handler)
+	secret, recovery := enrollTwoFactor(t, handler, pair.AccessToken)
+
+	// Код из enable уже использован, берём следующий интервал
+	nextCode, _ := totp.GenerateCode(secret, time.Now().Add(totpPeriod*time.Second))
+
+	challenge := passwordLogin(t, handler, "reader@example.com", "secret123")
+	rr := postJSON(handler, "/login/2fa", "", map[string]string{"two_factor_token": challenge, "code": "000000"})
+	assert.Equal(t, http.StatusUnauthorized, rr.Code, "Expected 401 Unauthorized")
+	rr = postJSON(handler, "/login/2fa", "", map[string]string{"two_factor_token": challenge, "code": nextCode})
+	assert.Equal(t, http.StatusOK, rr.Code, "Expected 200 OK")
+	var mfaPair tokenPair
+	assert.NoError(t, json.NewDecoder(rr.Body).Decode(&mfaPair))
+	claims, err := s.parseAccessToken(context.Background(), mfaPair.AccessToken)
+	assert.NoError(t, err)
+	assert.True(t, claims.MFA)
+
+	// Токен второго шага и TOTP-код одноразовые
+	rr = postJSON(handler, "/login/2fa", "", map[string]string{"two_factor_token": challenge, "code": nextCode})
+	assert.Equal(t, http.StatusUnauthorized, rr.Code, "Expected 401 Unauthorized")
+	challenge = passwordLogin(t, handler, "reader@example.com", "secret123")
+	rr = postJSON(handler, "/login/2fa", "", map[string]string{"two_factor_token": challenge, "code": nextCode})
+	assert.Equal(t, http.StatusUnauthorized, rr.Code, "Replayed code must be rejected")
+
+	// Код восстановления вместо TOTP, тоже один раз
+	rr = postJSON(handler, "/login/2fa", "", map[string]string{"two_factor_token": challenge, "recovery_code": recovery[0]})
+	assert.Equal(t, http.StatusOK, rr.Code, "Expected 200 OK")
+	challenge = passwordLogin(t, handler, "reader@example.com", "secret123")
+	rr = postJSON(handler, "/login/2fa", "", map[string]string{"two_factor_token": challenge, "recovery_code": recovery[0]})
+	assert.Equal(t, http.StatusUnauthorized, rr.Code, "Expected 401 Unauthorized")
+
+	left, _ := s.users.CountRecoveryCodes(context.Background(), 1)
+	assert.Equal(t, recoveryCodeCount-1, left)
+}
+
+func TestTwoFactorTokenAttemptsLimited(t *testing.T) {
+	t.Parallel()
+	s := newMemoryTestServer(t)
+	handler := s.routes()
+	pair := loginTestUser(t, s, handler)
+	secret, _ := enrollTwoFactor(t, handler, pair.AccessToken)
+
+	challenge := passwordLogin(t, handler, "reader@example.com", "secret123")
+	for i := 0; i < maxCodeAttempts; i++ {
+		postJSON(handler, "/login/2fa", "", map[string]string{"two_factor_token": challenge, "code": "000000"})
+	}
+	code, _ := totp.GenerateCode(secret, time.Now().Add(totpPeriod*time.Second))
+	rr := postJSON(handler, "/login/2fa", "", map[string]string{"two_factor_token": challenge, "code": code})
+	assert.Equal(t, http.StatusUnauthorized, rr.Code, "Expected 401 Unauthorized")
+}
+
+func TestAdminRequiresTwoFactor(t *testing.T) {
+	t.Parallel()
+	s := newMemoryTestServer(t)
+	handler := s.routes()
+	hash, _ := bcrypt.GenerateFromPassword([]byte("secret123"), bcrypt.MinCost)
+	admin := User{Email: "admin@example.com", PasswordHash: string(hash), Role: "admin", Confirmed: true}
+	assert.NoError(t, s.users.Create(context.Background(), &admin))
+
+	getAdmin := func(accessToken string) int {
+		req, _ := http.NewRequest("GET", "/admin", nil)
+		req.Header.Set("Authorization", "Bearer "+accessToken)
+		rr := httptest.NewRecorder()
+		handler.ServeHTTP(rr, req)
+		return rr.Code
+	}
+
+	rr := postJSON(handler, "/login", "", map[string]string{"email": admin.Email, "password": "secret123"})
+	var pair tokenPair
+	assert.NoError(t, json.NewDecoder(rr.Body).Decode(&pair))
+	assert.True(t, pair.TwoFactorSetupRequired)
+	assert.Equal(t, http.StatusForbidden, getAdmin(pair.AccessToken))
+
+	secret, _ := enrollTwoFactor(t, handler, pair.AccessToken)
+	assert.Equal(t, http.StatusForbidden, getAdmin(pair.AccessToken), "Token issued before 2FA is not enough")
+
+	code, _ := totp.GenerateCode(secret, time.Now().Add(totpPeriod*time.Second))
+	challenge := passwordLogin(t, handler, admin.Email, "secret123")
+	rr = postJSON(handler, "/login/2fa", "", map[string]string{"two_factor_token": challenge, "code": code})
+	assert.NoError(t, json.NewDecoder(rr.Body).Decode(&pair))
+	assert.Equal(t, http.StatusOK, getAdmin(pair.AccessToken))
+
+	// Администратор не может отключить 2FA, обычный пользователь — может
+	rr = postJSON(handler, "/api/2fa/disable", pair.AccessToken, map[string]string{"code": code})
+	assert.Equal(t, http.StatusForbidden, rr.Code, "Expected 403 Forbidden")
+	assert.Equal(t, http.StatusForbidden, getAdmin(loginTestUser(t, s, handler).AccessToken))
+}
+
+func TestValidateTOTP(t *testing.T) {
+	t.Parallel()
+	key, err := totp.Generate(totp.GenerateOpts{Issuer: "bookstore", AccountName: "a@example.com"})
+	assert.NoError(t, err)
+	now := time.Now()
+
+	for _, shift := range []time.Duration{0, -totpPeriod * time.Second, totpPeriod * time.Second} {
+		code, _ := totp.GenerateCode(key.Secret(), now.Add(shift))
+		step, ok := validateTOTP(key.Secret(), code, now)
+		assert.True(t, ok)
+		assert.Equal(t, now.Add(shift).Unix()/totpPeriod, step)
+	}
+	code, _ := totp.GenerateCode(key.Secret(), now.Add(-3*totpPeriod*time.Second))
+	_, ok := validateTOTP(key.Secret(), code, now)
+	assert.False(t, ok, "Codes from older intervals must be rejected")
+}
+
+func TestOIDCLoginRequiresSecondFactor(t *testing.T) {
+	t.Parallel()
+	s, provider := newOAuthTestServer(t)
+	s.oidc["google"].trustEmail = true
+	ctx := context.Background()
+	user := User{Email: "reader@gmail.com", Role: "user", Confirmed: true}
+	assert.NoError(t, s.users.Create(ctx, &user))
+	assert.NoError(t, s.users.SaveTwoFactor(ctx, &TwoFactor{UserID: user.ID, Enabled: true}))
+
+	provider.signIn(jwt.MapClaims{"sub": "google-1", "email": "reader@gmail.com", "email_verified": true})
+	rr := oauthLogin(t, s, provider, nil)
+	assert.Equal(t, http.StatusFound, rr.Code, rr.Body.String())
+	location, _ := url.Parse(rr.Header().Get("Location"))
+	assert.Equal(t, "/signin.html", location.Path)
+	fragment, _ := url.ParseQuery(location.Fragment)
+	assert.NotEmpty(t, fragment.Get("two_factor_token"))
+	assert.Empty(t, fragment.Get("token"))
+}
@@ -0,0 +1,195 @@
+package main
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/subtle"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"math/big"
+	"net/http"
+	"strconv"
+	"time"
+)
+
+// Одноразовые токены из писем: подтверждение email ссылкой или кодом.
+// В базе хранится только хеш, у каждого токена есть срок действия.
+
+// UserToken — одноразовый токен пользователя для действия purpose
+type UserToken struct {
+	ID        uint   `gorm:"primaryKey"`
+	UserID    uint   `gorm:"index"`
+	Purpose   string `gorm:"index"`
+	TokenHash string `gorm:"uniqueIndex"`
+	Attempts  int
+	ExpiresAt time.Time
+	UsedAt    *time.Time
+	CreatedAt time.Time
+}
+
+const (
+	purposeVerifyEmail     = "verify_email"
+	purposeVerifyEmailCode = "verify_email_code"
+
+	verificationLinkTTL = 24 * time.Hour
+	verificationCodeTTL = 15 * time.Minute
+	maxCodeAttempts     = 5
+)
+
+var errInvalidUserToken = errors.New("invalid or expired token")
+
+// issueUserToken выдаёт новый токен для ссылки, прежние токены с той же целью удаляются
+func (s *Server) issueUserToken(ctx context.Context, userID uint, purpose string, ttl time.Duration) (string, error) {
+	raw, err := randomToken(32)
+	if err != nil {
+		return "", err
+	}
+	return raw, s.storeUserToken(ctx, userID, purpose, hashToken(raw), ttl)
+}
+
+// issueUserCode выдаёт короткий цифровой код; попытки ввода ограничены maxCodeAttempts
+func (s *Server) issueUserCode(ctx context.Context, userID uint, purpose string, ttl time.Duration) (string, error) {
+	n, err := rand.Int(rand.Reader, big.NewInt(1_000_000))
+	if err != nil {
+		return "", err
+	}
+	code := fmt.Sprintf("%06d", n.Int64())
+	return code, s.storeUserToken(ctx, userID, purpose, userCodeHash(userID, purpose, code), ttl)
+}
+
+// userCodeHash привязывает код к пользователю, чтобы одинаковые коды разных людей не совпадали
+func userCodeHash(userID uint, purpose, code string) string {
+	return hashToken(strconv.FormatUint(uint64(userID), 10) + ":" + purpose + ":" + code)
+}
+
+func (s *Server) storeUserToken(ctx context.Context, userID uint, purpose, hash string, ttl time.Duration) error {
+	if err := s.tokens.DeleteUserTokens(ctx, userID, purpose); err != nil {
+		return err
+	}
+	now := time.Now()
+	return s.tokens.CreateUserToken(ctx, &UserToken{
+		UserID:    userID,
+		Purpose:   purpose,
+		TokenHash: hash,
+		ExpiresAt: now.Add(ttl),
+		CreatedAt: now,
+	})
+}
+
+// consumeUserToken проверяет токен из ссылки и помечает его использованным
+func (s *Server) consumeUserToken(ctx context.Context, purpose, raw string) (UserToken, error) {
+	token, err := s.tokens.GetUserToken(ctx, purpose, hashToken(raw))
+	if errors.Is(err, ErrNotFound) {
+		return UserToken{}, errInvalidUserToken
+	}
+	if err != nil {
+		return UserToken{}, err
+	}
+	return token, s.useUserToken(ctx, token)
+}
+
+// consumeUserCode проверяет код пользователя; после maxCodeAttempts ошибок код сгорает
+func (s *Server) consumeUserCode(ctx context.Context, userID uint, purpose, code string) error {
+	token, err := s.tokens.GetActiveUserToken(ctx, userID, purpose)
+	if errors.Is(err, ErrNotFound) {
+		return errInvalidUserToken
+	}
+	if err != nil {
+		return err
+	}
+	if token.Attempts >= maxCodeAttempts {
+		return errInvalidUserToken
+	}
+	if subtle.ConstantTimeCompare([]byte(token.TokenHash), []byte(userCodeHash(userID, purpose, code))) != 1 {
+		if err := s.tokens.AddUserTokenAttempt(ctx, token.ID); err != nil {
+			return err
+		}
+		return errInvalidUserToken
+	}
+	return s.useUserToken(ctx, token)
+}
+
+func (s *Server) useUserToken(ctx context.Context, token UserToken) error {
+	now := time.Now()
+	if token.UsedAt != nil || now.After(token.ExpiresAt) {
+		return errInvalidUserToken
+	}
+	fresh, err := s.tokens.UseUserToken(ctx, token.ID, now)
+	if err != nil {
+		return err
+	}
+	if !fresh {
+		return errInvalidUserToken
+	}
+	return nil
+}
+
+// startEmailVerification выдаёт ссылку и код и отправляет их письмом
+func (s *Server) startEmailVerification(ctx context.Context, user User) error {
+	link, err := s.issueUserToken(ctx, user.ID, purposeVerifyEmail, verificationLinkTTL)
+	if err != nil {
+		return err
+	}
+	code, err := s.issueUserCode(ctx, user.ID, purposeVerifyEmailCode, verificationCodeTTL)
+	if err != nil {
+		return err
+	}
+	go s.sendVerificationEmail(context.WithoutCancel(ctx), user.Email, link, code)
+	return nil
+}
+
+// confirmEmail отмечает почту подтверждённой и гасит оставшиеся токены подтверждения
+func (s *Server) confirmEmail(ctx context.Context, userID uint) error {
+	user, err := s.users.Get(ctx, userID)
+	if err != nil {
+		return err
+	}
+	user.Confirmed = true
+	if err := s.users.Save(ctx, &user); err != nil {
+		return err
+	}
+	s.tokens.DeleteUserTokens(ctx, userID, purposeVerifyEmail)
+	return s.tokens.DeleteUserTokens(ctx, userID, purposeVerifyEmailCode)
+}
+
+// Подтверждение кодом из письма: POST /verify/code {"email": "...", "code": "123456"}
+func (s *Server) verifyEmailCodeHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	ip := s.clientIP(r)
+	if !s.verifyAttempts.Allow(ip) {
+		http.Error(w, "Too many attempts, try again later", http.StatusTooManyRequests)
+		return
+	}
+
+	var req struct {
+		Email string `json:"email"`
+		Code  string `json:"code"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil || req.Email == "" || req.Code == "" {
+		http.Error(w, "Email and code are required", http.StatusBadRequest)
+		return
+	}
+
+	user, err := s.users.GetByEmail(r.Context(), req.Email)
+	if err == nil {
+		err = s.consumeUserCode(r.Context(), user.ID, purposeVerifyEmailCode, req.Code)
+	}
+	if err != nil {
+		// Одинаковый ответ для неизвестного email и неверного кода
+		s.verifyAttempts.Fail(ip)
+		http.Error(w, "Invalid or expired code", http.StatusBadRequest)
+		return
+	}
+	if err := s.confirmEmail(r.Context(), user.ID); err != nil {
+		http.Error(w, "Failed to verify email", http.StatusInternalServerError)
+		return
+	}
+	s.verifyAttempts.Reset(ip)
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]string{"message": "Email verified successfully. You can now log in."})
+}
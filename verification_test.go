@@ -0,0 +1,143 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"regexp"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+var (
+	verifyLinkPattern = regexp.MustCompile(`token=([A-Za-z0-9_-]+)`)
+	verifyCodePattern = regexp.MustCompile(`code on the site: (\d{6})`)
+)
+
+// mailedSecret ждёт письмо и достаёт из последнего письма первую группу pattern
+func mailedSecret(t *testing.T, mailer *fakeMailer, pattern *regexp.Regexp) string {
+	t.Helper()
+	assert.Eventually(t, func() bool { return len(mailer.Sent()) > 0 }, time.Second, 10*time.Millisecond)
+	sent := mailer.Sent()
+	match := pattern.FindStringSubmatch(sent[len(sent)-1].Msg)
+	if match == nil {
+		t.Fatalf("no %s in mail", pattern)
+	}
+	return match[1]
+}
+
+func registerTestUser(t *testing.T, s *Server) {
+	t.Helper()
+	body := `{"name":"Reader","email":"reader@example.com","password":"turn-the-page-42"}`
+	req, _ := http.NewRequest("POST", "/register", bytes.NewBufferString(body))
+	req.Header.Set("Content-Type", "application/json")
+	rr := httptest.NewRecorder()
+	s.routes().ServeHTTP(rr, req)
+	assert.Equal(t, http.StatusCreated, rr.Code, "Expected 201 Created")
+}
+
+func postVerifyCode(s *Server, email, code string) int {
+	req, _ := http.NewRequest("POST", "/verify/code", bytes.NewBufferString(`{"email":"`+email+`","code":"`+code+`"}`))
+	req.Header.Set("Content-Type", "application/json")
+	rr := httptest.NewRecorder()
+	s.routes().ServeHTTP(rr, req)
+	return rr.Code
+}
+
+func TestVerifyEmailWithCode(t *testing.T) {
+	t.Parallel()
+	s := newMemoryTestServer(t)
+	registerTestUser(t, s)
+	code := mailedSecret(t, s.mailer.(*fakeMailer), verifyCodePattern)
+
+	assert.Equal(t, http.StatusBadRequest, postVerifyCode(s, "nobody@example.com", code))
+	assert.Equal(t, http.StatusOK, postVerifyCode(s, "reader@example.com", code))
+	user, _ := s.users.GetByEmail(context.Background(), "reader@example.com")
+	assert.True(t, user.Confirmed)
+
+	// После подтверждения ни код, ни ссылка больше не работают
+	assert.Equal(t, http.StatusBadRequest, postVerifyCode(s, "reader@example.com", code))
+	link := mailedSecret(t, s.mailer.(*fakeMailer), verifyLinkPattern)
+	_, err := s.consumeUserToken(context.Background(), purposeVerifyEmail, link)
+	assert.ErrorIs(t, err, errInvalidUserToken)
+}
+
+func TestVerificationCodeBurnsAfterFailedAttempts(t *testing.T) {
+	t.Parallel()
+	s := newMemoryTestServer(t)
+	registerTestUser(t, s)
+	code := mailedSecret(t, s.mailer.(*fakeMailer), verifyCodePattern)
+
+	wrong := "000000"
+	if code == wrong {
+		wrong = "111111"
+	}
+	for i := 0; i < maxCodeAttempts; i++ {
+		assert.Equal(t, http.StatusBadRequest, postVerifyCode(s, "reader@example.com", wrong))
+	}
+	assert.Equal(t, http.StatusBadRequest, postVerifyCode(s, "reader@example.com", code), "Code must be burned")
+
+	// Дальше блокируется сам IP
+	for i := maxCodeAttempts + 1; i < 10; i++ {
+		postVerifyCode(s, "reader@example.com", wrong)
+	}
+	assert.Equal(t, http.StatusTooManyRequests, postVerifyCode(s, "reader@example.com", code))
+}
+
+func TestExpiredVerificationLink(t *testing.T) {
+	t.Parallel()
+	s := newMemoryTestServer(t)
+	ctx := context.Background()
+
+	raw, err := s.issueUserToken(ctx, 1, purposeVerifyEmail, -time.Second)
+	assert.NoError(t, err)
+	_, err = s.consumeUserToken(ctx, purposeVerifyEmail, raw)
+	assert.ErrorIs(t, err, errInvalidUserToken)
+
+	// Новый токен отменяет прежний
+	first, _ := s.issueUserToken(ctx, 1, purposeVerifyEmail, time.Hour)
+	second, _ := s.issueUserToken(ctx, 1, purposeVerifyEmail, time.Hour)
+	_, err = s.consumeUserToken(ctx, purposeVerifyEmail, first)
+	assert.ErrorIs(t, err, errInvalidUserToken)
+	token, err := s.consumeUserToken(ctx, purposeVerifyEmail, second)
+	assert.NoError(t, err)
+	assert.Equal(t, uint(1), token.UserID)
+}
+
+func TestAttemptLimiter(t *testing.T) {
+	t.Parallel()
+	l := newAttemptLimiter(2, time.Minute)
+	now := time.Now()
+	l.now = func() time.Time { return now }
+
+	l.Fail("ip")
+	assert.True(t, l.Allow("ip"))
+	l.Fail("ip")
+	assert.False(t, l.Allow("ip"))
+	assert.True(t, l.Allow("other"))
+
+	now = now.Add(time.Minute + time.Second)
+	assert.True(t, l.Allow("ip"), "Old failures expire")
+
+	l.Fail("ip")
+	l.Fail("ip")
+	l.Reset("ip")
+	assert.True(t, l.Allow("ip"))
+}
+
+func TestAttemptLimiterSweep(t *testing.T) {
+	t.Parallel()
+	l := newAttemptLimiter(2, time.Minute)
+	now := time.Now()
+	l.now = func() time.Time { return now }
+
+	// Ключи, которые больше не пробуют, не остаются в памяти навсегда
+	l.Fail("gone")
+	now = now.Add(time.Minute + time.Second)
+	l.Fail("ip")
+	assert.Len(t, l.failures, 1)
+	assert.Contains(t, l.failures, "ip")
+}